@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openSequential opens path for reading with read-ahead enabled
+func openSequential(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The hint is best effort; some file systems do not support it
+	unix.FcntlInt(file.Fd(), unix.F_RDAHEAD, 1)
+	return file, nil
+}
+
+// fileSystemProfile returns the -fs-profile matching the file system path is
+// on, from the type name statfs reports for it
+func fileSystemProfile(path string) string {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "local"
+	}
+	switch name := unix.ByteSliceToString(st.Fstypename[:]); {
+	case name == "nfs":
+		return "nfs"
+	case name == "smbfs" || name == "afpfs":
+		return "smb"
+	case strings.Contains(name, "fuse"):
+		return "objectfuse"
+	}
+	return "local"
+}
+
+// freeSpace returns the bytes available to this user on the file system dir
+// is on
+func freeSpace(dir string) (int64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// isStaleHandle reports whether err is a stale NFS file handle
+func isStaleHandle(err error) bool {
+	return errors.Is(err, unix.ESTALE)
+}
+
+// markOutputDirPlatform excludes dir from Spotlight indexing
+func markOutputDirPlatform(dir string) error {
+	marker := filepath.Join(dir, ".metadata_never_index")
+	return os.WriteFile(marker, nil, 0644)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openSequential opens path for reading and advises the kernel that it will
+// be read sequentially, enlarging readahead
+func openSequential(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The hint is best effort; some file systems do not support it
+	unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	return file, nil
+}
+
+// fileSystemProfile returns the -fs-profile matching the file system path is
+// on, from the magic number statfs reports for it
+func fileSystemProfile(path string) string {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "local"
+	}
+	switch uint32(st.Type) {
+	case unix.NFS_SUPER_MAGIC:
+		return "nfs"
+	case unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC:
+		return "smb"
+	case unix.FUSE_SUPER_MAGIC:
+		// Object store mounts such as s3fs, gcsfuse, and blobfuse
+		return "objectfuse"
+	}
+	return "local"
+}
+
+// freeSpace returns the bytes available to this user on the file system dir
+// is on
+func freeSpace(dir string) (int64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// isStaleHandle reports whether err is a stale NFS file handle
+func isStaleHandle(err error) bool {
+	return errors.Is(err, unix.ESTALE)
+}
+
+// markOutputDirPlatform has nothing to add beyond CACHEDIR.TAG on Linux
+func markOutputDirPlatform(dir string) error {
+	return nil
+}
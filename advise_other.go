@@ -0,0 +1,35 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+// openSequential opens path for reading; this platform has no sequential
+// access hint
+func openSequential(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// fileSystemProfile cannot tell file systems apart on this platform, so
+// -fs-profile must be given for network file systems
+func fileSystemProfile(path string) string {
+	return "local"
+}
+
+// freeSpace cannot tell the free space of a file system on this platform, so
+// the disk space is not checked
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
+
+// isStaleHandle reports whether err is a stale file handle, which this
+// platform does not distinguish
+func isStaleHandle(err error) bool {
+	return false
+}
+
+// markOutputDirPlatform has nothing to add beyond CACHEDIR.TAG on this
+// platform
+func markOutputDirPlatform(dir string) error {
+	return nil
+}
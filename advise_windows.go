@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// fileAttributeNotContentIndexed excludes a file or directory from the
+	// Windows Search indexer
+	fileAttributeNotContentIndexed = 0x2000
+
+	// fileFlagSequentialScan is FILE_FLAG_SEQUENTIAL_SCAN, which the
+	// syscall package does not define
+	fileFlagSequentialScan = 0x08000000
+)
+
+// fileSystemProfile returns the -fs-profile matching the volume path is on:
+// UNC paths and mapped network drives are SMB shares
+func fileSystemProfile(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "local"
+	}
+	volume := filepath.VolumeName(abs)
+	if strings.HasPrefix(volume, `\\`) {
+		return "smb"
+	}
+	root, err := syscall.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return "local"
+	}
+	if windows.GetDriveType(root) == windows.DRIVE_REMOTE {
+		return "smb"
+	}
+	return "local"
+}
+
+// freeSpace returns the bytes available to this user on the volume dir is on
+func freeSpace(dir string) (int64, bool) {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, &total, &free); err != nil {
+		return 0, false
+	}
+	return int64(available), true
+}
+
+// isStaleHandle reports whether err is a network error after which the file
+// can be opened again, such as a dropped SMB session
+func isStaleHandle(err error) bool {
+	return errors.Is(err, windows.ERROR_NETNAME_DELETED) || errors.Is(err, windows.ERROR_UNEXP_NET_ERR)
+}
+
+// openSequential opens path for reading with FILE_FLAG_SEQUENTIAL_SCAN, so
+// the cache manager reads ahead aggressively
+func openSequential(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL|fileFlagSequentialScan, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// markOutputDirPlatform sets the not-content-indexed attribute on dir, which
+// new files created inside it inherit
+func markOutputDirPlatform(dir string) error {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	attrs, err := syscall.GetFileAttributes(name)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(name, attrs|fileAttributeNotContentIndexed)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// alignment holds the part boundaries of a previous run that -align-with
+// reproduces, so that corresponding parts of two related inputs cover the
+// same input records
+type alignment struct {
+	path string
+	// bounds holds the last input record covered by each part
+	bounds []int
+	// keys holds the key of the last record of each part, where recorded
+	keys [][]string
+}
+
+// loadAlignment reads the part boundaries from the -report file of a
+// previous run. Reports that lack record positions fall back to the record
+// counts of the parts.
+func loadAlignment(path string) (*alignment, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, configErrorf("alignment report does not exist: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alignment report '%s': %w", path, err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, configErrorf("invalid alignment report '%s': %v", path, err)
+	}
+	parts := report.Output.Parts
+	if len(parts) == 0 {
+		return nil, configErrorf("alignment report '%s' lists no parts", path)
+	}
+
+	positions := true
+	for _, part := range parts {
+		if part.Records > 0 && part.LastRecord == 0 {
+			positions = false
+		}
+	}
+
+	a := &alignment{path: path}
+	bound := 0
+	for i, part := range parts {
+		switch {
+		case !positions:
+			bound += part.Records
+		case part.Records > 0:
+			if part.LastRecord < bound {
+				return nil, configErrorf("invalid alignment report '%s': part %d ends before the previous part", path, i+1)
+			}
+			bound = part.LastRecord
+		}
+		a.bounds = append(a.bounds, bound)
+		a.keys = append(a.keys, part.LastKey)
+	}
+	return a, nil
+}
+
+// past reports whether input record number record lies beyond the part at
+// index part, so that it belongs to a later part
+func (a *alignment) past(part, record int) bool {
+	return part < len(a.bounds) && record > a.bounds[part]
+}
+
+// ends reports whether input record number record ended the part at index
+// part in the previous run
+func (a *alignment) ends(part, record int) bool {
+	return part < len(a.bounds) && record == a.bounds[part]
+}
+
+// check verifies that key, of the record ending the part at index part, is
+// the key recorded for it by the previous run
+func (a *alignment) check(part, record int, key []string) error {
+	if key == nil || a.keys[part] == nil {
+		return nil
+	}
+	if !slices.Equal(key, a.keys[part]) {
+		return fmt.Errorf("input is not aligned with %s: record %d ends part %d with key %s, expected %s",
+			a.path, record, part+1, strings.Join(key, ","), strings.Join(a.keys[part], ","))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bagPayloadDir is the directory, relative to the bag root, holding the parts
+const bagPayloadDir = "data"
+
+// bagEntry is a file listed in a bag manifest
+type bagEntry struct {
+	path string
+	sum  []byte
+}
+
+// bagBuilder collects the payload digests of a run laid out as a BagIt bag
+// (RFC 8493) and writes the tag files once the parts are complete
+type bagBuilder struct {
+	root     string
+	hash     hash.Hash
+	manifest []bagEntry
+	octets   int64
+}
+
+// newBagBuilder returns a builder for a bag rooted at dir
+func newBagBuilder(dir string) *bagBuilder {
+	return &bagBuilder{root: dir}
+}
+
+// payloadWriter starts hashing a new payload file and returns the writer its
+// contents must also be written to
+func (b *bagBuilder) payloadWriter() io.Writer {
+	b.hash = sha256.New()
+	return b.hash
+}
+
+// addPayload records the payload file at path, of size bytes, using the
+// digest accumulated since the last payloadWriter call
+func (b *bagBuilder) addPayload(path string, size int64) {
+	b.manifest = append(b.manifest, bagEntry{
+		path: bagPayloadDir + "/" + filepath.Base(path),
+		sum:  b.hash.Sum(nil),
+	})
+	b.octets += size
+	b.hash = nil
+}
+
+// Finish writes bagit.txt, the payload manifest, bag-info.txt with the given
+// metadata, and a tag manifest covering them and any extra tag files
+func (b *bagBuilder) Finish(info [][2]string, extraTags []string) error {
+	info = append([][2]string{
+		{"Bagging-Date", time.Now().Format("2006-01-02")},
+		{"Bag-Software-Agent", "splitcsv"},
+		{"Payload-Oxum", fmt.Sprintf("%d.%d", b.octets, len(b.manifest))},
+	}, info...)
+
+	var bagInfo strings.Builder
+	for _, field := range info {
+		fmt.Fprintf(&bagInfo, "%s: %s\n", field[0], field[1])
+	}
+
+	tags := []struct {
+		name    string
+		content string
+	}{
+		{"bagit.txt", "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"},
+		{"manifest-sha256.txt", formatBagManifest(b.manifest)},
+		{"bag-info.txt", bagInfo.String()},
+	}
+
+	var tagManifest []bagEntry
+	for _, tag := range tags {
+		path := filepath.Join(b.root, tag.name)
+		if err := os.WriteFile(path, []byte(tag.content), 0644); err != nil {
+			return fmt.Errorf("failed to write bag file '%s': %w", path, err)
+		}
+		sum := sha256.Sum256([]byte(tag.content))
+		tagManifest = append(tagManifest, bagEntry{path: tag.name, sum: sum[:]})
+	}
+	for _, path := range extraTags {
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		tagManifest = append(tagManifest, bagEntry{path: filepath.Base(path), sum: sum})
+	}
+
+	path := filepath.Join(b.root, "tagmanifest-sha256.txt")
+	if err := os.WriteFile(path, []byte(formatBagManifest(tagManifest)), 0644); err != nil {
+		return fmt.Errorf("failed to write bag file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// formatBagManifest renders manifest lines as "<hex> <path>", percent-encoding
+// the characters RFC 8493 reserves in file names
+func formatBagManifest(entries []bagEntry) string {
+	escape := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s %s\n", hex.EncodeToString(entry.sum), escape.Replace(entry.path))
+	}
+	return b.String()
+}
+
+// hashFile returns the SHA-256 digest of the file at path
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// finishBag writes the bag tag files describing the run, listing the rejects
+// and validation files at the bag root as tag files
+func (s *CSVSplitter) finishBag(extraTags []string) error {
+	var info [][2]string
+	if s.config.InputPath != "" {
+		info = append(info, [2]string{"External-Identifier", filepath.Base(s.config.InputPath)})
+	}
+	if s.config.RunID != "" {
+		info = append(info, [2]string{"Internal-Sender-Identifier", s.config.RunID})
+	}
+	info = append(info,
+		[2]string{"Source-Records", fmt.Sprint(s.stats.records)},
+		[2]string{"Records-Written", fmt.Sprint(s.stats.written)},
+		[2]string{"Part-Count", fmt.Sprint(len(s.parts))},
+	)
+	if s.config.MaxRecords > 0 {
+		info = append(info, [2]string{"Records-Per-Part", fmt.Sprint(s.config.MaxRecords)})
+	}
+	info = append(info, [2]string{"Output-Format", s.config.OutputFormat})
+	return s.bag.Finish(info, extraTags)
+}
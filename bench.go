@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchModes are the split modes the bench subcommand can measure, in the
+// order they are run
+var benchModes = []string{"chunks", "passthrough", "mmap", "hash-key", "transforms"}
+
+// BenchConfig holds the configuration for the bench subcommand
+type BenchConfig struct {
+	InputPath  string
+	Dir        string
+	Rows       int
+	Columns    int
+	FieldWidth int
+	Quoted     float64
+	Limit      int
+	Modes      string
+	Buffers    string
+	Workers    string
+	Seed       uint64
+}
+
+// benchCase is a single split measured by the bench subcommand, of input
+// with the given delimiter, hashed on the key column in the hash-key mode
+type benchCase struct {
+	mode      string
+	buffer    int
+	workers   int
+	input     string
+	delimiter rune
+	key       string
+}
+
+// BenchResult is the measurement of a benchCase
+type BenchResult struct {
+	Mode     string
+	Buffer   int
+	Workers  int
+	Rows     int
+	Duration time.Duration
+}
+
+// runBench implements the bench subcommand, which measures how fast the
+// split modes and writer settings run on this machine
+func runBench(args []string) error {
+	config := BenchConfig{}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&config.InputPath, "input", "", "Measure splits of this CSV file instead of generated data")
+	fs.StringVar(&config.InputPath, "i", "", "Measure splits of this CSV file instead of generated data (shorthand)")
+	fs.StringVar(&config.Dir, "dir", "", "Directory for the generated data and the parts, removed afterwards (default: the system temporary directory)")
+	fs.IntVar(&config.Rows, "rows", 1000000, "Number of records to generate")
+	fs.IntVar(&config.Columns, "columns", 10, "Number of columns to generate")
+	fs.IntVar(&config.FieldWidth, "field-width", 12, "Number of characters of each generated field")
+	fs.Float64Var(&config.Quoted, "quoted", 0.05, "Fraction of generated fields holding a delimiter, and so quoted")
+	fs.IntVar(&config.Limit, "limit", 100000, "Records per part of each split")
+	fs.StringVar(&config.Modes, "modes", strings.Join(benchModes, ","), "Comma-separated split modes to measure: "+strings.Join(benchModes, ", "))
+	fs.StringVar(&config.Buffers, "buffers", "4KB,64KB,1MB", "Comma-separated -buffer sizes to measure each mode with")
+	fs.StringVar(&config.Workers, "workers", "1,"+strconv.Itoa(runtime.NumCPU()), "Comma-separated -workers counts to measure the transforms mode with")
+	fs.Uint64Var(&config.Seed, "seed", 1, "Seed of the generated data")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bench [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Measure the rows and megabytes per second of the split modes with different\n")
+		fmt.Fprintf(os.Stderr, "buffer sizes and worker counts, on generated data or on -input.\n\n")
+		fmt.Fprintf(os.Stderr, "Modes:\n")
+		fmt.Fprintf(os.Stderr, "  chunks       split by -limit\n")
+		fmt.Fprintf(os.Stderr, "  passthrough  split by -limit with -passthrough\n")
+		fmt.Fprintf(os.Stderr, "  mmap         split by -limit with -mmap\n")
+		fmt.Fprintf(os.Stderr, "  hash-key     hash the first column into 16 partitions\n")
+		fmt.Fprintf(os.Stderr, "  transforms   split by -limit with -trim-fields, -collapse-whitespace, and\n")
+		fmt.Fprintf(os.Stderr, "               -normalize-case upper on -workers\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cases, err := benchCases(config)
+	if err != nil {
+		return err
+	}
+	if config.InputPath == "" && (config.Rows <= 0 || config.Columns < 2 || config.FieldWidth <= 0) {
+		return configErrorf("rows and field-width must be greater than 0, and columns at least 2")
+	}
+	if config.Limit <= 0 {
+		return configErrorf("limit must be greater than 0")
+	}
+
+	dir, err := os.MkdirTemp(config.Dir, "splitcsv-bench-")
+	if err != nil {
+		return fmt.Errorf("failed to create bench directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input, label := config.InputPath, config.InputPath
+	if input == "" {
+		input = filepath.Join(dir, "bench.csv")
+		label = fmt.Sprintf("%d generated records", config.Rows)
+		fmt.Fprintf(os.Stderr, "Generating %d records of %d columns...\n", config.Rows, config.Columns)
+		if err := generateBenchData(input, config); err != nil {
+			return err
+		}
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	reader, delimiter := newInspectReader(file, 0)
+	header, err := readDiffHeader(reader, input)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Input: %s (%.1f MB)\n\n", label, float64(info.Size())/(1<<20))
+	fmt.Printf("%-12s %8s %8s %12s %10s %9s\n", "mode", "buffer", "workers", "rows/s", "MB/s", "time")
+	for i, c := range cases {
+		c.input, c.delimiter, c.key = input, delimiter, header[0]
+		result, err := runBenchCase(c, filepath.Join(dir, fmt.Sprintf("parts-%d", i)), config.Limit)
+		if err != nil {
+			return fmt.Errorf("%s split failed: %w", c.mode, err)
+		}
+		seconds := result.Duration.Seconds()
+		fmt.Printf("%-12s %8s %8d %12.0f %10.1f %8.2fs\n", result.Mode, formatByteSize(int64(result.Buffer)),
+			result.Workers, float64(result.Rows)/seconds, float64(info.Size())/(1<<20)/seconds, seconds)
+	}
+	return nil
+}
+
+// benchCases returns the splits to measure: every mode with every buffer
+// size, and the transforms mode with every worker count as well
+func benchCases(config BenchConfig) ([]benchCase, error) {
+	var buffers, workers []int
+	for _, value := range strings.Split(config.Buffers, ",") {
+		size, err := parseByteSize(value)
+		if err != nil || size <= 0 {
+			return nil, configErrorf("invalid buffer size %q", value)
+		}
+		buffers = append(buffers, int(size))
+	}
+	for _, value := range strings.Split(config.Workers, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return nil, configErrorf("invalid worker count %q", value)
+		}
+		if !slices.Contains(workers, n) {
+			workers = append(workers, n)
+		}
+	}
+
+	var cases []benchCase
+	for _, mode := range parseKeyColumns(config.Modes) {
+		if !slices.Contains(benchModes, mode) {
+			return nil, configErrorf("invalid mode %q: must be one of %s", mode, strings.Join(benchModes, ", "))
+		}
+		if mode == "mmap" && !mmapSupported {
+			continue
+		}
+		for _, buffer := range buffers {
+			if mode != "transforms" {
+				cases = append(cases, benchCase{mode: mode, buffer: buffer, workers: 1})
+				continue
+			}
+			for _, n := range workers {
+				cases = append(cases, benchCase{mode: mode, buffer: buffer, workers: n})
+			}
+		}
+	}
+	return cases, nil
+}
+
+// generateBenchData writes a CSV file of the shape of config to path: an
+// id column, an amount column, and columns of random lowercase text, some
+// of them holding a delimiter
+func generateBenchData(path string, config BenchConfig) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create bench data: %w", err)
+	}
+	defer file.Close()
+	buf := bufio.NewWriterSize(file, 1<<20)
+	writer := csv.NewWriter(buf)
+
+	header := []string{"id", "amount"}
+	for i := 2; i < config.Columns; i++ {
+		header = append(header, fmt.Sprintf("field_%d", i))
+	}
+	writer.Write(header)
+
+	rng := rand.New(rand.NewPCG(config.Seed, config.Seed))
+	record := make([]string, config.Columns)
+	field := make([]byte, config.FieldWidth)
+	for row := range config.Rows {
+		record[0] = strconv.Itoa(row + 1)
+		record[1] = strconv.Itoa(rng.IntN(100000))
+		for i := 2; i < config.Columns; i++ {
+			for j := range field {
+				field[j] = byte('a' + rng.IntN(26))
+			}
+			if rng.Float64() < config.Quoted {
+				field[rng.IntN(len(field))] = ','
+			}
+			record[i] = string(field)
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write bench data: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write bench data: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write bench data: %w", err)
+	}
+	return nil
+}
+
+// runBenchCase splits the input of c into parts under dir, timing the split,
+// and removes the parts
+func runBenchCase(c benchCase, dir string, limit int) (BenchResult, error) {
+	config := Config{}
+	registerFlags(flag.NewFlagSet("bench", flag.ContinueOnError), &config)
+	config.InputPath = c.input
+	config.Delimiter = c.delimiter
+	config.OutputDir = dir
+	config.MaxRecords = limit
+	config.BufferSize = c.buffer
+	config.Workers = c.workers
+	switch c.mode {
+	case "passthrough":
+		config.Passthrough = true
+	case "mmap":
+		config.Mmap = true
+	case "hash-key":
+		config.MaxRecords = 0
+		config.HashKey = c.key
+		config.Partitions = 16
+	case "transforms":
+		config.TrimFields = true
+		config.CollapseWhitespace = true
+		config.NormalizeCase = "upper"
+	}
+	defer os.RemoveAll(dir)
+	if err := validateConfig(config); err != nil {
+		return BenchResult{}, err
+	}
+
+	splitter := NewCSVSplitter(config)
+	start := time.Now()
+	if err := splitter.Split(); err != nil {
+		return BenchResult{}, err
+	}
+	result := BenchResult{Mode: c.mode, Buffer: c.buffer, Workers: c.workers, Duration: time.Since(start)}
+	for _, part := range splitter.parts {
+		result.Rows += part.Records
+	}
+	return result, nil
+}
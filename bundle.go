@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// bundleFormats maps the formats of -bundle to the extension of the archive
+var bundleFormats = map[string]string{
+	"zip":    "zip",
+	"tar":    "tar",
+	"tar.gz": "tar.gz",
+}
+
+// validateBundleConfig checks -bundle
+func validateBundleConfig(config Config) error {
+	if config.Bundle == "" {
+		return nil
+	}
+	if _, ok := bundleFormats[config.Bundle]; !ok {
+		return fmt.Errorf("bundle must be zip, tar, or tar.gz")
+	}
+	if _, routed := routedMode(config); routed || len(config.Routes) > 0 {
+		return fmt.Errorf("bundle cannot be combined with routed splits or -route, whose outputs are written side by side")
+	}
+	if config.FilterMode || config.BagIt || config.PgOnly || config.KafkaOnly {
+		return fmt.Errorf("bundle cannot be combined with filter-mode, bagit, pg-only, or kafka-only, which do not keep the parts as files")
+	}
+	return nil
+}
+
+// bundlePath returns the path of the archive of -bundle
+func bundlePath(config Config) string {
+	return filepath.Join(config.OutputDir, config.OutputPrefix+"."+bundleFormats[config.Bundle])
+}
+
+// prepareBundle checks the output directory for the archive of a previous
+// run, which is removed with -clean and otherwise only overwritten with
+// -force
+func prepareBundle(config Config) error {
+	path := bundlePath(config)
+	if config.Clean {
+		for _, stale := range []string{path, path + tmpSuffix, path + partialSuffix} {
+			if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale archive: %w", err)
+			}
+		}
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil && !config.Force {
+		return fmt.Errorf("output file '%s' already exists; use -force to overwrite or -clean to remove previous parts", path)
+	}
+	return nil
+}
+
+// partBundle writes the parts of a run into one archive. Every part is
+// moved into it as soon as it is complete, so the archive is written as
+// the parts are, and only the part being written takes room of its own
+// on disk. The archive has a temporary name until the run ends.
+type partBundle struct {
+	path    string
+	file    *os.File
+	archive bundleArchive
+}
+
+// bundleArchive writes the entries of an archive of one of bundleFormats
+type bundleArchive interface {
+	// add writes the file at path as an entry named by its base name
+	add(path string) error
+	// Close writes the end of the archive, without closing the file
+	Close() error
+}
+
+// newPartBundle creates the archive of -bundle
+func newPartBundle(config Config) (*partBundle, error) {
+	path := bundlePath(config)
+	file, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive '%s': %w", path+tmpSuffix, err)
+	}
+	var archive bundleArchive
+	switch config.Bundle {
+	case "zip":
+		archive = zipArchive{zip.NewWriter(file)}
+	default:
+		archive = newTarArchive(file, config.Bundle == "tar.gz")
+	}
+	return &partBundle{path: path, file: file, archive: archive}, nil
+}
+
+// add moves the files at paths, a part and its sidecars, into the archive
+// under their base names
+func (b *partBundle) add(paths ...string) error {
+	for _, path := range paths {
+		if err := b.archive.add(path); err != nil {
+			return fmt.Errorf("failed to add '%s' to archive '%s': %w", path, b.path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove '%s' added to archive: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// finish completes the archive and gives it its final name. The archive of
+// a run that failed keeps the parts completed before it, and is named like
+// a partial part.
+func (b *partBundle) finish(failed bool) error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.archive.Close()
+	if cerr := b.file.Close(); err == nil {
+		err = cerr
+	}
+	b.file = nil
+	tmpPath := b.path + tmpSuffix
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write archive '%s': %w", b.path, err)
+	}
+	path := b.path
+	if failed {
+		path += partialSuffix
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename archive '%s': %w", tmpPath, err)
+	}
+	return nil
+}
+
+// zipArchive writes the entries of a zip archive, deflated
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (z zipArchive) add(path string) error {
+	return addZipFile(z.Writer, path)
+}
+
+// tarArchive writes the entries of a tar archive, compressed with gzip as a
+// whole for tar.gz
+type tarArchive struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+	buf *bufio.Writer
+}
+
+func newTarArchive(w io.Writer, compress bool) *tarArchive {
+	t := &tarArchive{buf: bufio.NewWriter(w)}
+	w = t.buf
+	if compress {
+		t.gz = gzip.NewWriter(w)
+		w = t.gz
+	}
+	t.tar = tar.NewWriter(w)
+	return t
+}
+
+func (t *tarArchive) add(path string) error {
+	return addTarFile(t.tar, path)
+}
+
+func (t *tarArchive) Close() error {
+	err := t.tar.Close()
+	if t.gz != nil {
+		if cerr := t.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if ferr := t.buf.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
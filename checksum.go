@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+)
+
+// checksumAlgorithms maps supported -checksums values to hash constructors
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newChecksumHash returns a hash for the named checksum algorithm
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+	return newHash(), nil
+}
+
+// writeChecksumFile writes sum next to path as path.<algorithm>, using the
+// "<hex>  <name>" layout understood by sha256sum -c and friends
+func writeChecksumFile(path, algorithm string, sum []byte) error {
+	sidecar := path + "." + algorithm
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(path))
+	if err := os.WriteFile(sidecar, []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file '%s': %w", sidecar, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// validateCommentConfig checks -comment and -keep-comments
+func validateCommentConfig(config Config) error {
+	if config.Comment == 0 {
+		if config.KeepComments {
+			return fmt.Errorf("keep-comments needs -comment")
+		}
+		return nil
+	}
+	if config.Comment == config.Delimiter || strings.ContainsRune("\"\r\n", config.Comment) {
+		return fmt.Errorf("comment cannot be the delimiter, a quote, or a line break")
+	}
+	if config.KeepComments && config.OutputFormat != "csv" {
+		return fmt.Errorf("keep-comments is only used with csv output")
+	}
+	return nil
+}
+
+// takeComments takes the comment lines before the header just read, which
+// -keep-comments copies to the top of every part. Those of the first input
+// file are kept. With -passthrough they are kept as they were read, blank
+// lines included; otherwise only the comment lines are kept, with the line
+// ending of the parts.
+func (s *CSVSplitter) takeComments() {
+	if s.config.Comment == 0 {
+		return
+	}
+	line, _ := s.input.FieldPos(0)
+	start := s.tail.locate(line, 1).Offset
+	if start < 0 {
+		return
+	}
+	block := s.tail.slice(max(s.tail.mark, s.tail.start), start)
+	if s.raw != nil {
+		// The header of the parts starts at its own line
+		s.tail.mark = start
+	}
+	if !s.config.KeepComments || s.comments != nil {
+		return
+	}
+	if s.raw != nil {
+		s.comments = bytes.Clone(block)
+		return
+	}
+
+	eol := "\n"
+	if s.config.OutputLineEnding == "crlf" {
+		eol = "\r\n"
+	}
+	s.comments = []byte{}
+	for _, line := range strings.Split(string(block), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, string(s.config.Comment)) {
+			s.comments = slices.Concat(s.comments, []byte(line), []byte(eol))
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the names of environment variables that set options
+const envPrefix = "SPLITCSV_"
+
+// parseArgs parses args into fs after applying the options of the -config
+// file, if one is named in args or SPLITCSV_CONFIG, and then SPLITCSV_*
+// environment variables, so that command line flags override the environment
+// and the environment overrides the file
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	path, _ := findFlag(fs, args, "config")
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		values, err := loadConfigFile(path)
+		if err != nil {
+			return err
+		}
+		if err := applyConfigValues(fs, values); err != nil {
+			return configErrorf("config file '%s': %v", path, err)
+		}
+	}
+	if err := applyEnvValues(fs); err != nil {
+		return err
+	}
+
+	// Repeatable flags given on the command line replace, rather than add
+	// to, the values of the file and environment
+	fs.VisitAll(func(f *flag.Flag) {
+		if r, ok := f.Value.(interface{ reset() }); ok {
+			if _, found := findFlag(fs, args, f.Name); found {
+				r.reset()
+			}
+		}
+	})
+	return fs.Parse(args)
+}
+
+// envName returns the environment variable that sets the named option, such
+// as SPLITCSV_STATE_DIR for -state-dir
+func envName(option string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// applyEnvValues sets the flags of fs from non-empty SPLITCSV_* environment
+// variables. Single-letter aliases have no variable of their own.
+func applyEnvValues(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || len(f.Name) == 1 || f.Name == "config" {
+			return
+		}
+		name := envName(f.Name)
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = configErrorf("invalid value %q for %s: %v", value, name, serr)
+		}
+	})
+	return err
+}
+
+// getenv returns the value of the first of names that is set and non-empty
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// findFlag returns the first value of the named flag in args and whether it
+// is there, scanning them the way fs would and stopping at the first
+// non-flag argument
+func findFlag(fs *flag.FlagSet, args []string, flagName string) (string, bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			return "", false
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == flagName {
+			if hasValue {
+				return value, true
+			}
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", true
+		}
+		if f := fs.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) {
+			i++
+		}
+	}
+	return "", false
+}
+
+// isBoolFlag reports whether f is a boolean flag, which takes no separate
+// value argument
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// loadConfigFile reads a YAML, TOML, or JSON file, chosen by extension, into
+// a map from option names to values
+func loadConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, configErrorf("config file does not exist: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return nil, configErrorf("config file '%s' must end in .yaml, .yml, .toml, or .json", path)
+	}
+	if err != nil {
+		return nil, configErrorf("invalid config file '%s': %v", path, err)
+	}
+	return values, nil
+}
+
+// applyConfigValues sets the flags of fs named by the keys of values. Lists
+// set a repeatable flag once per element.
+func applyConfigValues(fs *flag.FlagSet, values map[string]any) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		items, ok := values[name].([]any)
+		if !ok {
+			items = []any{values[name]}
+		}
+		for _, item := range items {
+			value, err := formatConfigValue(item)
+			if err != nil {
+				return fmt.Errorf("option %q: %v", name, err)
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("option %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// formatConfigValue renders a decoded scalar as flag text
+func formatConfigValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("must be a string, number, boolean, or list of them")
+}
+
+// writeConfigFile writes options, in order, as a YAML, TOML, or JSON config
+// file chosen by the extension of path. Values that look like numbers or
+// booleans are written as such, and the values of an option given more than
+// once, such as -mask, as a list.
+func writeConfigFile(path string, options [][2]string) error {
+	typed := func(value string) any {
+		if value == "true" || value == "false" {
+			return value == "true"
+		}
+		if n, err := strconv.Atoi(value); err == nil && strconv.Itoa(n) == value {
+			return n
+		}
+		return value
+	}
+	var names []string
+	values := make(map[string][]any)
+	for _, option := range options {
+		if _, ok := values[option[0]]; !ok {
+			names = append(names, option[0])
+		}
+		values[option[0]] = append(values[option[0]], typed(option[1]))
+	}
+	value := func(name string) any {
+		if len(values[name]) == 1 {
+			return values[name][0]
+		}
+		return values[name]
+	}
+
+	var b strings.Builder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, name := range names {
+			var encoded yaml.Node
+			if err := encoded.Encode(value(name)); err != nil {
+				return err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &encoded)
+		}
+		data, err := yaml.Marshal(node)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+	case ".toml", ".json":
+		// JSON scalars are valid TOML values as well
+		isJSON := strings.ToLower(filepath.Ext(path)) == ".json"
+		if isJSON {
+			b.WriteString("{\n")
+		}
+		for i, name := range names {
+			encoded, err := marshalJSON(value(name))
+			if err != nil {
+				return err
+			}
+			if isJSON {
+				key, _ := marshalJSON(name)
+				separator := ","
+				if i == len(names)-1 {
+					separator = ""
+				}
+				fmt.Fprintf(&b, "  %s: %s%s\n", key, encoded, separator)
+			} else {
+				fmt.Fprintf(&b, "%s = %s\n", name, encoded)
+			}
+		}
+		if isJSON {
+			b.WriteString("}\n")
+		}
+	default:
+		return configErrorf("config file '%s' must end in .yaml, .yml, .toml, or .json", path)
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// marshalJSON encodes v without escaping HTML characters
+func marshalJSON(v any) (string, error) {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
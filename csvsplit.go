@@ -1,282 +1,2124 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"filippo.io/age"
 )
 
 // Config holds the configuration for CSV splitting
 type Config struct {
-	InputPath    string
-	OutputPrefix string
-	OutputDir    string
-	MaxRecords   int
-	BufferSize   int
-	SkipEmpty    bool
-	Delimiter    rune
-	Verbose      bool
+	InputPath          string
+	OutputPrefix       string
+	OutputDir          string
+	MaxRecords         int
+	BufferSize         int
+	ReadBufferSize     int
+	WriteBufferSize    int
+	MaxMemory          byteSize
+	MaxThroughput      rateValue
+	TempDir            string
+	SpaceFactor        float64
+	SkipSpaceCheck     bool
+	FooterRows         int
+	DetectFooter       bool
+	FooterAction       string
+	Header             string
+	SetHeader          string
+	DedupeHeaders      bool
+	FailOnDupHeaders   bool
+	SkipEmpty          bool
+	Delimiter          rune
+	InputFormat        string
+	InputEncoding      string
+	FWFSpec            string
+	Verbose            bool
+	Checksum           string
+	OnError            string
+	Strict             bool
+	StrictAction       string
+	OnWidthMismatch    string
+	OutputFormat       string
+	SQLTable           string
+	SQLDialect         string
+	SQLBatchSize       int
+	SQLNull            string
+	SchemaPath         string
+	ReportPath         string
+	LogFormat          string
+	MetricsAddr        string
+	MarkDir            bool
+	BagIt              bool
+	Incremental        bool
+	KeyColumns         string
+	Force              bool
+	Clean              bool
+	StateDir           string
+	Deadline           time.Duration
+	Resume             bool
+	WatchDir           string
+	ArchiveDir         string
+	AlignWith          string
+	FilterMode         bool
+	JSON               bool
+	Version            bool
+	Inputs             []string
+	LineagePath        string
+	InputDir           string
+	Recursive          bool
+	Combine            bool
+	SourceColumn       string
+	RunID              string
+	TimeFormat         string
+	HardLimit          int
+	Ratio              string
+	RatioNames         string
+	Seed               uint64
+	FSProfile          string
+	StratifyBy         string
+	HashKey            string
+	Partitions         int
+	RecordPath         string
+	TimeColumn         string
+	TimeGranularity    string
+	TimeLayout         string
+	Shuffle            bool
+	GroupBy            string
+	Routes             []string
+	Priority           string
+	PartColumn         string
+	PartRowColumn      string
+	RowNumberColumn    string
+	TrimFields         bool
+	CollapseWhitespace bool
+	NormalizeCase      string
+	NormalizeColumns   string
+	ReparseDates       []string
+	Masks              []string
+	HashColumns        []string
+	HMACKeyFile        string
+	Replaces           []string
+	Types              string
+	Join               string
+	NullValues         string
+	NullOutput         string
+	OutputDelimiter    rune
+	OutputFWFSpec      string
+	OutputLineEnding   string
+	QuoteStyle         string
+	Passthrough        bool
+	Mmap               bool
+	Workers            int
+	Comment            rune
+	KeepComments       bool
+	LazyQuotes         bool
+	TrimLeadingSpace   bool
+	Pedantic           bool
+	StartRow           int
+	MaxRows            int
+	Repair             bool
+	PgDSN              string
+	PgTable            string
+	PgBatch            int
+	PgOnly             bool
+	MySQLLoadSQL       bool
+	KafkaBrokers       string
+	KafkaTopic         string
+	KafkaFormat        string
+	KafkaKey           string
+	KafkaOnly          bool
+	NotifyURL          string
+	SlackWebhook       string
+	Bundle             string
+	Encrypt            string
+	Sign               string
 }
 
+// tmpSuffix is appended to the name of a part while it is being written
+const tmpSuffix = ".tmp"
+
 // CSVSplitter handles the CSV splitting operation
 type CSVSplitter struct {
-	config     Config
-	partNumber int
-	writer     *csv.Writer
-	outFile    *os.File
+	config      Config
+	partNumber  int
+	writer      recordWriter
+	outFile     *os.File
+	outBuf      *bufio.Writer
+	outPath     string
+	tmpPath     string
+	hash        hash.Hash
+	counter     *countingWriter
+	parts       []PartInfo
+	rejects     *rejectWriter
+	validator   *schemaValidator
+	validators  []chainedValidator
+	stats       splitStats
+	logger      *slog.Logger
+	metrics     *splitMetrics
+	bag         *bagBuilder
+	delta       *deltaTracker
+	recordsRead int
+	partStart   int
+	keys        []int
+	lastWritten []string
+	align       *alignment
+	lineage     *lineageWriter
+	source      string
+	routed      *routedSplit
+	partName    string
+	fs          fsProfile
+	fsName      string
+	tail        *tailReader
+	input       *csv.Reader
+	recorder    *sessionRecorder
+	shuffle     *recordShuffler
+	streams     *recordStreams
+	// groups are the columns of the records a part does not cut apart
+	groups   []int
+	partDone func(PartInfo)
+	// notifier posts the events of -notify-url
+	notifier *notifier
+	// nulls are the spellings of null of -null-values
+	nulls map[string]bool
+	// masks are those of -mask and -hash-column, and maskHeader the header
+	// they were resolved against, to mask the records that are rejected
+	masks      []fieldTransform
+	maskHeader []string
+	// raw holds the bytes of the records of a -passthrough run
+	raw *rawInput
+	// comments are the comment lines -keep-comments writes atop every part
+	comments []byte
+	// repairs counts the fixes of -repair
+	repairs *repairCounts
+	// widths counts the records of -on-width-mismatch
+	widths *widthCounts
+	// fwf is the spec of -input-format fwf
+	fwf *fwfSpec
+	// encodings are those the input files were read in, with
+	// -input-encoding
+	encodings []string
+	// mapped is the input file mapped into memory by -mmap
+	mapped []byte
+	// limits are the rate limiters of -max-throughput
+	limits *ioLimits
+	// firstRecord is the first record of an input without a header, read
+	// in place of its header
+	firstRecord []string
+	// headerChecked is set once the column names of the header were
+	// checked, and headerRenamed when -dedupe-headers renamed some
+	headerChecked bool
+	headerRenamed bool
+	// footer are the records -footer-action writes at the end of a part
+	footer partFooter
+	// positions are the lines and columns of the fields of a record that
+	// -workers transformed after later records were read, for fieldError
+	positions []int
+	// pg loads the parts into PostgreSQL with -pg-dsn
+	pg *pgLoader
+	// kafka produces the records of the parts with -kafka-brokers
+	kafka *kafkaProducer
+	// bundle is the archive of -bundle the parts are moved into
+	bundle *partBundle
+	// recipients are those of -encrypt, and encrypter encrypts the current
+	// part for them
+	recipients []age.Recipient
+	encrypter  io.WriteCloser
+	// signer signs the parts and their manifest with -sign
+	signer *partSigner
+	// partHeader is the header of the current part, which -mysql-load-sql
+	// names the columns of
+	partHeader []string
+}
+
+// commands maps subcommand names to their entry points
+var commands = map[string]func(args []string) error{
+	"bench":    runBench,
+	"diff":     runDiff,
+	"export":   runExport,
+	"pipeline": runPipeline,
+	"plan":     runPlan,
+	"repair":   runRepair,
+	"replay":   runReplay,
+	"sample":   runSample,
+	"serve":    runServe,
+	"stats":    runStats,
+	"view":     runView,
+	"wizard":   runWizard,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				if !isLogged(err) {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				}
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+	}
+
 	config := parseFlags()
+	run := func() error { return runSplit(config, flag.Usage) }
+	if config.Version {
+		run = func() error { return printVersion(os.Stdout, config.JSON) }
+	} else if config.WatchDir != "" {
+		run = func() error { return runWatch(config) }
+	} else if config.InputDir != "" {
+		run = func() error { return runInputDir(config, flag.Usage) }
+	}
+	if err := run(); err != nil {
+		if !isLogged(err) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
 
+// runSplit validates config and splits its input, reporting progress and
+// errors in the configured log format. usage, if not nil, is called when the
+// configuration is invalid. The returned error has already been logged.
+func runSplit(config Config, usage func()) error {
+	logger := newLogger(config)
 	if err := validateConfig(config); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		flag.Usage()
-		os.Exit(1)
+		if exitCode(err) == exitFailure {
+			err = withExitCode(exitConfig, err)
+		}
+		err = reportError(logger, err)
+		if logger == nil && usage != nil {
+			usage()
+		}
+		return err
+	}
+
+	if config.MaxMemory > 0 {
+		// Let the garbage collector work harder as the run nears the cap
+		// rather than grow the heap past it
+		debug.SetMemoryLimit(int64(config.MaxMemory))
 	}
 
 	splitter := NewCSVSplitter(config)
-	if err := splitter.Split(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if config.MetricsAddr != "" {
+		stop, err := serveMetrics(config.MetricsAddr, splitter.metrics)
+		if err != nil {
+			return reportError(logger, err)
+		}
+		defer stop()
+	}
+
+	if config.NotifyURL != "" {
+		splitter.notifier = newNotifier(config, logger)
+	}
+	if config.RecordPath != "" {
+		splitter.recorder = newSessionRecorder(config)
+		splitter.record(sessionEvent{Event: "detect", Name: "fs_profile", Value: splitter.fsName})
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	ctx, cancel := withDeadline(ctx, config.Deadline)
+	defer cancel()
+
+	err := splitter.SplitContext(ctx)
+	if err != nil {
+		splitter.metrics.errors.Add(1)
+	}
+	if splitter.recorder != nil {
+		splitter.finishSession(err)
+		if rerr := splitter.recorder.writeSession(config.RecordPath); rerr != nil {
+			reportError(logger, rerr)
+		}
+	}
+	if config.ReportPath != "" {
+		if rerr := splitter.WriteReport(config.ReportPath, err); rerr != nil {
+			reportError(logger, rerr)
+		}
+	}
+	if splitter.notifier != nil {
+		splitter.notifier.finish(splitter, err)
+	}
+	if config.SlackWebhook != "" {
+		splitter.notifySlack(inputSource(config), err)
+	}
+	if err != nil {
+		return reportError(logger, err)
+	}
+
+	if logger == nil && config.Verbose {
+		fmt.Printf("Splitting completed successfully. Created %d files.\n", len(splitter.parts))
+	}
+	if config.JSON {
+		if err := splitter.printSummary(os.Stdout); err != nil {
+			return reportError(logger, err)
+		}
 	}
 
-	if config.Verbose {
-		fmt.Printf("Splitting completed successfully. Created %d files.\n", splitter.partNumber-1)
+	if splitter.rejects != nil && splitter.rejects.count > 0 {
+		if logger != nil {
+			logger.Warn("records rejected", "count", splitter.rejects.count, "path", splitter.rejects.path)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: rejected %d records to %s\n",
+				splitter.rejects.count, splitter.rejects.path)
+		}
+		return loggedError{withExitCode(exitPartial, fmt.Errorf("rejected %d records", splitter.rejects.count))}
 	}
+	return nil
 }
 
 // parseFlags parses command-line flags and returns a Config
 func parseFlags() Config {
 	config := Config{}
-
-	flag.StringVar(&config.InputPath, "input", "", "Path to the input CSV file (required)")
-	flag.StringVar(&config.InputPath, "i", "", "Path to the input CSV file (shorthand)")
-	flag.StringVar(&config.OutputPrefix, "out", "output", "Prefix for the output files")
-	flag.StringVar(&config.OutputPrefix, "o", "output", "Prefix for the output files (shorthand)")
-	flag.StringVar(&config.OutputDir, "dir", ".", "Output directory for split files")
-	flag.IntVar(&config.MaxRecords, "limit", 10000, "Maximum number of records per output file")
-	flag.IntVar(&config.MaxRecords, "l", 10000, "Maximum number of records per output file (shorthand)")
-	flag.IntVar(&config.BufferSize, "buffer", 64*1024, "Buffer size for file I/O in bytes")
-	flag.BoolVar(&config.SkipEmpty, "skip-empty", true, "Skip empty records")
-	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
-	flag.BoolVar(&config.Verbose, "v", false, "Enable verbose output (shorthand)")
-
-	delimiterStr := flag.String("delimiter", ",", "CSV delimiter character")
+	registerFlags(flag.CommandLine, &config)
+	flag.StringVar(&config.WatchDir, "watch", "", "Split every CSV file that lands in this directory until stopped, instead of -input")
+	flag.StringVar(&config.ArchiveDir, "archive", "", "Move files split in -watch mode to this directory (default: processed/ under the watched directory); -bundle archives the parts")
+	flag.StringVar(&config.InputDir, "input-dir", "", "Split every CSV file in this directory, instead of -input")
+	flag.BoolVar(&config.Recursive, "recursive", false, "Include CSV files in subdirectories of -input-dir")
+	flag.BoolVar(&config.Combine, "combine", false, "Split the files of -input-dir as one input instead of each on its own")
+	flag.BoolVar(&config.FilterMode, "filter-mode", false, "Read CSV from stdin and write the cleaned records as a single CSV to stdout, for use in pipelines")
+	flag.BoolVar(&config.JSON, "json", false, "Print the result of the run as a JSON object on stdout, listing the parts written")
+	flag.BoolVar(&config.Version, "version", false, "Print the version, commit, build date, and Go version of the binary and exit")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s export -dsn DSN -query SQL [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s bench [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s diff [options] old.csv new.csv\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s pipeline -upload DEST [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s plan -target-parts N [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s repair [options] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s replay [options] session.bin\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s sample -n N [options] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s serve [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s stats [options] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s view [options] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s wizard [file]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Split large CSV files into smaller chunks while preserving headers.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s -input data.csv -limit 5000\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -i data.csv -o chunk -dir ./output -l 1000 -v\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -watch ./incoming -dir ./chunks -l 10000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -input-dir ./exports -recursive -dir ./chunks\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -filter-mode -strict -strict-action pad < data.csv > clean.csv\n", os.Args[0])
 	}
 
-	flag.Parse()
-
-	// Parse delimiter
-	if len(*delimiterStr) == 1 {
-		config.Delimiter = rune((*delimiterStr)[0])
-	} else {
-		config.Delimiter = ','
+	if err := parseArgs(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
 	}
+	startRun(&config)
 
 	return config
 }
 
+// registerFlags defines the input and splitting options on fs, storing values in config
+func registerFlags(fs *flag.FlagSet, config *Config) {
+	fs.Var(&inputsValue{config}, "input", "Path or glob pattern of the input CSV files, repeatable (required)")
+	fs.Var(&inputsValue{config}, "i", "Path or glob pattern of the input CSV files, repeatable (shorthand)")
+	registerOutputFlags(fs, config)
+}
+
+// registerOutputFlags defines the options controlling how records are
+// chunked and written, shared by every command that produces parts
+func registerOutputFlags(fs *flag.FlagSet, config *Config) {
+	fs.String("config", "", "Read options from a YAML, TOML, or JSON file; flags on the command line override it")
+	fs.StringVar(&config.OutputPrefix, "out", "output", "Prefix for the output files; {run_id} and {time} are replaced by the run ID and start time")
+	fs.StringVar(&config.OutputPrefix, "o", "output", "Prefix for the output files (shorthand)")
+	fs.StringVar(&config.RunID, "run-id", "", "ID of the run, recorded in reports and logs (default: a generated ULID)")
+	fs.StringVar(&config.TimeFormat, "time-format", "20060102T150405.000", "Go layout of the UTC start time that replaces {time} in file names")
+	fs.StringVar(&config.OutputDir, "dir", ".", "Output directory for split files")
+	fs.BoolVar(&config.Force, "force", false, "Overwrite parts, rejects, validation, report, and lineage files left by a previous run")
+	fs.BoolVar(&config.Clean, "clean", false, "Remove parts, rejects, validation, report, and lineage files left by a previous run before starting")
+	fs.IntVar(&config.MaxRecords, "limit", 10000, "Maximum number of records per output file, or 0 to write a single file")
+	fs.IntVar(&config.MaxRecords, "l", 10000, "Maximum number of records per output file, or 0 to write a single file (shorthand)")
+	fs.StringVar(&config.GroupBy, "group-by", "", "Comma-separated columns; let a part grow past -limit until the records with the same values end")
+	fs.IntVar(&config.HardLimit, "hard-limit", 0, "Let a part grow past -limit, up to this many records, to keep records with the same -group-by or -key together")
+	fs.StringVar(&config.Ratio, "ratio", "", "Route records at random into two or three outputs in these proportions, such as 80:10:10, instead of chunks of -limit")
+	fs.StringVar(&config.RatioNames, "ratio-names", "", "Comma-separated names of the -ratio outputs (default: train,test or train,validation,test)")
+	fs.StringVar(&config.StratifyBy, "stratify-by", "", "Keep the proportions of -ratio for each value of this column, such as a class label")
+	fs.BoolVar(&config.Shuffle, "shuffle", false, "Write the records in random order, so that each part is a random sample of the input")
+	fs.Uint64Var(&config.Seed, "seed", 0, "Seed of the random generator of -ratio and -shuffle, for a reproducible split (default: random)")
+	fs.Var(&listValue{&config.Routes, checkRoute}, "route", "Rule column:pattern=>name sending the records whose column matches the regular expression to parts of their own, repeatable")
+	fs.StringVar(&config.Priority, "priority", "", "Comma-separated outputs of -route, -ratio, -hash-key, or -time-column completed first, in this order; records of the other outputs are held in a spill file until then")
+	fs.StringVar(&config.HashKey, "hash-key", "", "Comma-separated columns whose hash picks the part of each record, so that equal keys share a part, instead of chunks of -limit")
+	fs.IntVar(&config.Partitions, "partitions", 0, "Number of parts records are hashed into with -hash-key")
+	fs.StringVar(&config.TimeColumn, "time-column", "", "Write records into one output per period of the timestamp in this column, instead of chunks of -limit")
+	fs.StringVar(&config.TimeGranularity, "time-granularity", "day", "Period of the -time-column outputs: day, week, or month")
+	fs.StringVar(&config.TimeLayout, "time-layout", time.RFC3339, "Go layout of the -time-column values, or unix or unixms for epoch seconds or milliseconds")
+	fs.IntVar(&config.BufferSize, "buffer", 64*1024, "Buffer size for file I/O in bytes")
+	fs.IntVar(&config.ReadBufferSize, "read-buffer", 0, "Buffer size for reading the input in bytes (default: -buffer)")
+	fs.IntVar(&config.WriteBufferSize, "write-buffer", 0, "Buffer size for writing each part in bytes (default: -buffer)")
+	fs.Var(&config.MaxMemory, "max-memory", "Cap the memory of the run, such as 512MB, spilling -shuffle and -incremental buffers to disk past it (default: no cap)")
+	fs.Var(&config.MaxThroughput, "max-throughput", "Cap the rate of reading the input, and that of writing the parts, such as 50MB/s (default: no cap)")
+	fs.StringVar(&config.TempDir, "temp-dir", "", "Directory the records of -shuffle and -priority spill to (default: the output directory)")
+	fs.Float64Var(&config.SpaceFactor, "space-factor", 0, "Estimated size of the parts relative to the input, checked against the free disk space before the split (default: by output format)")
+	fs.BoolVar(&config.SkipSpaceCheck, "skip-space-check", false, "Do not check the free disk space of the output directory before the split")
+	fs.IntVar(&config.Workers, "workers", 1, "Number of goroutines transforming, coercing, and masking records in parallel; records are still written in input order")
+	fs.StringVar(&config.FSProfile, "fs-profile", "auto", "I/O settings for the file system: auto to detect, local, nfs, smb, or objectfuse")
+	fs.BoolVar(&config.SkipEmpty, "skip-empty", true, "Skip empty records")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+	fs.BoolVar(&config.Verbose, "v", false, "Enable verbose output (shorthand)")
+
+	fs.StringVar(&config.OnError, "on-error", "fail", "Action on malformed records: fail or quarantine")
+	fs.StringVar(&config.SchemaPath, "schema", "", "JSON schema file; rows violating it are written to the rejects file")
+	fs.IntVar(&config.StartRow, "start-row", 0, "Number of the first record to split, counting from 1; earlier records are read and dropped")
+	fs.StringVar(&config.Header, "header", "yes", "Whether the first line of each input file is its header: yes, no, or auto to detect it from the lines below")
+	fs.StringVar(&config.SetHeader, "set-header", "", "Comma-separated column names replacing the header of the input in every part, or naming the columns of an input without one")
+	fs.BoolVar(&config.DedupeHeaders, "dedupe-headers", false, "Rename duplicate column names of the header by appending _2, _3, and so on, and name blank ones column_N")
+	fs.BoolVar(&config.FailOnDupHeaders, "fail-on-duplicate-headers", false, "Fail when the header has duplicate or blank column names, instead of warning")
+	fs.IntVar(&config.FooterRows, "footer-rows", 0, "Hold back the last N records of each input file as its footer, instead of splitting them")
+	fs.BoolVar(&config.DetectFooter, "detect-footer", false, "Hold back the last record of each input file as its footer when it looks like a totals row, starting with Total, Sum, or Trailer")
+	fs.StringVar(&config.FooterAction, "footer-action", "drop", "What to do with the footer: drop it, copy it to the end of every part, or to the end of the last part (drop, every, or last)")
+	fs.IntVar(&config.MaxRows, "max-rows", 0, "Stop reading after this many records from -start-row (default: to the end)")
+	fs.BoolVar(&config.Strict, "strict", false, "Require every record to have as many fields as the header")
+	fs.StringVar(&config.StrictAction, "strict-action", "fail", "Action on records with the wrong field count in strict mode: fail, skip, pad, or truncate")
+	fs.StringVar(&config.OnWidthMismatch, "on-width-mismatch", "", "What to do with records with fewer or more fields than the header: pad short ones, truncate long ones, reject them, or keep them as they are")
+	fs.StringVar(&config.OutputFormat, "output-format", "csv", "Format of the output parts: csv, sql, markdown, html, sqlite, mysql for LOAD DATA INFILE, or fwf for fixed-width records laid out by -output-fwf-spec")
+	fs.StringVar(&config.OutputFWFSpec, "output-fwf-spec", "", "JSON file giving the name, start, width, alignment, and padding of every column of fwf output")
+	fs.StringVar(&config.SQLTable, "table", "", "Table name for sql output, and for sqlite output (default: data)")
+	fs.StringVar(&config.SQLDialect, "sql-dialect", "ansi", "SQL dialect for sql output: ansi, postgres, mysql, sqlite, or sqlserver")
+	fs.IntVar(&config.SQLBatchSize, "sql-batch", 500, "Rows per INSERT statement for sql output")
+	fs.StringVar(&config.SQLNull, "sql-null", "null", "How sql output writes empty fields: null writes NULL, string writes ''")
+	fs.BoolVar(&config.MySQLLoadSQL, "mysql-load-sql", false, "Write the LOAD DATA statement loading each part of mysql output into -table next to it, as {part}.sql")
+	fs.StringVar(&config.PgDSN, "pg-dsn", "", "Load the records of every part into PostgreSQL at this connection string with COPY, as they are written")
+	fs.StringVar(&config.PgTable, "pg-table", "", "Table the records are loaded into with -pg-dsn, whose columns are named like the header")
+	fs.IntVar(&config.PgBatch, "pg-batch", 0, "Commit the records loaded with -pg-dsn every N records (default: once per part)")
+	fs.BoolVar(&config.PgOnly, "pg-only", false, "Remove every part once it is loaded with -pg-dsn, keeping only the loaded records")
+	fs.StringVar(&config.KafkaBrokers, "kafka-brokers", "", "Comma-separated Kafka brokers to produce every record of the parts to, as they are written")
+	fs.StringVar(&config.KafkaTopic, "kafka-topic", "", "Topic the records are produced to with -kafka-brokers")
+	fs.StringVar(&config.KafkaFormat, "kafka-format", "json", "Encoding of the records produced to Kafka: json objects by column name, or delimited lines")
+	fs.StringVar(&config.KafkaKey, "kafka-key", "", "Column whose values key the records produced to Kafka, keeping equal keys in order on one partition (default: no key)")
+	fs.BoolVar(&config.KafkaOnly, "kafka-only", false, "Remove every part once its records are produced with -kafka-brokers")
+	fs.StringVar(&config.NotifyURL, "notify-url", "", "POST a JSON event to this URL when each part is completed and when the run ends")
+	fs.StringVar(&config.SlackWebhook, "slack-webhook", "", "Post a summary of the run, or the error it failed with, to this Slack incoming webhook when it ends")
+	fs.StringVar(&config.ReportPath, "report", "", "Write a JSON report of the run to this file")
+	fs.BoolVar(&config.TrimFields, "trim-fields", false, "Remove leading and trailing whitespace from fields")
+	fs.BoolVar(&config.CollapseWhitespace, "collapse-whitespace", false, "Replace every run of whitespace within fields with a single space")
+	fs.StringVar(&config.NormalizeCase, "normalize-case", "", "Convert fields to upper or lower case")
+	fs.StringVar(&config.NormalizeColumns, "normalize-columns", "", "Comma-separated columns -trim-fields, -collapse-whitespace, and -normalize-case apply to (default: all)")
+	fs.Var(&listValue{&config.Replaces, checkReplace}, "replace", "Rule column:/pattern/replacement/ replacing the matches of a regular expression in a column, repeatable")
+	fs.Var(&listValue{&config.ReparseDates, checkDateRule}, "reparse-date", "Rule column:inputLayout=>outputLayout rewriting the dates of the column, repeatable")
+	fs.StringVar(&config.NullValues, "null-values", "", "Comma-separated spellings of null, such as NULL,N/A,- (a trailing comma adds the empty field), read as empty fields")
+	fs.StringVar(&config.NullOutput, "null-output", "", "Write empty fields, and those read as -null-values, as this value")
+	fs.StringVar(&config.Types, "types", "", "Comma-separated column:type hints coercing values to int, decimal, bool, date, or datetime and rejecting records that do not parse")
+	fs.StringVar(&config.Join, "join", "", "Rule 'lookup.csv on column' appending the columns of the lookup file row with the same key to each record")
+	fs.Var(&listValue{&config.Masks, checkMask}, "mask", "Rule column:method masking the values of a sensitive column with hash, redact, or fake, repeatable; hash and fake are keyed by -hmac-key-file or SPLITCSV_HMAC_KEY, and without a key go by a plain hash that does not anonymise guessable values")
+	fs.Var(&listValue{&config.HashColumns, checkHashColumn}, "hash-column", "Rule column:algorithm replacing the values of a column with their hmac-sha256 or hmac-sha512 in hexadecimal, repeatable")
+	fs.StringVar(&config.HMACKeyFile, "hmac-key-file", "", "File holding the key of -hash-column and of the hash and fake -mask methods (default: the SPLITCSV_HMAC_KEY environment variable)")
+	fs.StringVar(&config.RowNumberColumn, "add-row-number", "", "Add a first column of this name holding the input record number of each record, across all parts")
+	fs.StringVar(&config.PartColumn, "add-part-column", "", "Add a column of this name holding the number of the part each record is written to")
+	fs.StringVar(&config.PartRowColumn, "add-part-row-column", "", "Add a column of this name holding the row number of each record within its part")
+	fs.StringVar(&config.SourceColumn, "source-column", "", "Add a column of this name holding the input file each record came from")
+	fs.StringVar(&config.RecordPath, "record", "", "Record the decisions of the run, without any input values, to this file for the replay subcommand")
+	fs.StringVar(&config.LineagePath, "lineage", "", "Write the part and part record number of every input record to this CSV file, gzipped if it ends in .gz")
+	fs.StringVar(&config.LogFormat, "log-format", "text", "Log format: text, or json for structured logs on stderr")
+	fs.StringVar(&config.MetricsAddr, "metrics-addr", "", "Expose Prometheus metrics at /metrics on this address during the run")
+	fs.BoolVar(&config.MarkDir, "mark-output-dir", false, "Tag the output directory so indexers and scanners skip it (CACHEDIR.TAG and OS attributes)")
+	fs.StringVar(&config.Checksum, "checksums", "", "Write a checksum sidecar file for each part (md5, sha1, sha256, sha512)")
+	fs.StringVar(&config.Encrypt, "encrypt", "", "Encrypt every part with age as it is written: age:FILE for the public keys in FILE, or passphrase for that of $"+passphraseEnv+", or passphrase:FILE")
+	fs.StringVar(&config.Sign, "sign", "", "Write a detached signature of every part, and of a manifest of the parts, as {file}.sig: gpg:KEYID, or minisign:KEYFILE")
+	fs.StringVar(&config.Bundle, "bundle", "", "Move every part into one archive, {prefix}.{format}, as soon as it is complete: zip, tar, or tar.gz (-archive is the directory of -watch instead)")
+	fs.BoolVar(&config.BagIt, "bagit", false, "Package the parts as a BagIt bag, with the parts under data/ and SHA-256 manifests")
+	fs.BoolVar(&config.Incremental, "incremental", false, "Emit only rows inserted, updated, or deleted since the previous run, with an op column")
+	fs.StringVar(&config.KeyColumns, "key", "", "Comma-separated key columns identifying rows, recorded per part in the report and required in incremental mode")
+	fs.StringVar(&config.AlignWith, "align-with", "", "Place part boundaries at the same input records as the run that wrote this -report file")
+	fs.StringVar(&config.StateDir, "state-dir", "", "Keep the incremental index on disk in this directory instead of a sidecar file")
+	fs.DurationVar(&config.Deadline, "deadline", 0, "Stop after this long (e.g. 2h), finishing the current part and writing a checkpoint to -resume from")
+	fs.BoolVar(&config.Resume, "resume", false, "Continue a run stopped by -deadline or a signal from its checkpoint")
+
+	config.Delimiter = ','
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character")
+	fs.StringVar(&config.InputFormat, "input-format", "csv", "Format of the input files: csv, or fwf for fixed-width records cut into columns by -fwf-spec")
+	fs.StringVar(&config.InputEncoding, "input-encoding", "utf-8", "Encoding of the input files, such as utf-16le, latin1, or windows-1252, or auto to detect it; the parts are written in UTF-8")
+	fs.StringVar(&config.FWFSpec, "fwf-spec", "", "JSON file giving the name, start, and width of every column of -input-format fwf")
+	fs.BoolVar(&config.LazyQuotes, "lazy-quotes", true, "Accept quotes within unquoted fields and unescaped quotes within quoted fields")
+	fs.BoolVar(&config.TrimLeadingSpace, "trim-leading-space", true, "Drop the leading whitespace of fields as they are read")
+	fs.BoolVar(&config.Repair, "repair", false, "Repair malformed input as it is read: strip NUL bytes, close unbalanced quotes, and pad or truncate records to the width of the header")
+	fs.BoolVar(&config.Pedantic, "pedantic", false, "Read the input strictly, turning off -lazy-quotes and -trim-leading-space")
+	fs.Var((*runeValue)(&config.Comment), "comment", "Skip the input lines starting with this character as comments")
+	fs.BoolVar(&config.KeepComments, "keep-comments", false, "Copy the comment lines before the header to the top of every part")
+	fs.Var((*runeValue)(&config.OutputDelimiter), "output-delimiter", "Delimiter character of the output parts (default: the input delimiter)")
+	fs.StringVar(&config.OutputLineEnding, "output-line-ending", "lf", "Line ending of the output parts: lf, or crlf for Windows and Excel")
+	fs.BoolVar(&config.Passthrough, "passthrough", false, "Write the header and records byte for byte as they were read, only splitting them into parts")
+	fs.BoolVar(&config.Mmap, "mmap", false, "Read the input files through a memory mapping, taking -passthrough records straight from it")
+	fs.StringVar(&config.QuoteStyle, "quote-style", "minimal", "Quoting of the fields of csv parts: minimal, all, non-numeric, or none")
+	fs.BoolFunc("crlf", "End the lines of the output parts with CRLF (shorthand for -output-line-ending crlf)", func(value string) error {
+		crlf, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		config.OutputLineEnding = "lf"
+		if crlf {
+			config.OutputLineEnding = "crlf"
+		}
+		return nil
+	})
+}
+
+// listValue is a repeatable flag whose values are checked by parse as they
+// are set and collected in list
+type listValue struct {
+	list  *[]string
+	parse func(value string) error
+}
+
+func (v *listValue) String() string {
+	if v == nil || v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, " ")
+}
+
+func (v *listValue) Set(value string) error {
+	if err := v.parse(value); err != nil {
+		return err
+	}
+	*v.list = append(*v.list, value)
+	return nil
+}
+
+// reset forgets the values set so far
+func (v *listValue) reset() {
+	*v.list = nil
+}
+
+// runeValue is a flag.Value holding a single character, where \t or tab
+// stands for a tab. Other values that are not exactly one byte long fall back
+// to a comma.
+type runeValue rune
+
+func (r *runeValue) String() string {
+	return string(rune(*r))
+}
+
+func (r *runeValue) Set(value string) error {
+	if len(value) == 1 {
+		*r = runeValue(value[0])
+	} else if value == `\t` || value == "tab" {
+		*r = '\t'
+	} else {
+		*r = ','
+	}
+	return nil
+}
+
 // validateConfig validates the configuration
 func validateConfig(config Config) error {
-	if config.InputPath == "" {
-		return fmt.Errorf("input file path is required")
+	if config.Mmap && !mmapSupported {
+		return fmt.Errorf("mmap is not supported on this platform")
 	}
-
-	if config.MaxRecords <= 0 {
-		return fmt.Errorf("limit must be greater than 0")
+	if config.FilterMode {
+		if config.InputPath != "" || config.WatchDir != "" {
+			return fmt.Errorf("filter-mode reads stdin and cannot be combined with -input or -watch")
+		}
+		if config.Mmap {
+			return fmt.Errorf("mmap cannot be combined with filter-mode, as stdin cannot be mapped")
+		}
+		return validateOutputConfig(config)
 	}
 
-	if config.BufferSize <= 0 {
-		return fmt.Errorf("buffer size must be greater than 0")
+	if config.InputPath == "" {
+		return fmt.Errorf("input file path is required")
 	}
 
-	// Check if input file exists and is readable
-	if _, err := os.Stat(config.InputPath); os.IsNotExist(err) {
-		return fmt.Errorf("input file does not exist: %s", config.InputPath)
+	if err := validateOutputConfig(config); err != nil {
+		return err
 	}
 
-	// Ensure output directory exists
-	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// Check if the input files exist and are readable
+	files, err := inputFiles(config)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := statInput(file); os.IsNotExist(err) {
+			return withExitCode(exitInputNotFound, fmt.Errorf("input file does not exist: %s", file))
+		} else if _, _, ok := zipInputPath(file); ok && err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// NewCSVSplitter creates a new CSV splitter with the given configuration
-func NewCSVSplitter(config Config) *CSVSplitter {
-	return &CSVSplitter{
-		config:     config,
-		partNumber: 1,
+// validateOutputConfig validates the options that control how records are
+// written, independent of where they are read from
+func validateOutputConfig(config Config) error {
+	if config.MaxRecords < 0 {
+		return fmt.Errorf("limit must not be negative")
 	}
-}
 
-// Split performs the CSV splitting operation
-func (s *CSVSplitter) Split() error {
-	file, err := s.openInputFile()
-	if err != nil {
+	if config.HardLimit != 0 {
+		if config.MaxRecords == 0 || config.HardLimit < config.MaxRecords {
+			return fmt.Errorf("hard-limit must be at least -limit, which must not be 0")
+		}
+		if len(parseKeyColumns(config.GroupBy)) == 0 && len(parseKeyColumns(config.KeyColumns)) == 0 {
+			return fmt.Errorf("hard-limit needs -group-by or -key to tell which records belong together")
+		}
+	}
+
+	if config.StartRow < 0 || config.MaxRows < 0 {
+		return fmt.Errorf("start-row and max-rows must not be negative")
+	}
+	if config.Incremental && (config.StartRow > 1 || config.MaxRows > 0) {
+		return fmt.Errorf("start-row and max-rows cannot be combined with incremental, which would take the records outside the range as deleted")
+	}
+
+	if config.Ratio == "" && config.StratifyBy != "" {
+		return fmt.Errorf("stratify-by is only used with -ratio")
+	}
+	if config.HashKey == "" && config.Partitions != 0 {
+		return fmt.Errorf("partitions is only used with -hash-key")
+	}
+	if err := validateTransformConfig(config); err != nil {
+		return err
+	}
+	if len(config.HashColumns) > 0 || config.HMACKeyFile != "" {
+		if _, err := loadHMACKey(config); err != nil {
+			return err
+		}
+	}
+	if err := validateCommentConfig(config); err != nil {
+		return err
+	}
+	if err := validateFooterConfig(config); err != nil {
+		return err
+	}
+	if err := validateHeaderConfig(config); err != nil {
+		return err
+	}
+	if err := validateFWFConfig(config); err != nil {
+		return err
+	}
+	if err := validateEncodingConfig(config); err != nil {
+		return err
+	}
+	if err := validateWidthConfig(config); err != nil {
+		return err
+	}
+	if config.Passthrough {
+		if err := validatePassthroughConfig(config); err != nil {
+			return err
+		}
+	}
+	if config.Shuffle {
+		if err := validateShuffleConfig(config); err != nil {
+			return err
+		}
+	}
+	if len(config.Routes) > 0 {
+		if err := validateRoutesConfig(config); err != nil {
+			return err
+		}
+	}
+	if err := validatePriorityConfig(config); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	reader := s.createReader(file)
-	header, err := s.readHeader(reader)
-	if err != nil {
+	if config.BufferSize <= 0 {
+		return fmt.Errorf("buffer size must be greater than 0")
+	}
+	if config.ReadBufferSize < 0 || config.WriteBufferSize < 0 {
+		return fmt.Errorf("read-buffer and write-buffer must not be negative")
+	}
+	if config.SpaceFactor < 0 {
+		return fmt.Errorf("space-factor must not be negative")
+	}
+	if err := validateMemoryConfig(config); err != nil {
+		return err
+	}
+	if err := validateWorkersConfig(config); err != nil {
+		return err
+	}
+	if err := validateFSProfile(config); err != nil {
 		return err
 	}
 
-	if s.config.Verbose {
-		fmt.Printf("Starting to split CSV file: %s\n", s.config.InputPath)
-		fmt.Printf("Max records per file: %d\n", s.config.MaxRecords)
+	if config.OnError != "fail" && config.OnError != "quarantine" {
+		return fmt.Errorf("on-error must be fail or quarantine")
 	}
 
-	recordCount := 0
-	totalRecords := 0
+	switch config.StrictAction {
+	case "fail", "skip", "pad", "truncate":
+	default:
+		return fmt.Errorf("strict-action must be fail, skip, pad, or truncate")
+	}
 
-	// Create first output file
-	if err := s.createNewFile(header); err != nil {
+	if err := validateOutputFormat(config); err != nil {
+		return err
+	}
+	if err := validatePgConfig(config); err != nil {
+		return err
+	}
+	if err := validateLoadSQLConfig(config); err != nil {
+		return err
+	}
+	if err := validateKafkaConfig(config); err != nil {
+		return err
+	}
+	if err := validateNotifyConfig(config); err != nil {
+		return err
+	}
+	if err := validateBundleConfig(config); err != nil {
+		return err
+	}
+	if err := validateEncryptConfig(config); err != nil {
+		return err
+	}
+	if err := validateSignConfig(config); err != nil {
 		return err
 	}
-	defer s.closeCurrentFile()
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return fmt.Errorf("log-format must be text or json")
+	}
+	if config.JSON {
+		if config.FilterMode {
+			return fmt.Errorf("json cannot be combined with filter-mode, which writes the records to stdout")
 		}
-		if err != nil {
-			return fmt.Errorf("error reading record at line %d: %w", totalRecords+2, err)
+		if config.Verbose && config.LogFormat != "json" {
+			return fmt.Errorf("verbose output would mix with the json result on stdout; use -log-format json to log to stderr")
 		}
+	}
 
-		totalRecords++
+	if config.SchemaPath != "" {
+		if _, err := loadSchema(config.SchemaPath); err != nil {
+			return err
+		}
+	}
 
-		// Skip empty records if configured
-		if s.config.SkipEmpty && s.isEmptyRecord(record) {
-			continue
+	if config.Incremental && len(parseKeyColumns(config.KeyColumns)) == 0 {
+		return fmt.Errorf("incremental mode requires -key")
+	}
+	if !config.Incremental && config.StateDir != "" {
+		return fmt.Errorf("state-dir is only used with -incremental")
+	}
+
+	if config.Checksum != "" {
+		if _, err := newChecksumHash(config.Checksum); err != nil {
+			return err
+		}
+		if config.BagIt {
+			return fmt.Errorf("checksums cannot be combined with bagit; the bag manifest already covers every part")
 		}
+	}
 
-		// Check if we need to create a new file
-		if recordCount >= s.config.MaxRecords {
-			if err := s.createNewFile(header); err != nil {
-				return err
-			}
-			recordCount = 0
+	if config.FilterMode {
+		if err := validateFilterConfig(config); err != nil {
+			return err
 		}
+	}
 
-		// Write record to current file
-		if err := s.writer.Write(record); err != nil {
-			return fmt.Errorf("error writing record at line %d: %w", totalRecords+1, err)
+	// Ensure output directory exists
+	if err := os.MkdirAll(partDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if config.Deadline < 0 {
+		return fmt.Errorf("deadline must not be negative")
+	}
+	if config.AlignWith != "" {
+		if config.Incremental {
+			return fmt.Errorf("align-with cannot be combined with incremental")
 		}
-		recordCount++
+		if _, err := loadAlignment(config.AlignWith); err != nil {
+			return err
+		}
+	}
+	if config.Resume && (config.Incremental || config.BagIt || config.Clean || config.Bundle != "") {
+		return fmt.Errorf("resume cannot be combined with -incremental, -bagit, -clean, or -bundle")
 	}
 
-	if s.config.Verbose {
-		fmt.Printf("Processed %d total records\n", totalRecords)
+	if config.FilterMode {
+		// No parts are written to the output directory
+		return nil
+	}
+
+	if _, routed := routedMode(config); routed {
+		if err := validateRoutedConfig(config); err != nil {
+			return err
+		}
+	} else if err := prepareOutputDir(config); err != nil {
+		return err
+	}
+	if err := prepareRunFiles(config); err != nil {
+		return err
+	}
+	if config.Bundle != "" {
+		if err := prepareBundle(config); err != nil {
+			return err
+		}
+	}
+
+	if config.MarkDir {
+		if err := markOutputDir(config.OutputDir); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// openInputFile opens the input CSV file with buffering
-func (s *CSVSplitter) openInputFile() (*os.File, error) {
-	file, err := os.Open(s.config.InputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open input CSV file '%s': %w", s.config.InputPath, err)
+// NewCSVSplitter creates a new CSV splitter with the given configuration
+func NewCSVSplitter(config Config) *CSVSplitter {
+	if _, routed := routedMode(config); routed || config.FilterMode {
+		// The outputs never rotate
+		config.MaxRecords = 0
 	}
-	return file, nil
+	if config.InputFormat == "fwf" {
+		// Fixed-width records have no header line; the spec names the columns
+		config.Header = "no"
+	}
+	fsName := detectFSProfile(config)
+	fs := fsProfiles[fsName]
+	if config.ReadBufferSize == 0 {
+		config.ReadBufferSize = config.BufferSize
+	}
+	if config.WriteBufferSize == 0 {
+		config.WriteBufferSize = config.BufferSize
+	}
+	// Network file systems cap the writes, which are sent as they are
+	// buffered, but not the reads
+	if fs.maxBuffer > 0 {
+		config.BufferSize = min(config.BufferSize, fs.maxBuffer)
+		config.WriteBufferSize = min(config.WriteBufferSize, fs.maxBuffer)
+	}
+	if budget := newMemoryBudget(config); budget.buffer > 0 {
+		config.BufferSize = min(config.BufferSize, budget.buffer)
+		config.ReadBufferSize = min(config.ReadBufferSize, budget.buffer)
+		config.WriteBufferSize = min(config.WriteBufferSize, budget.writeBuffer)
+	}
+	s := &CSVSplitter{
+		config:     config,
+		partNumber: 1,
+		logger:     newLogger(config),
+		metrics:    newSplitMetrics(),
+		fs:         fs,
+		fsName:     fsName,
+		limits:     newIOLimits(config),
+	}
+	if config.OnWidthMismatch != "" {
+		s.widths = &widthCounts{}
+	}
+	return s
 }
 
-// createReader creates a CSV reader with the configured options
-func (s *CSVSplitter) createReader(file *os.File) *csv.Reader {
-	reader := csv.NewReader(file)
-	reader.Comma = s.config.Delimiter
-	reader.LazyQuotes = true
-	reader.TrimLeadingSpace = true
-	return reader
+// Split performs the CSV splitting operation
+func (s *CSVSplitter) Split() error {
+	return s.SplitContext(context.Background())
 }
 
-// readHeader reads and validates the CSV header
-func (s *CSVSplitter) readHeader(reader *csv.Reader) ([]string, error) {
-	header, err := reader.Read()
+// SplitContext performs the CSV splitting operation, stopping early with the
+// context's error if ctx is canceled
+func (s *CSVSplitter) SplitContext(ctx context.Context) error {
+	if err := s.loadInputFormat(); err != nil {
+		return err
+	}
+	if s.config.FilterMode {
+		s.source = "-"
+		return s.SplitReader(ctx, os.Stdin)
+	}
+	files, err := inputFiles(s.config)
 	if err != nil {
-		if err == io.EOF {
-			return nil, fmt.Errorf("input file is empty")
-		}
-		return nil, fmt.Errorf("failed to read header: %w", err)
+		return err
 	}
-
-	if len(header) == 0 {
-		return nil, fmt.Errorf("header is empty")
+	if err := s.checkDiskSpace(files); err != nil {
+		return err
 	}
-
-	return header, nil
-}
-
-// isEmptyRecord checks if a record contains only empty fields
-func (s *CSVSplitter) isEmptyRecord(record []string) bool {
-	for _, field := range record {
-		if field != "" {
-			return false
+	if len(files) > 1 {
+		return s.splitFiles(ctx, files)
+	}
+	s.source = s.sourceName(s.config.InputPath)
+	file, err := s.openInputFile()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if s.config.Mmap {
+		r, err := s.mapInput(file)
+		if err != nil {
+			return err
 		}
+		defer s.unmapInput()
+		return s.SplitReader(ctx, r)
 	}
-	return true
+	return s.SplitReader(ctx, file)
 }
 
-// createNewFile creates a new output file and initializes the writer
-func (s *CSVSplitter) createNewFile(header []string) error {
-	// Close previous file if it exists
-	s.closeCurrentFile()
-
-	// Generate output filename
-	filename := fmt.Sprintf("%s_%d.csv", s.config.OutputPrefix, s.partNumber)
-	filepath := filepath.Join(s.config.OutputDir, filename)
+// SplitReader splits the CSV read from r, such as a stream received over the
+// network, stopping early with the context's error if ctx is canceled
+func (s *CSVSplitter) SplitReader(ctx context.Context, r io.Reader) error {
+	s.stats.startedAt = time.Now()
 
-	// Create the output file
-	outFile, err := os.Create(filepath)
+	reader := s.inputReader(r)
+	header, err := s.readHeader(reader)
 	if err != nil {
-		return fmt.Errorf("failed to create output file '%s': %w", filepath, err)
+		return err
 	}
+	s.takeComments()
+	s.passHeader(header)
 
-	// Create CSV writer
-	s.outFile = outFile
-	s.writer = csv.NewWriter(outFile)
-	s.writer.Comma = s.config.Delimiter
-
-	// Write header to new file
-	if err := s.writer.Write(header); err != nil {
-		s.closeCurrentFile()
-		return fmt.Errorf("failed to write header to file '%s': %w", filepath, err)
+	if s.logger != nil {
+		s.logger.Info("split started", "input", s.config.InputPath, "limit", s.config.MaxRecords, "fs_profile", s.fsName)
+	} else if s.config.Verbose {
+		fmt.Printf("Starting to split CSV file: %s\n", s.config.InputPath)
+		fmt.Printf("Run ID: %s\n", s.config.RunID)
+		fmt.Printf("File system profile: %s\n", s.fsName)
+		fmt.Printf("Max records per file: %s\n", limitText(s.config.MaxRecords))
 	}
 
-	if s.config.Verbose {
+	if s.config.Header == "no" {
+		return s.splitRecords(ctx, header, &firstRecordReader{s, reader})
+	}
+	return s.splitRecords(ctx, header, reader)
+}
+
+// recordReader is a source of records following a header
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// splitRecords writes the records from reader into rotating output files,
+// each starting with header
+func (s *CSVSplitter) splitRecords(ctx context.Context, header []string, reader recordReader) error {
+	totalRecords := 0
+	s.stats.columns = len(header)
+	defer func() { s.stats.records = totalRecords }()
+
+	// Records already split by the run being resumed are read and dropped
+	skip, loaded, produced := 0, 0, 0
+	var completed []PartInfo
+	if s.config.Resume {
+		checkpoint, err := loadCheckpoint(s.config)
+		if err != nil {
+			return err
+		}
+		if checkpoint.Input != s.config.InputPath {
+			return configErrorf("checkpoint is for input '%s', not '%s'", checkpoint.Input, s.config.InputPath)
+		}
+		skip, loaded, produced = checkpoint.ResumeAt, checkpoint.PgLoaded, checkpoint.KafkaProduced
+		completed = checkpoint.CompletedParts
+		s.partStart = skip
+		s.partNumber = checkpoint.NextPart
+		if s.logger != nil {
+			s.logger.Info("resuming split", "records", skip, "part", s.partNumber)
+		} else if s.config.Verbose {
+			fmt.Printf("Resuming after %d records at part %d\n", skip, s.partNumber)
+		}
+	}
+	if s.config.PgDSN != "" {
+		var err error
+		if s.pg, err = newPgLoader(ctx, s.config); err != nil {
+			return err
+		}
+		defer s.pg.Close()
+		// The records of the part cut short that were already loaded
+		s.pg.skip = loaded
+	}
+	if s.config.KafkaBrokers != "" {
+		var err error
+		if s.kafka, err = newKafkaProducer(ctx, s.config); err != nil {
+			return err
+		}
+		defer s.kafka.Close()
+		s.kafka.skip = produced
+	}
+	if s.config.Encrypt != "" {
+		var err error
+		if s.recipients, err = loadRecipients(s.config); err != nil {
+			return err
+		}
+	}
+	if s.config.Sign != "" {
+		var err error
+		if s.signer, err = newPartSigner(s.config); err != nil {
+			return err
+		}
+		// The manifest lists the parts of the run being resumed too
+		s.signer.previous = completed
+	}
+
+	// So are the records before -start-row, and reading stops after the
+	// -max-rows records from there
+	if s.config.StartRow-1 > skip {
+		skip = s.config.StartRow - 1
+		s.partStart = skip
+	}
+	end := 0
+	if s.config.MaxRows > 0 {
+		end = max(s.config.StartRow-1, 0) + s.config.MaxRows
+	}
+	if s.config.StartRow > 1 || end > 0 {
+		if s.logger != nil {
+			s.logger.Info("row range", "start_row", max(s.config.StartRow, 1), "end_row", end)
+		} else if s.config.Verbose {
+			fmt.Printf("Splitting records %d to %s\n", max(s.config.StartRow, 1), rowRangeEnd(end))
+		}
+	}
+
+	if s.config.SchemaPath != "" {
+		schema, err := loadSchema(s.config.SchemaPath)
+		if err != nil {
+			return err
+		}
+		if s.validator, err = newSchemaValidator(schema, header); err != nil {
+			return err
+		}
+	}
+
+	s.nulls = parseNullValues(s.config.NullValues)
+	transforms, err := s.newTransforms(header)
+	if err != nil {
+		return err
+	}
+	types, err := s.newTypes(header)
+	if err != nil {
+		return err
+	}
+	masks, err := s.newMasks(header)
+	if err != nil {
+		return err
+	}
+	s.masks, s.maskHeader = masks, header
+
+	quarantine := s.config.OnError == "quarantine"
+	chain := s.schemaChain()
+	if quarantine || len(chain) > 0 || len(types) > 0 {
+		rejectsPath := filepath.Join(s.config.OutputDir, s.config.OutputPrefix+"_rejects.csv")
+		s.rejects = newRejectWriter(rejectsPath, s.config.Delimiter)
+		s.rejects.sourceColumn = s.config.SourceColumn
+		s.rejects.appendOnly = s.config.Resume
+		defer s.rejects.Close()
+	}
+
+	if s.config.LineagePath != "" {
+		var err error
+		if s.lineage, err = newLineageWriter(s.config.LineagePath, s.config.Resume, s.config.SourceColumn); err != nil {
+			return err
+		}
+		defer s.lineage.Close()
+	}
+
+	if s.config.BagIt {
+		s.bag = newBagBuilder(s.config.OutputDir)
+	}
+	if s.config.Bundle != "" {
+		var err error
+		if s.bundle, err = newPartBundle(s.config); err != nil {
+			return err
+		}
+		// A run that fails keeps the parts completed before it
+		defer s.bundle.finish(true)
+	}
+
+	if s.config.AlignWith != "" {
+		var err error
+		if s.align, err = loadAlignment(s.config.AlignWith); err != nil {
+			return err
+		}
+	}
+
+	var join *lookupJoin
+	if s.config.Join != "" {
+		if join, err = s.newLookupJoin(header); err != nil {
+			return err
+		}
+	}
+
+	s.recordHeader(header)
+	if join != nil {
+		header = append(slices.Clip(header), join.columns...)
+	}
+	if s.config.SourceColumn != "" {
+		if s.lineage != nil {
+			s.lineage.source = len(header)
+		}
+		header = append(slices.Clip(header), s.config.SourceColumn)
+	}
+
+	if s.keys, err = keyIndexes(header, s.config.KeyColumns); err != nil {
+		return err
+	}
+	if s.groups, err = keyIndexes(header, groupColumns(s.config)); err != nil {
+		return err
+	}
+	indexPath := filepath.Join(s.config.OutputDir, s.config.OutputPrefix+".index")
+	if s.config.Incremental {
+		s.delta, err = newDeltaTracker(s.config, indexPath, header)
+		if err != nil {
+			return err
+		}
+		defer s.delta.Close()
+		header = append([]string{opColumn}, header...)
+		// Written records start with the op column
+		for i := range s.keys {
+			s.keys[i]++
+		}
+		for i := range s.groups {
+			s.groups[i]++
+		}
+		if s.lineage != nil && s.lineage.source >= 0 {
+			s.lineage.source++
+		}
+	}
+
+	for _, column := range partColumns(s.config) {
+		if slices.Contains(header, column) || column == s.config.RowNumberColumn {
+			return configErrorf("column %q is already in the header", column)
+		}
+		header = append(slices.Clip(header), column)
+	}
+
+	if s.config.RowNumberColumn != "" && slices.Contains(header, s.config.RowNumberColumn) {
+		return configErrorf("column %q is already in the header", s.config.RowNumberColumn)
+	}
+
+	// Create first output file, or every output of a routed split
+	if _, routed := routedMode(s.config); routed {
+		if s.routed, err = s.newRoutedSplit(header); err != nil {
+			return err
+		}
+		defer s.routed.discard()
+	} else if err := s.createNewFile(header); err != nil {
+		return err
+	}
+	defer s.discardCurrentFile()
+
+	if len(s.config.Routes) > 0 {
+		if s.streams, err = s.newRecordStreams(header); err != nil {
+			return err
+		}
+		defer s.streams.discard()
+	}
+
+	// Records of a -shuffle run are held until all are read
+	if s.config.Shuffle {
+		s.shuffle = s.newShuffler()
+		defer s.shuffle.Close()
+	}
+
+	// The footer of the input is held back from the records
+	footer := s.newFooterReader(reader)
+	if footer != nil {
+		reader = footer
+		if s.config.FooterAction == "every" {
+			path, err := inputFooterPath(s.config)
+			if err != nil {
+				return err
+			}
+			if s.footer, err = s.readFileFooter(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	stages := &recordTransforms{transforms, types, masks}
+	pool := s.newWorkerPool(stages)
+	// process takes a record from its transforms to its part
+	process := func(p *pendingRecord) error {
+		// Fail the run on a transform that failed, or quarantine the record
+		if p.err != nil && !p.typed {
+			err := s.fieldError(p.fields, p.column, p.err)
+			if quarantine {
+				return s.reject(p.number+1, p.record, err)
+			}
+			return fmt.Errorf("error reading record at line %d: %w", p.number+1, err)
+		}
+
+		// Coerce typed columns, rejecting records whose values do not parse
+		if p.err != nil {
+			err := s.fieldError(p.fields, p.column, p.err)
+			s.countTypeFailure(err)
+			return s.reject(p.number+1, p.transformed, err)
+		}
+		record := p.coerced
+
+		// Route schema violations to the rejects file, and records failing
+		// other validators as they choose
+		if len(chain) > 0 {
+			keep, err := s.validate(chain, p.number+1, p.fields, header, record)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				return nil
+			}
+		}
+
+		// Write the record with its sensitive columns masked once it is
+		// validated
+		record = p.masked
+		if join != nil {
+			record = join.apply(record)
+		}
+		if s.config.NullOutput != "" {
+			record = s.writeNulls(record)
+		}
+
+		// Find the period of the record in a -time-column split
+		if s.routed != nil && s.routed.window != nil {
+			if err := s.routed.window.parse(record); err != nil {
+				err = s.fieldError(p.fields, s.routed.window.column, err)
+				if quarantine {
+					return s.reject(p.number+1, record, err)
+				}
+				return fmt.Errorf("error reading record at line %d: %w", p.number+1, err)
+			}
+		}
+
+		if s.config.SourceColumn != "" {
+			record = append(record, s.source)
+		}
+
+		// Emit only changed rows in incremental mode
+		if s.delta != nil {
+			op, err := s.delta.Diff(record)
+			if err != nil {
+				err = s.fieldError(p.fields, 0, err)
+			}
+			if err != nil && quarantine {
+				return s.reject(p.number+1, record, err)
+			}
+			if err != nil {
+				return fmt.Errorf("error reading record at line %d: %w", p.number+1, err)
+			}
+			if op == "" {
+				return nil
+			}
+			record = append([]string{op}, record...)
+		}
+
+		if s.shuffle != nil {
+			return s.shuffle.add(p.number, record)
+		}
+		if err := s.writeRecord(header, record); err != nil {
+			return fmt.Errorf("error writing record at line %d: %w", p.number+1, err)
+		}
+
+		if s.validator != nil {
+			s.validator.Tally(filepath.Base(s.outPath), nil)
+		}
+		return nil
+	}
+
+	for {
+		if end > 0 && totalRecords >= end {
+			break
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if s.raw != nil && footer == nil {
+			s.raw.record = s.rawRecord()
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && quarantine {
+			totalRecords++
+			s.metrics.recordsRead.Add(1)
+			if totalRecords <= skip {
+				continue
+			}
+			if err := pool.flush(process); err != nil {
+				return err
+			}
+			reason := &locatedError{s.locate(parseErr.Line, parseErr.Column), parseErr.Err}
+			if err := s.reject(parseErr.StartLine, record, reason); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", totalRecords+2, s.locateError(err))
+		}
+		fields := len(record)
+
+		totalRecords++
+		s.metrics.recordsRead.Add(1)
+		s.recordsRead = totalRecords
+		if totalRecords <= skip {
+			continue
+		}
+
+		if s.repairs != nil {
+			record = s.repairs.fit(record, s.stats.columns)
+		}
+
+		if s.config.Strict || s.widths != nil {
+			fitted, err := s.fitRecordWidth(record, s.stats.columns)
+			if err != nil {
+				err = s.fieldError(fields, s.stats.columns, err)
+			}
+			if err != nil || fitted == nil {
+				// Records still transforming come first
+				if err := pool.flush(process); err != nil {
+					return err
+				}
+			}
+			if err != nil && quarantine {
+				if err := s.reject(totalRecords+1, record, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error reading record at line %d: %w", totalRecords+1, err)
+			}
+			if fitted == nil {
+				s.skip(totalRecords+1, csv.ErrFieldCount.Error())
+				continue
+			}
+			record = fitted
+		}
+
+		if totalRecords%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				if err := pool.flush(process); err != nil {
+					return err
+				}
+				return s.interrupt(err, totalRecords-1)
+			}
+		}
+
+		// Skip empty records if configured
+		if s.config.SkipEmpty && s.isEmptyRecord(record) {
+			if err := pool.flush(process); err != nil {
+				return err
+			}
+			s.skip(totalRecords+1, "empty record")
+			continue
+		}
+
+		// Transform the record, along with others on -workers
+		p := &pendingRecord{number: totalRecords, fields: fields, record: record}
+		if pool == nil {
+			stages.prepare(p)
+			if err := process(p); err != nil {
+				return err
+			}
+			continue
+		}
+		pool.add(p)
+		if pool.full() {
+			if err := pool.flush(process); err != nil {
+				return err
+			}
+		}
+	}
+	if err := pool.flush(process); err != nil {
+		return err
+	}
+	if footer != nil {
+		s.positions = nil
+		if s.logger != nil {
+			s.logger.Info("footer held back", "records", footer.dropped, "action", s.config.FooterAction)
+		} else if s.config.Verbose {
+			fmt.Printf("Footer: %d records held back (%s)\n", footer.dropped, s.config.FooterAction)
+		}
+		if s.config.FooterAction == "every" {
+			if err := s.checkFileFooter(footer.footer); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.shuffle != nil {
+		written := 0
+		err := s.shuffle.each(func(number int, record []string) error {
+			if written++; written%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return s.interrupt(err, totalRecords)
+				}
+			}
+			s.recordsRead = number
+			if err := s.writeRecord(header, record); err != nil {
+				return fmt.Errorf("error writing record at line %d: %w", number+1, err)
+			}
+			if s.validator != nil {
+				s.validator.Tally(filepath.Base(s.outPath), nil)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.delta != nil {
+		// Deleted rows have no input record
+		s.recordsRead = 0
+		err := s.delta.Deleted(func(record []string) error {
+			if err := s.writeRecord(header, append([]string{opDelete}, record...)); err != nil {
+				return fmt.Errorf("error writing deleted record: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.streams != nil && len(s.parts) == 1 && s.parts[0].Records == 0 {
+		// Every record went to a -route stream
+		s.discardCurrentFile()
+	}
+	if footer != nil && s.config.FooterAction == "last" {
+		s.footer = footer.footer
+	}
+	if err := s.closeCurrentFile(); err != nil {
+		return err
+	}
+	if s.routed != nil {
+		if err := s.routed.close(); err != nil {
+			return err
+		}
+	}
+	if s.streams != nil {
+		if err := s.streams.close(); err != nil {
+			return err
+		}
+	}
+	if s.rejects != nil {
+		if err := s.rejects.Close(); err != nil {
+			return err
+		}
+	}
+	if s.lineage != nil {
+		if err := s.lineage.Close(); err != nil {
+			return err
+		}
+	}
+	if s.signer != nil {
+		manifest, err := s.signer.writeManifest(s.config)
+		if err != nil {
+			return err
+		}
+		if s.bundle != nil {
+			if err := s.bundle.add(manifest, manifest+signSuffix); err != nil {
+				return err
+			}
+		}
+	}
+	if s.bundle != nil {
+		if err := s.bundle.finish(false); err != nil {
+			return err
+		}
+	}
+	var tagFiles []string
+	if s.rejects != nil && s.rejects.count > 0 {
+		tagFiles = append(tagFiles, s.rejects.path)
+	}
+	if s.validator != nil {
+		summaryPath := filepath.Join(s.config.OutputDir, s.config.OutputPrefix+"_validation.json")
+		if err := s.validator.WriteSummary(summaryPath); err != nil {
+			return err
+		}
+		tagFiles = append(tagFiles, summaryPath)
+	}
+	if s.delta != nil {
+		if err := s.delta.Save(); err != nil {
+			return err
+		}
+		if s.config.StateDir == "" {
+			tagFiles = append(tagFiles, indexPath)
+		}
+	}
+	if s.bag != nil {
+		s.stats.records = totalRecords
+		if err := s.finishBag(tagFiles); err != nil {
+			return err
+		}
+	}
+	if s.config.Resume {
+		os.Remove(checkpointPath(s.config))
+	}
+
+	if s.logger != nil {
+		rejected := 0
+		if s.rejects != nil {
+			rejected = s.rejects.count
+		}
+		s.logger.Info("split finished",
+			"records", totalRecords,
+			"written", s.stats.written,
+			"parts", len(s.parts),
+			"rejected", rejected,
+			"duration_seconds", time.Since(s.stats.startedAt).Seconds())
+		if len(s.stats.typeFailures) > 0 {
+			s.logger.Info("type failures", "columns", s.stats.typeFailures)
+		}
+		if s.repairs != nil {
+			s.logger.Info("input repaired", "nuls", s.repairs.nuls, "quotes", s.repairs.quotes,
+				"padded", s.repairs.padded, "truncated", s.repairs.truncated)
+		}
+		if s.widths != nil {
+			s.logger.Info("width mismatches", "policy", s.config.OnWidthMismatch, "padded", s.widths.padded,
+				"truncated", s.widths.truncated, "rejected", s.widths.rejected, "kept", s.widths.kept)
+		}
+		if join != nil {
+			s.logger.Info("lookup joined", "matched", join.matched, "unmatched", join.unmatched)
+		}
+	} else if s.config.Verbose {
+		fmt.Printf("Processed %d total records\n", totalRecords)
+		if s.delta != nil {
+			fmt.Printf("Changes: %d inserted, %d updated, %d deleted, %d unchanged\n", s.delta.counts.Inserted,
+				s.delta.counts.Updated, s.delta.counts.Deleted, s.delta.counts.Unchanged)
+		}
+		if len(s.stats.typeFailures) > 0 {
+			fmt.Printf("Type failures: %s\n", s.typeFailureSummary())
+		}
+		if s.repairs != nil {
+			fmt.Printf("Repaired: %s\n", s.repairs)
+		}
+		if s.widths != nil {
+			fmt.Printf("Width mismatches: %s\n", s.widths)
+		}
+		if len(s.encodings) > 0 {
+			fmt.Printf("Input encoding: %s\n", strings.Join(s.encodings, ", "))
+		}
+		if join != nil {
+			fmt.Printf("Joined: %d records matched, %d without a match\n", join.matched, join.unmatched)
+		}
+	}
+
+	return nil
+}
+
+// writeRecord writes record to the current part, starting a new part with
+// header first when the current one is full. Without a limit, every record
+// goes to the first part. With -align-with, parts end where the parts of the
+// previous run did, and -limit only applies past its last part.
+func (s *CSVSplitter) writeRecord(header, record []string) error {
+	if s.routed != nil {
+		return s.routed.write(record)
+	}
+	if s.streams != nil {
+		if name := s.streams.route(record); name != "" {
+			return s.streams.add(name, record)
+		}
+	}
+	// Index of the current part among the parts of this and resumed runs
+	current := func() int { return s.partNumber - 2 }
+	for s.align != nil && s.align.past(current(), s.recordsRead) {
+		if err := s.createNewFile(header); err != nil {
+			return err
+		}
+		s.partStart = s.recordsRead - 1
+	}
+	aligned := s.align != nil && current() < len(s.align.bounds)
+	if !aligned && s.config.MaxRecords > 0 && s.partFull(record) {
+		if err := s.createNewFile(header); err != nil {
+			return err
+		}
+		// The new part starts with the record being written
+		s.partStart = s.recordsRead - 1
+	}
+	out := record
+	if s.config.PartColumn != "" || s.config.PartRowColumn != "" {
+		out = s.withPartColumns(out)
+	}
+	if s.config.RowNumberColumn != "" {
+		out = append([]string{rowNumber(s.recordsRead)}, out...)
+	}
+	if err := s.writer.Write(out); err != nil {
+		return err
+	}
+
+	part := &s.parts[len(s.parts)-1]
+	if part.Records == 0 {
+		part.FirstRecord = s.recordsRead
+		part.FirstKey = s.recordKey(record)
+	}
+	if s.recordsRead > 0 {
+		part.LastRecord = s.recordsRead
+	}
+	part.Records++
+	if s.lineage != nil {
+		if err := s.lineage.Write(s.recordsRead, filepath.Base(s.outPath), part.Records, record); err != nil {
+			return err
+		}
+	}
+	s.lastWritten = record
+	s.stats.written++
+	s.metrics.recordsWritten.Add(1)
+	if s.align != nil && s.align.ends(current(), s.recordsRead) {
+		return s.align.check(current(), s.recordsRead, s.recordKey(record))
+	}
+	return nil
+}
+
+// groupColumns returns the columns whose records a part keeps together:
+// those of -group-by, or of -key when only -hard-limit is given
+func groupColumns(config Config) string {
+	if config.GroupBy == "" && config.HardLimit != 0 {
+		return config.KeyColumns
+	}
+	return config.GroupBy
+}
+
+// partFull reports whether the current part must end before record. Parts
+// end at -limit records, except that a record continuing the group of the
+// previous one is kept with it, until -hard-limit records if it is set.
+func (s *CSVSplitter) partFull(record []string) bool {
+	records := s.parts[len(s.parts)-1].Records
+	if records < s.config.MaxRecords {
+		return false
+	}
+	if len(s.groups) == 0 || s.config.HardLimit != 0 && records >= s.config.HardLimit {
+		return true
+	}
+	return !slices.Equal(fieldValues(s.groups, record), fieldValues(s.groups, s.lastWritten))
+}
+
+// recordKey returns the -key values of a written record, or nil without -key
+func (s *CSVSplitter) recordKey(record []string) []string {
+	return fieldValues(s.keys, record)
+}
+
+// fieldValues returns the values of record at the given indexes, or nil
+// without any
+func fieldValues(indexes []int, record []string) []string {
+	if len(indexes) == 0 {
+		return nil
+	}
+	values := make([]string, len(indexes))
+	for i, k := range indexes {
+		if k < len(record) {
+			values[i] = record[k]
+		}
+	}
+	return values
+}
+
+// openInputFile opens the input CSV file with buffering
+func (s *CSVSplitter) openInputFile() (inputFile, error) {
+	return s.openInputPath(s.config.InputPath)
+}
+
+// openInputPath opens an input CSV file, retrying stale handles as the file
+// system profile allows
+func (s *CSVSplitter) openInputPath(path string) (inputFile, error) {
+	var file inputFile
+	err := s.retryStale(func() (err error) {
+		file, err = openInput(path)
+		return err
+	})
+	return file, err
+}
+
+// openInput opens an input CSV file for sequential reading, tagging a
+// missing file with its own exit code. A path naming a zip archive opens
+// one of its entries, which is decompressed as it is read.
+func openInput(path string) (inputFile, error) {
+	if _, _, ok := zipInputPath(path); ok {
+		return openZipEntry(path)
+	}
+	file, err := openSequential(path)
+	if os.IsNotExist(err) {
+		return nil, withExitCode(exitInputNotFound, fmt.Errorf("failed to open input CSV file '%s': %w", path, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input CSV file '%s': %w", path, err)
+	}
+	return file, nil
+}
+
+// createReader creates a CSV reader with the configured options
+func (s *CSVSplitter) createReader(input io.Reader) *csv.Reader {
+	trim := s.config.TrimLeadingSpace && !s.config.Pedantic
+	if s.fwf != nil {
+		input = newFWFReader(input, s.fwf, s.config.Delimiter)
+	}
+	if s.config.Repair {
+		if s.repairs == nil {
+			s.repairs = &repairCounts{}
+		}
+		input = newRepairReader(input, s.config.Delimiter, trim, s.repairs)
+	}
+	reader := csv.NewReader(input)
+	reader.Comma = s.config.Delimiter
+	reader.Comment = s.config.Comment
+	reader.LazyQuotes = s.config.LazyQuotes && !s.config.Pedantic || s.config.Repair
+	reader.TrimLeadingSpace = trim
+	if s.config.Repair {
+		// Records are fitted to the header by the repair
+		reader.FieldsPerRecord = -1
+	}
+	if s.config.Strict || s.widths != nil {
+		// Field counts are checked against the header by fitRecordWidth
+		reader.FieldsPerRecord = -1
+	}
+	return reader
+}
+
+// readHeader reads and validates the CSV header
+func (s *CSVSplitter) readHeader(reader *csv.Reader) ([]string, error) {
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, withExitCode(exitParse, fmt.Errorf("input file is empty"))
+		}
+		if reader == s.input {
+			err = s.locateError(err)
+		}
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if len(header) == 0 {
+		return nil, withExitCode(exitParse, fmt.Errorf("header is empty"))
+	}
+
+	header, err = s.applySetHeader(reader, header)
+	if err != nil {
+		return nil, err
+	}
+	return s.checkHeaderNames(header)
+}
+
+// isEmptyRecord checks if a record contains only empty fields and
+// -null-values
+func (s *CSVSplitter) isEmptyRecord(record []string) bool {
+	for _, field := range record {
+		if field != "" && !s.isNull(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// fitRecordWidth applies the strict-mode action, or -on-width-mismatch, to a
+// record whose field count differs from width. It returns the record to
+// write, nil to skip it, or an error when the record must be rejected.
+func (s *CSVSplitter) fitRecordWidth(record []string, width int) ([]string, error) {
+	if len(record) == width {
+		return record, nil
+	}
+	if s.widths != nil {
+		return s.fitWidth(record, width)
+	}
+
+	switch {
+	case s.config.StrictAction == "skip":
+		return nil, nil
+	case s.config.StrictAction == "pad" && len(record) < width:
+		padded := make([]string, width)
+		copy(padded, record)
+		return padded, nil
+	case s.config.StrictAction == "truncate" && len(record) > width:
+		return record[:width], nil
+	}
+	return nil, fmt.Errorf("%w: record has %d fields, header has %d", csv.ErrFieldCount, len(record), width)
+}
+
+// partDir returns the directory output parts are written to, which is the
+// payload directory of the bag when packaging as BagIt
+func partDir(config Config) string {
+	if config.BagIt {
+		return filepath.Join(config.OutputDir, bagPayloadDir)
+	}
+	return config.OutputDir
+}
+
+// createNewFile creates a new output file and initializes the writer
+func (s *CSVSplitter) createNewFile(header []string) error {
+	// Close previous file if it exists
+	if err := s.closeCurrentFile(); err != nil {
+		return err
+	}
+
+	// Generate output filename
+	filename := fmt.Sprintf("%s_%d.%s", s.config.OutputPrefix, s.partNumber, partExtension(s.config))
+	if s.partName != "" {
+		filename = filepath.Base(outputPath(s.config, s.partName))
+	}
+	filepath := filepath.Join(partDir(s.config), filename)
+
+	// Create the output file under a temporary name until it is complete
+	var out io.Writer = os.Stdout
+	if s.config.FilterMode {
+		filepath = "-"
+	} else {
+		tmpPath := filepath + tmpSuffix
+		var outFile *os.File
+		err := s.retryStale(func() (err error) {
+			outFile, err = os.Create(tmpPath)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create output file '%s': %w", tmpPath, err)
+		}
+		s.outFile = outFile
+		s.tmpPath = tmpPath
+		out = outFile
+	}
+	if s.limits != nil {
+		out = &throttledWriter{out, s.limits.write}
+	}
+	s.outBuf = bufio.NewWriterSize(out, s.config.WriteBufferSize)
+	out = s.outBuf
+
+	// Create record writer
+	s.outPath = filepath
+	s.counter = &countingWriter{w: out, total: &s.metrics.bytesWritten}
+	var w io.Writer = s.counter
+	if s.config.Checksum != "" {
+		s.hash, _ = newChecksumHash(s.config.Checksum)
+		w = io.MultiWriter(s.counter, s.hash)
+	}
+	if s.bag != nil {
+		w = io.MultiWriter(w, s.bag.payloadWriter())
+	}
+	s.parts = append(s.parts, PartInfo{Path: filepath})
+	if s.recipients != nil {
+		// The checksums and sizes are those of the encrypted part
+		var err error
+		if s.encrypter, err = s.encryptPart(w); err != nil {
+			s.discardCurrentFile()
+			return err
+		}
+		w = s.encrypter
+	}
+	if s.raw != nil {
+		s.writer = &rawRecordWriter{w, s.raw}
+	} else {
+		s.writer = newRecordWriter(w, s.config)
+	}
+	if s.pg != nil {
+		s.writer = &pgRecordWriter{s.writer, s.pg}
+	}
+	if s.kafka != nil {
+		s.writer = &kafkaRecordWriter{s.writer, s.kafka, s.partNumber}
+	}
+
+	// Write header to new file, after the comments of -keep-comments
+	if len(s.comments) > 0 {
+		if _, err := w.Write(s.comments); err != nil {
+			s.discardCurrentFile()
+			return fmt.Errorf("failed to write comments to file '%s': %w", filepath, err)
+		}
+	}
+	if s.config.RowNumberColumn != "" {
+		header = append([]string{s.config.RowNumberColumn}, header...)
+	}
+	s.partHeader = header
+	if err := s.writer.WriteHeader(header); err != nil {
+		s.discardCurrentFile()
+		return fmt.Errorf("failed to write header to file '%s': %w", filepath, err)
+	}
+
+	s.record(sessionEvent{Event: "part", Name: s.partName, Part: s.partNumber, Record: s.recordsRead})
+	if s.logger != nil {
+		s.logger.Info("part created", "path", filepath, "part", s.partNumber)
+	} else if s.config.Verbose {
 		fmt.Printf("Created output file: %s\n", filepath)
 	}
 
 	s.partNumber++
+	s.metrics.partsCreated.Add(1)
 	return nil
 }
 
-// closeCurrentFile flushes and closes the current output file
-func (s *CSVSplitter) closeCurrentFile() {
+// closeCurrentFile flushes and closes the current output file, renames it from
+// its temporary name, and writes its checksum sidecar when configured
+func (s *CSVSplitter) closeCurrentFile() error {
+	var err error
+	sum := s.hash
+	s.hash = nil
 	if s.writer != nil {
-		s.writer.Flush()
+		if len(s.footer) > 0 {
+			err = s.writeFooter()
+		}
+		if cerr := s.writer.Close(); err == nil {
+			err = cerr
+		}
 		s.writer = nil
 	}
+	if s.encrypter != nil {
+		if cerr := s.encrypter.Close(); err == nil {
+			err = cerr
+		}
+		s.encrypter = nil
+	}
+	if s.outBuf != nil {
+		if err == nil {
+			err = s.outBuf.Flush()
+		}
+		s.outBuf = nil
+	}
 	if s.outFile != nil {
-		s.outFile.Close()
+		if err == nil && s.fs.syncParts {
+			err = s.outFile.Sync()
+		}
+		if cerr := s.outFile.Close(); err == nil {
+			err = cerr
+		}
 		s.outFile = nil
 	}
+	if err == nil && s.pg != nil && s.counter != nil {
+		err = s.pg.commit()
+	}
+	var offsets []KafkaOffsets
+	if err == nil && s.kafka != nil && s.counter != nil {
+		offsets, err = s.kafka.flush()
+	}
+	if err != nil {
+		if s.pg != nil {
+			s.pg.abort()
+		}
+		os.Remove(s.tmpPath)
+		s.counter = nil
+		s.parts = s.parts[:len(s.parts)-1]
+		return fmt.Errorf("failed to write output file '%s': %w", s.outPath, err)
+	}
+	if s.counter == nil {
+		return nil
+	}
+	if s.pg != nil {
+		s.logLoaded()
+	}
+	if s.kafka != nil {
+		s.parts[len(s.parts)-1].Kafka = offsets
+		s.logProduced(offsets)
+	}
+	if s.config.FilterMode {
+		s.parts[len(s.parts)-1].Bytes = s.counter.n
+		s.counter = nil
+		return nil
+	}
+	part := &s.parts[len(s.parts)-1]
+	if s.config.PgOnly || s.config.KafkaOnly {
+		// The records are in the table or the topic, so the part is not kept
+		os.Remove(s.tmpPath)
+		part.Bytes = s.counter.n
+		s.counter = nil
+		return nil
+	}
+	if err := s.retryStale(func() error { return os.Rename(s.tmpPath, s.outPath) }); err != nil {
+		return fmt.Errorf("failed to rename output file '%s': %w", s.tmpPath, err)
+	}
+	part.Bytes = s.counter.n
+	if part.Records > 0 {
+		part.LastKey = s.recordKey(s.lastWritten)
+	}
+	s.counter = nil
+	if sum != nil {
+		digest := sum.Sum(nil)
+		part.Checksum = s.config.Checksum + ":" + hex.EncodeToString(digest)
+		if err := writeChecksumFile(s.outPath, s.config.Checksum, digest); err != nil {
+			return err
+		}
+	}
+	if s.config.MySQLLoadSQL {
+		if err := writeLoadSQLFile(s.outPath, s.partHeader, s.config); err != nil {
+			return err
+		}
+	}
+	if s.signer != nil && !strings.HasSuffix(s.outPath, partialSuffix) {
+		// A part cut short is written again on -resume, so it is not signed
+		if err := s.signer.addPart(*part, s.config.OutputDir); err != nil {
+			return err
+		}
+	}
+	if s.bundle != nil {
+		files := []string{s.outPath}
+		if sum != nil {
+			files = append(files, s.outPath+"."+s.config.Checksum)
+		}
+		if s.config.MySQLLoadSQL {
+			files = append(files, s.outPath+".sql")
+		}
+		if s.signer != nil {
+			files = append(files, s.outPath+signSuffix)
+		}
+		if err := s.bundle.add(files...); err != nil {
+			return err
+		}
+	}
+	if s.bag != nil {
+		s.bag.addPayload(part.Path, part.Bytes)
+	}
+	if s.partDone != nil {
+		s.partDone(*part)
+	}
+	if s.notifier != nil {
+		s.notifier.part(*part)
+	}
+	if s.logger != nil {
+		s.logger.Info("part completed", "path", part.Path, "records", part.Records, "bytes", part.Bytes)
+	}
+	return nil
+}
+
+// discardCurrentFile closes the current output file, if any, without
+// publishing it: the temporary file is removed and the part is dropped. It is
+// used when a run stops with an error partway through a part.
+func (s *CSVSplitter) discardCurrentFile() {
+	if s.outFile == nil {
+		return
+	}
+	if s.pg != nil {
+		s.pg.abort()
+	}
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	s.outFile.Close()
+	s.outFile = nil
+	s.outBuf = nil
+	s.hash = nil
+	s.counter = nil
+	s.encrypter = nil
+	os.Remove(s.tmpPath)
+	s.parts = s.parts[:len(s.parts)-1]
+}
+
+// countingWriter counts the bytes written through it, adding them to a
+// shared total as well
+type countingWriter struct {
+	w     io.Writer
+	n     int64
+	total *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.total.Add(int64(n))
+	return n, err
+}
+
+// limitText describes a -limit value for progress output
+func limitText(limit int) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
+
+// rowRangeEnd describes the last record of a -max-rows range, 0 being the
+// end of the input
+func rowRangeEnd(end int) string {
+	if end == 0 {
+		return "the end"
+	}
+	return fmt.Sprint(end)
 }
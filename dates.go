@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateRule is a -reparse-date rule, rewriting the dates of column from one
+// layout to another
+type dateRule struct {
+	column string
+	input  string
+	output string
+}
+
+// dateTokens maps the tokens of layouts such as YYYY-MM-DD to their Go
+// reference time equivalents, longest first
+var dateTokens = strings.NewReplacer(
+	"YYYY", "2006",
+	"YY", "06",
+	"MM", "01",
+	"DD", "02",
+	"HH", "15",
+	"mm", "04",
+	"ss", "05",
+)
+
+// dateLayout returns the Go layout of a -reparse-date layout, which is
+// either a Go layout such as 01/02/2006, rfc3339, or a pattern of the tokens
+// YYYY, YY, MM, DD, HH, mm, and ss such as MM/DD/YYYY
+func dateLayout(layout string) string {
+	if strings.EqualFold(layout, "rfc3339") {
+		return time.RFC3339
+	}
+	return dateTokens.Replace(layout)
+}
+
+// parseDateRule parses a -reparse-date rule of the form
+// column:inputLayout=>outputLayout. The column ends at the first colon.
+func parseDateRule(value string) (dateRule, error) {
+	column, layouts, ok := strings.Cut(value, ":")
+	input, output, arrow := strings.Cut(layouts, "=>")
+	if !ok || !arrow || strings.TrimSpace(column) == "" || input == "" || output == "" {
+		return dateRule{}, fmt.Errorf("invalid reparse-date %q: must be column:inputLayout=>outputLayout", value)
+	}
+	return dateRule{strings.TrimSpace(column), dateLayout(input), dateLayout(output)}, nil
+}
+
+// checkDateRule checks the syntax of a -reparse-date rule
+func checkDateRule(value string) error {
+	_, err := parseDateRule(value)
+	return err
+}
+
+// apply rewrites the date value, leaving empty values empty. A time without
+// a zone in its layout is taken as UTC.
+func (r dateRule) apply(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(r.input, value)
+	if err != nil {
+		return "", fmt.Errorf("date does not match layout %s", r.input)
+	}
+	return t.Format(r.output), nil
+}
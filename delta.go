@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Change operations written to the op column in incremental mode
+const (
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// opColumn is the column prepended to every part in incremental mode
+const opColumn = "op"
+
+// ChangeCounts tallies the rows of an incremental run by operation
+type ChangeCounts struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// changeIndex stores the key to row-hash index of an incremental run. It
+// answers lookups against the previous run's index while building the next.
+type changeIndex interface {
+	// Diff records key with the row hash sum and returns the operation the
+	// row is emitted with, or an empty string when it is unchanged
+	Diff(key []string, sum uint64) (string, error)
+	// Deleted calls fn with every key of the previous run not seen in this one
+	Deleted(fn func(key []string) error) error
+	// Save replaces the previous index with the one built by this run
+	Save() error
+	// Close releases the index, discarding this run's changes unless saved
+	Close() error
+}
+
+// deltaTracker classifies the records of an incremental run against the
+// previous run's index
+type deltaTracker struct {
+	index  changeIndex
+	keys   []int
+	width  int
+	counts ChangeCounts
+}
+
+// parseKeyColumns splits a comma-separated -key value into column names
+func parseKeyColumns(value string) []string {
+	var columns []string
+	for _, column := range strings.Split(value, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// keyIndexes resolves the comma-separated key columns of a -key value
+// against header
+func keyIndexes(header []string, value string) ([]int, error) {
+	var keys []int
+	for _, column := range parseKeyColumns(value) {
+		i := columnIndex(header, column)
+		if i < 0 {
+			return nil, configErrorf("key column %q not found in header", column)
+		}
+		keys = append(keys, i)
+	}
+	return keys, nil
+}
+
+// newDeltaTracker resolves the configured key columns against header and
+// opens the index, kept in the state directory if one is configured and in
+// the sidecar file at path otherwise
+func newDeltaTracker(config Config, path string, header []string) (*deltaTracker, error) {
+	d := &deltaTracker{width: len(header)}
+	var err error
+	if d.keys, err = keyIndexes(header, config.KeyColumns); err != nil {
+		return nil, err
+	}
+	var keyNames []string
+	for _, i := range d.keys {
+		keyNames = append(keyNames, header[i])
+	}
+
+	if config.StateDir != "" {
+		d.index, err = openDiskIndex(config.StateDir, keyNames, newMemoryBudget(config).indexEntries)
+	} else {
+		d.index, err = openMemoryIndex(path, keyNames)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Diff classifies record against the previous run, returning the operation
+// to emit it with, or an empty string when it is unchanged
+func (d *deltaTracker) Diff(record []string) (string, error) {
+	key := make([]string, len(d.keys))
+	for i, k := range d.keys {
+		if k < len(record) {
+			key[i] = record[k]
+		}
+	}
+
+	op, err := d.index.Diff(key, rowHash(record))
+	switch {
+	case err != nil:
+		return "", err
+	case op == opInsert:
+		d.counts.Inserted++
+	case op == opUpdate:
+		d.counts.Updated++
+	default:
+		d.counts.Unchanged++
+	}
+	return op, nil
+}
+
+// Deleted calls fn with a record for every key of the previous run that was
+// not seen in this one, with only the key columns filled in
+func (d *deltaTracker) Deleted(fn func(record []string) error) error {
+	return d.index.Deleted(func(key []string) error {
+		record := make([]string, d.width)
+		for i, field := range key {
+			record[d.keys[i]] = field
+		}
+		d.counts.Deleted++
+		return fn(record)
+	})
+}
+
+// Save replaces the previous run's index with this run's
+func (d *deltaTracker) Save() error {
+	return d.index.Save()
+}
+
+// Close releases the index
+func (d *deltaTracker) Close() error {
+	return d.index.Close()
+}
+
+// rowHash fingerprints a record. Fields are length-prefixed so that moving
+// characters between adjacent fields changes the hash.
+func rowHash(record []string) uint64 {
+	h := fnv.New64a()
+	writeFields(h, record)
+	return h.Sum64()
+}
+
+// writeFields writes fields to w, each prefixed with its length
+func writeFields(w io.Writer, fields []string) {
+	var size [binary.MaxVarintLen64]byte
+	for _, field := range fields {
+		w.Write(size[:binary.PutUvarint(size[:], uint64(len(field)))])
+		io.WriteString(w, field)
+	}
+}
+
+// memoryIndex is a changeIndex held in memory and stored as a CSV sidecar
+// with the key columns and the row hash
+type memoryIndex struct {
+	path     string
+	keyNames []string
+	previous map[string]uint64
+	current  map[string]uint64
+}
+
+// openMemoryIndex loads the index at path. A missing index means every row
+// is new.
+func openMemoryIndex(path string, keyNames []string) (*memoryIndex, error) {
+	m := &memoryIndex{
+		path:     path,
+		keyNames: keyNames,
+		previous: make(map[string]uint64),
+		current:  make(map[string]uint64),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(keyNames) + 1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index '%s': %w", path, err)
+	}
+	if !slices.Equal(header[:len(keyNames)], keyNames) {
+		return nil, configErrorf("index '%s' was built with key %s, not %s",
+			path, strings.Join(header[:len(keyNames)], ","), strings.Join(keyNames, ","))
+	}
+
+	for {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index '%s': %w", path, err)
+		}
+		sum, err := strconv.ParseUint(entry[len(keyNames)], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index '%s': invalid row hash %q", path, entry[len(keyNames)])
+		}
+		m.previous[strings.Join(entry[:len(keyNames)], "\x00")] = sum
+	}
+}
+
+func (m *memoryIndex) Diff(fields []string, sum uint64) (string, error) {
+	key := strings.Join(fields, "\x00")
+	if _, ok := m.current[key]; ok {
+		return "", fmt.Errorf("duplicate key %s", strings.Join(fields, ","))
+	}
+
+	m.current[key] = sum
+	previous, ok := m.previous[key]
+	delete(m.previous, key)
+	switch {
+	case !ok:
+		return opInsert, nil
+	case previous != sum:
+		return opUpdate, nil
+	}
+	return "", nil
+}
+
+// Deleted reports the unseen keys in sorted order
+func (m *memoryIndex) Deleted(fn func(key []string) error) error {
+	keys := make([]string, 0, len(m.previous))
+	for key := range m.previous {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if err := fn(strings.Split(key, "\x00")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryIndex) Save() error {
+	tmpPath := m.path + tmpSuffix
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to write index '%s': %w", m.path, err)
+	}
+	defer os.Remove(tmpPath)
+
+	writer := csv.NewWriter(file)
+	writer.Write(append(slices.Clone(m.keyNames), "row_hash"))
+	for key, sum := range m.current {
+		writer.Write(append(strings.Split(key, "\x00"), strconv.FormatUint(sum, 16)))
+	}
+	writer.Flush()
+	err = writer.Error()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write index '%s': %w", m.path, err)
+	}
+	return os.Rename(tmpPath, m.path)
+}
+
+func (m *memoryIndex) Close() error {
+	return nil
+}
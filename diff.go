@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Changes written to the change column of the diff subcommand
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// DiffConfig holds the configuration for the diff subcommand
+type DiffConfig struct {
+	OldPath    string
+	NewPath    string
+	OutputPath string
+	KeyColumns string
+	Delimiter  rune
+}
+
+// DiffCounts tallies the records of a diff by change
+type DiffCounts struct {
+	Added     int
+	Removed   int
+	Changed   int
+	Unchanged int
+}
+
+// diffRecord is a record of the old file, held until the new file is read.
+// Without a key, identical records are held once, with their count.
+type diffRecord struct {
+	record  []string
+	count   int
+	matched int
+}
+
+// runDiff implements the diff subcommand, which lists the records added,
+// removed, and changed between two versions of a CSV file
+func runDiff(args []string) error {
+	config := DiffConfig{}
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(&config.KeyColumns, "key", "", "Comma-separated key columns identifying records (default: whole records, so changes show as removed and added)")
+	fs.StringVar(&config.OutputPath, "output", "", "Write the differences to this file instead of stdout")
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character (default: detected)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff [options] old.csv new.csv\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "List the records added, removed, and changed between two versions of a CSV file.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	// Flags may come after the files, as in diff old.csv new.csv -key id
+	var files []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) != 2 {
+		return configErrorf("diff needs the old and the new file")
+	}
+	config.OldPath, config.NewPath = files[0], files[1]
+
+	out := os.Stdout
+	if config.OutputPath != "" {
+		file, err := os.Create(config.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create diff file '%s': %w", config.OutputPath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	buf := bufio.NewWriter(out)
+
+	counts, err := diffFiles(config, buf)
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d added, %d removed, %d changed, %d unchanged\n",
+		counts.Added, counts.Removed, counts.Changed, counts.Unchanged)
+	return nil
+}
+
+// diffFiles writes the differences between the old and new files to w, as a
+// CSV file with the columns change and changed_columns before those of the
+// new file. The old file is held in memory, by key, while the new file is
+// streamed; added and changed records come in the order of the new file,
+// then removed ones in the order of the old file.
+func diffFiles(config DiffConfig, w io.Writer) (DiffCounts, error) {
+	var counts DiffCounts
+	oldFile, err := openInput(config.OldPath)
+	if err != nil {
+		return counts, err
+	}
+	defer oldFile.Close()
+	newFile, err := openInput(config.NewPath)
+	if err != nil {
+		return counts, err
+	}
+	defer newFile.Close()
+
+	oldReader, _ := newInspectReader(oldFile, config.Delimiter)
+	newReader, delimiter := newInspectReader(newFile, config.Delimiter)
+	oldHeader, err := readDiffHeader(oldReader, config.OldPath)
+	if err != nil {
+		return counts, err
+	}
+	header, err := readDiffHeader(newReader, config.NewPath)
+	if err != nil {
+		return counts, err
+	}
+
+	// Old records are compared in the column order of the new file
+	columns := make([]int, len(header))
+	for i, name := range header {
+		if columns[i] = columnIndex(oldHeader, name); columns[i] < 0 {
+			return counts, configErrorf("column %q of '%s' is not in '%s'", name, config.NewPath, config.OldPath)
+		}
+	}
+	if len(oldHeader) != len(header) {
+		return counts, configErrorf("'%s' has columns that '%s' does not", config.OldPath, config.NewPath)
+	}
+	keys, err := keyIndexes(header, config.KeyColumns)
+	if err != nil {
+		return counts, err
+	}
+	recordKey := func(record []string) string {
+		if len(keys) == 0 {
+			return strings.Join(record, "\x00")
+		}
+		return strings.Join(fieldValues(keys, record), "\x00")
+	}
+
+	old := make(map[string]*diffRecord)
+	var order []string
+	for line := 2; ; line++ {
+		record, err := oldReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, fmt.Errorf("'%s': error reading record at line %d: %w", config.OldPath, line, err)
+		}
+		reordered := make([]string, len(columns))
+		for i, column := range columns {
+			if column < len(record) {
+				reordered[i] = record[column]
+			}
+		}
+		key := recordKey(reordered)
+		if previous, ok := old[key]; ok {
+			if len(keys) > 0 {
+				return counts, fmt.Errorf("'%s': duplicate key at line %d", config.OldPath, line)
+			}
+			previous.count++
+			continue
+		}
+		old[key] = &diffRecord{record: reordered, count: 1}
+		order = append(order, key)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	if err := writer.Write(append([]string{"change", "changed_columns"}, header...)); err != nil {
+		return counts, err
+	}
+	// With -key, keys must be unique in the new file as in the old one;
+	// added keys are held to tell, as those of old records are already
+	added := make(map[string]bool)
+	for line := 2; ; line++ {
+		record, err := newReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, fmt.Errorf("'%s': error reading record at line %d: %w", config.NewPath, line, err)
+		}
+		key := recordKey(record)
+		previous := old[key]
+		if len(keys) > 0 && (added[key] || previous != nil && previous.matched == previous.count) {
+			return counts, fmt.Errorf("'%s': duplicate key at line %d", config.NewPath, line)
+		}
+		switch {
+		case previous == nil || previous.matched == previous.count:
+			if len(keys) > 0 {
+				added[key] = true
+			}
+			counts.Added++
+			err = writer.Write(append([]string{diffAdded, ""}, record...))
+		default:
+			previous.matched++
+			changed := changedColumns(header, previous.record, record)
+			if len(changed) == 0 {
+				counts.Unchanged++
+				continue
+			}
+			counts.Changed++
+			err = writer.Write(append([]string{diffChanged, strings.Join(changed, ",")}, record...))
+		}
+		if err != nil {
+			return counts, err
+		}
+	}
+	for _, key := range order {
+		previous := old[key]
+		for ; previous.matched < previous.count; previous.matched++ {
+			counts.Removed++
+			if err := writer.Write(append([]string{diffRemoved, ""}, previous.record...)); err != nil {
+				return counts, err
+			}
+		}
+	}
+	writer.Flush()
+	return counts, writer.Error()
+}
+
+// readDiffHeader reads the header of a file being diffed
+func readDiffHeader(reader *csv.Reader, path string) ([]string, error) {
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, withExitCode(exitParse, fmt.Errorf("'%s' is empty", path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("'%s': failed to read header: %w", path, err)
+	}
+	return header, nil
+}
+
+// changedColumns returns the names of the columns whose values differ
+// between the old and new versions of a record
+func changedColumns(header, old, record []string) []string {
+	var changed []string
+	for i, name := range header {
+		var a, b string
+		if i < len(old) {
+			a = old[i]
+		}
+		if i < len(record) {
+			b = record[i]
+		}
+		if a != b {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		old     string
+		new     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "keyed",
+			key:  "id",
+			old:  "id,name\n1,ann\n2,bob\n3,cy\n",
+			new:  "name,id\nann,1\nbo,2\ndee,4\n",
+			want: "change,changed_columns,name,id\nchanged,name,bo,2\nadded,,dee,4\nremoved,,cy,3\n",
+		},
+		{
+			name: "whole records",
+			old:  "id,name\n1,ann\n1,ann\n2,bob\n",
+			new:  "id,name\n1,ann\n2,bo\n",
+			want: "change,changed_columns,id,name\nadded,,2,bo\nremoved,,1,ann\nremoved,,2,bob\n",
+		},
+		{
+			name:    "duplicate key in old",
+			key:     "id",
+			old:     "id,name\n1,ann\n1,al\n",
+			new:     "id,name\n1,ann\n",
+			wantErr: "old.csv': duplicate key at line 3",
+		},
+		{
+			name:    "duplicate matched key in new",
+			key:     "id",
+			old:     "id,name\n1,ann\n",
+			new:     "id,name\n1,ann\n1,al\n",
+			wantErr: "new.csv': duplicate key at line 3",
+		},
+		{
+			name:    "duplicate added key in new",
+			key:     "id",
+			old:     "id,name\n1,ann\n",
+			new:     "id,name\n2,bob\n1,ann\n2,bo\n",
+			wantErr: "new.csv': duplicate key at line 4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := DiffConfig{OldPath: filepath.Join(dir, "old.csv"), NewPath: filepath.Join(dir, "new.csv"), KeyColumns: tt.key}
+			if err := os.WriteFile(config.OldPath, []byte(tt.old), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(config.NewPath, []byte(tt.new), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			_, err := diffFiles(config, &b)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("diffFiles = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("diffFiles output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cacheDirTag is the content of a CACHEDIR.TAG file as defined by the Cache
+// Directory Tagging Specification (https://bford.info/cachedir/)
+const cacheDirTag = "Signature: 8a477f597d28d172789f06886806bc55\n" +
+	"# This file is a cache directory tag created by splitcsv.\n" +
+	"# For information about cache directory tags, see:\n" +
+	"#\thttps://bford.info/cachedir/\n"
+
+// markOutputDir tags dir so backup tools, indexers, and scanners that honor
+// exclusion markers skip the freshly written parts
+func markOutputDir(dir string) error {
+	tag := filepath.Join(dir, "CACHEDIR.TAG")
+	if err := os.WriteFile(tag, []byte(cacheDirTag), 0644); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", tag, err)
+	}
+	if err := markOutputDirPlatform(dir); err != nil {
+		return fmt.Errorf("failed to mark output directory '%s': %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+)
+
+// outputSizeFactors estimate the size of the parts of each output format
+// relative to the input, erring on the large side: the markup of markdown,
+// html, and sql grows with the number of fields, so narrow records cost more,
+// and fwf pads every field to the width of its column
+var outputSizeFactors = map[string]float64{
+	"csv":      1.1,
+	"sql":      2.5,
+	"markdown": 2,
+	"html":     4.5,
+	"sqlite":   1.5,
+	"mysql":    1.1,
+	"fwf":      2,
+}
+
+// spaceNeed is the estimated number of bytes a run writes to a directory
+type spaceNeed struct {
+	dir   string
+	bytes int64
+}
+
+// estimateSpace returns the estimated bytes the run writes for the input
+// files: the parts, at -space-factor or the factor of the output format, and
+// the bucket files -shuffle spills its records to, in -temp-dir or with the
+// parts
+func estimateSpace(config Config, files []string) []spaceNeed {
+	var input int64
+	for _, path := range files {
+		// Inputs that are not regular files, such as pipes, have no size
+		if info, err := statInput(path); err == nil && info.Mode().IsRegular() {
+			input += info.Size()
+		}
+	}
+	factor := config.SpaceFactor
+	if factor == 0 {
+		factor = outputSizeFactors[config.OutputFormat]
+	}
+	parts := spaceNeed{dir: partDir(config), bytes: int64(float64(input) * factor)}
+	if !config.Shuffle {
+		return []spaceNeed{parts}
+	}
+	if config.TempDir == "" {
+		parts.bytes += input
+		return []spaceNeed{parts}
+	}
+	return []spaceNeed{parts, {dir: config.TempDir, bytes: input}}
+}
+
+// checkDiskSpace fails a run before it writes any part if the free space of
+// the output file system is smaller than the estimated size of the parts, so
+// a full disk does not stop it halfway with a pile of parts. A file system
+// whose free space cannot be told is not checked.
+func (s *CSVSplitter) checkDiskSpace(files []string) error {
+	if s.config.SkipSpaceCheck || s.config.Resume {
+		return nil
+	}
+	for _, need := range estimateSpace(s.config, files) {
+		free, ok := freeSpace(need.dir)
+		if !ok {
+			continue
+		}
+		if s.logger != nil {
+			s.logger.Debug("disk space checked", "dir", need.dir, "needed", need.bytes, "free", free)
+		} else if s.config.Verbose {
+			fmt.Printf("Disk space: about %s needed in %s, %s free\n", formatSpace(need.bytes), need.dir, formatSpace(free))
+		}
+		if need.bytes > free {
+			return withExitCode(exitIO, fmt.Errorf("not enough disk space in '%s': the run needs about %s and %s is free (set -space-factor to change the estimate, or -skip-space-check)",
+				need.dir, formatSpace(need.bytes), formatSpace(free)))
+		}
+	}
+	return nil
+}
+
+// formatSpace formats a number of bytes in the largest unit it is at least
+// one of, to one decimal
+func formatSpace(size int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if size >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(size)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// utf8BOM starts some UTF-8 files, mostly those saved by Windows tools
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// validateEncodingConfig checks -input-encoding, which is auto or the name
+// of an encoding
+func validateEncodingConfig(config Config) error {
+	if config.InputEncoding == "auto" {
+		return nil
+	}
+	if _, _, err := inputEncoding(config.InputEncoding); err != nil {
+		return err
+	}
+	return nil
+}
+
+// inputEncoding returns the encoding of name, any of the labels of the
+// WHATWG Encoding Standard such as utf-16le, latin1, or shift_jis, with its
+// canonical name. UTF-16 drops its byte order mark.
+func inputEncoding(name string) (encoding.Encoding, string, error) {
+	switch strings.ToLower(name) {
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), "utf-16le", nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), "utf-16be", nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown input-encoding '%s'", name)
+	}
+	canonical, _ := htmlindex.Name(enc)
+	return enc, canonical, nil
+}
+
+// detectEncoding guesses the encoding of sample, the start of an input, which
+// is complete when it holds all of it: from its byte order mark, from the
+// NUL bytes of UTF-16 without one, or from whether it is valid UTF-8. Other
+// inputs are taken as windows-1252, the superset of Latin-1 most 8-bit files
+// are in; sure is false unless their bytes past ASCII stand alone, as the
+// accented letters of Western European words do, rather than run together
+// as the letters of other scripts would.
+func detectEncoding(sample []byte, complete bool) (name string, sure bool) {
+	switch {
+	case bytes.HasPrefix(sample, utf8BOM):
+		return "utf-8", true
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return "utf-16le", true
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return "utf-16be", true
+	}
+
+	// Text mostly in ASCII has a NUL in every other byte in UTF-16
+	pairs, even, odd := len(sample)/2, 0, 0
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			even++
+		}
+		if sample[i+1] == 0 {
+			odd++
+		}
+	}
+	switch {
+	case pairs > 0 && odd > pairs/3 && even < pairs/20:
+		return "utf-16le", true
+	case pairs > 0 && even > pairs/3 && odd < pairs/20:
+		return "utf-16be", true
+	}
+
+	if !complete {
+		// The last character may have been cut off by the end of the sample
+		for i := len(sample) - 1; i >= max(len(sample)-utf8.UTFMax, 0); i-- {
+			if utf8.RuneStart(sample[i]) {
+				sample = sample[:i]
+				break
+			}
+		}
+	}
+	if utf8.Valid(sample) {
+		return "utf-8", true
+	}
+
+	high, alone := 0, 0
+	for i, b := range sample {
+		if b < utf8.RuneSelf {
+			continue
+		}
+		high++
+		if (i == 0 || sample[i-1] < utf8.RuneSelf) && (i == len(sample)-1 || sample[i+1] < utf8.RuneSelf) {
+			alone++
+		}
+	}
+	return "windows-1252", alone*10 >= high*9
+}
+
+// inputDecoder returns the decoder of the input whose start is sample, as
+// the bytes of -input-encoding, or nil when it is read as UTF-8 as it is.
+// With -input-encoding auto, the encoding is detected from sample, and
+// reported with a warning when the detection is unsure.
+func (s *CSVSplitter) inputDecoder(sample []byte, complete bool) transform.Transformer {
+	name := s.config.InputEncoding
+	if name == "utf-8" {
+		return nil
+	}
+	if name == "auto" {
+		detected, sure := detectEncoding(sample, complete)
+		switch {
+		case !sure && s.logger != nil:
+			s.logger.Warn("encoding detection unsure", "input", s.source, "encoding", detected)
+		case !sure:
+			fmt.Fprintf(os.Stderr, "Warning: -input-encoding auto read %s as %s, but is not sure; set -input-encoding if its text looks wrong\n", s.source, detected)
+		case s.logger != nil:
+			s.logger.Info("encoding detected", "input", s.source, "encoding", detected)
+		case s.config.Verbose:
+			fmt.Printf("Input encoding of %s: %s\n", s.source, detected)
+		}
+		name = detected
+		if name == "utf-8" {
+			s.addEncoding(name)
+			if bytes.HasPrefix(sample, utf8BOM) {
+				return unicode.UTF8BOM.NewDecoder()
+			}
+			return nil
+		}
+	}
+	// The name was checked by validateEncodingConfig
+	enc, canonical, _ := inputEncoding(name)
+	s.addEncoding(canonical)
+	if canonical == "utf-8" {
+		return nil
+	}
+	return enc.NewDecoder()
+}
+
+// addEncoding records the encoding an input file was read in, for the
+// report and the summary of the run
+func (s *CSVSplitter) addEncoding(name string) {
+	if !slices.Contains(s.encodings, name) {
+		s.encodings = append(s.encodings, name)
+	}
+}
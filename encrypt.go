@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedSuffix is appended to the name of every part encrypted with
+// -encrypt, as the age tool does
+const encryptedSuffix = ".age"
+
+// passphraseEnv holds the passphrase of -encrypt passphrase
+const passphraseEnv = "SPLITCSV_PASSPHRASE"
+
+// validateEncryptConfig checks -encrypt, which is age:FILE, passphrase, or
+// passphrase:FILE
+func validateEncryptConfig(config Config) error {
+	if config.Encrypt == "" {
+		return nil
+	}
+	method, file, _ := strings.Cut(config.Encrypt, ":")
+	switch {
+	case method == "age" && file != "":
+	case method == "passphrase":
+		if file == "" && os.Getenv(passphraseEnv) == "" {
+			return fmt.Errorf("encrypt passphrase reads the passphrase from %s, which is not set", passphraseEnv)
+		}
+	default:
+		return fmt.Errorf("encrypt must be age:FILE, passphrase, or passphrase:FILE")
+	}
+	if config.FilterMode {
+		return fmt.Errorf("encrypt cannot be combined with filter-mode, which writes no parts")
+	}
+	if config.OutputFormat == "sqlite" || config.Shuffle {
+		return fmt.Errorf("encrypt cannot be combined with sqlite output or -shuffle, which write the records to scratch files in the clear")
+	}
+	if config.MySQLLoadSQL {
+		return fmt.Errorf("encrypt cannot be combined with mysql-load-sql, whose statement cannot read encrypted parts")
+	}
+	// Only the parts are encrypted, so no other file may hold the records
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.OnError == "quarantine", "on-error quarantine"},
+		{config.SchemaPath != "", "schema"},
+		{config.Types != "", "types"},
+		{config.LineagePath != "", "lineage"},
+		{config.ReportPath != "", "report"},
+		{config.Incremental, "incremental"},
+		{config.StateDir != "", "state-dir"},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("encrypt cannot be combined with %s, which writes fields or keys of the records to disk in the clear", option.name)
+		}
+	}
+	return nil
+}
+
+// loadRecipients returns the recipients every part is encrypted to: the age
+// public keys listed in the file of -encrypt age:FILE, or the passphrase of
+// -encrypt passphrase
+func loadRecipients(config Config) ([]age.Recipient, error) {
+	method, path, _ := strings.Cut(config.Encrypt, ":")
+	if method == "passphrase" {
+		passphrase := os.Getenv(passphraseEnv)
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, configErrorf("failed to read passphrase: %v", err)
+			}
+			passphrase, _, _ = strings.Cut(string(data), "\n")
+			passphrase = strings.TrimSuffix(passphrase, "\r")
+		}
+		if passphrase == "" {
+			return nil, configErrorf("encrypt passphrase is empty")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, configErrorf("invalid passphrase: %v", err)
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, configErrorf("failed to read recipients: %v", err)
+	}
+	defer file.Close()
+	recipients, err := age.ParseRecipients(bufio.NewReader(file))
+	if err != nil {
+		return nil, configErrorf("invalid recipients file '%s': %v", path, err)
+	}
+	return recipients, nil
+}
+
+// encryptPart returns a writer encrypting the part written to w for the
+// recipients of -encrypt. It must be closed to finish the part.
+func (s *CSVSplitter) encryptPart(w io.Writer) (io.WriteCloser, error) {
+	enc, err := age.Encrypt(w, s.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt part: %w", err)
+	}
+	return enc, nil
+}
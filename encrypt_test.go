@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEncryptConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"parts only", nil, ""},
+		{"checksums", []string{"-checksums", "sha256"}, ""},
+		{"quarantine", []string{"-on-error", "quarantine"}, "combined with on-error quarantine"},
+		{"schema", []string{"-schema", "schema.json"}, "combined with schema"},
+		{"types", []string{"-types", "amount:decimal"}, "combined with types"},
+		{"lineage", []string{"-lineage", "lineage.csv.gz"}, "combined with lineage"},
+		{"report", []string{"-report", "report.json"}, "combined with report"},
+		{"incremental", []string{"-incremental"}, "combined with incremental"},
+		{"state dir", []string{"-state-dir", "state"}, "combined with state-dir"},
+		{"shuffle", []string{"-shuffle"}, "-shuffle"},
+		{"sqlite", []string{"-output-format", "sqlite", "-table", "t"}, "sqlite output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, append([]string{"-encrypt", "age:recipients.txt"}, tt.args...)...)
+			err := validateEncryptConfig(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateEncryptConfig(%v) = %v, want no error", tt.args, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateEncryptConfig(%v) = %v, want error containing %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
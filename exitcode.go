@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Process exit codes. Scripts can branch on these to tell failure types
+// apart.
+const (
+	exitOK            = 0
+	exitFailure       = 1 // unclassified failure
+	exitConfig        = 2 // invalid flags or configuration
+	exitInputNotFound = 3 // input file does not exist
+	exitParse         = 4 // input could not be parsed
+	exitIO            = 5 // reading or writing files failed
+	exitPartial       = 6 // finished, but some rows were rejected
+	exitInterrupted   = 7 // stopped by SIGINT or SIGTERM
+	exitPartsFailed   = 8 // finished, but some parts failed to ship
+	exitDeadline      = 9 // stopped at the -deadline, resumable
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode tags err with the exit code the process should end with
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// configErrorf formats an invalid-configuration error
+func configErrorf(format string, args ...any) error {
+	return withExitCode(exitConfig, fmt.Errorf(format, args...))
+}
+
+// exitCode maps err to the process exit code. Explicitly tagged errors win;
+// otherwise CSV parse errors and file system errors are recognized by type.
+func exitCode(err error) int {
+	var coded *exitError
+	var parseErr *csv.ParseError
+	var pathErr *fs.PathError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.As(err, &parseErr), errors.Is(err, csv.ErrFieldCount):
+		return exitParse
+	case errors.As(err, &pathErr):
+		return exitIO
+	}
+	return exitFailure
+}
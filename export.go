@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// sqlDrivers maps DSN schemes to registered database/sql driver names
+var sqlDrivers = map[string]string{
+	"postgres":   "pgx",
+	"postgresql": "pgx",
+}
+
+// inferSQLDriver returns the database/sql driver registered for the scheme
+// of dsn
+func inferSQLDriver(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || sqlDrivers[u.Scheme] == "" {
+		return "", configErrorf("cannot infer driver from dsn; use -driver")
+	}
+	return sqlDrivers[u.Scheme], nil
+}
+
+// runExport implements the export subcommand, which streams the result of a
+// SQL query into split CSV parts
+func runExport(args []string) error {
+	config := Config{}
+	var dsn, query, driver string
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&dsn, "dsn", "", "Database connection string, e.g. postgres://user@host/db (required)")
+	fs.StringVar(&query, "query", "", "SQL query whose result rows are exported (required)")
+	fs.StringVar(&driver, "driver", "", "database/sql driver name (default: inferred from the DSN scheme)")
+	registerOutputFlags(fs, &config)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export -dsn DSN -query SQL [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Stream the result of a SQL query into split CSV files.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s export -dsn postgres://localhost/shop -query 'select * from orders' -l 50000\n", os.Args[0])
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	startRun(&config)
+
+	if dsn == "" {
+		return configErrorf("dsn is required")
+	}
+	if query == "" {
+		return configErrorf("query is required")
+	}
+	if driver == "" {
+		var err error
+		if driver, err = inferSQLDriver(dsn); err != nil {
+			return err
+		}
+	}
+	if err := validateOutputConfig(config); err != nil {
+		if exitCode(err) == exitFailure {
+			err = withExitCode(exitConfig, err)
+		}
+		return err
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	splitter := NewCSVSplitter(config)
+	if config.NotifyURL != "" {
+		splitter.notifier = newNotifier(config, splitter.logger)
+	}
+	if config.MetricsAddr != "" {
+		stop, err := serveMetrics(config.MetricsAddr, splitter.metrics)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	ctx, cancel := withDeadline(ctx, config.Deadline)
+	defer cancel()
+
+	err = splitter.Export(ctx, db, query)
+	if err != nil {
+		splitter.metrics.errors.Add(1)
+	}
+	if config.ReportPath != "" {
+		if rerr := splitter.WriteReport(config.ReportPath, err); rerr != nil {
+			reportError(splitter.logger, rerr)
+		}
+	}
+	if splitter.notifier != nil {
+		splitter.notifier.finish(splitter, err)
+	}
+	if config.SlackWebhook != "" {
+		splitter.notifySlack("export", err)
+	}
+	if err != nil {
+		return reportError(splitter.logger, err)
+	}
+
+	if splitter.logger == nil && config.Verbose {
+		fmt.Printf("Export completed successfully. Created %d files.\n", len(splitter.parts))
+	}
+
+	if splitter.rejects != nil && splitter.rejects.count > 0 {
+		err := withExitCode(exitPartial, fmt.Errorf("rejected %d records to %s",
+			splitter.rejects.count, splitter.rejects.path))
+		return reportError(splitter.logger, err)
+	}
+	return nil
+}
+
+// Export runs query against db and splits its result rows, using the column
+// names as the header
+func (s *CSVSplitter) Export(ctx context.Context, db *sql.DB, query string) error {
+	s.stats.startedAt = time.Now()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	header, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to read result columns: %w", err)
+	}
+
+	if s.logger != nil {
+		s.logger.Info("export started", "columns", len(header), "limit", s.config.MaxRecords)
+	} else if s.config.Verbose {
+		fmt.Printf("Starting to export query results\n")
+		fmt.Printf("Max records per file: %s\n", limitText(s.config.MaxRecords))
+	}
+
+	return s.splitRecords(ctx, header, newRowReader(rows, len(header)))
+}
+
+// rowReader adapts sql.Rows to a recordReader, formatting every column value
+// as a string
+type rowReader struct {
+	rows   *sql.Rows
+	values []any
+	ptrs   []any
+}
+
+// newRowReader returns a reader over rows having the given number of columns
+func newRowReader(rows *sql.Rows, columns int) *rowReader {
+	r := &rowReader{
+		rows:   rows,
+		values: make([]any, columns),
+		ptrs:   make([]any, columns),
+	}
+	for i := range r.values {
+		r.ptrs[i] = &r.values[i]
+	}
+	return r
+}
+
+// Read returns the next result row, or io.EOF when the rows are exhausted
+func (r *rowReader) Read() ([]string, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := r.rows.Scan(r.ptrs...); err != nil {
+		return nil, err
+	}
+
+	record := make([]string, len(r.values))
+	for i, value := range r.values {
+		record[i] = formatSQLValue(value)
+	}
+	return record, nil
+}
+
+// formatSQLValue renders a scanned column value as CSV text. NULL becomes an
+// empty field and timestamps use RFC 3339.
+func formatSQLValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
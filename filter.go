@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+)
+
+// validateFilterConfig rejects options that -filter-mode cannot honor, since
+// its only output is the CSV on stdout
+func validateFilterConfig(config Config) error {
+	if config.Verbose && config.LogFormat != "json" {
+		return fmt.Errorf("verbose output would mix with the records on stdout; use -log-format json to log to stderr")
+	}
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.Checksum != "", "checksums"},
+		{config.BagIt, "bagit"},
+		{config.Incremental, "incremental"},
+		{config.AlignWith != "", "align-with"},
+		{config.Deadline != 0, "deadline"},
+		{config.Resume, "resume"},
+		{config.MarkDir, "mark-output-dir"},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("%s cannot be combined with filter-mode, which writes no part files", option.name)
+		}
+	}
+	return nil
+}
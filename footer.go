@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+)
+
+// footerActions are the values of -footer-action
+var footerActions = []string{"drop", "every", "last"}
+
+// totalsPattern matches the first field of a totals or trailer row, as
+// recognized by -detect-footer
+var totalsPattern = regexp.MustCompile(`(?i)^\s*(grand\s+total|sub-?totals?|totals?|sum|trailer|trl|eof)\b`)
+
+// validateFooterConfig checks -footer-rows, -detect-footer, and
+// -footer-action
+func validateFooterConfig(config Config) error {
+	if config.FooterRows < 0 {
+		return fmt.Errorf("footer-rows must not be negative")
+	}
+	if config.FooterRows > 0 && config.DetectFooter {
+		return fmt.Errorf("footer-rows cannot be combined with detect-footer")
+	}
+	if !slices.Contains(footerActions, config.FooterAction) {
+		return fmt.Errorf("footer-action must be drop, every, or last")
+	}
+	if config.FooterAction == "drop" {
+		return nil
+	}
+	if config.FooterRows == 0 && !config.DetectFooter {
+		return fmt.Errorf("footer-action is only used with -footer-rows or -detect-footer")
+	}
+	// The footer is written as it was read, so it must fit the parts
+	if config.OutputFormat != "csv" {
+		return fmt.Errorf("footer-action %s needs csv output, as the footer is copied as it was read", config.FooterAction)
+	}
+	if _, routed := routedMode(config); routed || len(config.Routes) > 0 {
+		return fmt.Errorf("footer-action %s cannot be combined with routed splits or -route, whose outputs have no last part", config.FooterAction)
+	}
+	if config.Incremental {
+		return fmt.Errorf("footer-action %s cannot be combined with incremental, whose records start with the op column", config.FooterAction)
+	}
+	if config.FooterAction == "every" && config.FilterMode {
+		return fmt.Errorf("footer-action every needs an input file, whose footer is read before the split")
+	}
+	return nil
+}
+
+// footerRecord is a record held back by a footerReader, with where it was
+// read, to restore when it turns out not to be part of a footer
+type footerRecord struct {
+	record []string
+	// err is the wrong field count of the record, which trailer rows tend
+	// to have
+	err error
+	// raw is the bytes of the record with -passthrough
+	raw       []byte
+	source    string
+	tail      *tailReader
+	positions []int
+}
+
+// partFooter is the footer written at the end of parts by -footer-action
+type partFooter []footerRecord
+
+// footerReader holds back the last -footer-rows records of each input file,
+// or its last record when -detect-footer takes it for a totals row, so that
+// they are not split with the other records
+type footerReader struct {
+	s      *CSVSplitter
+	reader recordReader
+	rows   int
+	detect bool
+	held   []footerRecord
+	// footer is the footer of the input file read last, and dropped the
+	// number of footer records of every file
+	footer  partFooter
+	dropped int
+	eof     bool
+	// source and tail are those of the input being read, which the records
+	// returned replace with their own
+	source string
+	tail   *tailReader
+}
+
+// newFooterReader returns a reader of the records of reader without their
+// footer, or nil without -footer-rows or -detect-footer
+func (s *CSVSplitter) newFooterReader(reader recordReader) *footerReader {
+	if s.config.FooterRows == 0 && !s.config.DetectFooter {
+		return nil
+	}
+	rows := s.config.FooterRows
+	if s.config.DetectFooter {
+		rows = 1
+	}
+	return &footerReader{s: s, reader: reader, rows: rows, detect: s.config.DetectFooter, source: s.source, tail: s.tail}
+}
+
+func (f *footerReader) Read() ([]string, error) {
+	s := f.s
+	for !f.eof && len(f.held) <= f.rows {
+		s.source, s.tail = f.source, f.tail
+		record, err := f.reader.Read()
+		f.source, f.tail = s.source, s.tail
+		if err == io.EOF {
+			f.eof = true
+			f.cut()
+			break
+		}
+		if len(f.held) > 0 && f.held[0].source != f.source {
+			// The held records end the file read before
+			f.cut()
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return record, err
+		}
+		held := footerRecord{record: record, err: err, source: f.source, tail: f.tail}
+		if s.raw != nil {
+			held.raw = bytes.Clone(s.rawRecord())
+		}
+		held.positions = make([]int, 2*len(record))
+		for i := range record {
+			held.positions[2*i], held.positions[2*i+1] = s.input.FieldPos(i)
+		}
+		f.held = append(f.held, held)
+	}
+	if len(f.held) == 0 {
+		return nil, io.EOF
+	}
+	next := f.held[0]
+	f.held = f.held[1:]
+	s.source, s.tail, s.positions = next.source, next.tail, next.positions
+	if s.raw != nil {
+		s.raw.record = next.raw
+	}
+	return next.record, next.err
+}
+
+// cut takes the held records, which end an input file, as its footer. With
+// -detect-footer, only a last record that looks like a totals row is.
+func (f *footerReader) cut() {
+	if len(f.held) == 0 {
+		return
+	}
+	if f.detect {
+		last := f.held[len(f.held)-1]
+		if !isTotalsRow(last.record) {
+			return
+		}
+		f.held = f.held[:len(f.held)-1]
+		f.footer = partFooter{last}
+	} else {
+		f.footer = partFooter(f.held)
+		f.held = nil
+	}
+	f.dropped += len(f.footer)
+}
+
+// isTotalsRow reports whether the first non-empty field of record starts
+// with a word such as Total, Sum, or Trailer
+func isTotalsRow(record []string) bool {
+	for _, field := range record {
+		if field != "" {
+			return totalsPattern.MatchString(field)
+		}
+	}
+	return false
+}
+
+// readFileFooter reads the footer at the end of the input file at path,
+// before the split, for -footer-action every to end every part with it. The
+// tail of the file is read in growing pieces until it holds a line more
+// than the footer, so the footer starts on a record boundary unless a
+// quoted field runs across lines there; the footer held back at the end of
+// the split is then compared with it.
+func (s *CSVSplitter) readFileFooter(path string) (partFooter, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	file, ok := input.(*os.File)
+	if !ok {
+		return nil, configErrorf("footer-action every needs a regular input file, whose footer is read before the split")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer of '%s': %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, configErrorf("footer-action every needs a regular input file, whose footer is read before the split")
+	}
+
+	rows := s.config.FooterRows
+	if s.config.DetectFooter {
+		rows = 1
+	}
+	for size := int64(64 << 10); ; size *= 2 {
+		start := max(info.Size()-size, 0)
+		data := make([]byte, info.Size()-start)
+		if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read footer of '%s': %w", path, err)
+		}
+		skip := 1 // the header
+		if start > 0 {
+			// The piece starts within a line
+			line := bytes.IndexByte(data, '\n')
+			if line < 0 || bytes.Count(data, []byte{'\n'}) <= rows+1 {
+				continue
+			}
+			data, skip = data[line+1:], 0
+		}
+		footer, err := s.parseFooter(data, skip, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read footer of '%s': %w", path, err)
+		}
+		if start == 0 || len(footer) == rows || s.config.DetectFooter {
+			return footer, nil
+		}
+	}
+}
+
+// parseFooter returns the last rows records of data, after skipping skip
+func (s *CSVSplitter) parseFooter(data []byte, skip, rows int) (partFooter, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = s.config.Delimiter
+	reader.Comment = s.config.Comment
+	reader.LazyQuotes = s.config.LazyQuotes && !s.config.Pedantic || s.config.Repair
+	reader.TrimLeadingSpace = s.config.TrimLeadingSpace && !s.config.Pedantic
+	reader.FieldsPerRecord = -1
+	var footer partFooter
+	var offset int64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := reader.InputOffset()
+		raw := data[offset:end]
+		offset = end
+		if skip > 0 {
+			skip--
+			continue
+		}
+		footer = append(footer, footerRecord{record: record, raw: raw})
+		if len(footer) > rows {
+			footer = footer[1:]
+		}
+	}
+	if s.config.DetectFooter && (len(footer) == 0 || !isTotalsRow(footer[0].record)) {
+		return partFooter{}, nil
+	}
+	return footer, nil
+}
+
+// equal reports whether f has the same records as other
+func (f partFooter) equal(other partFooter) bool {
+	return slices.EqualFunc(f, other, func(a, b footerRecord) bool {
+		return slices.Equal(a.record, b.record)
+	})
+}
+
+// writeFooter ends the current part with the records of s.footer
+func (s *CSVSplitter) writeFooter() error {
+	for _, footer := range s.footer {
+		if s.raw != nil {
+			s.raw.record = footer.raw
+		}
+		if err := s.writer.Write(footer.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFileFooter fails a -footer-action every run whose footer, as held
+// back at the end of the input, is not the one read before the split and
+// written to every part
+func (s *CSVSplitter) checkFileFooter(footer partFooter) error {
+	if !s.footer.equal(footer) {
+		return withExitCode(exitParse, fmt.Errorf("the footer read from the end of '%s' before the split does not match its last records; the parts end with the wrong footer", s.config.InputPath))
+	}
+	return nil
+}
+
+// inputFooterPath returns the single input file of a -footer-action every
+// run
+func inputFooterPath(config Config) (string, error) {
+	files, err := inputFiles(config)
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", configErrorf("footer-action every needs a single input file, whose footer is read before the split")
+	}
+	return files[0], nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// fsProfile holds the I/O settings suited to a kind of file system
+type fsProfile struct {
+	// maxBuffer caps -buffer, or is 0 to leave it as given
+	maxBuffer int
+	// syncParts flushes each part to stable storage before it is renamed
+	// into place, so a completed part is never seen half written
+	syncParts bool
+	// retries is how many times an open, create, or rename failing with a
+	// stale handle is tried again
+	retries int
+}
+
+// fsProfiles maps -fs-profile values to their settings. Network and FUSE
+// mounts get small buffers, since their clients hold dirty pages longer and
+// fail writes late, parts synced on rotation, and retries of stale handles.
+var fsProfiles = map[string]fsProfile{
+	"local":      {},
+	"nfs":        {maxBuffer: 32 * 1024, syncParts: true, retries: 3},
+	"smb":        {maxBuffer: 32 * 1024, syncParts: true, retries: 3},
+	"objectfuse": {maxBuffer: 32 * 1024, syncParts: true, retries: 5},
+}
+
+// validateFSProfile checks the -fs-profile value
+func validateFSProfile(config Config) error {
+	if _, ok := fsProfiles[config.FSProfile]; !ok && config.FSProfile != "auto" {
+		return fmt.Errorf("fs-profile must be auto, local, nfs, smb, or objectfuse")
+	}
+	return nil
+}
+
+// detectFSProfile returns the name of the -fs-profile for a run. With auto,
+// it is the profile of the first of the part directory and the input files
+// that is not on a local file system.
+func detectFSProfile(config Config) string {
+	if config.FSProfile != "auto" {
+		return config.FSProfile
+	}
+	paths := []string{partDir(config)}
+	if !config.FilterMode {
+		files, _ := inputFiles(config)
+		paths = append(paths, files...)
+	}
+	for _, path := range paths {
+		if profile := fileSystemProfile(path); profile != "local" {
+			return profile
+		}
+	}
+	return "local"
+}
+
+// retryStale runs op, running it again with a growing delay while it fails
+// with a stale file handle, up to the retries of the file system profile
+func (s *CSVSplitter) retryStale(op func() error) error {
+	delay := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= s.fs.retries || !isStaleHandle(err) {
+			return err
+		}
+		if s.logger != nil {
+			s.logger.Warn("stale file handle, retrying", "error", err, "attempt", attempt+1)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// fwfSpec describes the columns of a fixed-width file, read from the JSON
+// file of -fwf-spec
+type fwfSpec struct {
+	Columns []fwfColumn `json:"columns"`
+	// SkipLines is the number of lines before the records, such as a
+	// banner or a line of column titles
+	SkipLines int `json:"skip_lines"`
+	// KeepSpaces keeps the spaces padding the fields
+	KeepSpaces bool `json:"keep_spaces"`
+	// Header writes a line of column names atop every part of -output-format
+	// fwf, and Overflow is what is done with a field longer than its column
+	Header   bool   `json:"header"`
+	Overflow string `json:"overflow"`
+}
+
+// fwfColumn is a column of a fixed-width file. Start counts characters from
+// 1, and defaults to the character after the previous column. Align, Pad,
+// and Numeric lay out the fields of -output-format fwf.
+type fwfColumn struct {
+	Name    string `json:"name"`
+	Start   int    `json:"start"`
+	Width   int    `json:"width"`
+	Align   string `json:"align"`
+	Pad     string `json:"pad"`
+	Numeric bool   `json:"numeric"`
+}
+
+// validateFWFConfig checks -input-format and -fwf-spec
+func validateFWFConfig(config Config) error {
+	switch config.InputFormat {
+	case "csv":
+		if config.FWFSpec != "" {
+			return fmt.Errorf("fwf-spec is only used with -input-format fwf")
+		}
+		return nil
+	case "fwf":
+	default:
+		return fmt.Errorf("input-format must be csv or fwf")
+	}
+	if config.FWFSpec == "" {
+		return fmt.Errorf("input-format fwf needs the columns of the input from -fwf-spec")
+	}
+	if config.Passthrough || config.Repair {
+		return fmt.Errorf("input-format fwf cannot be combined with passthrough or repair, which work on the lines of a CSV input")
+	}
+	if config.Comment != 0 || config.FooterAction != "drop" {
+		return fmt.Errorf("input-format fwf cannot be combined with -comment or -footer-action %s, which copy lines of the input as they were read", config.FooterAction)
+	}
+	spec, err := loadFWFSpec(config.FWFSpec)
+	if err != nil {
+		return err
+	}
+	if config.SetHeader != "" {
+		if names, _ := parseSetHeader(config.SetHeader); len(names) != len(spec.Columns) {
+			return fmt.Errorf("set-header has %d columns, but fwf-spec has %d", len(names), len(spec.Columns))
+		}
+	}
+	return nil
+}
+
+// loadFWFSpec reads and checks the fixed-width spec at path
+func loadFWFSpec(path string) (*fwfSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fwf-spec '%s': %w", path, err)
+	}
+	var spec fwfSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse fwf-spec '%s': %w", path, err)
+	}
+	if len(spec.Columns) == 0 {
+		return nil, fmt.Errorf("fwf-spec '%s' defines no columns", path)
+	}
+	if spec.SkipLines < 0 {
+		return nil, fmt.Errorf("fwf-spec '%s': skip_lines must not be negative", path)
+	}
+	next := 1
+	for i := range spec.Columns {
+		column := &spec.Columns[i]
+		if strings.TrimSpace(column.Name) == "" {
+			return nil, fmt.Errorf("fwf-spec '%s': column %d has no name", path, i+1)
+		}
+		if column.Start == 0 {
+			column.Start = next
+		}
+		if column.Start < 1 || column.Width < 1 {
+			return nil, fmt.Errorf("fwf-spec '%s': column %q needs a start from 1 and a width of at least 1", path, column.Name)
+		}
+		next = column.Start + column.Width
+	}
+	return &spec, nil
+}
+
+// names returns the column names of the spec as a -set-header value
+func (f *fwfSpec) names() string {
+	names := make([]string, len(f.Columns))
+	for i, column := range f.Columns {
+		names[i] = column.Name
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(names)
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// loadInputFormat loads the spec of -input-format fwf, whose column names
+// name the columns of the input unless -set-header does
+func (s *CSVSplitter) loadInputFormat() error {
+	if s.config.InputFormat != "fwf" || s.fwf != nil {
+		return nil
+	}
+	spec, err := loadFWFSpec(s.config.FWFSpec)
+	if err != nil {
+		return configErrorf("%v", err)
+	}
+	s.fwf = spec
+	if s.config.SetHeader == "" {
+		s.config.SetHeader = spec.names()
+	}
+	return nil
+}
+
+// fwfReader converts the lines of a fixed-width input into lines of CSV as
+// they are read, one for one, so that errors are found on the line of the
+// input they are on. The lines skipped by the spec become blank lines, which
+// the CSV reader skips.
+type fwfReader struct {
+	r         *bufio.Reader
+	spec      *fwfSpec
+	line      int
+	out       bytes.Buffer
+	writer    *csv.Writer
+	fields    []string
+	remaining []byte
+}
+
+func newFWFReader(r io.Reader, spec *fwfSpec, delimiter rune) *fwfReader {
+	f := &fwfReader{r: bufio.NewReader(r), spec: spec, fields: make([]string, len(spec.Columns))}
+	f.writer = csv.NewWriter(&f.out)
+	f.writer.Comma = delimiter
+	return f
+}
+
+func (f *fwfReader) Read(p []byte) (int, error) {
+	for len(f.remaining) == 0 {
+		line, err := f.r.ReadString('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		f.line++
+		f.out.Reset()
+		if err := f.convert(line); err != nil {
+			return 0, err
+		}
+		f.remaining = f.out.Bytes()
+	}
+	n := copy(p, f.remaining)
+	f.remaining = f.remaining[n:]
+	return n, nil
+}
+
+// convert writes the line as a line of CSV to f.out
+func (f *fwfReader) convert(line string) error {
+	text := strings.TrimRight(line, "\r\n")
+	ending := line[len(text):]
+	if f.line <= f.spec.SkipLines || text == "" {
+		f.out.WriteString("\n")
+		return nil
+	}
+	f.spec.split(text, f.fields)
+	f.writer.UseCRLF = ending == "\r\n"
+	f.writer.Write(f.fields)
+	f.writer.Flush()
+	return f.writer.Error()
+}
+
+// split cuts line into the fields of the columns of the spec. A line
+// shorter than the spec leaves the columns past its end empty.
+func (f *fwfSpec) split(line string, fields []string) {
+	ascii := true
+	for i := 0; i < len(line); i++ {
+		if line[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	var runes []rune
+	if !ascii {
+		runes = []rune(line)
+	}
+	for i, column := range f.Columns {
+		start, end := column.Start-1, column.Start-1+column.Width
+		var field string
+		if ascii {
+			field = line[min(start, len(line)):min(end, len(line))]
+		} else {
+			field = string(runes[min(start, len(runes)):min(end, len(runes))])
+		}
+		if !f.KeepSpaces {
+			field = strings.TrimSpace(field)
+		}
+		fields[i] = field
+	}
+}
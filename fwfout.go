@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fwfOverflows are the values of overflow in the spec of -output-fwf-spec:
+// what is done with a text field longer than its column
+var fwfOverflows = []string{"truncate", "fail"}
+
+// validateFWFOutputConfig checks -output-fwf-spec
+func validateFWFOutputConfig(config Config) error {
+	if config.OutputFormat != "fwf" {
+		if config.OutputFWFSpec != "" {
+			return fmt.Errorf("output-fwf-spec is only used with fwf output")
+		}
+		return nil
+	}
+	if config.OutputFWFSpec == "" {
+		return fmt.Errorf("fwf output needs the layout of the parts from -output-fwf-spec")
+	}
+	_, err := loadFWFOutputSpec(config.OutputFWFSpec)
+	return err
+}
+
+// loadFWFOutputSpec reads the spec laying out the parts of fwf output, whose
+// columns must not overlap
+func loadFWFOutputSpec(path string) (*fwfSpec, error) {
+	spec, err := loadFWFSpec(path)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Overflow == "" {
+		spec.Overflow = "truncate"
+	} else if !slices.Contains(fwfOverflows, spec.Overflow) {
+		return nil, fmt.Errorf("fwf-spec '%s': overflow must be truncate or fail", path)
+	}
+	end := 0
+	for i := range spec.Columns {
+		column := &spec.Columns[i]
+		if column.Start <= end {
+			return nil, fmt.Errorf("fwf-spec '%s': column %q starts inside the column before it", path, column.Name)
+		}
+		end = column.Start + column.Width - 1
+		switch column.Align {
+		case "":
+			column.Align = "left"
+			if column.Numeric {
+				column.Align = "right"
+			}
+		case "left", "right":
+		default:
+			return nil, fmt.Errorf("fwf-spec '%s': column %q: align must be left or right", path, column.Name)
+		}
+		if column.Pad == "" {
+			column.Pad = " "
+		} else if utf8.RuneCountInString(column.Pad) != 1 || strings.ContainsAny(column.Pad, "\r\n") {
+			return nil, fmt.Errorf("fwf-spec '%s': column %q: pad must be a single character", path, column.Name)
+		}
+	}
+	return spec, nil
+}
+
+// fwfWriter writes records as fixed-width lines laid out by -output-fwf-spec.
+// Every column of the spec takes the field of the header column of its
+// name; the fields of other columns are left out.
+type fwfWriter struct {
+	writer   *bufio.Writer
+	specPath string
+	newline  string
+	spec     *fwfSpec
+	indexes  []int
+	line     strings.Builder
+	// width is the number of characters in line
+	width int
+}
+
+func newFWFWriter(w io.Writer, config Config) *fwfWriter {
+	return &fwfWriter{writer: bufio.NewWriter(w), specPath: config.OutputFWFSpec, newline: lineEnding(config)}
+}
+
+// WriteHeader finds the column of every field of the spec, and writes the
+// line of column names if the spec has one
+func (f *fwfWriter) WriteHeader(header []string) error {
+	spec, err := loadFWFOutputSpec(f.specPath)
+	if err != nil {
+		return err
+	}
+	f.spec = spec
+	f.indexes = make([]int, len(spec.Columns))
+	for i, column := range spec.Columns {
+		if f.indexes[i] = slices.Index(header, column.Name); f.indexes[i] < 0 {
+			return fmt.Errorf("column %q of output-fwf-spec is not in the header", column.Name)
+		}
+	}
+	if !spec.Header {
+		return nil
+	}
+	f.line.Reset()
+	f.width = 0
+	for _, column := range spec.Columns {
+		// Names are laid out as text, whatever the column holds
+		names := column
+		names.Numeric, names.Align, names.Pad = false, "left", " "
+		f.pad(column.Start)
+		f.line.WriteString(names.fit(names.Name))
+		f.width += column.Width
+	}
+	return f.writeLine()
+}
+
+func (f *fwfWriter) Write(record []string) error {
+	f.line.Reset()
+	f.width = 0
+	for i, column := range f.spec.Columns {
+		var field string
+		if f.indexes[i] < len(record) {
+			field = record[f.indexes[i]]
+		}
+		if strings.ContainsAny(field, "\r\n") {
+			return fmt.Errorf("column %q of fwf output: %q holds a line break", column.Name, field)
+		}
+		if column.Numeric && field != "" {
+			field = strings.TrimSpace(field)
+			if _, err := strconv.ParseFloat(field, 64); err != nil {
+				return fmt.Errorf("column %q of fwf output: %q is not a number", column.Name, field)
+			}
+		}
+		if n := utf8.RuneCountInString(field); n > column.Width && (column.Numeric || f.spec.Overflow == "fail") {
+			// A number cut short would be another number
+			return fmt.Errorf("column %q of fwf output: %q is longer than its width of %d", column.Name, field, column.Width)
+		}
+		f.pad(column.Start)
+		f.line.WriteString(column.fit(field))
+		f.width += column.Width
+	}
+	return f.writeLine()
+}
+
+// pad fills the line with spaces up to the character before start, the gap
+// left between columns
+func (f *fwfWriter) pad(start int) {
+	if n := start - 1 - f.width; n > 0 {
+		f.line.WriteString(strings.Repeat(" ", n))
+	}
+	f.width = start - 1
+}
+
+func (f *fwfWriter) writeLine() error {
+	f.line.WriteString(f.newline)
+	_, err := f.writer.WriteString(f.line.String())
+	return err
+}
+
+func (f *fwfWriter) Close() error {
+	return f.writer.Flush()
+}
+
+// fit pads field to the width of the column, or cuts it to the width. A
+// number padded with zeros keeps its sign in front of them, and an empty
+// number is left blank.
+func (c fwfColumn) fit(field string) string {
+	if n := utf8.RuneCountInString(field); n > c.Width {
+		runes := []rune(field)
+		return string(runes[:c.Width])
+	}
+	pad := c.Pad
+	if c.Numeric && field == "" {
+		pad = " "
+	}
+	sign := ""
+	if c.Numeric && pad != " " && (strings.HasPrefix(field, "-") || strings.HasPrefix(field, "+")) {
+		sign, field = field[:1], field[1:]
+	}
+	padding := strings.Repeat(pad, c.Width-utf8.RuneCountInString(sign+field))
+	if c.Align == "right" {
+		return sign + padding + field
+	}
+	return sign + field + padding
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of splitcsv.proto are few and small, so they are encoded by
+// hand rather than generated, keeping protoc out of the build
+
+// splitRequest is the SplitRequest message
+type splitRequest struct {
+	options map[string]string
+	data    []byte
+}
+
+// splitEvent is the SplitEvent message. Exactly one field is set.
+type splitEvent struct {
+	started  *splitStarted
+	part     *PartInfo
+	finished *splitFinished
+}
+
+// splitStarted is the SplitStarted message
+type splitStarted struct {
+	id string
+}
+
+// splitFinished is the SplitFinished message
+type splitFinished struct {
+	records  int
+	written  int
+	parts    int
+	rejected int
+}
+
+// newGRPCServer returns a gRPC server exposing the Splitter service of
+// splitcsv.proto, running splits on the slots and job list of q
+func newGRPCServer(q *JobQueue) *grpc.Server {
+	server := grpc.NewServer(grpc.ForceServerCodec(wireCodec{}))
+	server.RegisterService(&splitterServiceDesc, q)
+	return server
+}
+
+// splitterServiceDesc describes the Splitter service of splitcsv.proto
+var splitterServiceDesc = grpc.ServiceDesc{
+	ServiceName: "splitcsv.v1.Splitter",
+	HandlerType: (*interface{ SplitStream(grpc.ServerStream) error })(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Split",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			return srv.(*JobQueue).SplitStream(stream)
+		},
+	}},
+	Metadata: "splitcsv.proto",
+}
+
+// SplitStream implements the Split method: it splits the CSV carried by the
+// request stream into the directory of a new job, sending an event as each
+// part is completed. Parts are checksummed with SHA-256 unless the options
+// choose another algorithm.
+func (q *JobQueue) SplitStream(stream grpc.ServerStream) error {
+	first := &splitRequest{}
+	if err := stream.RecvMsg(first); err != nil {
+		if err == io.EOF {
+			return status.Error(codes.InvalidArgument, "no request received")
+		}
+		return err
+	}
+
+	job, err := q.newJob("stream", first.options)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	config := job.config
+	config.OutputDir = filepath.Join(job.dir, "parts")
+	if config.Checksum == "" {
+		config.Checksum = "sha256"
+	}
+	if err := validateOutputConfig(config); err != nil {
+		job.cancel()
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		defer func() { <-q.slots }()
+	case <-stream.Context().Done():
+		job.cancel()
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		job.cancel()
+		return status.Errorf(codes.Internal, "failed to create job directory: %v", err)
+	}
+	q.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = time.Now()
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+	defer context.AfterFunc(stream.Context(), job.cancel)()
+
+	if err := stream.SendMsg(&splitEvent{started: &splitStarted{id: job.ID}}); err != nil {
+		q.finish(job, nil, err)
+		return err
+	}
+
+	// Requests are fed to the splitter as they arrive
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		data := first.data
+		var received int64
+		for {
+			if received += int64(len(data)); q.maxUpload > 0 && received > q.maxUpload {
+				pw.CloseWithError(&http.MaxBytesError{Limit: q.maxUpload})
+				return
+			}
+			if _, err := pw.Write(data); err != nil {
+				return
+			}
+			req := &splitRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			data = req.data
+		}
+	}()
+
+	splitter := NewCSVSplitter(config)
+	var sendErr error
+	splitter.partDone = func(part PartInfo) {
+		if sendErr == nil {
+			sendErr = stream.SendMsg(&splitEvent{part: &part})
+		}
+	}
+	err = splitter.SplitReader(job.ctx, pr)
+	if err == nil {
+		err = sendErr
+	}
+
+	parts := make([]string, len(splitter.parts))
+	for i, part := range splitter.parts {
+		parts[i] = filepath.Base(part.Path)
+	}
+	q.finish(job, parts, err)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case uploadStatus(err) == http.StatusRequestEntityTooLarge:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		code := codes.Internal
+		if c := exitCode(err); c == exitParse || c == exitConfig {
+			code = codes.InvalidArgument
+		}
+		return status.Error(code, err.Error())
+	}
+
+	finished := &splitFinished{
+		records: splitter.stats.records,
+		written: splitter.stats.written,
+		parts:   len(splitter.parts),
+	}
+	for _, count := range splitter.stats.rejected {
+		finished.rejected += count
+	}
+	return stream.SendMsg(&splitEvent{finished: finished})
+}
+
+// wireCodec encodes the messages of splitcsv.proto in the protobuf wire
+// format, standing in for the generated code the default codec expects. The
+// server only decodes requests and encodes events.
+type wireCodec struct{}
+
+func (wireCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(interface{ marshal() []byte })
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (wireCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(interface{ unmarshal(b []byte) error })
+	if !ok {
+		return fmt.Errorf("cannot decode %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (wireCodec) Name() string {
+	return "proto"
+}
+
+func (r *splitRequest) unmarshal(b []byte) error {
+	r.options = map[string]string{}
+	return consumeFields(b, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			var key, val string
+			err := consumeFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					key = string(value)
+				case 2:
+					val = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			r.options[key] = val
+		case 2:
+			// The buffer may be reused once decoding returns
+			r.data = append(r.data[:0], value...)
+		}
+		return nil
+	})
+}
+
+func (e *splitEvent) marshal() []byte {
+	var b []byte
+	switch {
+	case e.started != nil:
+		b = appendMessageField(b, 1, appendStringField(nil, 1, e.started.id))
+	case e.part != nil:
+		var part []byte
+		part = appendStringField(part, 1, filepath.Base(e.part.Path))
+		part = appendIntField(part, 2, int64(e.part.Records))
+		part = appendIntField(part, 3, e.part.Bytes)
+		part = appendStringField(part, 4, e.part.Checksum)
+		b = appendMessageField(b, 2, part)
+	case e.finished != nil:
+		var finished []byte
+		finished = appendIntField(finished, 1, int64(e.finished.records))
+		finished = appendIntField(finished, 2, int64(e.finished.written))
+		finished = appendIntField(finished, 3, int64(e.finished.parts))
+		finished = appendIntField(finished, 4, int64(e.finished.rejected))
+		b = appendMessageField(b, 3, finished)
+	}
+	return b
+}
+
+// consumeFields calls field with the number and contents of each
+// length-delimited field of the encoded message b, skipping other fields
+func consumeFields(b []byte, field func(num protowire.Number, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := field(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendStringField appends a string field, omitting it when empty as proto3
+// does
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendIntField appends an int64 field, omitting it when zero as proto3
+// does
+func appendIntField(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendMessageField appends an embedded message field
+func appendMessageField(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseSetHeader parses the column names of -set-header, a line of CSV so
+// that a name may hold a comma when quoted
+func parseSetHeader(value string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(value))
+	reader.TrimLeadingSpace = true
+	names, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid set-header %q: %v", value, err)
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if names[i] == "" {
+			return nil, fmt.Errorf("invalid set-header %q: column %d has no name", value, i+1)
+		}
+	}
+	return names, nil
+}
+
+// validateHeaderConfig checks -header and -set-header
+func validateHeaderConfig(config Config) error {
+	if config.Header != "yes" && config.Header != "no" && config.Header != "auto" {
+		return fmt.Errorf("header must be yes, no, or auto")
+	}
+	if config.SetHeader != "" {
+		if _, err := parseSetHeader(config.SetHeader); err != nil {
+			return err
+		}
+	}
+	if config.Header == "no" && config.SetHeader == "" && config.InputFormat != "fwf" {
+		return fmt.Errorf("an input without a header needs its column names from -set-header")
+	}
+	if config.DedupeHeaders && config.FailOnDupHeaders {
+		return fmt.Errorf("dedupe-headers cannot be combined with fail-on-duplicate-headers")
+	}
+	if config.SetHeader != "" && config.FailOnDupHeaders {
+		names, _ := parseSetHeader(config.SetHeader)
+		if problems := headerProblems(names); len(problems) > 0 {
+			return fmt.Errorf("set-header has %s", strings.Join(problems, ", "))
+		}
+	}
+	return nil
+}
+
+// resolveHeader settles -header auto from sample, the start of the input,
+// which is complete when it holds all of it. The first line is then read as
+// with -header yes or no, for this input and those after it. An input found
+// to have no header has its columns named by -set-header, or column_1,
+// column_2, and so on.
+func (s *CSVSplitter) resolveHeader(sample []byte, complete bool) {
+	if s.config.Header != "auto" {
+		return
+	}
+	reader := csv.NewReader(bytes.NewReader(sample))
+	reader.Comma = s.config.Delimiter
+	reader.Comment = s.config.Comment
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = s.config.TrimLeadingSpace && !s.config.Pedantic
+	reader.FieldsPerRecord = -1
+	var records [][]string
+	for len(records) <= headerSampleRecords {
+		record, err := reader.Read()
+		if err == io.EOF && !complete && len(records) > 1 {
+			// The last record may have been cut off by the end of the sample
+			records = records[:len(records)-1]
+		}
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	s.config.Header = "yes"
+	if len(records) == 0 {
+		// An empty input is reported by readHeader
+		return
+	}
+
+	header, sure := detectHeader(records[0], records[1:])
+	what := "a header"
+	if !header {
+		s.config.Header = "no"
+		what = "the first record"
+		if s.config.SetHeader == "" {
+			names := make([]string, len(records[0]))
+			for i := range names {
+				names[i] = fmt.Sprintf("column_%d", i+1)
+			}
+			s.config.SetHeader = strings.Join(names, ",")
+		}
+	}
+	switch {
+	case !sure && s.logger != nil:
+		s.logger.Warn("header detection unsure", "input", s.source, "header", header)
+	case !sure:
+		fmt.Fprintf(os.Stderr, "Warning: -header auto took the first line of %s as %s, but is not sure; set -header yes or no\n", s.source, what)
+	case s.logger != nil:
+		s.logger.Info("header detected", "input", s.source, "header", header)
+	case s.config.Verbose:
+		fmt.Printf("First line of %s read as %s\n", s.source, what)
+	}
+}
+
+// applySetHeader returns the -set-header names in place of first, the first
+// record of an input, which must have as many fields. Without a header, first
+// is the first data record, and is held for takeFirstRecord when it was read
+// from the input being split.
+func (s *CSVSplitter) applySetHeader(reader *csv.Reader, first []string) ([]string, error) {
+	if s.config.SetHeader == "" {
+		return first, nil
+	}
+	names, _ := parseSetHeader(s.config.SetHeader)
+	if len(names) != len(first) {
+		what := "header"
+		if s.config.Header == "no" {
+			what = "first record"
+		}
+		return nil, withExitCode(exitParse, fmt.Errorf("set-header has %d columns, but the %s of the input has %d", len(names), what, len(first)))
+	}
+	if s.config.Header == "no" && reader == s.input {
+		s.firstRecord = first
+	}
+	return names, nil
+}
+
+// headerProblems describes the duplicate and blank column names of header,
+// which leave columns that cannot be told apart by name
+func headerProblems(header []string) []string {
+	var problems []string
+	positions := map[string][]string{}
+	var names []string
+	for i, name := range header {
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, fmt.Sprintf("blank column %d", i+1))
+			continue
+		}
+		if positions[name] == nil {
+			names = append(names, name)
+		}
+		positions[name] = append(positions[name], strconv.Itoa(i+1))
+	}
+	for _, name := range names {
+		if columns := positions[name]; len(columns) > 1 {
+			problems = append(problems, fmt.Sprintf("duplicate column %q (columns %s)", name, strings.Join(columns, ", ")))
+		}
+	}
+	return problems
+}
+
+// dedupeHeader returns header with every duplicate column name after the
+// first given the suffix _2, _3, and so on, and blank names replaced by
+// column_N, skipping names the header already has
+func dedupeHeader(header []string) []string {
+	taken := make(map[string]bool, len(header))
+	for _, name := range header {
+		taken[name] = true
+	}
+	seen := make(map[string]bool, len(header))
+	names := make([]string, len(header))
+	for i, name := range header {
+		if strings.TrimSpace(name) == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+			if !taken[name] {
+				names[i], taken[name], seen[name] = name, true, true
+				continue
+			}
+		} else if !seen[name] {
+			names[i], seen[name] = name, true
+			continue
+		}
+		for n := 2; ; n++ {
+			renamed := fmt.Sprintf("%s_%d", name, n)
+			if !taken[renamed] {
+				names[i], taken[renamed], seen[renamed] = renamed, true, true
+				break
+			}
+		}
+	}
+	return names
+}
+
+// checkHeaderNames looks for duplicate and blank column names in header,
+// which -dedupe-headers renames and -fail-on-duplicate-headers fails on.
+// Otherwise they are kept, with a warning for the first input that has
+// them.
+func (s *CSVSplitter) checkHeaderNames(header []string) ([]string, error) {
+	problems := headerProblems(header)
+	if len(problems) == 0 {
+		return header, nil
+	}
+	first := !s.headerChecked
+	s.headerChecked = true
+	switch {
+	case s.config.FailOnDupHeaders:
+		return nil, withExitCode(exitParse, fmt.Errorf("header has %s", strings.Join(problems, ", ")))
+	case s.config.DedupeHeaders:
+		names := dedupeHeader(header)
+		s.headerRenamed = true
+		if !first {
+			return names, nil
+		}
+		var renamed []string
+		for i := range header {
+			if names[i] != header[i] {
+				renamed = append(renamed, fmt.Sprintf("%q to %q", header[i], names[i]))
+			}
+		}
+		if s.logger != nil {
+			s.logger.Info("header columns renamed", "input", s.source, "renamed", renamed)
+		} else if s.config.Verbose {
+			fmt.Printf("Renamed header columns of %s: %s\n", s.source, strings.Join(renamed, ", "))
+		}
+		return names, nil
+	}
+	if first {
+		if s.logger != nil {
+			s.logger.Warn("header has duplicate or blank column names", "input", s.source, "problems", problems)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: header of %s has %s; set -dedupe-headers to rename them\n", s.source, strings.Join(problems, ", "))
+		}
+	}
+	return header, nil
+}
+
+// takeFirstRecord returns the first record of an input without a header,
+// read in place of its header, once
+func (s *CSVSplitter) takeFirstRecord() []string {
+	record := s.firstRecord
+	s.firstRecord = nil
+	return record
+}
+
+// firstRecordReader reads the records of an input without a header, starting
+// with the one read in place of its header
+type firstRecordReader struct {
+	s      *CSVSplitter
+	reader recordReader
+}
+
+func (f *firstRecordReader) Read() ([]string, error) {
+	if record := f.s.takeFirstRecord(); record != nil {
+		return record, nil
+	}
+	return f.reader.Read()
+}
+
+// encodeHeader returns the -set-header names as a line of the input, with
+// the delimiter of the input and the line ending of line, the header or first
+// record they stand for
+func (s *CSVSplitter) encodeHeader(names []string, line []byte) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Comma = s.config.Delimiter
+	writer.UseCRLF = bytes.HasSuffix(line, []byte("\r\n"))
+	writer.Write(names)
+	writer.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+)
+
+// hmacKeyEnv is the environment variable holding the key of -hash-column
+// when no -hmac-key-file is given. It is not an option of its own, so that
+// the key never appears in a command line or config file.
+const hmacKeyEnv = envPrefix + "HMAC_KEY"
+
+// hmacAlgorithms are the algorithms of -hash-column
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// parseHashColumn parses a -hash-column rule of the form column:algorithm
+func parseHashColumn(value string) (column string, algorithm string, err error) {
+	column, algorithm, ok := strings.Cut(value, ":")
+	column = strings.TrimSpace(column)
+	if !ok || column == "" {
+		return "", "", fmt.Errorf("invalid hash-column %q: must be column:algorithm", value)
+	}
+	if _, ok := hmacAlgorithms[algorithm]; !ok {
+		return "", "", fmt.Errorf("invalid hash-column %q: algorithm must be hmac-sha256 or hmac-sha512", value)
+	}
+	return column, algorithm, nil
+}
+
+// checkHashColumn checks the syntax of a -hash-column rule
+func checkHashColumn(value string) error {
+	_, _, err := parseHashColumn(value)
+	return err
+}
+
+// loadHMACKey returns the key of -hash-column, read from -hmac-key-file or
+// the SPLITCSV_HMAC_KEY environment variable. A trailing newline of the file
+// is not part of the key.
+func loadHMACKey(config Config) ([]byte, error) {
+	if config.HMACKeyFile != "" {
+		data, err := os.ReadFile(config.HMACKeyFile)
+		if err != nil {
+			return nil, configErrorf("failed to read hmac key: %v", err)
+		}
+		key := strings.TrimRight(string(data), "\r\n")
+		if key == "" {
+			return nil, configErrorf("hmac key file '%s' is empty", config.HMACKeyFile)
+		}
+		return []byte(key), nil
+	}
+	key := os.Getenv(hmacKeyEnv)
+	if key == "" {
+		return nil, configErrorf("hash-column needs a key in -hmac-key-file or %s", hmacKeyEnv)
+	}
+	return []byte(key), nil
+}
+
+// newHashColumns returns the transforms of the -hash-column rules for the
+// columns of header, which replace values with their keyed hash in
+// hexadecimal
+func (s *CSVSplitter) newHashColumns(header []string) ([]fieldTransform, error) {
+	if len(s.config.HashColumns) == 0 {
+		return nil, nil
+	}
+	key, err := loadHMACKey(s.config)
+	if err != nil {
+		return nil, err
+	}
+	var transforms []fieldTransform
+	for _, value := range s.config.HashColumns {
+		name, algorithm, err := parseHashColumn(value)
+		if err != nil {
+			return nil, err
+		}
+		column := columnIndex(header, name)
+		if column < 0 {
+			return nil, configErrorf("hash-column %q not found in header", name)
+		}
+		// Each of the -workers needs a hash of its own
+		macs := &sync.Pool{New: func() any { return hmac.New(hmacAlgorithms[algorithm], key) }}
+		transforms = append(transforms, fieldTransform{column, func(value string) (string, error) {
+			if value == "" {
+				return "", nil
+			}
+			mac := macs.Get().(hash.Hash)
+			defer macs.Put(mac)
+			mac.Reset()
+			mac.Write([]byte(value))
+			return hex.EncodeToString(mac.Sum(nil)), nil
+		}})
+	}
+	return transforms, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashColumns(t *testing.T) {
+	header := []string{"id", "user_id"}
+	want := func(key, value string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	t.Setenv(hmacKeyEnv, "env-key")
+	s := &CSVSplitter{config: testConfig(t, "-hash-column", "user_id:hmac-sha256")}
+	transforms, err := s.newHashColumns(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := applyTransforms(transforms, []string{"1", "u-42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "1" || got[1] != want("env-key", "u-42") {
+		t.Errorf("hashed record = %q, want [1 %s]", got, want("env-key", "u-42"))
+	}
+	if got, _, _ := applyTransforms(transforms, []string{"2", ""}); got[1] != "" {
+		t.Errorf("hashed empty field = %q, want it empty", got[1])
+	}
+
+	// The key file takes precedence over the environment, without its line
+	// break
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = &CSVSplitter{config: testConfig(t, "-hash-column", "user_id:hmac-sha256", "-hmac-key-file", keyFile)}
+	if transforms, err = s.newHashColumns(header); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := applyTransforms(transforms, []string{"1", "u-42"}); got[1] != want("file-key", "u-42") {
+		t.Errorf("hashed with the key file = %q, want %s", got[1], want("file-key", "u-42"))
+	}
+
+	t.Setenv(hmacKeyEnv, "")
+	s = &CSVSplitter{config: testConfig(t, "-hash-column", "user_id:hmac-sha256")}
+	if _, err := s.newHashColumns(header); err == nil || !strings.Contains(err.Error(), "needs a key") {
+		t.Errorf("newHashColumns without a key = %v, want an error", err)
+	}
+	s = &CSVSplitter{config: testConfig(t, "-hash-column", "email:hmac-sha256", "-hmac-key-file", keyFile)}
+	if _, err := s.newHashColumns(header); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("newHashColumns of a missing column = %v, want an error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// dateLayouts are the layouts recognized when inferring date columns
+var dateLayouts = []string{time.DateOnly, "01/02/2006", "02.01.2006"}
+
+// datetimeLayouts are the layouts recognized when inferring datetime columns
+var datetimeLayouts = []string{time.RFC3339Nano, time.DateTime, "2006-01-02T15:04:05"}
+
+// inferValueType returns the narrowest schema type that value parses as
+func inferValueType(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "int"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "float"
+	}
+	if _, err := strconv.ParseBool(value); err == nil {
+		return "bool"
+	}
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return "date"
+		}
+	}
+	for _, layout := range datetimeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return "datetime"
+		}
+	}
+	return "string"
+}
+
+// mergeTypes returns the type able to hold values of both a and b
+func mergeTypes(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "" || a == b:
+		return a
+	case (a == "int" && b == "float") || (a == "float" && b == "int"):
+		return "float"
+	}
+	return "string"
+}
+
+// inferColumnTypes infers a schema type for each of columns columns from
+// sample rows. Empty values are ignored; columns without any values are
+// reported as string.
+func inferColumnTypes(rows [][]string, columns int) []string {
+	types := make([]string, columns)
+	for _, row := range rows {
+		for i := 0; i < columns && i < len(row); i++ {
+			if row[i] == "" || types[i] == "string" {
+				continue
+			}
+			types[i] = mergeTypes(types[i], inferValueType(row[i]))
+		}
+	}
+	for i, t := range types {
+		if t == "" {
+			types[i] = "string"
+		}
+	}
+	return types
+}
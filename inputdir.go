@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runInputDir splits the CSV files found in config.InputDir, descending into
+// subdirectories with -recursive. With -combine the files are read as one
+// input; otherwise each is split on its own into a directory under
+// config.OutputDir that mirrors its place below config.InputDir.
+func runInputDir(config Config, usage func()) error {
+	logger := newLogger(config)
+	if config.InputPath != "" || config.WatchDir != "" || config.FilterMode {
+		return reportError(logger, configErrorf("input-dir cannot be combined with -input, -watch, or -filter-mode"))
+	}
+	if !config.Combine && (config.Resume || config.Deadline != 0) {
+		return reportError(logger, configErrorf("resume and deadline need -combine when splitting an -input-dir"))
+	}
+	files, err := findInputFiles(config)
+	if err != nil {
+		return reportError(logger, err)
+	}
+	if len(files) == 0 {
+		return reportError(logger, withExitCode(exitInputNotFound, fmt.Errorf("no CSV files found in '%s'", config.InputDir)))
+	}
+
+	if config.Combine {
+		config.InputPath = files[0]
+		config.Inputs = files[1:]
+		return runSplit(config, usage)
+	}
+
+	failed, partial := 0, 0
+	for _, path := range files {
+		rel, _ := filepath.Rel(config.InputDir, path)
+		fileConfig := config
+		fileConfig.InputPath = path
+		fileConfig.OutputDir = filepath.Join(config.OutputDir, strings.TrimSuffix(rel, filepath.Ext(rel)))
+		if config.ReportPath != "" {
+			fileConfig.ReportPath = filepath.Join(fileConfig.OutputDir, filepath.Base(config.ReportPath))
+		}
+		if config.LineagePath != "" {
+			fileConfig.LineagePath = filepath.Join(fileConfig.OutputDir, filepath.Base(config.LineagePath))
+		}
+		if config.RecordPath != "" {
+			fileConfig.RecordPath = filepath.Join(fileConfig.OutputDir, filepath.Base(config.RecordPath))
+		}
+
+		err := runSplit(fileConfig, nil)
+		switch exitCode(err) {
+		case exitOK:
+		case exitPartial:
+			partial++
+		case exitConfig, exitInterrupted:
+			return err
+		default:
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return reportError(logger, fmt.Errorf("%d of %d files failed to split", failed, len(files)))
+	}
+	if partial > 0 {
+		return loggedError{withExitCode(exitPartial, fmt.Errorf("%d of %d files had rejected records", partial, len(files)))}
+	}
+	return nil
+}
+
+// findInputFiles returns the CSV files in config.InputDir, and below it with
+// -recursive, in lexical order. Hidden files and directories, and the output
+// directory, are skipped.
+func findInputFiles(config Config) ([]string, error) {
+	root := filepath.Clean(config.InputDir)
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return nil, withExitCode(exitInputNotFound, fmt.Errorf("input directory does not exist: %s", config.InputDir))
+	}
+	outputDir := filepath.Clean(config.OutputDir)
+
+	var files []string
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == root {
+				return nil
+			}
+			if !config.Recursive || path == outputDir || strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() && isInputCandidate(entry.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input directory: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// inputsValue is the -input flag. It can be given more than once: the first
+// value is kept in InputPath, so that single-file runs are unaffected, and
+// the others in Inputs.
+type inputsValue struct {
+	config *Config
+}
+
+func (v *inputsValue) String() string {
+	if v == nil || v.config == nil {
+		return ""
+	}
+	return strings.Join(append([]string{v.config.InputPath}, v.config.Inputs...), ",")
+}
+
+func (v *inputsValue) Set(value string) error {
+	if v.config.InputPath == "" {
+		v.config.InputPath = value
+	} else {
+		v.config.Inputs = append(v.config.Inputs, value)
+	}
+	return nil
+}
+
+// reset forgets the inputs set so far
+func (v *inputsValue) reset() {
+	v.config.InputPath = ""
+	v.config.Inputs = nil
+}
+
+// inputFiles returns the files named by the -input values, in order,
+// expanding those that are glob patterns such as data/*.csv. A file matched
+// more than once is read once.
+func inputFiles(config Config) ([]string, error) {
+	var files []string
+	for _, pattern := range append([]string{config.InputPath}, config.Inputs...) {
+		matches := []string{pattern}
+		if _, err := os.Stat(pattern); err != nil && strings.ContainsAny(pattern, "*?[") {
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, configErrorf("invalid input pattern '%s': %v", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, withExitCode(exitInputNotFound, fmt.Errorf("no input files match '%s'", pattern))
+			}
+		}
+		for _, match := range matches {
+			entries := []string{match}
+			if _, _, ok := zipInputPath(match); ok {
+				var err error
+				if entries, err = zipEntries(match); err != nil {
+					return nil, err
+				}
+			}
+			for _, entry := range entries {
+				if !slices.Contains(files, entry) {
+					files = append(files, entry)
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// splitFiles splits the records of several CSV files as one input. Every file
+// must start with the same header, which the parts carry once.
+func (s *CSVSplitter) splitFiles(ctx context.Context, files []string) error {
+	s.stats.startedAt = time.Now()
+
+	reader := &multiFileReader{s: s, files: files}
+	defer reader.Close()
+	if err := reader.next(); err != nil {
+		return err
+	}
+	// Headers are compared up front so a mismatch does not leave parts behind
+	for _, path := range reader.files {
+		if err := reader.checkHeader(path); err != nil {
+			return err
+		}
+	}
+
+	if s.logger != nil {
+		s.logger.Info("split started", "input", s.config.InputPath, "files", len(files), "limit", s.config.MaxRecords, "fs_profile", s.fsName)
+	} else if s.config.Verbose {
+		fmt.Printf("Starting to split %d CSV files: %s\n", len(files), strings.Join(files, ", "))
+		fmt.Printf("Run ID: %s\n", s.config.RunID)
+		fmt.Printf("File system profile: %s\n", s.fsName)
+		fmt.Printf("Max records per file: %s\n", limitText(s.config.MaxRecords))
+	}
+
+	return s.splitRecords(ctx, reader.header, reader)
+}
+
+// sourceName returns how -source-column names the input file at path:
+// relative to -input-dir when splitting one, and as given otherwise
+func (s *CSVSplitter) sourceName(path string) string {
+	if s.config.InputDir != "" {
+		if rel, err := filepath.Rel(s.config.InputDir, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+// multiFileReader reads the records of several CSV files in turn, skipping
+// the header of each after checking it against the header of the first
+type multiFileReader struct {
+	s      *CSVSplitter
+	files  []string
+	first  string
+	header []string
+	file   inputFile
+	reader *csv.Reader
+}
+
+func (m *multiFileReader) Read() ([]string, error) {
+	for {
+		if record := m.s.takeFirstRecord(); record != nil {
+			return record, nil
+		}
+		record, err := m.reader.Read()
+		if err != io.EOF || len(m.files) == 0 {
+			return record, err
+		}
+		if err := m.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// next closes the current file and opens the following one, reading its
+// header
+func (m *multiFileReader) next() error {
+	m.Close()
+	path := m.files[0]
+	m.files = m.files[1:]
+
+	file, err := m.s.openInputPath(path)
+	if err != nil {
+		return err
+	}
+	m.file = file
+	m.s.source = m.s.sourceName(path)
+	var r io.Reader = file
+	if m.s.config.Mmap {
+		if r, err = m.s.mapInput(file); err != nil {
+			return err
+		}
+	}
+	m.reader = m.s.inputReader(r)
+	header, err := m.s.readHeader(m.reader)
+	if err != nil {
+		// Not a *csv.ParseError, so that -on-error quarantine does not
+		// mistake a header for a record
+		return withExitCode(exitParse, fmt.Errorf("'%s': %v", path, err))
+	}
+	m.s.takeComments()
+	m.s.passHeader(header)
+
+	if m.header == nil {
+		m.header = header
+		m.first = path
+		return nil
+	}
+	return m.compare(path, header)
+}
+
+// checkHeader reads the header of the file at path and compares it with
+// the header of the first file
+func (m *multiFileReader) checkHeader(path string) error {
+	file, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header, err := m.s.readHeader(m.s.createReader(file))
+	if err != nil {
+		return withExitCode(exitParse, fmt.Errorf("'%s': %v", path, err))
+	}
+	return m.compare(path, header)
+}
+
+// compare checks the header of the file at path against the header of the
+// first file
+func (m *multiFileReader) compare(path string, header []string) error {
+	if !slices.Equal(header, m.header) {
+		return withExitCode(exitParse, fmt.Errorf("header of '%s' does not match the header of '%s'", path, m.first))
+	}
+	return nil
+}
+
+// Close closes the file being read
+func (m *multiFileReader) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	m.s.unmapInput()
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// partialSuffix marks a trailing part that was cut short by an interruption
+const partialSuffix = ".partial"
+
+// Checkpoint records how far an interrupted split got. ResumeAt is the
+// number of input records covered by the completed parts, which -resume
+// skips before writing part NextPart.
+type Checkpoint struct {
+	RunID          string     `json:"run_id,omitempty"`
+	Input          string     `json:"input,omitempty"`
+	Reason         string     `json:"reason"`
+	RecordsRead    int        `json:"records_read"`
+	RecordsWritten int        `json:"records_written"`
+	CompletedParts []PartInfo `json:"completed_parts"`
+	PartialPart    *PartInfo  `json:"partial_part,omitempty"`
+	NextPart       int        `json:"next_part"`
+	ResumeAt       int        `json:"resume_at"`
+	InterruptedAt  time.Time  `json:"interrupted_at"`
+	// PgLoaded is the number of records of the partial part -pg-batch
+	// committed, which -resume does not load again
+	PgLoaded int `json:"pg_loaded,omitempty"`
+	// KafkaProduced is the number of records of the partial part produced
+	// to -kafka-topic, which -resume does not produce again
+	KafkaProduced int `json:"kafka_produced,omitempty"`
+}
+
+// checkpointPath returns where the checkpoint of a stopped run is written
+func checkpointPath(config Config) string {
+	return filepath.Join(config.OutputDir, config.OutputPrefix+"_checkpoint.json")
+}
+
+// loadCheckpoint reads the checkpoint a run with config is resumed from
+func loadCheckpoint(config Config) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	path := checkpointPath(config)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, configErrorf("no checkpoint to resume from: %s", path)
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("failed to read checkpoint '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil || checkpoint.NextPart < 1 {
+		return checkpoint, configErrorf("invalid checkpoint '%s'", path)
+	}
+	return checkpoint, nil
+}
+
+// signalContext returns a context canceled on SIGINT or SIGTERM. After the
+// first signal the default handling is restored, so a second one kills the
+// process immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// withDeadline returns a copy of ctx that is canceled after d, or ctx itself
+// if d is zero
+func withDeadline(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// interrupt stops a split whose context was canceled after recordsRead input
+// records. The current part is flushed and closed. When the -deadline passed,
+// it is published as a complete part; after a signal, if it is not full it is
+// published with the partial suffix so globs for complete parts skip it. A
+// checkpoint describing the completed parts is written next to the output.
+// In -filter-mode the records are only flushed, and the outputs of a routed
+// split, such as -ratio, or of a -shuffle are removed, as they cannot be
+// resumed.
+func (s *CSVSplitter) interrupt(cause error, recordsRead int) error {
+	if s.routed != nil {
+		s.routed.discard()
+		return withExitCode(exitInterrupted, fmt.Errorf("interrupted after %d records, outputs removed: %w", recordsRead, cause))
+	}
+	if s.streams != nil {
+		// The parts of -route streams cannot be resumed, so only the
+		// completed ones are kept
+		s.streams.discard()
+		s.discardCurrentFile()
+		return withExitCode(exitInterrupted, fmt.Errorf("interrupted after %d records, incomplete parts removed: %w", recordsRead, cause))
+	}
+	if s.shuffle != nil {
+		s.discardCurrentFile()
+		for _, part := range s.parts {
+			os.Remove(part.Path)
+			if s.config.Checksum != "" {
+				os.Remove(part.Path + "." + s.config.Checksum)
+			}
+		}
+		s.parts = nil
+		return withExitCode(exitInterrupted, fmt.Errorf("interrupted after %d records, outputs removed: %w", recordsRead, cause))
+	}
+	if s.config.FilterMode {
+		// Records on stdout are already consumed, so there is nothing to resume
+		if err := s.closeCurrentFile(); err != nil {
+			return err
+		}
+		return withExitCode(exitInterrupted, fmt.Errorf("interrupted after %d records: %w", recordsRead, cause))
+	}
+	deadline := errors.Is(cause, context.DeadlineExceeded)
+	n := len(s.parts)
+	if deadline && n > 0 && s.parts[n-1].Records == 0 {
+		// Nothing was written to the current part yet, so it is dropped
+		s.discardCurrentFile()
+		s.partNumber--
+		n--
+	}
+	partial := !deadline && n > 0 && (s.config.MaxRecords == 0 || s.parts[n-1].Records < s.config.MaxRecords)
+	if partial {
+		s.outPath += partialSuffix
+		s.parts[n-1].Path = s.outPath
+		s.partDone = nil
+		if s.pg != nil {
+			// The part is written again on -resume, so only the batches
+			// already committed stay loaded
+			s.pg.abort()
+		}
+	}
+	if err := s.closeCurrentFile(); err != nil {
+		return err
+	}
+
+	checkpoint := Checkpoint{
+		RunID:          s.config.RunID,
+		Input:          s.config.InputPath,
+		Reason:         "interrupted",
+		RecordsRead:    recordsRead,
+		RecordsWritten: s.stats.written,
+		NextPart:       s.partNumber,
+		ResumeAt:       recordsRead,
+		InterruptedAt:  time.Now(),
+	}
+	if deadline {
+		checkpoint.Reason = "deadline"
+	}
+
+	if partial {
+		part := s.parts[n-1]
+		checkpoint.PartialPart = &part
+		checkpoint.NextPart = s.partNumber - 1
+		checkpoint.ResumeAt = s.partStart
+		if s.pg != nil {
+			checkpoint.PgLoaded = s.pg.loaded
+		}
+		if s.kafka != nil {
+			checkpoint.KafkaProduced = s.kafka.produced
+		}
+		checkpoint.CompletedParts = s.parts[:n-1]
+	} else {
+		checkpoint.CompletedParts = s.parts
+	}
+	if checkpoint.CompletedParts == nil {
+		checkpoint.CompletedParts = []PartInfo{}
+	}
+
+	path := checkpointPath(s.config)
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint '%s': %w", path, err)
+	}
+
+	if deadline {
+		if s.logger != nil {
+			s.logger.Warn("split deadline reached", "records", recordsRead, "checkpoint", path)
+		}
+		return withExitCode(exitDeadline, fmt.Errorf("deadline of %s reached after %d records, checkpoint written to %s; continue with -resume",
+			s.config.Deadline, recordsRead, path))
+	}
+	if s.logger != nil {
+		s.logger.Warn("split interrupted", "records", recordsRead, "checkpoint", path)
+	}
+	return withExitCode(exitInterrupted, fmt.Errorf("interrupted after %d records, checkpoint written to %s: %w",
+		recordsRead, path, cause))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// joinRule is a -join rule: the records are matched on column to the rows of
+// the lookup file at path whose lookup column holds the same value
+type joinRule struct {
+	path   string
+	column string
+	lookup string
+}
+
+// parseJoin parses a -join rule of the form 'path on column', or 'path on
+// column=lookup_column' when the key column is named differently in the
+// lookup file. The path ends at the last " on ", so it may contain one.
+func parseJoin(value string) (joinRule, error) {
+	at := strings.LastIndex(value, " on ")
+	if at < 0 {
+		return joinRule{}, fmt.Errorf("invalid join %q: must be 'lookup.csv on column'", value)
+	}
+	rule := joinRule{path: strings.TrimSpace(value[:at])}
+	column, lookup, ok := strings.Cut(value[at+len(" on "):], "=")
+	rule.column = strings.TrimSpace(column)
+	rule.lookup = rule.column
+	if ok {
+		rule.lookup = strings.TrimSpace(lookup)
+	}
+	if rule.path == "" || rule.column == "" || rule.lookup == "" {
+		return joinRule{}, fmt.Errorf("invalid join %q: must be 'lookup.csv on column'", value)
+	}
+	return rule, nil
+}
+
+// lookupJoin holds the rows of a -join lookup file by key, without their key
+// column, to append to the records with the same key
+type lookupJoin struct {
+	column  int
+	columns []string
+	rows    map[string][]string
+	// empty is appended to records without a matching row
+	empty     []string
+	matched   int
+	unmatched int
+}
+
+// newLookupJoin loads the lookup file of the -join rule, and resolves its
+// key column against header
+func (s *CSVSplitter) newLookupJoin(header []string) (*lookupJoin, error) {
+	rule, err := parseJoin(s.config.Join)
+	if err != nil {
+		return nil, err
+	}
+	j := &lookupJoin{column: columnIndex(header, rule.column), rows: map[string][]string{}}
+	if j.column < 0 {
+		return nil, configErrorf("join column %q not found in header", rule.column)
+	}
+
+	file, err := openInput(rule.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, _ := newInspectReader(file, 0)
+	reader.FieldsPerRecord = -1
+	lookupHeader, err := reader.Read()
+	if err == io.EOF {
+		return nil, withExitCode(exitParse, fmt.Errorf("lookup file '%s' is empty", rule.path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup file '%s': failed to read header: %w", rule.path, err)
+	}
+	key := columnIndex(lookupHeader, rule.lookup)
+	if key < 0 {
+		return nil, configErrorf("join column %q not found in lookup file '%s'", rule.lookup, rule.path)
+	}
+	j.columns = slices.Delete(slices.Clone(lookupHeader), key, key+1)
+	for _, column := range j.columns {
+		if slices.Contains(header, column) {
+			return nil, configErrorf("column %q of lookup file '%s' is already in the header", column, rule.path)
+		}
+	}
+	j.empty = make([]string, len(j.columns))
+
+	budget := newMemoryBudget(s.config).lookup
+	var size int64
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lookup file '%s': error reading record at line %d: %w", rule.path, line, err)
+		}
+		if key >= len(record) {
+			return nil, fmt.Errorf("lookup file '%s': record at line %d has no %s", rule.path, line, rule.lookup)
+		}
+		value := record[key]
+		if _, ok := j.rows[value]; ok {
+			return nil, fmt.Errorf("lookup file '%s': duplicate key %q at line %d", rule.path, value, line)
+		}
+		row := make([]string, len(j.columns))
+		copy(row, slices.Delete(record, key, key+1))
+		j.rows[value] = row
+		if budget > 0 {
+			for _, field := range record {
+				size += int64(len(field)) + 16
+			}
+			if size > budget {
+				return nil, fmt.Errorf("lookup file '%s' does not fit in its quarter of -max-memory", rule.path)
+			}
+		}
+	}
+
+	if s.logger != nil {
+		s.logger.Info("joining lookup file", "path", rule.path, "rows", len(j.rows), "columns", strings.Join(j.columns, ","))
+	} else if s.config.Verbose {
+		fmt.Printf("Joining %d rows of %s on %s\n", len(j.rows), rule.path, rule.column)
+	}
+	return j, nil
+}
+
+// apply returns record with the columns of its lookup row appended, or empty
+// fields when no row has its key, as a record too short to have one has not
+func (j *lookupJoin) apply(record []string) []string {
+	var row []string
+	ok := false
+	if j.column < len(record) {
+		row, ok = j.rows[record[j.column]]
+	}
+	if !ok {
+		j.unmatched++
+		row = j.empty
+	} else {
+		j.matched++
+	}
+	return append(slices.Clip(record), row...)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaFormats are the values of -kafka-format
+var kafkaFormats = []string{"json", "delimited"}
+
+// kafkaConnectTimeout bounds the check that -kafka-brokers are reachable
+const kafkaConnectTimeout = 10 * time.Second
+
+// KafkaOffsets is the range of offsets of a partition of -kafka-topic that
+// the records of a part were produced to
+type KafkaOffsets struct {
+	Topic       string `json:"topic"`
+	Partition   int32  `json:"partition"`
+	FirstOffset int64  `json:"first_offset"`
+	LastOffset  int64  `json:"last_offset"`
+}
+
+// validateKafkaConfig checks -kafka-brokers, -kafka-topic, -kafka-format,
+// -kafka-key, and -kafka-only
+func validateKafkaConfig(config Config) error {
+	if config.KafkaBrokers == "" {
+		if config.KafkaTopic != "" || config.KafkaKey != "" || config.KafkaOnly {
+			return fmt.Errorf("kafka-topic, kafka-key, and kafka-only are only used with -kafka-brokers")
+		}
+		return nil
+	}
+	if config.KafkaTopic == "" {
+		return fmt.Errorf("kafka-topic is required with -kafka-brokers")
+	}
+	if !slices.Contains(kafkaFormats, config.KafkaFormat) {
+		return fmt.Errorf("kafka-format must be json or delimited")
+	}
+	if _, routed := routedMode(config); routed || len(config.Routes) > 0 {
+		return fmt.Errorf("kafka-brokers cannot be combined with routed splits or -route, whose outputs are written side by side")
+	}
+	if config.Incremental {
+		return fmt.Errorf("kafka-brokers cannot be combined with incremental, whose records start with the op column")
+	}
+	if config.FooterAction != "drop" {
+		return fmt.Errorf("kafka-brokers cannot be combined with -footer-action %s, as the footer would be produced as records", config.FooterAction)
+	}
+	if config.KafkaOnly && (config.FilterMode || config.Checksum != "" || config.BagIt || config.MySQLLoadSQL) {
+		return fmt.Errorf("kafka-only cannot be combined with filter-mode, checksums, bagit, or mysql-load-sql, which need the parts")
+	}
+	return nil
+}
+
+// kafkaProducer produces every record written to the parts to -kafka-topic,
+// with the number of its part and the run ID as headers. Records are
+// produced as they are written and flushed when a part is closed, so the
+// records of every completed part have been acknowledged by the brokers
+// and the part records the offsets they were given.
+type kafkaProducer struct {
+	ctx    context.Context
+	client *kgo.Client
+	topic  string
+	format string
+	// key is the column of the message keys, or -1
+	key       int
+	keyColumn string
+	delimiter rune
+	runID     string
+	header    []string
+	part      []byte
+
+	mu sync.Mutex
+	// offsets are those of the records of the current part produced so
+	// far, by partition, and err the first record that failed
+	offsets map[int32]*KafkaOffsets
+	err     error
+	// produced is the number of records of the current part produced, and
+	// skip the number the run being resumed already produced
+	produced int
+	skip     int
+}
+
+// newKafkaProducer connects to -kafka-brokers. Producing outlives the
+// cancellation of ctx, so that the part being finished when a run stops is
+// still flushed.
+func newKafkaProducer(ctx context.Context, config Config) (*kafkaProducer, error) {
+	ctx = context.WithoutCancel(ctx)
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(parseKeyColumns(config.KafkaBrokers)...),
+		kgo.DefaultProduceTopic(config.KafkaTopic),
+	)
+	if err != nil {
+		return nil, configErrorf("invalid -kafka-brokers: %v", err)
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, kafkaConnectTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to -kafka-brokers: %w", err)
+	}
+	return &kafkaProducer{
+		ctx:       ctx,
+		client:    client,
+		topic:     config.KafkaTopic,
+		format:    config.KafkaFormat,
+		key:       -1,
+		keyColumn: config.KafkaKey,
+		delimiter: outputDelimiter(config),
+		runID:     config.RunID,
+	}, nil
+}
+
+// startPart takes the column names of the part number, and of the records
+// produced after it
+func (k *kafkaProducer) startPart(header []string, number int) error {
+	if k.keyColumn != "" {
+		if k.key = columnIndex(header, k.keyColumn); k.key < 0 {
+			return configErrorf("kafka-key column %q not found in header", k.keyColumn)
+		}
+	}
+	k.header = slices.Clone(header)
+	k.part = []byte(strconv.Itoa(number))
+	k.mu.Lock()
+	k.offsets = make(map[int32]*KafkaOffsets)
+	k.produced = 0
+	k.mu.Unlock()
+	return nil
+}
+
+// produce encodes record as a message and hands it to the client, which
+// sends it in the background
+func (k *kafkaProducer) produce(record []string) error {
+	if k.skip > 0 {
+		k.skip--
+		k.mu.Lock()
+		k.produced++
+		k.mu.Unlock()
+		return nil
+	}
+	value, err := k.encode(record)
+	if err != nil {
+		return err
+	}
+	message := &kgo.Record{Value: value, Headers: []kgo.RecordHeader{{Key: "part", Value: k.part}}}
+	if k.runID != "" {
+		message.Headers = append(message.Headers, kgo.RecordHeader{Key: "run_id", Value: []byte(k.runID)})
+	}
+	if k.key >= 0 && k.key < len(record) {
+		message.Key = []byte(record[k.key])
+	}
+	k.client.Produce(k.ctx, message, k.done)
+	return k.failed()
+}
+
+// encode returns the message value of record: a JSON object of the fields
+// by column name, in column order, or a line of -output-delimiter
+// separated fields
+func (k *kafkaProducer) encode(record []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if k.format == "delimited" {
+		writer := csv.NewWriter(&buf)
+		writer.Comma = k.delimiter
+		writer.Write(record)
+		writer.Flush()
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), writer.Error()
+	}
+	buf.WriteByte('{')
+	for i, name := range k.header {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		field := ""
+		if i < len(record) {
+			field = record[i]
+		}
+		key, _ := json.Marshal(name)
+		value, _ := json.Marshal(field)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// done records the offset of a produced message, or its error
+func (k *kafkaProducer) done(message *kgo.Record, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		if k.err == nil {
+			k.err = err
+		}
+		return
+	}
+	k.produced++
+	offsets := k.offsets[message.Partition]
+	if offsets == nil {
+		offsets = &KafkaOffsets{Topic: message.Topic, Partition: message.Partition, FirstOffset: message.Offset}
+		k.offsets[message.Partition] = offsets
+	}
+	offsets.FirstOffset = min(offsets.FirstOffset, message.Offset)
+	offsets.LastOffset = max(offsets.LastOffset, message.Offset)
+}
+
+// failed returns the error of the first message that could not be produced
+func (k *kafkaProducer) failed() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return fmt.Errorf("failed to produce to %s: %w", k.topic, k.err)
+	}
+	return nil
+}
+
+// flush waits until the records handed to the client are acknowledged,
+// and returns the offsets of the records of the current part by partition
+func (k *kafkaProducer) flush() ([]KafkaOffsets, error) {
+	if err := k.client.Flush(k.ctx); err != nil {
+		return nil, fmt.Errorf("failed to produce to %s: %w", k.topic, err)
+	}
+	if err := k.failed(); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var offsets []KafkaOffsets
+	for _, o := range k.offsets {
+		offsets = append(offsets, *o)
+	}
+	slices.SortFunc(offsets, func(a, b KafkaOffsets) int { return int(a.Partition - b.Partition) })
+	return offsets, nil
+}
+
+// Close flushes the records not yet acknowledged, and disconnects
+func (k *kafkaProducer) Close() {
+	k.client.Flush(k.ctx)
+	k.client.Close()
+}
+
+// logProduced reports the records of the part just closed that were
+// produced
+func (s *CSVSplitter) logProduced(offsets []KafkaOffsets) {
+	ranges := make([]string, len(offsets))
+	for i, o := range offsets {
+		ranges[i] = fmt.Sprintf("%d:%d-%d", o.Partition, o.FirstOffset, o.LastOffset)
+	}
+	if s.logger != nil {
+		s.logger.Info("part produced", "path", s.outPath, "topic", s.config.KafkaTopic, "records", s.kafka.produced, "offsets", strings.Join(ranges, ","))
+	} else if s.config.Verbose {
+		fmt.Printf("Produced %d records of %s to %s (offsets %s)\n", s.kafka.produced, s.outPath, s.config.KafkaTopic, strings.Join(ranges, ", "))
+	}
+}
+
+// kafkaRecordWriter writes the records of a part to its file and produces
+// them to Kafka
+type kafkaRecordWriter struct {
+	recordWriter
+	producer *kafkaProducer
+	part     int
+}
+
+func (k *kafkaRecordWriter) WriteHeader(header []string) error {
+	if err := k.producer.startPart(header, k.part); err != nil {
+		return err
+	}
+	return k.recordWriter.WriteHeader(header)
+}
+
+func (k *kafkaRecordWriter) Write(record []string) error {
+	if err := k.recordWriter.Write(record); err != nil {
+		return err
+	}
+	return k.producer.produce(record)
+}
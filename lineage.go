@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lineageWriter maps every written record to its input record number, the
+// part it went to, and its record number within that part, plus its input
+// file with -source-column. The mapping is a CSV file, gzip-compressed when
+// its name ends in .gz.
+type lineageWriter struct {
+	path   string
+	file   *os.File
+	buf    *bufio.Writer
+	gz     *gzip.Writer
+	writer *csv.Writer
+	// source is the index of the -source-column in written records, or -1
+	source int
+}
+
+// newLineageWriter creates the lineage file at path, with a column named
+// sourceColumn for the input file of each record unless it is empty. When
+// appendOnly is set, the mappings of a previous run are kept; a gzip file
+// then gains a second member, which readers decompress as one stream.
+func newLineageWriter(path string, appendOnly bool, sourceColumn string) (*lineageWriter, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendOnly {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lineage file '%s': %w", path, err)
+	}
+
+	l := &lineageWriter{path: path, file: file, buf: bufio.NewWriter(file), source: -1}
+	var w io.Writer = l.buf
+	if strings.HasSuffix(path, ".gz") {
+		l.gz = gzip.NewWriter(l.buf)
+		w = l.gz
+	}
+	l.writer = csv.NewWriter(w)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		header := []string{"input_record", "part", "part_record"}
+		if sourceColumn != "" {
+			header = append(header, sourceColumn)
+		}
+		if err := l.writer.Write(header); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write lineage file '%s': %w", path, err)
+		}
+	}
+	return l, nil
+}
+
+// Write maps input record number inputRecord, or none if it is 0, to record
+// number partRecord of part, which is record
+func (l *lineageWriter) Write(inputRecord int, part string, partRecord int, record []string) error {
+	input := ""
+	if inputRecord > 0 {
+		input = strconv.Itoa(inputRecord)
+	}
+	row := []string{input, part, strconv.Itoa(partRecord)}
+	if l.source >= 0 {
+		source := ""
+		if l.source < len(record) {
+			source = record[l.source]
+		}
+		row = append(row, source)
+	}
+	return l.writer.Write(row)
+}
+
+// Close flushes and closes the lineage file
+func (l *lineageWriter) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	l.writer.Flush()
+	err := l.writer.Error()
+	if l.gz != nil {
+		if cerr := l.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if ferr := l.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to write lineage file '%s': %w", l.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadDataNull is how mysql output writes null, which LOAD DATA reads back
+// as NULL rather than an empty string
+const loadDataNull = `\N`
+
+// loadDataReplacer escapes the characters LOAD DATA INFILE treats specially
+// with its default FIELDS ESCAPED BY '\\'
+var loadDataReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x00", `\0`,
+	"\x1a", `\Z`,
+)
+
+// validateLoadDataOptions checks the options used by the mysql output format
+func validateLoadDataOptions(config Config) error {
+	if config.NullOutput != "" {
+		return fmt.Errorf("null-output cannot be combined with mysql output, which writes empty fields as %s", loadDataNull)
+	}
+	return nil
+}
+
+// validateLoadSQLConfig checks -mysql-load-sql
+func validateLoadSQLConfig(config Config) error {
+	if !config.MySQLLoadSQL {
+		return nil
+	}
+	if config.OutputFormat != "mysql" {
+		return fmt.Errorf("mysql-load-sql is only used with mysql output")
+	}
+	if config.SQLTable == "" {
+		return fmt.Errorf("table is required with -mysql-load-sql")
+	}
+	if config.FilterMode || config.PgOnly {
+		return fmt.Errorf("mysql-load-sql needs the parts, which filter-mode and pg-only do not keep")
+	}
+	return nil
+}
+
+// loadDataWriter writes records in the default text format of MySQL's LOAD
+// DATA INFILE and SELECT ... INTO OUTFILE: fields separated by tabs, with
+// tabs, line breaks, and backslashes escaped by a backslash, and empty
+// fields written as \N
+type loadDataWriter struct {
+	writer  *bufio.Writer
+	newline string
+}
+
+func newLoadDataWriter(w io.Writer, config Config) *loadDataWriter {
+	return &loadDataWriter{writer: bufio.NewWriter(w), newline: lineEnding(config)}
+}
+
+// WriteHeader writes the column names as the first line, which the LOAD
+// DATA statement of -mysql-load-sql skips
+func (l *loadDataWriter) WriteHeader(header []string) error {
+	return l.write(header, false)
+}
+
+func (l *loadDataWriter) Write(record []string) error {
+	return l.write(record, true)
+}
+
+func (l *loadDataWriter) write(record []string, nulls bool) error {
+	for i, field := range record {
+		if i > 0 {
+			l.writer.WriteByte('\t')
+		}
+		if field == "" && nulls {
+			l.writer.WriteString(loadDataNull)
+			continue
+		}
+		loadDataReplacer.WriteString(l.writer, field)
+	}
+	_, err := l.writer.WriteString(l.newline)
+	return err
+}
+
+func (l *loadDataWriter) Close() error {
+	return l.writer.Flush()
+}
+
+// lineEnding returns the line break of -output-line-ending
+func lineEnding(config Config) string {
+	if config.OutputLineEnding == "crlf" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// loadDataStatement returns the LOAD DATA statement loading the mysql part
+// at path, with the columns of header, into the table of -table. The file
+// is named without its directory, so the statement is run from there.
+func loadDataStatement(path string, header []string, config Config) string {
+	dialect := sqlDialects["mysql"]
+	table := strings.Split(config.SQLTable, ".")
+	for i, part := range table {
+		table[i] = dialect.quoteIdent(part)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = dialect.quoteIdent(name)
+	}
+	newline := `\n`
+	if config.OutputLineEnding == "crlf" {
+		newline = `\r\n`
+	}
+	return fmt.Sprintf("LOAD DATA LOCAL INFILE %s\nINTO TABLE %s\nCHARACTER SET utf8mb4\nFIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\'\nLINES TERMINATED BY '%s'\nIGNORE 1 LINES\n(%s);\n",
+		dialect.quoteString(filepath.Base(path)), strings.Join(table, "."), newline, strings.Join(columns, ", "))
+}
+
+// writeLoadSQLFile writes the LOAD DATA statement of -mysql-load-sql for the
+// part at path next to it
+func writeLoadSQLFile(path string, header []string, config Config) error {
+	sidecar := path + ".sql"
+	if err := os.WriteFile(sidecar, []byte(loadDataStatement(path, header, config)), 0644); err != nil {
+		return fmt.Errorf("failed to write load statement '%s': %w", sidecar, err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// tailSize is how much recent input is kept to locate errors in. A record
+// longer than this may be reported without its byte offset.
+const tailSize = 256 * 1024
+
+// contextSize is the number of bytes shown on each side of an error location
+const contextSize = 16
+
+// Location pinpoints an error in an input file. Offset is the byte offset
+// from the start of the file, or -1 when the input there is no longer held
+// or was decoded by -input-encoding; Line and Column count from 1, the column
+// in bytes of the line as read, in UTF-8.
+type Location struct {
+	File    string `json:"file,omitempty"`
+	Offset  int64  `json:"offset"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Context string `json:"context,omitempty"`
+	Hex     string `json:"hex,omitempty"`
+}
+
+func (l Location) String() string {
+	if l.Offset < 0 {
+		return fmt.Sprintf("at line %d, column %d", l.Line, l.Column)
+	}
+	if l.Context == "" {
+		return fmt.Sprintf("at byte %d (line %d, column %d)", l.Offset, l.Line, l.Column)
+	}
+	return fmt.Sprintf("at byte %d (line %d, column %d) near %q", l.Offset, l.Line, l.Column, l.Context)
+}
+
+// locatedError is an error in a record, with where it was found
+type locatedError struct {
+	Location Location
+	err      error
+}
+
+func (e *locatedError) Error() string {
+	return e.err.Error() + " " + e.Location.String()
+}
+
+func (e *locatedError) Unwrap() error {
+	return e.err
+}
+
+// errorLocation returns the location of err, if it has one
+func errorLocation(err error) *Location {
+	var located *locatedError
+	if errors.As(err, &located) {
+		return &located.Location
+	}
+	return nil
+}
+
+// positionError returns the message of err with its location, if it has one,
+// reduced to the position, leaving out the bytes around it, for messages
+// sent off the machine, such as webhooks
+func positionError(err error) string {
+	msg := err.Error()
+	var located *locatedError
+	if !errors.As(err, &located) {
+		return msg
+	}
+	position := located.Location
+	position.Context, position.Hex = "", ""
+	return strings.Replace(msg, located.Error(), located.err.Error()+" "+position.String(), 1)
+}
+
+// tailReader keeps the last bytes read through it, from the start of a line,
+// along with their offset and line number in the input
+type tailReader struct {
+	r     io.Reader
+	buf   []byte
+	start int64 // offset of buf[0]
+	lines int   // newlines before buf[0]
+	// partial is set when a line longer than tailSize pushed its own start
+	// out of buf
+	partial bool
+	// pinned keeps the bytes from offset mark on in buf, however long, for
+	// -passthrough to take the bytes of each record
+	pinned bool
+	mark   int64
+	// mapped is the whole input, when -mmap maps it, so that slices are
+	// taken from it instead of buf
+	mapped []byte
+	// decoded is set when the input is decoded by -input-encoding, so that
+	// the bytes held are not those of the file
+	decoded bool
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.buf = append(t.buf, p[:n]...)
+	if len(t.buf) > 2*tailSize {
+		drop := len(t.buf) - tailSize
+		if t.pinned {
+			drop = min(drop, int(t.mark-t.start))
+		}
+		if i := bytes.LastIndexByte(t.buf[:drop], '\n'); i >= 0 {
+			drop = i + 1
+			t.partial = false
+		} else {
+			t.partial = true
+		}
+		t.lines += bytes.Count(t.buf[:drop], []byte{'\n'})
+		t.start += int64(drop)
+		t.buf = append(t.buf[:0], t.buf[drop:]...)
+	}
+	return n, err
+}
+
+// slice returns the bytes held from offset from up to offset to
+func (t *tailReader) slice(from, to int64) []byte {
+	if t.mapped != nil {
+		return t.mapped[from:to]
+	}
+	return t.buf[from-t.start : to-t.start]
+}
+
+// locate returns the location of column of line
+func (t *tailReader) locate(line, column int) Location {
+	loc := Location{Offset: -1, Line: line, Column: column}
+	current := t.lines + 1
+	if t.decoded || line < current || line == current && t.partial {
+		return loc
+	}
+	i := 0
+	for ; current < line; current++ {
+		j := bytes.IndexByte(t.buf[i:], '\n')
+		if j < 0 {
+			return loc
+		}
+		i += j + 1
+	}
+	i += column - 1
+	if i > len(t.buf) {
+		return loc
+	}
+	loc.Offset = t.start + int64(i)
+	context := t.buf[max(i-contextSize, 0):min(i+contextSize, len(t.buf))]
+	loc.Context = string(context)
+	loc.Hex = hex.EncodeToString(context)
+	return loc
+}
+
+// inputReader returns a CSV reader of the input r, read in chunks of
+// -read-buffer unless it is mapped by -mmap, and keeping its recent bytes so
+// that the errors found in it can be located
+func (s *CSVSplitter) inputReader(r io.Reader) *csv.Reader {
+	if s.limits != nil {
+		r = &throttledReader{r, s.limits.read}
+	}
+	// Bytes are counted as they are in the file, before any decoding
+	r = &countingReader{r: r, total: &s.metrics.bytesRead}
+	mapped := s.mapped
+	var sample []byte
+	complete := false
+	if mapped == nil {
+		buffered := bufio.NewReaderSize(r, s.config.ReadBufferSize)
+		if s.config.Header == "auto" || s.config.InputEncoding != "utf-8" {
+			var err error
+			sample, err = buffered.Peek(sniffSize)
+			complete = err == io.EOF
+		}
+		r = buffered
+	} else {
+		sample, complete = mapped[:min(len(mapped), sniffSize)], len(mapped) <= sniffSize
+	}
+	decoder := s.inputDecoder(sample, complete)
+	// A UTF-8 input only loses its byte order mark, so the offsets of its
+	// bytes after it are still known
+	var start int64
+	if decoder != nil && s.config.InputEncoding == "auto" && bytes.HasPrefix(sample, utf8BOM) {
+		start = int64(len(utf8BOM))
+	}
+	if decoder != nil {
+		// The input is read as UTF-8 from here on, so records are no longer
+		// taken from the mapping
+		decoded := bufio.NewReaderSize(transform.NewReader(r, decoder), s.config.ReadBufferSize)
+		if s.config.Header == "auto" {
+			var err error
+			sample, err = decoded.Peek(sniffSize)
+			complete = err == io.EOF
+		}
+		r, mapped = decoded, nil
+	}
+	s.resolveHeader(sample, complete)
+	s.tail = &tailReader{r: r, mapped: mapped, start: start, decoded: decoder != nil && start == 0}
+	if s.config.Passthrough {
+		s.tail.pinned = mapped == nil
+		if s.raw == nil {
+			s.raw = &rawInput{}
+		}
+	}
+	s.input = s.createReader(s.tail)
+	return s.input
+}
+
+// locateError adds its location in the input to a CSV parse error
+func (s *CSVSplitter) locateError(err error) error {
+	var parseErr *csv.ParseError
+	if s.tail == nil || !errors.As(err, &parseErr) {
+		return err
+	}
+	return &locatedError{s.locate(parseErr.Line, parseErr.Column), err}
+}
+
+// fieldError adds to err the location of field i of the last record read,
+// or of the record -workers are handing on, which had the given number of
+// fields, or of its last field if i is past it
+func (s *CSVSplitter) fieldError(fields, i int, err error) error {
+	if s.tail == nil || fields == 0 {
+		return err
+	}
+	i = max(min(i, fields-1), 0)
+	var line, column int
+	if s.positions != nil {
+		line, column = s.positions[2*i], s.positions[2*i+1]
+	} else {
+		line, column = s.input.FieldPos(i)
+	}
+	if s.fwf != nil && i < len(s.fwf.Columns) {
+		// The field was cut from the fixed-width line at the start of its
+		// column, not where the CSV it was converted to has it
+		column = s.fwf.Columns[i].Start
+	}
+	return &locatedError{s.locate(line, column), err}
+}
+
+// locate returns the location of column of line in the current input file
+func (s *CSVSplitter) locate(line, column int) Location {
+	loc := s.tail.locate(line, column)
+	loc.File = s.source
+	if len(s.masks) > 0 {
+		// The bytes around the error may be those of a masked column
+		loc.Context, loc.Hex = "", ""
+	}
+	return loc
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTailReaderLocate(t *testing.T) {
+	input := "id,name\n1,ann\n2,b\"ob\n3,cy\n"
+	tail := &tailReader{r: strings.NewReader(input)}
+	reader := csv.NewReader(tail)
+	var parseErr *csv.ParseError
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			t.Fatal("no parse error in input")
+		}
+		if errors.As(err, &parseErr) {
+			break
+		}
+	}
+
+	loc := tail.locate(parseErr.Line, parseErr.Column)
+	offset := int64(strings.Index(input, `"`))
+	if loc.Line != 3 || loc.Offset != offset {
+		t.Fatalf("locate(%d, %d) = %+v, want line 3 at byte %d", parseErr.Line, parseErr.Column, loc, offset)
+	}
+	if !strings.Contains(loc.Context, `2,b"ob`) || loc.Hex == "" {
+		t.Errorf("locate context = %q, hex %q, want the bytes around the error", loc.Context, loc.Hex)
+	}
+
+	// Bytes decoded from another encoding are not at their offset in the file
+	tail.decoded = true
+	if loc := tail.locate(parseErr.Line, parseErr.Column); loc.Offset != -1 || loc.Context != "" {
+		t.Errorf("locate of decoded input = %+v, want line and column only", loc)
+	}
+}
+
+func TestTailReaderLocateDropped(t *testing.T) {
+	// Lines read long ago are no longer held, so only their line and
+	// column are known
+	var b strings.Builder
+	b.WriteString("id\n")
+	for b.Len() < 3*tailSize {
+		b.WriteString("0123456789\n")
+	}
+	tail := &tailReader{r: strings.NewReader(b.String())}
+	if _, err := io.Copy(io.Discard, tail); err != nil {
+		t.Fatal(err)
+	}
+	if loc := tail.locate(2, 1); loc.Offset != -1 {
+		t.Errorf("locate of a dropped line = %+v, want no offset", loc)
+	}
+	if loc := tail.locate(tail.lines+1, 1); loc.Offset != tail.start {
+		t.Errorf("locate of the first line held = %+v, want byte %d", loc, tail.start)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// newLogger returns the structured logger for the configured log format, or
+// nil when progress is reported as plain text. Structured logs go to stderr
+// at info level, or debug level with -verbose.
+func newLogger(config Config) *slog.Logger {
+	if config.LogFormat != "json" {
+		return nil
+	}
+	level := slog.LevelInfo
+	if config.Verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if config.RunID != "" {
+		logger = logger.With("run_id", config.RunID)
+	}
+	return logger
+}
+
+// loggedError marks an error that has already been written to the log, so
+// the command dispatcher does not print it again
+type loggedError struct {
+	error
+}
+
+func (e loggedError) Unwrap() error {
+	return e.error
+}
+
+// reportError writes err in the configured log format and returns it marked
+// as logged
+func reportError(logger *slog.Logger, err error) error {
+	if logger == nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	} else if loc := errorLocation(err); loc != nil {
+		logger.Error("run failed", "error", err.Error(), "exit_code", exitCode(err), "location", loc)
+	} else {
+		logger.Error("run failed", "error", err.Error(), "exit_code", exitCode(err))
+	}
+	return loggedError{err}
+}
+
+// isLogged reports whether err has already been written to the log
+func isLogged(err error) bool {
+	var logged loggedError
+	return errors.As(err, &logged)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maskMethods are the methods of -mask, which all map equal values to equal
+// masked values so that masked columns can still be joined and counted
+var maskMethods = map[string]func(value string) string{
+	"hash":   maskHash,
+	"redact": maskRedact,
+	"fake":   maskFake,
+}
+
+// redacted replaces the values of -mask column:redact
+const redacted = "REDACTED"
+
+// parseMask parses a -mask rule of the form column:method
+func parseMask(value string) (column string, method string, err error) {
+	column, method, ok := strings.Cut(value, ":")
+	column = strings.TrimSpace(column)
+	if !ok || column == "" {
+		return "", "", fmt.Errorf("invalid mask %q: must be column:method", value)
+	}
+	if _, ok := maskMethods[method]; !ok {
+		return "", "", fmt.Errorf("invalid mask %q: method must be hash, redact, or fake", value)
+	}
+	return column, method, nil
+}
+
+// checkMask checks the syntax of a -mask rule
+func checkMask(value string) error {
+	_, _, err := parseMask(value)
+	return err
+}
+
+// newMasks returns the masks of the -mask rules for the columns of header,
+// after those of -hash-column
+func (s *CSVSplitter) newMasks(header []string) ([]fieldTransform, error) {
+	masks, err := s.newHashColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range s.config.Masks {
+		name, method, err := parseMask(value)
+		if err != nil {
+			return nil, err
+		}
+		column := columnIndex(header, name)
+		if column < 0 {
+			return nil, configErrorf("mask column %q not found in header", name)
+		}
+		mask := maskMethods[method]
+		if method == "hash" || method == "fake" {
+			if mask, err = s.newKeyedMask(name, method); err != nil {
+				return nil, err
+			}
+		}
+		masks = append(masks, fieldTransform{column, func(value string) (string, error) {
+			if value == "" {
+				return "", nil
+			}
+			return mask(value), nil
+		}})
+	}
+	return masks, nil
+}
+
+// newKeyedMask returns the mask of -mask column:hash or column:fake. With a
+// key in -hmac-key-file or SPLITCSV_HMAC_KEY, hash replaces values with their
+// HMAC-SHA256 under it, as -hash-column does, and fake picks its values by
+// that HMAC. Without one, both go by a plain hash of the value, which anyone
+// can compute for a guessed value.
+func (s *CSVSplitter) newKeyedMask(column, method string) (func(value string) string, error) {
+	if s.config.HMACKeyFile == "" && os.Getenv(hmacKeyEnv) == "" {
+		what := "is an unkeyed SHA-256"
+		if method == "fake" {
+			what = "picks its values by an unkeyed hash"
+		}
+		if s.logger != nil {
+			s.logger.Warn("unkeyed mask", "column", column, "method", method)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: -mask %s:%s %s, which does not anonymise values that can be guessed; set -hmac-key-file or %s\n", column, method, what, hmacKeyEnv)
+		}
+		return maskMethods[method], nil
+	}
+	key, err := loadHMACKey(s.config)
+	if err != nil {
+		return nil, err
+	}
+	// Each of the -workers needs a hash of its own
+	macs := &sync.Pool{New: func() any { return hmac.New(sha256.New, key) }}
+	sum := func(value string) []byte {
+		mac := macs.Get().(hash.Hash)
+		defer macs.Put(mac)
+		mac.Reset()
+		mac.Write([]byte(value))
+		return mac.Sum(nil)
+	}
+	if method == "hash" {
+		return func(value string) string { return hex.EncodeToString(sum(value)) }, nil
+	}
+	return func(value string) string { return fakeValue(value, binary.BigEndian.Uint64(sum(value))) }, nil
+}
+
+// maskRejected returns record with the masks applied, for the rejects file,
+// and masks the value of a masked column quoted by reason, so that rejected
+// records give away no more of a masked column than the parts do
+func (s *CSVSplitter) maskRejected(record []string, reason error) []string {
+	if len(s.masks) == 0 {
+		return record
+	}
+	s.maskReason(reason)
+	// Masks do not fail
+	masked, _, _ := applyTransforms(s.masks, record)
+	return masked
+}
+
+// maskReason masks the value quoted by err, a type or schema violation, if
+// it is that of a masked column
+func (s *CSVSplitter) maskReason(err error) {
+	if len(s.masks) == 0 {
+		return
+	}
+	var typed *typeViolation
+	var invalid *schemaViolation
+	switch {
+	case errors.As(err, &typed):
+		typed.Value = s.maskValue(typed.Column, typed.Value)
+	case errors.As(err, &invalid):
+		invalid.Value = s.maskValue(invalid.Column, invalid.Value)
+	}
+}
+
+// maskValue returns value, a value of the column called name, as the masks
+// of the column write it
+func (s *CSVSplitter) maskValue(name, value string) string {
+	column := columnIndex(s.maskHeader, name)
+	for _, mask := range s.masks {
+		if mask.column == column {
+			value, _ = mask.apply(value)
+		}
+	}
+	return value
+}
+
+// maskHash returns the SHA-256 digest of value in hexadecimal
+func maskHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func maskRedact(string) string {
+	return redacted
+}
+
+// fakeFirstNames and fakeLastNames make up the names of -mask column:fake
+var (
+	fakeFirstNames = []string{
+		"Alex", "Blair", "Casey", "Dana", "Eli", "Farah", "Gus", "Hana", "Ivan", "Jun",
+		"Kai", "Lena", "Milo", "Nia", "Omar", "Pia", "Quinn", "Rosa", "Sam", "Tara",
+		"Uma", "Vic", "Wes", "Xena", "Yuri", "Zoe",
+	}
+	fakeLastNames = []string{
+		"Abbott", "Baker", "Chen", "Diaz", "Evans", "Fischer", "Garcia", "Hughes", "Ito", "Jensen",
+		"Khan", "Lopez", "Moreau", "Novak", "Okafor", "Park", "Quist", "Rossi", "Silva", "Tanaka",
+		"Ueda", "Varga", "Weber", "Xu", "Young", "Zhou",
+	}
+)
+
+// maskFake returns a made-up value of the same shape as value, picked by its
+// unkeyed hash
+func maskFake(value string) string {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return fakeValue(value, h.Sum64())
+}
+
+// fakeValue returns a made-up value of the same shape as value, picked by
+// seed: an email address for an email address, the same pattern of digits
+// for a value without letters, such as a phone or social security number,
+// and a person's name otherwise
+func fakeValue(value string, seed uint64) string {
+	next := func(n int) int {
+		// xorshift keeps the picks of one value independent of each other
+		seed ^= seed << 13
+		seed ^= seed >> 7
+		seed ^= seed << 17
+		return int(seed % uint64(n))
+	}
+
+	if _, domain, ok := strings.Cut(value, "@"); ok && domain != "" {
+		first := fakeFirstNames[next(len(fakeFirstNames))]
+		last := fakeLastNames[next(len(fakeLastNames))]
+		return fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), next(1000))
+	}
+	if !strings.ContainsFunc(value, unicode.IsLetter) {
+		var b strings.Builder
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				r = rune('0' + next(10))
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return fakeFirstNames[next(len(fakeFirstNames))] + " " + fakeLastNames[next(len(fakeLastNames))]
+}
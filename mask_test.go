@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testMasks returns a splitter masking the columns of header by rules
+func testMasks(t *testing.T, header []string, rules ...string) *CSVSplitter {
+	t.Helper()
+	s := &CSVSplitter{config: Config{Masks: rules}}
+	masks, err := s.newMasks(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.masks, s.maskHeader = masks, header
+	return s
+}
+
+func TestMaskRejected(t *testing.T) {
+	header := []string{"id", "ssn", "amount"}
+	tests := []struct {
+		name       string
+		record     []string
+		reason     error
+		wantRecord []string
+		wantReason string
+	}{
+		{
+			name:       "masked record",
+			record:     []string{"1", "987-65-4321", "x"},
+			reason:     &typeViolation{Column: "amount", Type: "int", Value: "x"},
+			wantRecord: []string{"1", redacted, "x"},
+			wantReason: `column "amount": "x" is not a valid int`,
+		},
+		{
+			name:       "masked value in reason",
+			record:     []string{"1", "987-65-4321", "5"},
+			reason:     &typeViolation{Column: "ssn", Type: "int", Value: "987-65-4321"},
+			wantRecord: []string{"1", redacted, "5"},
+			wantReason: `column "ssn": "REDACTED" is not a valid int`,
+		},
+		{
+			name:       "schema violation",
+			record:     []string{"1", "987-65-4321", "5"},
+			reason:     &locatedError{Location{Offset: -1, Line: 2, Column: 3}, &schemaViolation{Column: "ssn", Rule: "pattern", Value: "987-65-4321"}},
+			wantRecord: []string{"1", redacted, "5"},
+			wantReason: `column "ssn": "REDACTED" does not match pattern at line 2, column 3`,
+		},
+		{
+			name:       "short record",
+			record:     []string{"1"},
+			reason:     fmt.Errorf("wrong number of fields"),
+			wantRecord: []string{"1"},
+			wantReason: "wrong number of fields",
+		},
+		{
+			name:       "long record",
+			record:     []string{"1", "987-65-4321", "5", "extra"},
+			reason:     fmt.Errorf("wrong number of fields"),
+			wantRecord: []string{"1", redacted, "5", "extra"},
+			wantReason: "wrong number of fields",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testMasks(t, header, "ssn:redact")
+			got := s.maskRejected(tt.record, tt.reason)
+			if !slices.Equal(got, tt.wantRecord) {
+				t.Errorf("maskRejected record = %q, want %q", got, tt.wantRecord)
+			}
+			if strings.Contains(tt.reason.Error(), "987-65-4321") {
+				t.Errorf("maskRejected reason = %q, still holds the masked value", tt.reason)
+			}
+			if tt.wantReason != "" && tt.reason.Error() != tt.wantReason {
+				t.Errorf("maskRejected reason = %q, want %q", tt.reason, tt.wantReason)
+			}
+		})
+	}
+
+	t.Run("without masks", func(t *testing.T) {
+		s := &CSVSplitter{}
+		record := []string{"1", "987-65-4321"}
+		if got := s.maskRejected(record, fmt.Errorf("rejected")); !slices.Equal(got, record) {
+			t.Errorf("maskRejected = %q, want %q", got, record)
+		}
+	})
+}
+
+func TestLocationString(t *testing.T) {
+	tests := []struct {
+		loc  Location
+		want string
+	}{
+		{Location{Offset: 10, Line: 2, Column: 3, Context: "a,b"}, `at byte 10 (line 2, column 3) near "a,b"`},
+		{Location{Offset: 10, Line: 2, Column: 3}, "at byte 10 (line 2, column 3)"},
+		{Location{Offset: -1, Line: 2, Column: 3, Context: "a,b"}, "at line 2, column 3"},
+	}
+	for _, tt := range tests {
+		if got := tt.loc.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestMaskFake(t *testing.T) {
+	header := []string{"email", "ssn", "name"}
+	record := []string{"jane.doe@corp.com", "987-65-4321", "Jane Doe"}
+	fake := func() []string {
+		s := testMasks(t, header, "email:fake", "ssn:fake", "name:fake")
+		masked, _, _ := applyTransforms(s.masks, record)
+		return masked
+	}
+	unkeyed := fake()
+
+	t.Setenv(hmacKeyEnv, "secret")
+	got := fake()
+	if again := fake(); !slices.Equal(got, again) {
+		t.Errorf("fake masks = %q, then %q, want the same values", got, again)
+	}
+	if slices.Equal(got, unkeyed) {
+		t.Errorf("keyed fake masks = %q, want other values than unkeyed", got)
+	}
+	if !strings.HasSuffix(got[0], "@example.com") {
+		t.Errorf("fake email = %q, want an @example.com address", got[0])
+	}
+	if len(got[1]) != len(record[1]) || got[1][3] != '-' || got[1][6] != '-' {
+		t.Errorf("fake ssn = %q, want the shape of %q", got[1], record[1])
+	}
+
+	t.Setenv(hmacKeyEnv, "other")
+	if other := fake(); slices.Equal(got, other) {
+		t.Errorf("fake masks = %q under both keys, want other values under another key", got)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minMaxMemory is the smallest -max-memory a split can run in
+const minMaxMemory = 16 << 20
+
+// minWriteBuffer is the smallest write buffer -max-memory leaves an output,
+// the default size of a bufio.Writer
+const minWriteBuffer = 4 << 10
+
+// byteUnits are the units of a byteSize, longest first so that MB is not
+// taken for B
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// byteSize is a flag value holding a number of bytes, given with an
+// optional unit such as 512MB or 2GiB. Units are powers of 1024.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return formatByteSize(int64(*b))
+}
+
+func (b *byteSize) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(size)
+	return nil
+}
+
+// parseByteSize parses a number of bytes with an optional unit
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: must be a number of bytes such as 512MB", value)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// formatByteSize formats size in the largest unit it is a whole number of
+func formatByteSize(size int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if size >= u.size && size%u.size == 0 {
+			return fmt.Sprintf("%d%s", size/u.size, u.suffix)
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// validateMemoryConfig checks -max-memory, which cannot bound an index that
+// is only kept in memory
+func validateMemoryConfig(config Config) error {
+	if config.TempDir != "" && !config.Shuffle && config.Priority == "" {
+		return fmt.Errorf("temp-dir is only used with -shuffle and -priority")
+	}
+	if config.MaxMemory == 0 {
+		return nil
+	}
+	if config.MaxMemory < minMaxMemory {
+		return fmt.Errorf("max-memory must be at least %s", formatByteSize(minMaxMemory))
+	}
+	if config.Incremental && config.StateDir == "" {
+		return fmt.Errorf("max-memory needs -state-dir with -incremental, whose sidecar index is held in memory whole")
+	}
+	if budget, outputs := newMemoryBudget(config), openOutputs(config); outputs > budget.outputs {
+		return fmt.Errorf("max-memory of %s leaves room for the write buffers of %d outputs open at once, and the split has %d",
+			formatByteSize(int64(config.MaxMemory)), budget.outputs, outputs)
+	}
+	return nil
+}
+
+// openOutputs returns how many outputs a split writes to at once: those of
+// a -ratio or -hash-key split, the -route streams and the main parts, or
+// the main parts alone. The periods of -time-column are only known as they
+// are read, and 1 is returned for them.
+func openOutputs(config Config) int {
+	if names := outputNames(config); names != nil {
+		return len(names)
+	}
+	streams := map[string]bool{}
+	for _, value := range config.Routes {
+		if rule, err := parseRoute(value); err == nil {
+			streams[rule.name] = true
+		}
+	}
+	return 1 + len(streams)
+}
+
+// memoryBudget is the share of -max-memory of each buffer of a split. Half
+// of it goes to records sorted in memory before they spill, by -shuffle or
+// the -incremental index, which never run together; a quarter to the -join
+// lookup table; a sixteenth to the write buffers of the outputs open at
+// once; and the rest to the other I/O buffers and the runtime.
+type memoryBudget struct {
+	// shuffle is how many bytes of records -shuffle holds before it spills
+	shuffle int
+	// indexEntries is how many entries of the -state-dir index are sorted
+	// in memory before they spill to a run file
+	indexEntries int
+	// lookup is how many bytes the -join lookup table may take, or 0 for
+	// no limit
+	lookup int64
+	// buffer is the largest I/O buffer, or 0 for no limit
+	buffer int
+	// writeBuffer is the largest write buffer of each output, or 0 for no
+	// limit
+	writeBuffer int
+	// outputs is how many outputs may be open at once, each with a write
+	// buffer of at least minWriteBuffer, or 0 for no limit
+	outputs int
+}
+
+// newMemoryBudget divides the -max-memory of config, or returns the
+// defaults without it
+func newMemoryBudget(config Config) memoryBudget {
+	limit := int64(config.MaxMemory)
+	if limit == 0 {
+		return memoryBudget{shuffle: shuffleMemory, indexEntries: indexSpillEntries}
+	}
+	writers := int(limit / 16)
+	budget := memoryBudget{
+		shuffle: int(limit / 2),
+		// The buffer of entries takes up to twice their size as it grows
+		indexEntries: int(limit / 2 / (2 * indexEntrySize)),
+		lookup:       limit / 4,
+		buffer:       int(limit / 64),
+		outputs:      writers / minWriteBuffer,
+	}
+	// Outputs of -time-column are opened as their periods are read, so each
+	// takes the smallest buffer, and the run fails past the last one that fits
+	budget.writeBuffer = minWriteBuffer
+	if config.TimeColumn == "" {
+		budget.writeBuffer = max(minWriteBuffer, min(budget.buffer, writers/openOutputs(config)))
+	}
+	return budget
+}
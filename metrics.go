@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// splitMetrics holds the counters exposed on the Prometheus metrics endpoint.
+// Every field is updated atomically so the endpoint can be scraped while a
+// split is running.
+type splitMetrics struct {
+	startTime       time.Time
+	recordsRead     atomic.Int64
+	recordsWritten  atomic.Int64
+	recordsSkipped  atomic.Int64
+	recordsRejected atomic.Int64
+	bytesRead       atomic.Int64
+	bytesWritten    atomic.Int64
+	partsCreated    atomic.Int64
+	errors          atomic.Int64
+}
+
+func newSplitMetrics() *splitMetrics {
+	return &splitMetrics{startTime: time.Now()}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *splitMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "splitcsv_records_read_total", "counter", "Input records read.", m.recordsRead.Load())
+	writeMetric(w, "splitcsv_records_written_total", "counter", "Records written to output parts.", m.recordsWritten.Load())
+	writeMetric(w, "splitcsv_records_skipped_total", "counter", "Records dropped without being written.", m.recordsSkipped.Load())
+	writeMetric(w, "splitcsv_records_rejected_total", "counter", "Records written to the rejects file.", m.recordsRejected.Load())
+	writeMetric(w, "splitcsv_bytes_read_total", "counter", "Bytes read from the input.", m.bytesRead.Load())
+	writeMetric(w, "splitcsv_bytes_written_total", "counter", "Bytes written to output parts.", m.bytesWritten.Load())
+	writeMetric(w, "splitcsv_parts_created_total", "counter", "Output parts created.", m.partsCreated.Load())
+	writeMetric(w, "splitcsv_errors_total", "counter", "Runs that ended with an error.", m.errors.Load())
+	writeMetric(w, "splitcsv_start_time_seconds", "gauge", "Unix time the run started.", m.startTime.Unix())
+}
+
+// writeMetric writes a single sample with its HELP and TYPE lines
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// serveMetrics exposes m on addr at /metrics in the background. The returned
+// function stops the server.
+func serveMetrics(addr string, m *splitMetrics) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics address %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	return func() { server.Close() }, nil
+}
+
+// countingReader counts the bytes read through it into a shared counter
+type countingReader struct {
+	r     io.Reader
+	total *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.total.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mapInput maps file into memory for -mmap, returning a reader of the
+// mapping. The bytes of -passthrough records are then taken straight from
+// the mapping, instead of being copied as they are read. The mapping is
+// released by unmapInput.
+func (s *CSVSplitter) mapInput(input inputFile) (io.Reader, error) {
+	file, ok := input.(*os.File)
+	if !ok {
+		return nil, configErrorf("mmap needs an input file, not an entry of a zip archive")
+	}
+	data, err := mapFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map input file '%s': %w", file.Name(), err)
+	}
+	s.mapped = data
+	return bytes.NewReader(data), nil
+}
+
+// unmapInput releases the mapping of the input file, if any
+func (s *CSVSplitter) unmapInput() {
+	if s.mapped == nil {
+		return
+	}
+	unmapFile(s.mapped)
+	s.mapped = nil
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported reports whether -mmap can map files on this platform
+const mmapSupported = false
+
+// mapFile cannot map files on this platform, so -mmap fails
+func mapFile(file *os.File) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported on this platform")
+}
+
+// unmapFile has no mapping to release on this platform
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// notifyAttempts is how many times an event is posted to -notify-url
+	// before it is given up on
+	notifyAttempts = 3
+	// notifyTimeout bounds every post of an event
+	notifyTimeout = 10 * time.Second
+	// notifyQueue is how many part events wait to be posted before
+	// splitting waits for the endpoint
+	notifyQueue = 64
+)
+
+// NotifyEvent is the JSON body posted to -notify-url when a part is
+// completed, with event "part", and when the run ends, with event "run"
+type NotifyEvent struct {
+	Event    string    `json:"event"`
+	RunID    string    `json:"run_id,omitempty"`
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Path     string    `json:"path,omitempty"`
+	Records  int       `json:"records"`
+	Bytes    int64     `json:"bytes"`
+	Checksum string    `json:"checksum,omitempty"`
+	// Parts, Rejected, Error, and DurationSeconds are set on run events
+	Parts           int     `json:"parts,omitempty"`
+	Rejected        int     `json:"rejected,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// validateNotifyConfig checks -notify-url and -slack-webhook
+func validateNotifyConfig(config Config) error {
+	if err := checkWebhookURL("notify-url", config.NotifyURL); err != nil {
+		return err
+	}
+	return checkWebhookURL("slack-webhook", config.SlackWebhook)
+}
+
+// checkWebhookURL checks that the value of option, if set, is an http or
+// https URL
+func checkWebhookURL(option, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http or https URL", option)
+	}
+	return nil
+}
+
+// notifier posts the events of a run to -notify-url. Events are posted in
+// order by a goroutine of their own, so a slow endpoint does not hold up
+// splitting until the queue is full. An event that cannot be posted is
+// logged as a warning and does not fail the run.
+type notifier struct {
+	url    string
+	runID  string
+	logger *slog.Logger
+	events chan NotifyEvent
+	done   chan struct{}
+}
+
+// newNotifier starts posting events to -notify-url
+func newNotifier(config Config, logger *slog.Logger) *notifier {
+	n := &notifier{
+		url:    config.NotifyURL,
+		runID:  config.RunID,
+		logger: logger,
+		events: make(chan NotifyEvent, notifyQueue),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(n.done)
+		for event := range n.events {
+			n.post(event)
+		}
+	}()
+	return n
+}
+
+// part queues the event of a completed part. Parts cut short by a signal
+// are reported with status partial.
+func (n *notifier) part(part PartInfo) {
+	status := "completed"
+	if strings.HasSuffix(part.Path, partialSuffix) {
+		status = "partial"
+	}
+	n.events <- NotifyEvent{
+		Event:    "part",
+		RunID:    n.runID,
+		Time:     time.Now().UTC(),
+		Status:   status,
+		Path:     part.Path,
+		Records:  part.Records,
+		Bytes:    part.Bytes,
+		Checksum: part.Checksum,
+	}
+}
+
+// finish waits for the part events to be posted, and posts the event of the
+// end of the run of s, which ended with runErr
+func (n *notifier) finish(s *CSVSplitter, runErr error) {
+	close(n.events)
+	<-n.done
+	n.post(s.runEvent(runErr))
+}
+
+// runEvent returns the event of the end of the run, which ended with runErr
+func (s *CSVSplitter) runEvent(runErr error) NotifyEvent {
+	event := NotifyEvent{
+		Event:   "run",
+		RunID:   s.config.RunID,
+		Time:    time.Now().UTC(),
+		Status:  runStatus(runErr),
+		Records: s.stats.written,
+		Parts:   len(s.parts),
+	}
+	if !s.stats.startedAt.IsZero() {
+		// The run may fail before it starts reading, such as on a missing
+		// input
+		event.DurationSeconds = time.Since(s.stats.startedAt).Seconds()
+	}
+	for _, part := range s.parts {
+		event.Bytes += part.Bytes
+	}
+	if s.rejects != nil {
+		event.Rejected = s.rejects.count
+		if runErr == nil && s.rejects.count > 0 {
+			event.Status = "partial"
+		}
+	}
+	if runErr != nil {
+		// Webhooks and Slack are off the machine, so the bytes around an
+		// error in a record stay out of them
+		event.Error = positionError(runErr)
+	}
+	return event
+}
+
+// post sends event, reporting it if it cannot be posted
+func (n *notifier) post(event NotifyEvent) {
+	body, err := json.Marshal(event)
+	if err == nil {
+		err = postJSON(n.url, body)
+	}
+	if err != nil {
+		n.warn(event, err)
+	}
+}
+
+// postJSON posts body to endpoint, retrying failed attempts with a growing
+// delay up to notifyAttempts in all
+func postJSON(endpoint string, body []byte) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postOnce(endpoint, body)
+		if err == nil || attempt == notifyAttempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postOnce posts body to endpoint once, failing on a response other than 2xx
+func postOnce(endpoint string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// warn reports an event that could not be posted
+func (n *notifier) warn(event NotifyEvent, err error) {
+	if n.logger != nil {
+		n.logger.Warn("notification failed", "event", event.Event, "path", event.Path, "error", err.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: failed to post %s event to -notify-url: %v\n", event.Event, err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRunEventError(t *testing.T) {
+	located := &locatedError{Location{Offset: 42, Line: 3, Column: 7, Context: "1,987-65-4321,x", Hex: "31 2c"}, errors.New(`bare " in non-quoted field`)}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"located", located, `bare " in non-quoted field at byte 42 (line 3, column 7)`},
+		{"wrapped", fmt.Errorf("failed to read input: %w", located), `failed to read input: bare " in non-quoted field at byte 42 (line 3, column 7)`},
+		{"unlocated", errors.New("input not found"), "input not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &CSVSplitter{}
+			if got := s.runEvent(tt.err).Error; got != tt.want {
+				t.Errorf("runEvent error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// parseNullValues parses -null-values, a comma-separated list of the
+// spellings of null in the input. An empty item, as in a trailing comma,
+// stands for the empty field.
+func parseNullValues(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+	nulls := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		nulls[strings.TrimSpace(item)] = true
+	}
+	return nulls
+}
+
+// isNull reports whether value is one of -null-values
+func (s *CSVSplitter) isNull(value string) bool {
+	return s.nulls[strings.TrimSpace(value)]
+}
+
+// nullTransforms returns the transforms reading the -null-values of every
+// column of header as empty fields, which the other transforms, the
+// validators, and the masks all take for null
+func (s *CSVSplitter) nullTransforms(header []string) []fieldTransform {
+	if len(s.nulls) == 0 {
+		return nil
+	}
+	transforms := make([]fieldTransform, len(header))
+	for i := range header {
+		transforms[i] = fieldTransform{i, func(value string) (string, error) {
+			if s.isNull(value) {
+				return "", nil
+			}
+			return value, nil
+		}}
+	}
+	return transforms
+}
+
+// writeNulls returns a copy of record with its empty fields replaced by
+// -null-output
+func (s *CSVSplitter) writeNulls(record []string) []string {
+	out := slices.Clone(record)
+	for i, field := range out {
+		if field == "" {
+			out[i] = s.config.NullOutput
+		}
+	}
+	return out
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploader stores finished parts in a destination
+type uploader interface {
+	// Upload stores the file at path under name and returns its URL
+	Upload(ctx context.Context, path, name string) (string, error)
+}
+
+// newUploader returns an uploader for a destination URL: s3://bucket/prefix,
+// an http(s) URL that files are PUT under, or a file:// directory
+func newUploader(destination string) (uploader, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, configErrorf("invalid upload destination '%s': %v", destination, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Uploader(u)
+	case "http", "https":
+		return &httpUploader{
+			base:  strings.TrimSuffix(destination, "/"),
+			token: os.Getenv(envPrefix + "UPLOAD_TOKEN"),
+		}, nil
+	case "file":
+		return &fileUploader{dir: u.Path}, nil
+	}
+	return nil, configErrorf("unsupported upload destination scheme: %s", u.Scheme)
+}
+
+// fileUploader copies parts into a local or mounted directory
+type fileUploader struct {
+	dir string
+}
+
+func (f *fileUploader) Upload(ctx context.Context, path, name string) (string, error) {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	target := filepath.Join(f.dir, name)
+	dst, err := os.Create(target + tmpSuffix)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(target+tmpSuffix, target)
+	}
+	if err != nil {
+		os.Remove(target + tmpSuffix)
+		return "", fmt.Errorf("failed to copy '%s': %w", path, err)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(target)}).String(), nil
+}
+
+// httpUploader PUTs parts under a base URL, such as a presigned prefix or a
+// WebDAV collection, with an optional bearer token
+type httpUploader struct {
+	base  string
+	token string
+}
+
+func (h *httpUploader) Upload(ctx context.Context, path, name string) (string, error) {
+	target := h.base + "/" + url.PathEscape(name)
+	req, err := newFileRequest(ctx, http.MethodPut, target, path)
+	if err != nil {
+		return "", err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return target, doUpload(req)
+}
+
+// s3Uploader PUTs parts into an S3 or S3-compatible bucket, signing requests
+// with AWS Signature Version 4 using credentials from the environment
+type s3Uploader struct {
+	bucket    string
+	prefix    string
+	region    string
+	endpoint  string
+	accessKey string
+	secretKey string
+	token     string
+}
+
+// newS3Uploader configures an uploader for an s3:// URL. Credentials come
+// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN, the
+// region from AWS_REGION, and AWS_ENDPOINT_URL selects an S3-compatible
+// service addressed path-style. Each variable can be overridden by the same
+// name prefixed with SPLITCSV_S3_ in place of AWS_, which keeps the tool's
+// credentials apart from others in a shared environment.
+func newS3Uploader(u *url.URL) (*s3Uploader, error) {
+	s := &s3Uploader{
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    getenv(envPrefix+"S3_REGION", "AWS_REGION", "AWS_DEFAULT_REGION"),
+		endpoint:  strings.TrimSuffix(getenv(envPrefix+"S3_ENDPOINT_URL", "AWS_ENDPOINT_URL"), "/"),
+		accessKey: getenv(envPrefix+"S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		secretKey: getenv(envPrefix+"S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if os.Getenv(envPrefix+"S3_ACCESS_KEY_ID") != "" {
+		// Never pair the tool's own keys with a session token of another identity
+		s.token = os.Getenv(envPrefix + "S3_SESSION_TOKEN")
+	}
+	if s.bucket == "" {
+		return nil, configErrorf("s3 destination needs a bucket: s3://bucket/prefix")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, configErrorf("s3 destination needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	return s, nil
+}
+
+func (s *s3Uploader) Upload(ctx context.Context, file, name string) (string, error) {
+	key := path.Join(s.prefix, name)
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, s3EscapePath(key))
+	if s.endpoint != "" {
+		target = fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, s3EscapePath(key))
+	}
+
+	payloadHash, err := hashFile(file)
+	if err != nil {
+		return "", err
+	}
+	req, err := newFileRequest(ctx, http.MethodPut, target, file)
+	if err != nil {
+		return "", err
+	}
+	s.sign(req, hex.EncodeToString(payloadHash), time.Now().UTC())
+	if err := doUpload(req); err != nil {
+		return "", err
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req
+func (s *s3Uploader) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.token != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath escapes an object key as Signature Version 4 expects in the
+// canonical request path: every byte but the unreserved A-Z, a-z, 0-9, '-',
+// '.', '_', and '~' is percent-encoded, keeping the separating slashes
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// newFileRequest builds a request whose body is the file at path
+func newFileRequest(ctx context.Context, method, target, path string) (*http.Request, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid upload URL '%s': %w", target, err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType(path))
+	return req, nil
+}
+
+// contentType returns the media type uploaded parts are labeled with
+func contentType(path string) string {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return "application/gzip"
+	case ".csv":
+		return "text/csv"
+	case ".sql":
+		return "application/sql"
+	case ".md":
+		return "text/markdown"
+	case ".html":
+		return "text/html"
+	}
+	return "application/octet-stream"
+}
+
+// doUpload sends req, treating any non-2xx response as an error
+func doUpload(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload to '%s': %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to upload to '%s': %s", req.URL.Redacted(),
+			strings.TrimSpace(resp.Status+" "+strings.TrimSpace(string(body))))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestS3EscapePath(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"incoming/part_1.csv.gz", "incoming/part_1.csv.gz"},
+		{"in/run=2024-03-01/a,b:c@d(e)$f&g+h.csv", "in/run%3D2024-03-01/a%2Cb%3Ac%40d%28e%29%24f%26g%2Bh.csv"},
+		{"in/my part~1.csv", "in/my%20part~1.csv"},
+		{"in/é.csv", "in/%C3%A9.csv"},
+	}
+	for _, tt := range tests {
+		got := s3EscapePath(tt.key)
+		if got != tt.want {
+			t.Errorf("s3EscapePath(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+		// The path signed is the one the request is sent with
+		req, err := http.NewRequest(http.MethodPut, "https://bucket.s3.us-east-1.amazonaws.com/"+got, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := req.URL.EscapedPath(); path != "/"+tt.want {
+			t.Errorf("EscapedPath of %q = %q, want %q", tt.key, path, "/"+tt.want)
+		}
+	}
+}
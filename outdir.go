@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// partFilePattern matches the files a run with the given prefix leaves in its
+// part directory: parts of any output format, encrypted or not, their
+// temporary and partial variants, and checksum and signature sidecars
+func partFilePattern(prefix string) *regexp.Regexp {
+	var exts, algorithms []string
+	for _, ext := range outputFormats {
+		exts = append(exts, regexp.QuoteMeta(ext))
+	}
+	for algorithm := range checksumAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	slices.Sort(exts)
+	slices.Sort(algorithms)
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_[0-9]+\.(` + strings.Join(exts, "|") + `)(` + regexp.QuoteMeta(encryptedSuffix) + `)?` +
+		`(` + regexp.QuoteMeta(tmpSuffix) + `|` + regexp.QuoteMeta(partialSuffix) + `)?` +
+		`(\.(` + strings.Join(algorithms, "|") + `))?(` + regexp.QuoteMeta(signSuffix) + `)?$`)
+}
+
+// prepareOutputDir checks the part directory for parts left by a previous
+// run. With -clean they are removed, along with any checkpoint; otherwise a
+// part that this run would overwrite is an error unless -force is set. With
+// -resume, the parts completed before the checkpoint are kept and the partial
+// part is removed. A directory not created yet holds nothing to check, as
+// is the case of the -route streams, which are checked before it is made.
+func prepareOutputDir(config Config) error {
+	dir := partDir(config)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read output directory: %w", err)
+	}
+
+	firstPart := 1
+	if config.Clean {
+		if err := os.Remove(checkpointPath(config)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale checkpoint: %w", err)
+		}
+		for _, stale := range []string{manifestPath(config), manifestPath(config) + signSuffix} {
+			if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale manifest: %w", err)
+			}
+		}
+	}
+	if config.Resume {
+		checkpoint, err := loadCheckpoint(config)
+		if err != nil {
+			return err
+		}
+		firstPart = checkpoint.NextPart
+		if checkpoint.PartialPart != nil {
+			os.Remove(checkpoint.PartialPart.Path)
+		}
+	}
+
+	pattern := partFilePattern(config.OutputPrefix)
+	ext := "." + partExtension(config)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !pattern.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		switch {
+		case config.Clean:
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove stale part: %w", err)
+			}
+		case !config.Force && strings.HasSuffix(name, ext) && partNumber(config.OutputPrefix, name) >= firstPart:
+			return fmt.Errorf("output file '%s' already exists; use -force to overwrite or -clean to remove previous parts", path)
+		}
+	}
+	return nil
+}
+
+// runFiles returns the files other than parts that a run with config writes:
+// the rejects file of -on-error quarantine, -schema, and -types, the
+// validation summary of -schema, the -report, and the -lineage file
+func runFiles(config Config) []string {
+	var files []string
+	if config.OnError == "quarantine" || config.SchemaPath != "" || config.Types != "" {
+		files = append(files, filepath.Join(config.OutputDir, config.OutputPrefix+"_rejects.csv"))
+	}
+	if config.SchemaPath != "" {
+		files = append(files, filepath.Join(config.OutputDir, config.OutputPrefix+"_validation.json"))
+	}
+	if config.ReportPath != "" {
+		files = append(files, config.ReportPath)
+	}
+	if config.LineagePath != "" {
+		files = append(files, config.LineagePath)
+	}
+	return files
+}
+
+// prepareRunFiles checks the files of runFiles as prepareOutputDir checks
+// parts: with -clean they are removed, and otherwise one that exists is an
+// error unless -force is set. With -resume they belong to the run being
+// resumed, which appends to the rejects and lineage files.
+func prepareRunFiles(config Config) error {
+	if config.Resume {
+		return nil
+	}
+	for _, path := range runFiles(config) {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		switch {
+		case config.Clean:
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove stale output file: %w", err)
+			}
+		case !config.Force:
+			return fmt.Errorf("output file '%s' already exists; use -force to overwrite it or -clean to remove it", path)
+		}
+	}
+	return nil
+}
+
+// partNumber returns the number of the part file name, which must match
+// partFilePattern(prefix)
+func partNumber(prefix, name string) int {
+	digits, _, _ := strings.Cut(strings.TrimPrefix(name, prefix+"_"), ".")
+	n, _ := strconv.Atoi(digits)
+	return n
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareRunFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		existing string
+		wantErr  bool
+		wantGone bool
+	}{
+		{"rejects", []string{"-on-error", "quarantine"}, "output_rejects.csv", true, false},
+		{"validation", []string{"-schema", "schema.json"}, "output_validation.json", true, false},
+		{"report", []string{"-report", "report.json"}, "report.json", true, false},
+		{"lineage", []string{"-lineage", "lineage.csv"}, "lineage.csv", true, false},
+		{"not written by the run", nil, "output_rejects.csv", false, false},
+		{"force", []string{"-report", "report.json", "-force"}, "report.json", false, false},
+		{"clean", []string{"-report", "report.json", "-clean"}, "report.json", false, true},
+		{"resume", []string{"-on-error", "quarantine", "-resume"}, "output_rejects.csv", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := testConfig(t, tt.args...)
+			config.OutputDir = dir
+			if config.ReportPath != "" {
+				config.ReportPath = filepath.Join(dir, config.ReportPath)
+			}
+			if config.LineagePath != "" {
+				config.LineagePath = filepath.Join(dir, config.LineagePath)
+			}
+			existing := filepath.Join(dir, tt.existing)
+			if err := os.WriteFile(existing, []byte("previous run\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := prepareRunFiles(config)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("prepareRunFiles(%v) = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.existing) {
+				t.Errorf("prepareRunFiles(%v) = %v, want it to name %s", tt.args, err, tt.existing)
+			}
+			if _, err := os.Stat(existing); os.IsNotExist(err) != tt.wantGone {
+				t.Errorf("after prepareRunFiles(%v), %s removed = %v, want %v", tt.args, tt.existing, os.IsNotExist(err), tt.wantGone)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// recordWriter encodes the header and records of a single output part
+type recordWriter interface {
+	WriteHeader(header []string) error
+	Write(record []string) error
+	// Close flushes buffered output and writes any trailer. It does not
+	// close the underlying writer.
+	Close() error
+}
+
+// outputFormats maps -output-format values to the file extension of parts
+var outputFormats = map[string]string{
+	"csv":      "csv",
+	"sql":      "sql",
+	"markdown": "md",
+	"html":     "html",
+	"sqlite":   "sqlite",
+	"mysql":    "txt",
+	"fwf":      "txt",
+}
+
+// partExtension returns the file extension of parts, which is that of the
+// output format, followed by that of age when they are encrypted
+func partExtension(config Config) string {
+	if config.Encrypt != "" {
+		return outputFormats[config.OutputFormat] + encryptedSuffix
+	}
+	return outputFormats[config.OutputFormat]
+}
+
+// newRecordWriter returns a writer for the configured output format
+func newRecordWriter(w io.Writer, config Config) recordWriter {
+	switch config.OutputFormat {
+	case "sql":
+		return newSQLWriter(w, config)
+	case "markdown":
+		return newMarkdownWriter(w)
+	case "html":
+		return newHTMLWriter(w)
+	case "sqlite":
+		return newSQLiteWriter(w, config)
+	case "mysql":
+		return newLoadDataWriter(w, config)
+	case "fwf":
+		return newFWFWriter(w, config)
+	default:
+		if config.QuoteStyle != "minimal" {
+			return newQuotingWriter(w, config)
+		}
+		writer := newCSVRecordWriter(w, outputDelimiter(config))
+		writer.writer.UseCRLF = config.OutputLineEnding == "crlf"
+		return writer
+	}
+}
+
+// outputDelimiter returns the delimiter of csv parts, which is the input
+// delimiter unless -output-delimiter is set
+func outputDelimiter(config Config) rune {
+	if config.OutputDelimiter != 0 {
+		return config.OutputDelimiter
+	}
+	return config.Delimiter
+}
+
+// validateOutputFormat checks the output format and its format-specific
+// options
+func validateOutputFormat(config Config) error {
+	if _, ok := outputFormats[config.OutputFormat]; !ok {
+		return fmt.Errorf("unsupported output format: %s", config.OutputFormat)
+	}
+	switch config.OutputLineEnding {
+	case "lf":
+	case "crlf":
+		if config.OutputFormat != "csv" && config.OutputFormat != "mysql" && config.OutputFormat != "fwf" {
+			return fmt.Errorf("output-line-ending crlf is only used with csv, mysql, and fwf output")
+		}
+	default:
+		return fmt.Errorf("invalid output-line-ending '%s': must be lf or crlf", config.OutputLineEnding)
+	}
+	if !slices.Contains(quoteStyles, config.QuoteStyle) {
+		return fmt.Errorf("invalid quote-style '%s': must be minimal, all, non-numeric, or none", config.QuoteStyle)
+	}
+	if config.QuoteStyle != "minimal" && config.OutputFormat != "csv" {
+		return fmt.Errorf("quote-style is only used with csv output")
+	}
+	if config.OutputDelimiter != 0 {
+		if config.OutputFormat != "csv" {
+			return fmt.Errorf("output-delimiter is only used with csv output")
+		}
+		if strings.ContainsRune("\"\r\n", config.OutputDelimiter) {
+			return fmt.Errorf("output-delimiter cannot be a quote or line break")
+		}
+	}
+	if err := validateFWFOutputConfig(config); err != nil {
+		return err
+	}
+	switch config.OutputFormat {
+	case "sql":
+		return validateSQLOptions(config)
+	case "sqlite":
+		return validateSQLiteOptions(config)
+	case "mysql":
+		return validateLoadDataOptions(config)
+	}
+	return nil
+}
+
+// csvRecordWriter writes records as delimited text
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVRecordWriter(w io.Writer, delimiter rune) *csvRecordWriter {
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	return &csvRecordWriter{writer: writer}
+}
+
+func (c *csvRecordWriter) WriteHeader(header []string) error {
+	return c.writer.Write(header)
+}
+
+func (c *csvRecordWriter) Write(record []string) error {
+	return c.writer.Write(record)
+}
+
+func (c *csvRecordWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+)
+
+// partColumns returns the names of the columns -add-part-column and
+// -add-part-row-column append to every record
+func partColumns(config Config) []string {
+	var columns []string
+	if config.PartColumn != "" {
+		columns = append(columns, config.PartColumn)
+	}
+	if config.PartRowColumn != "" {
+		columns = append(columns, config.PartRowColumn)
+	}
+	return columns
+}
+
+// rowNumber formats the -add-row-number value of input record number n,
+// which is empty for the deleted rows of -incremental that have none
+func rowNumber(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// withPartColumns returns record with the part it is written to and its row
+// number within the part appended, as configured. The part is named by its
+// number, or by its name in a routed split such as -ratio.
+func (s *CSVSplitter) withPartColumns(record []string) []string {
+	record = slices.Clip(record)
+	if s.config.PartColumn != "" {
+		part := s.partName
+		if part == "" {
+			part = strconv.Itoa(s.partNumber - 1)
+		}
+		record = append(record, part)
+	}
+	if s.config.PartRowColumn != "" {
+		record = append(record, strconv.Itoa(s.parts[len(s.parts)-1].Records+1))
+	}
+	return record
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// partitionNames returns the names of the outputs of a -hash-key run,
+// zero-padded so that they sort in order
+func partitionNames(partitions int) []string {
+	width := len(fmt.Sprint(partitions - 1))
+	names := make([]string, partitions)
+	for i := range names {
+		names[i] = fmt.Sprintf("p%0*d", width, i)
+	}
+	return names
+}
+
+// validatePartitionConfig checks -hash-key and -partitions
+func validatePartitionConfig(config Config) error {
+	if len(parseKeyColumns(config.HashKey)) == 0 {
+		return fmt.Errorf("hash-key must name at least one column")
+	}
+	if config.Partitions < 1 {
+		return fmt.Errorf("hash-key needs -partitions of at least 1")
+	}
+	return nil
+}
+
+// partitionRoute returns the route of a -hash-key split, which sends a record
+// to the partition given by the FNV-1a hash of its key, modulo -partitions.
+// The values of a key of several columns are joined with the unit separator
+// (0x1F) before hashing. The same key thus always lands in the same
+// partition, across files and runs.
+func (s *CSVSplitter) partitionRoute(header []string) (func(record []string) int, error) {
+	keys, err := keyIndexes(header, s.config.HashKey)
+	if err != nil {
+		return nil, err
+	}
+	if s.logger != nil {
+		s.logger.Info("hash partitioning", "key", s.config.HashKey, "partitions", s.config.Partitions)
+	} else if s.config.Verbose {
+		fmt.Printf("Partitioning by hash of %s into %d parts\n", s.config.HashKey, s.config.Partitions)
+	}
+
+	partitions := uint64(s.config.Partitions)
+	values := make([]string, len(keys))
+	route := func(record []string) int {
+		for i, k := range keys {
+			values[i] = ""
+			if k < len(record) {
+				values[i] = record[k]
+			}
+		}
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(values, "\x1f")))
+		return int(h.Sum64() % partitions)
+	}
+	return route, nil
+}
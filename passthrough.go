@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// rawInput holds the bytes of the header and of the last record read in
+// -passthrough mode, exactly as they were in the input
+type rawInput struct {
+	header []byte
+	record []byte
+}
+
+// validatePassthroughConfig checks that -passthrough is not combined with
+// options that change the bytes of the records
+func validatePassthroughConfig(config Config) error {
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.OutputFormat != "csv", "output-format " + config.OutputFormat},
+		{config.OutputDelimiter != 0, "output-delimiter"},
+		{config.OutputLineEnding != "lf", "output-line-ending"},
+		{config.QuoteStyle != "minimal", "quote-style"},
+		{config.Strict && (config.StrictAction == "pad" || config.StrictAction == "truncate"), "strict-action " + config.StrictAction},
+		{config.OnWidthMismatch == "pad" || config.OnWidthMismatch == "truncate", "on-width-mismatch " + config.OnWidthMismatch},
+		{config.TrimFields || config.CollapseWhitespace || config.NormalizeCase != "", "field normalization"},
+		{len(config.Replaces) > 0, "replace"},
+		{len(config.ReparseDates) > 0, "reparse-date"},
+		{config.Types != "", "types"},
+		{config.NullValues != "" || config.NullOutput != "", "null-values and null-output"},
+		{len(config.Masks) > 0 || len(config.HashColumns) > 0, "mask and hash-column"},
+		{config.Join != "", "join"},
+		{config.SourceColumn != "", "source-column"},
+		{config.RowNumberColumn != "", "add-row-number"},
+		{config.PartColumn != "" || config.PartRowColumn != "", "add-part-column and add-part-row-column"},
+		{config.Incremental, "incremental"},
+		{config.Shuffle, "shuffle"},
+		{config.Repair, "repair"},
+		{config.InputEncoding != "utf-8", "input-encoding " + config.InputEncoding},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("%s cannot be combined with passthrough, which writes records as they were read", option.name)
+		}
+	}
+	return nil
+}
+
+// rawRecord returns the bytes of the input between the end of the record
+// taken before and the end of the last record read, which are those of the
+// last record and its line ending
+func (s *CSVSplitter) rawRecord() []byte {
+	end := s.input.InputOffset()
+	raw := s.tail.slice(s.tail.mark, end)
+	s.tail.mark = end
+	return raw
+}
+
+// passHeader takes the bytes of the header just read. Those of the first
+// input file become the header of every part, unless -set-header or
+// -dedupe-headers replaces them with the names of header. The first record of
+// an input without a header is left to be taken as a record.
+func (s *CSVSplitter) passHeader(header []string) {
+	if s.raw == nil {
+		return
+	}
+	var raw []byte
+	if s.config.Header == "no" {
+		raw = s.tail.slice(s.tail.mark, s.input.InputOffset())
+	} else {
+		raw = s.rawRecord()
+	}
+	if s.raw.header != nil {
+		return
+	}
+	if s.config.SetHeader != "" || s.headerRenamed {
+		s.raw.header = s.encodeHeader(header, raw)
+		return
+	}
+	s.raw.header = bytes.Clone(raw)
+}
+
+// lineEnding returns the line ending of the header, which ends a last
+// record that lacks one
+func (r *rawInput) lineEnding() []byte {
+	if bytes.HasSuffix(r.header, []byte("\r\n")) {
+		return []byte("\r\n")
+	}
+	return []byte("\n")
+}
+
+// rawRecordWriter writes the header and records of a part as they were in
+// the input, ignoring the fields it is given
+type rawRecordWriter struct {
+	w   io.Writer
+	raw *rawInput
+}
+
+func (r *rawRecordWriter) WriteHeader([]string) error {
+	return r.write(r.raw.header)
+}
+
+func (r *rawRecordWriter) Write([]string) error {
+	return r.write(r.raw.record)
+}
+
+// write writes line, ending it if the input did not
+func (r *rawRecordWriter) write(line []byte) error {
+	if _, err := r.w.Write(line); err != nil {
+		return err
+	}
+	if len(line) > 0 && line[len(line)-1] != '\n' {
+		_, err := r.w.Write(r.raw.lineEnding())
+		return err
+	}
+	return nil
+}
+
+func (r *rawRecordWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// errLoadAborted ends the COPY of a batch that is rolled back
+var errLoadAborted = errors.New("load aborted")
+
+// validatePgConfig checks -pg-dsn, -pg-table, -pg-batch, and -pg-only
+func validatePgConfig(config Config) error {
+	if config.PgDSN == "" {
+		if config.PgTable != "" || config.PgBatch != 0 || config.PgOnly {
+			return fmt.Errorf("pg-table, pg-batch, and pg-only are only used with -pg-dsn")
+		}
+		return nil
+	}
+	if config.PgTable == "" {
+		return fmt.Errorf("pg-table is required with -pg-dsn")
+	}
+	if config.PgBatch < 0 {
+		return fmt.Errorf("pg-batch must not be negative")
+	}
+	if _, routed := routedMode(config); routed || len(config.Routes) > 0 {
+		return fmt.Errorf("pg-dsn cannot be combined with routed splits or -route, whose outputs are written side by side")
+	}
+	if config.Incremental {
+		return fmt.Errorf("pg-dsn cannot be combined with incremental, whose records start with the op column")
+	}
+	if config.FooterAction != "drop" {
+		return fmt.Errorf("pg-dsn cannot be combined with -footer-action %s, as the footer would be loaded as records", config.FooterAction)
+	}
+	if config.PgOnly && (config.FilterMode || config.Checksum != "" || config.BagIt) {
+		return fmt.Errorf("pg-only cannot be combined with filter-mode, checksums, or bagit, which need the parts")
+	}
+	return nil
+}
+
+// pgLoader streams the records of every part into a PostgreSQL table with
+// COPY, as they are written. A part is loaded in one transaction, committed
+// when it is complete, or in transactions of -pg-batch records, so a run
+// stopped partway leaves the table holding whole parts or batches only.
+type pgLoader struct {
+	ctx   context.Context
+	conn  *pgx.Conn
+	table string
+	batch int
+	sql   string
+	// tx is the transaction of the batch being copied, whose records are
+	// written to pipe through writer, and done receives the end of its COPY
+	tx     pgx.Tx
+	pipe   *io.PipeWriter
+	writer *csv.Writer
+	done   chan error
+	rows   int
+	// loaded is the number of records of the current part committed, and
+	// skip the number the run being resumed already committed
+	loaded int
+	skip   int
+}
+
+// newPgLoader connects to the database of -pg-dsn. Loading outlives the
+// cancellation of ctx, so that the part being finished when a run stops
+// is still committed.
+func newPgLoader(ctx context.Context, config Config) (*pgLoader, error) {
+	ctx = context.WithoutCancel(ctx)
+	conn, err := pgx.Connect(ctx, config.PgDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to -pg-dsn: %w", err)
+	}
+	return &pgLoader{
+		ctx:   ctx,
+		conn:  conn,
+		table: pgx.Identifier(strings.Split(config.PgTable, ".")).Sanitize(),
+		batch: config.PgBatch,
+	}, nil
+}
+
+// startPart prepares the COPY of a part with the columns of header
+func (l *pgLoader) startPart(header []string) {
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = pgx.Identifier{name}.Sanitize()
+	}
+	l.sql = fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FORMAT csv)", l.table, strings.Join(columns, ", "))
+	l.loaded = 0
+}
+
+// write copies record, starting a batch if none is open, and commits the
+// batch once it holds -pg-batch records
+func (l *pgLoader) write(record []string) error {
+	if l.skip > 0 {
+		l.skip--
+		l.loaded++
+		return nil
+	}
+	if l.tx == nil {
+		if err := l.begin(); err != nil {
+			return err
+		}
+	}
+	if err := l.writer.Write(record); err != nil {
+		return l.fail(err)
+	}
+	l.rows++
+	if l.batch > 0 && l.rows == l.batch {
+		return l.commit()
+	}
+	return nil
+}
+
+// begin opens a transaction and starts a COPY reading the records written
+// to the pipe
+func (l *pgLoader) begin() error {
+	tx, err := l.conn.Begin(l.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin load: %w", err)
+	}
+	reader, pipe := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := tx.Conn().PgConn().CopyFrom(l.ctx, reader, l.sql)
+		reader.CloseWithError(err)
+		done <- err
+	}()
+	l.tx, l.pipe, l.done = tx, pipe, done
+	l.writer = csv.NewWriter(pipe)
+	return nil
+}
+
+// commit ends the COPY of the open batch, if any, and commits it
+func (l *pgLoader) commit() error {
+	if l.tx == nil {
+		return nil
+	}
+	l.writer.Flush()
+	if err := l.writer.Error(); err != nil {
+		return l.fail(err)
+	}
+	l.pipe.Close()
+	err := <-l.done
+	if err == nil {
+		err = l.tx.Commit(l.ctx)
+	} else {
+		l.tx.Rollback(l.ctx)
+	}
+	l.tx = nil
+	if err != nil {
+		return fmt.Errorf("failed to load into %s: %w", l.table, err)
+	}
+	l.loaded += l.rows
+	l.rows = 0
+	return nil
+}
+
+// fail aborts the open batch after writing to its COPY failed, returning
+// the error of the COPY, which is what made the write fail
+func (l *pgLoader) fail(err error) error {
+	l.pipe.CloseWithError(errLoadAborted)
+	if cerr := <-l.done; cerr != nil {
+		err = cerr
+	}
+	l.tx.Rollback(l.ctx)
+	l.tx, l.rows = nil, 0
+	return fmt.Errorf("failed to load into %s: %w", l.table, err)
+}
+
+// abort rolls back the open batch, if any, leaving the records committed
+// before it loaded
+func (l *pgLoader) abort() {
+	if l.tx == nil {
+		return
+	}
+	l.pipe.CloseWithError(errLoadAborted)
+	<-l.done
+	l.tx.Rollback(l.ctx)
+	l.tx, l.rows = nil, 0
+}
+
+// Close rolls back the open batch, if any, and disconnects
+func (l *pgLoader) Close() error {
+	l.abort()
+	return l.conn.Close(l.ctx)
+}
+
+// logLoaded reports the records of the part just closed that were loaded
+func (s *CSVSplitter) logLoaded() {
+	if s.logger != nil {
+		s.logger.Info("part loaded", "path", s.outPath, "table", s.config.PgTable, "records", s.pg.loaded)
+	} else if s.config.Verbose {
+		fmt.Printf("Loaded %d records of %s into %s\n", s.pg.loaded, s.outPath, s.config.PgTable)
+	}
+}
+
+// pgRecordWriter writes the records of a part to its file and loads them
+// into PostgreSQL
+type pgRecordWriter struct {
+	recordWriter
+	loader *pgLoader
+}
+
+func (p *pgRecordWriter) WriteHeader(header []string) error {
+	p.loader.startPart(header)
+	return p.recordWriter.WriteHeader(header)
+}
+
+func (p *pgRecordWriter) Write(record []string) error {
+	if err := p.recordWriter.Write(record); err != nil {
+		return err
+	}
+	return p.loader.write(record)
+}
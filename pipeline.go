@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PipelinePart is the outcome of shipping a single part
+type PipelinePart struct {
+	Part            string  `json:"part"`
+	Records         int     `json:"records"`
+	Bytes           int64   `json:"bytes"`
+	Object          string  `json:"object,omitempty"`
+	Loaded          bool    `json:"loaded"`
+	Attempts        int     `json:"attempts"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// PipelineSummary is the machine-readable summary written by -summary
+type PipelineSummary struct {
+	RunID           string         `json:"run_id,omitempty"`
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	Succeeded       int            `json:"succeeded"`
+	Failed          int            `json:"failed"`
+	Parts           []PipelinePart `json:"parts"`
+	StartedAt       time.Time      `json:"started_at"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+}
+
+// pipeline ships finished parts to object storage and triggers their load,
+// with a bounded number of parts in flight
+type pipeline struct {
+	compress  string
+	retries   int
+	remove    bool
+	uploader  uploader
+	loadURL   string
+	loadSQL   string
+	db        *sql.DB
+	logger    *slog.Logger
+	verbose   bool
+	mu        sync.Mutex
+	results   []PipelinePart
+	jobs      chan pipelineJob
+	wg        sync.WaitGroup
+	startedAt time.Time
+	runID     string
+}
+
+// pipelineJob is a finished part waiting to be shipped
+type pipelineJob struct {
+	index int
+	part  PartInfo
+}
+
+// runPipeline implements the pipeline subcommand, which splits the input and
+// compresses, uploads, and loads every part as soon as it is complete
+func runPipeline(args []string) error {
+	config := Config{}
+	var destination, compress, loadDSN, summaryPath, retryPath string
+	p := &pipeline{}
+
+	fs := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	fs.StringVar(&destination, "upload", "", "Destination for parts: s3://bucket/prefix, an http(s) URL, or file:///dir (required)")
+	fs.StringVar(&compress, "compress", "gzip", "Compression applied before upload: gzip or none")
+	parallel := fs.Int("parallel", 4, "Number of parts shipped concurrently")
+	fs.IntVar(&p.retries, "retries", 3, "Retries per upload or load step before a part fails")
+	fs.StringVar(&p.loadURL, "load-url", "", "POST a JSON notification for each uploaded part to this URL")
+	fs.StringVar(&p.loadSQL, "load-sql", "", "SQL run for each uploaded part, with {object} and {name} substituted as quoted string literals and {records} as a number")
+	fs.StringVar(&loadDSN, "load-dsn", "", "Database connection string for -load-sql")
+	fs.StringVar(&summaryPath, "summary", "", "Write the JSON summary of the shipped parts to this file (default: {prefix}_pipeline.json in the output directory)")
+	fs.BoolVar(&p.remove, "delete-uploaded", false, "Remove local parts once they are shipped")
+	fs.StringVar(&retryPath, "retry-failed", "", "Ship only the parts marked failed in this summary, without splitting again")
+	registerFlags(fs, &config)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s pipeline -upload DEST [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Split the input and compress, upload, and load every part as it completes.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s pipeline -i data.csv -l 100000 -upload s3://bucket/incoming\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s pipeline -i data.csv -upload s3://bucket/in -load-dsn postgres://db/warehouse \\\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "      -load-sql \"select load_part('{object}')\"\n")
+		fmt.Fprintf(os.Stderr, "  %s pipeline -upload s3://bucket/incoming -retry-failed output_pipeline.json\n", os.Args[0])
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	startRun(&config)
+
+	logger := newLogger(config)
+	p.logger = logger
+	p.runID = config.RunID
+	p.verbose = config.Verbose
+	p.compress = compress
+	if err := p.configure(config, destination, loadDSN, *parallel, retryPath == ""); err != nil {
+		if exitCode(err) == exitFailure {
+			err = withExitCode(exitConfig, err)
+		}
+		return reportError(logger, err)
+	}
+	if p.db != nil {
+		defer p.db.Close()
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	if retryPath != "" {
+		if summaryPath == "" {
+			summaryPath = retryPath
+		}
+		if err := p.retryFailed(ctx, retryPath, *parallel); err != nil {
+			return reportError(logger, err)
+		}
+		return p.finish(summaryPath, nil)
+	}
+	if summaryPath == "" {
+		summaryPath = filepath.Join(config.OutputDir, config.OutputPrefix+"_pipeline.json")
+	}
+
+	splitter := NewCSVSplitter(config)
+	if config.NotifyURL != "" {
+		splitter.notifier = newNotifier(config, logger)
+	}
+	if config.MetricsAddr != "" {
+		stop, err := serveMetrics(config.MetricsAddr, splitter.metrics)
+		if err != nil {
+			return reportError(logger, err)
+		}
+		defer stop()
+	}
+
+	// The deadline bounds the split; parts already queued are still shipped
+	splitCtx, cancel := withDeadline(ctx, config.Deadline)
+	defer cancel()
+
+	p.start(ctx, *parallel)
+	splitter.partDone = p.submit
+	err := splitter.SplitContext(splitCtx)
+	p.wait()
+
+	if err != nil {
+		splitter.metrics.errors.Add(1)
+	}
+	if config.ReportPath != "" {
+		if rerr := splitter.WriteReport(config.ReportPath, err); rerr != nil {
+			reportError(logger, rerr)
+		}
+	}
+	if splitter.notifier != nil {
+		splitter.notifier.finish(splitter, err)
+	}
+	if config.SlackWebhook != "" {
+		splitter.notifySlack(inputSource(config), err)
+	}
+	if err := p.finish(summaryPath, err); err != nil {
+		return err
+	}
+	if splitter.rejects != nil && splitter.rejects.count > 0 {
+		err := withExitCode(exitPartial, fmt.Errorf("rejected %d records to %s",
+			splitter.rejects.count, splitter.rejects.path))
+		return reportError(logger, err)
+	}
+	return nil
+}
+
+// configure validates the pipeline and split options and connects to the
+// load database
+func (p *pipeline) configure(config Config, destination, loadDSN string, parallel int, splitting bool) error {
+	if destination == "" {
+		return fmt.Errorf("upload destination is required")
+	}
+	if p.compress != "gzip" && p.compress != "none" {
+		return fmt.Errorf("compress must be gzip or none")
+	}
+	if parallel <= 0 {
+		return fmt.Errorf("parallel must be greater than 0")
+	}
+	if p.retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if (p.loadSQL == "") != (loadDSN == "") {
+		return fmt.Errorf("load-sql and load-dsn must be used together")
+	}
+	if config.Bundle != "" {
+		return fmt.Errorf("bundle cannot be used with pipeline, which ships every part on its own")
+	}
+	if splitting {
+		if err := validateConfig(config); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if p.uploader, err = newUploader(destination); err != nil {
+		return err
+	}
+	if loadDSN != "" {
+		driver, err := inferSQLDriver(loadDSN)
+		if err != nil {
+			return err
+		}
+		if p.db, err = sql.Open(driver, loadDSN); err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+	return nil
+}
+
+// start launches the workers that ship submitted parts
+func (p *pipeline) start(ctx context.Context, workers int) {
+	p.startedAt = time.Now()
+	p.jobs = make(chan pipelineJob, workers)
+	for range workers {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				result := p.ship(ctx, job.part)
+				p.mu.Lock()
+				p.results[job.index] = result
+				p.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// submit queues a finished part, blocking while all workers are busy so
+// that finished parts do not pile up on disk
+func (p *pipeline) submit(part PartInfo) {
+	p.mu.Lock()
+	p.results = append(p.results, PipelinePart{Part: part.Path, Status: "pending"})
+	index := len(p.results) - 1
+	p.mu.Unlock()
+	p.jobs <- pipelineJob{index: index, part: part}
+}
+
+// wait stops accepting parts and waits for the queued ones to be shipped
+func (p *pipeline) wait() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// ship compresses, uploads, and loads a single part
+func (p *pipeline) ship(ctx context.Context, part PartInfo) PipelinePart {
+	started := time.Now()
+	result := PipelinePart{Part: part.Path, Records: part.Records, Status: "failed"}
+	err := p.shipPart(ctx, part, &result)
+	result.DurationSeconds = time.Since(started).Seconds()
+	if err != nil {
+		result.Error = err.Error()
+		if p.logger != nil {
+			p.logger.Error("part failed", "path", part.Path, "attempts", result.Attempts, "error", err.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: failed to ship %s: %v\n", part.Path, err)
+		}
+		return result
+	}
+
+	result.Status = "succeeded"
+	if p.logger != nil {
+		p.logger.Info("part shipped", "path", part.Path, "object", result.Object, "bytes", result.Bytes,
+			"attempts", result.Attempts, "duration_seconds", result.DurationSeconds)
+	} else if p.verbose {
+		fmt.Printf("Shipped %s to %s\n", part.Path, result.Object)
+	}
+	return result
+}
+
+func (p *pipeline) shipPart(ctx context.Context, part PartInfo, result *PipelinePart) error {
+	path := part.Path
+	if p.compress == "gzip" {
+		path = part.Path + ".gz"
+		if err := gzipFile(part.Path, path); err != nil {
+			return err
+		}
+		defer os.Remove(path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	result.Bytes = info.Size()
+
+	name := filepath.Base(path)
+	err = p.retry(ctx, result, func() error {
+		object, err := p.uploader.Upload(ctx, path, name)
+		result.Object = object
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.loadURL != "" || p.db != nil {
+		err = p.retry(ctx, result, func() error {
+			return p.load(ctx, name, result)
+		})
+		if err != nil {
+			return err
+		}
+		result.Loaded = true
+	}
+
+	if p.remove {
+		os.Remove(part.Path)
+	}
+	return nil
+}
+
+// retry runs step until it succeeds or the retries are exhausted, backing
+// off exponentially between attempts
+func (p *pipeline) retry(ctx context.Context, result *PipelinePart, step func() error) error {
+	delay := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		result.Attempts++
+		err := step()
+		if err == nil || attempt >= p.retries {
+			return err
+		}
+		if p.logger != nil {
+			p.logger.Warn("retrying part", "path", result.Part, "attempt", attempt+1, "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+// load triggers the load of an uploaded part through the configured HTTP
+// endpoint and SQL statement
+func (p *pipeline) load(ctx context.Context, name string, result *PipelinePart) error {
+	if p.loadURL != "" {
+		body, _ := json.Marshal(map[string]any{
+			"part":    name,
+			"object":  result.Object,
+			"records": result.Records,
+			"bytes":   result.Bytes,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.loadURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("invalid load URL '%s': %w", p.loadURL, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("load request failed: %w", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("load request failed: %s", resp.Status)
+		}
+	}
+
+	if p.db != nil {
+		if _, err := p.db.ExecContext(ctx, loadStatement(p.loadSQL, result.Object, name, result.Records)); err != nil {
+			return fmt.Errorf("load statement failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadStatement returns the -load-sql statement loadSQL for the part name,
+// uploaded as object. The object and part names come from the prefix and the
+// destination, and may hold any character, so {object} and {name} are
+// substituted as string literals; the quotes of a placeholder written as
+// '{object}' are part of it.
+func loadStatement(loadSQL, object, name string, records int) string {
+	quote := sqlDialects["postgres"].quoteString
+	return strings.NewReplacer(
+		"'{object}'", quote(object),
+		"{object}", quote(object),
+		"'{name}'", quote(name),
+		"{name}", quote(name),
+		"{records}", strconv.Itoa(records),
+	).Replace(loadSQL)
+}
+
+// retryFailed ships the parts marked failed in the summary at path again,
+// keeping the results of the parts that already succeeded
+func (p *pipeline) retryFailed(ctx context.Context, path string, workers int) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return configErrorf("summary file does not exist: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read summary '%s': %w", path, err)
+	}
+	var previous PipelineSummary
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return configErrorf("invalid summary file '%s': %v", path, err)
+	}
+
+	p.results = previous.Parts
+	p.start(ctx, workers)
+	for i, part := range previous.Parts {
+		if part.Status == "succeeded" {
+			continue
+		}
+		if _, err := os.Stat(part.Part); err != nil {
+			p.mu.Lock()
+			p.results[i].Status = "failed"
+			p.results[i].Error = fmt.Sprintf("part is no longer available: %v", err)
+			p.mu.Unlock()
+			continue
+		}
+		p.jobs <- pipelineJob{index: i, part: PartInfo{Path: part.Part, Records: part.Records}}
+	}
+	p.wait()
+	return nil
+}
+
+// finish writes the summary to path and reports the outcome of the run.
+// splitErr is the error the split ended with, if any.
+func (p *pipeline) finish(path string, splitErr error) error {
+	summary := p.summary(splitErr)
+	written := true
+	if err := writeSummary(path, summary); err != nil {
+		reportError(p.logger, err)
+		written = false
+	}
+	if splitErr != nil {
+		return reportError(p.logger, splitErr)
+	}
+
+	if p.logger == nil {
+		fmt.Printf("Shipped %d of %d parts in %.1fs.\n", summary.Succeeded, len(summary.Parts), summary.DurationSeconds)
+	}
+	if summary.Failed > 0 {
+		err := fmt.Errorf("%d of %d parts failed to ship", summary.Failed, len(summary.Parts))
+		if written {
+			err = fmt.Errorf("%w; retry them with -retry-failed %s", err, path)
+		}
+		return reportError(p.logger, withExitCode(exitPartsFailed, err))
+	}
+	return nil
+}
+
+// summary collects the results of the shipped parts. splitErr is the error
+// the split ended with, if any.
+func (p *pipeline) summary(splitErr error) PipelineSummary {
+	summary := PipelineSummary{
+		RunID:     p.runID,
+		Status:    "succeeded",
+		Parts:     p.results,
+		StartedAt: p.startedAt,
+	}
+	if summary.Parts == nil {
+		summary.Parts = []PipelinePart{}
+	}
+	for _, part := range summary.Parts {
+		if part.Status == "succeeded" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	switch {
+	case summary.Failed > 0 && summary.Succeeded > 0:
+		summary.Status = "partial"
+	case summary.Failed > 0:
+		summary.Status = "failed"
+	}
+	if splitErr != nil {
+		summary.Status = "failed"
+		summary.Error = splitErr.Error()
+	}
+	summary.FinishedAt = time.Now()
+	summary.DurationSeconds = summary.FinishedAt.Sub(summary.StartedAt).Seconds()
+	return summary
+}
+
+// writeSummary writes summary to path as indented JSON
+func writeSummary(path string, summary PipelineSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write summary '%s': %w", path, err)
+	}
+	return nil
+}
+
+// gzipFile compresses src into dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(src)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to compress '%s': %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		loadSQL string
+		object  string
+		want    string
+	}{
+		{"bare", "select load_part({object}, {name}, {records})", "s3://b/in/part_1.csv.gz", "select load_part('s3://b/in/part_1.csv.gz', 'part_1.csv', 12)"},
+		{"quoted", "select load_part('{object}', '{name}')", "s3://b/in/part_1.csv.gz", "select load_part('s3://b/in/part_1.csv.gz', 'part_1.csv')"},
+		{"quote in object", "select load_part('{object}')", "s3://b/x'); drop table orders; --/part_1.csv.gz", "select load_part('s3://b/x''); drop table orders; --/part_1.csv.gz')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loadStatement(tt.loadSQL, tt.object, "part_1.csv", 12); got != tt.want {
+				t.Errorf("loadStatement(%q) = %q, want %q", tt.loadSQL, got, tt.want)
+			}
+		})
+	}
+}
+
+// flakyUploader fails the first failures uploads, and records the names of
+// the parts it uploaded
+type flakyUploader struct {
+	mu       sync.Mutex
+	failures int
+	uploaded []string
+}
+
+func (f *flakyUploader) Upload(ctx context.Context, path, name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("connection reset")
+	}
+	f.uploaded = append(f.uploaded, name)
+	return "mem://" + name, nil
+}
+
+func TestPipelineRetry(t *testing.T) {
+	part := filepath.Join(t.TempDir(), "output_1.csv")
+	if err := os.WriteFile(part, []byte("id\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"first attempt", 2, 0, "succeeded", 1},
+		{"after a failure", 2, 1, "succeeded", 2},
+		{"retries exhausted", 0, 1, "failed", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &pipeline{compress: "none", retries: tt.retries, uploader: &flakyUploader{failures: tt.failures}}
+			result := p.ship(context.Background(), PartInfo{Path: part, Records: 1})
+			if result.Status != tt.wantStatus || result.Attempts != tt.wantAttempts {
+				t.Errorf("ship = %s after %d attempts, want %s after %d", result.Status, result.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if (result.Error != "") != (tt.wantStatus == "failed") {
+				t.Errorf("ship error = %q, want one only when failed", result.Error)
+			}
+		})
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	dir := t.TempDir()
+	shipped := filepath.Join(dir, "output_1.csv")
+	failed := filepath.Join(dir, "output_2.csv")
+	if err := os.WriteFile(failed, []byte("id\n2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := PipelineSummary{Status: "partial", Parts: []PipelinePart{
+		{Part: shipped, Records: 1, Object: "mem://output_1.csv", Status: "succeeded"},
+		{Part: failed, Records: 1, Status: "failed", Error: "connection reset"},
+		{Part: filepath.Join(dir, "output_3.csv"), Records: 1, Status: "failed", Error: "connection reset"},
+	}}
+	data, err := json.Marshal(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaryPath := filepath.Join(dir, "output_pipeline.json")
+	if err := os.WriteFile(summaryPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := &flakyUploader{}
+	p := &pipeline{compress: "none", uploader: uploader}
+	if err := p.retryFailed(context.Background(), summaryPath, 2); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"output_2.csv"}; !slices.Equal(uploader.uploaded, want) {
+		t.Errorf("uploaded %q, want only %q", uploader.uploaded, want)
+	}
+	summary := p.summary(nil)
+	if summary.Succeeded != 2 || summary.Failed != 1 {
+		t.Errorf("summary = %d succeeded, %d failed, want 2 and 1", summary.Succeeded, summary.Failed)
+	}
+	if got := summary.Parts[2]; got.Status != "failed" || !strings.Contains(got.Error, "no longer available") {
+		t.Errorf("missing part = %s: %q, want it failed as no longer available", got.Status, got.Error)
+	}
+
+	if err := p.retryFailed(context.Background(), filepath.Join(dir, "missing.json"), 1); exitCode(err) != exitConfig {
+		t.Errorf("retryFailed of a missing summary = %v, want a config error", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/text/transform"
+)
+
+// planSampleRecords is the number of records read to estimate the average
+// record size in -estimate mode
+const planSampleRecords = 10000
+
+// runPlan implements the plan subcommand, which works out the -limit value
+// that splits the input into a target number of parts
+func runPlan(args []string) error {
+	config := Config{}
+	var targetParts int
+	var estimate, apply bool
+
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	fs.IntVar(&targetParts, "target-parts", 0, "Desired number of output parts (required)")
+	fs.BoolVar(&estimate, "estimate", false, "Estimate the record count from a sample instead of counting every record")
+	fs.BoolVar(&apply, "apply", false, "Split the input with the computed limit")
+	registerFlags(fs, &config)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s plan -target-parts N [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Compute the -limit that splits the input into about N parts.\n")
+		fmt.Fprintf(os.Stderr, "With -apply, the split is run immediately using the other options.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s plan -i data.csv -target-parts 64\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s plan -i data.csv -target-parts 64 -apply -o chunk -dir ./out\n", os.Args[0])
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	startRun(&config)
+
+	if config.InputPath == "" {
+		return configErrorf("input file path is required")
+	}
+	if targetParts <= 0 {
+		return configErrorf("target-parts must be greater than 0")
+	}
+
+	files, err := inputFiles(config)
+	if err != nil {
+		return err
+	}
+	// Several inputs are split as one, so their records are counted together
+	records, exact := 0, true
+	for _, file := range files {
+		fileConfig := config
+		fileConfig.InputPath, fileConfig.Inputs = file, nil
+		count, fileExact, err := NewCSVSplitter(fileConfig).countRecords(estimate)
+		if err != nil {
+			return err
+		}
+		records += count
+		exact = exact && fileExact
+	}
+
+	limit := max((records+targetParts-1)/targetParts, 1)
+	parts := max((records+limit-1)/limit, 1)
+
+	kind := "Records"
+	if !exact {
+		kind = "Estimated records"
+	}
+	fmt.Printf("%s: %d\n", kind, records)
+	fmt.Printf("Suggested -limit: %d (about %d parts)\n", limit, parts)
+
+	if !apply {
+		return nil
+	}
+	config.MaxRecords = limit
+	return runSplit(config, fs.Usage)
+}
+
+// countRecords counts the data records of the input. When estimate is set
+// and the input is larger than the sample, the count is extrapolated from the
+// average size of the sampled records and exact is false.
+func (s *CSVSplitter) countRecords(estimate bool) (count int, exact bool, err error) {
+	if err := s.loadInputFormat(); err != nil {
+		return 0, false, err
+	}
+	file, err := s.openInputFile()
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	input := bufio.NewReader(file)
+	sample, err := input.Peek(sniffSize)
+	complete := err == io.EOF
+	if decoder := s.inputDecoder(sample, complete); decoder != nil {
+		input = bufio.NewReader(transform.NewReader(input, decoder))
+		sample, err = input.Peek(sniffSize)
+		complete = err == io.EOF
+	}
+	s.resolveHeader(sample, complete)
+	reader := s.createReader(input)
+	reader.FieldsPerRecord = -1
+	if _, err := s.readHeader(reader); err != nil {
+		return 0, false, err
+	}
+	headerSize := reader.InputOffset()
+	if s.config.Header == "no" {
+		// What was read as the header is the first record
+		headerSize, count = 0, 1
+	}
+
+	for {
+		if estimate && count == planSampleRecords {
+			info, err := file.Stat()
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to stat input: %w", err)
+			}
+			perRecord := float64(reader.InputOffset()-headerSize) / float64(count)
+			return int(float64(info.Size()-headerSize) / perRecord), false, nil
+		}
+
+		_, err := reader.Read()
+		if err == io.EOF {
+			return count, true, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("error reading record at line %d: %w", count+2, err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// spilledRecord is a record of an output of lower priority, held in the
+// spill file of -priority with its input record number
+type spilledRecord struct {
+	Output string
+	Number int
+	Fields []string
+}
+
+// prioritySpill holds back the records of the outputs -priority does not
+// name, so that the outputs it names are completed first: their records are
+// written as they are read, and their parts finalized at the end of the
+// input, in the order given, before the records held back are written to
+// their outputs. The records held back go to a spill file in -temp-dir or
+// the output directory, which is removed once they are written.
+type prioritySpill struct {
+	names []string
+	dir   string
+	file  *os.File
+	buf   *bufio.Writer
+	enc   *gob.Encoder
+	count int
+}
+
+// priorityNames returns the outputs named by -priority, highest first
+func priorityNames(config Config) []string {
+	if config.Priority == "" {
+		return nil
+	}
+	names := strings.Split(config.Priority, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+// validatePriorityConfig checks that -priority names outputs of -route or of
+// a routed split, each once
+func validatePriorityConfig(config Config) error {
+	names := priorityNames(config)
+	if names == nil {
+		return nil
+	}
+	mode, routed := routedMode(config)
+	if !routed && len(config.Routes) == 0 {
+		return fmt.Errorf("priority needs the outputs of -route, -ratio, -hash-key, or -time-column")
+	}
+	if config.Encrypt != "" {
+		return fmt.Errorf("priority cannot be combined with encrypt, as the records held back would spill to disk in the clear")
+	}
+	outputs := outputNames(config)
+	for _, value := range config.Routes {
+		if rule, err := parseRoute(value); err == nil {
+			outputs = append(outputs, rule.name)
+		}
+	}
+	for i, name := range names {
+		if name == "" || slices.Contains(names[:i], name) {
+			return fmt.Errorf("invalid priority '%s': outputs must be named once each", config.Priority)
+		}
+		// The periods of -time-column are only known as they are read
+		if mode != "time-column" && !slices.Contains(outputs, name) {
+			return fmt.Errorf("priority names '%s', which is not an output of the split", name)
+		}
+	}
+	return nil
+}
+
+// newPrioritySpill returns the spill of -priority, or nil without it
+func (s *CSVSplitter) newPrioritySpill() *prioritySpill {
+	names := priorityNames(s.config)
+	if names == nil {
+		return nil
+	}
+	dir := s.config.TempDir
+	if dir == "" {
+		dir = s.config.OutputDir
+	}
+	if s.logger != nil {
+		s.logger.Info("prioritizing outputs", "priority", names)
+	} else if s.config.Verbose {
+		fmt.Printf("Writing %s first; records of other outputs are held back\n", strings.Join(names, ", "))
+	}
+	return &prioritySpill{names: names, dir: dir}
+}
+
+// priorities returns the outputs named by -priority, highest first
+func (p *prioritySpill) priorities() []string {
+	if p == nil {
+		return nil
+	}
+	return p.names
+}
+
+// deferred reports whether the records of the output called name are held
+// back until the outputs of higher priority are complete
+func (p *prioritySpill) deferred(name string) bool {
+	return p != nil && !slices.Contains(p.names, name)
+}
+
+// add holds record, the given input record, of the output called name
+func (p *prioritySpill) add(name string, number int, record []string) error {
+	if p.file == nil {
+		file, err := os.CreateTemp(p.dir, ".priority-*")
+		if err != nil {
+			return fmt.Errorf("failed to create priority spill file: %w", err)
+		}
+		p.file = file
+		p.buf = bufio.NewWriter(file)
+		p.enc = gob.NewEncoder(p.buf)
+	}
+	if err := p.enc.Encode(spilledRecord{name, number, record}); err != nil {
+		return fmt.Errorf("failed to write priority spill file: %w", err)
+	}
+	p.count++
+	return nil
+}
+
+// each calls fn with every record held back, in input order, and removes
+// the spill file
+func (p *prioritySpill) each(fn func(name string, number int, record []string) error) error {
+	if p == nil || p.file == nil {
+		return nil
+	}
+	defer p.Close()
+	if err := p.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write priority spill file: %w", err)
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(p.file))
+	for {
+		var r spilledRecord
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read priority spill file: %w", err)
+		}
+		if err := fn(r.Output, r.Number, r.Fields); err != nil {
+			return err
+		}
+	}
+}
+
+// Close removes the spill file, if any
+func (p *prioritySpill) Close() {
+	if p == nil || p.file == nil {
+		return
+	}
+	p.file.Close()
+	os.Remove(p.file.Name())
+	p.file = nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// quoteStyles are the values of -quote-style. minimal quotes only the fields
+// that need it, as encoding/csv does.
+var quoteStyles = []string{"minimal", "all", "non-numeric", "none"}
+
+// quotingWriter writes records as delimited text with a -quote-style that
+// encoding/csv does not offer
+type quotingWriter struct {
+	w         *bufio.Writer
+	delimiter rune
+	eol       string
+	style     string
+}
+
+func newQuotingWriter(w io.Writer, config Config) *quotingWriter {
+	eol := "\n"
+	if config.OutputLineEnding == "crlf" {
+		eol = "\r\n"
+	}
+	return &quotingWriter{
+		w:         bufio.NewWriter(w),
+		delimiter: outputDelimiter(config),
+		eol:       eol,
+		style:     config.QuoteStyle,
+	}
+}
+
+func (q *quotingWriter) WriteHeader(header []string) error {
+	return q.Write(header)
+}
+
+// Write writes record, quoting its fields by the style of q. With the none
+// style, a field holding the delimiter or a line break cannot be written and
+// fails the record, since the loaders that forbid quoting could not read it
+// back either.
+func (q *quotingWriter) Write(record []string) error {
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteRune(q.delimiter)
+		}
+		quote := false
+		switch q.style {
+		case "all":
+			quote = true
+		case "non-numeric":
+			quote = !isNumeric(field)
+		case "none":
+			if strings.ContainsRune(field, q.delimiter) || strings.ContainsAny(field, "\r\n") {
+				return fmt.Errorf("field %d holds the delimiter or a line break and cannot be written with -quote-style none", i+1)
+			}
+		}
+		if !quote {
+			q.w.WriteString(field)
+			continue
+		}
+		q.w.WriteByte('"')
+		q.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		q.w.WriteByte('"')
+	}
+	_, err := q.w.WriteString(q.eol)
+	return err
+}
+
+func (q *quotingWriter) Close() error {
+	return q.w.Flush()
+}
+
+// isNumeric reports whether field is a plain decimal number, such as -12,
+// 3.50, or 1e-3
+func isNumeric(field string) bool {
+	if field != "" && (field[0] == '+' || field[0] == '-') {
+		field = field[1:]
+	}
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(field), "e")
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return false
+	}
+	if hasExponent {
+		exponent = strings.TrimPrefix(strings.TrimPrefix(exponent, "+"), "-")
+		return exponent != "" && isDigits(exponent)
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseRatio parses a -ratio value such as 80:10:10 into its weights,
+// reduced to the smallest integers in the same proportions
+func parseRatio(value string) ([]int, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("ratio must have two or three parts, such as 80:20 or 80:10:10")
+	}
+	weights := make([]int, len(fields))
+	for i, field := range fields {
+		weight, err := strconv.Atoi(field)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid ratio '%s': parts must be positive integers", value)
+		}
+		weights[i] = weight
+	}
+	d := weights[0]
+	for _, weight := range weights[1:] {
+		for weight != 0 {
+			d, weight = weight, d%weight
+		}
+	}
+	for i := range weights {
+		weights[i] /= d
+	}
+	return weights, nil
+}
+
+// ratioNames returns the names of the outputs of a -ratio run
+func ratioNames(config Config) []string {
+	if config.RatioNames != "" {
+		return strings.Split(config.RatioNames, ",")
+	}
+	if strings.Count(config.Ratio, ":") == 1 {
+		return []string{"train", "test"}
+	}
+	return []string{"train", "validation", "test"}
+}
+
+// validateRatioConfig checks -ratio and the names of its outputs
+func validateRatioConfig(config Config) error {
+	weights, err := parseRatio(config.Ratio)
+	if err != nil {
+		return err
+	}
+	names := ratioNames(config)
+	if len(names) != len(weights) {
+		return fmt.Errorf("ratio-names must name each of the %d parts of -ratio", len(weights))
+	}
+	for i, name := range names {
+		if name == "" || strings.ContainsAny(name, `/\`) || slices.Contains(names[:i], name) {
+			return fmt.Errorf("invalid ratio-names '%s': names must be distinct and usable in file names", config.RatioNames)
+		}
+	}
+	return nil
+}
+
+// ratioRoute returns the route of a -ratio split, which deals records to the
+// outputs at random, and the seed of its random generator. Without -seed a
+// random one is used, and printed so that the split can be repeated.
+//
+// Each value of the -stratify-by column, or every record without it, is
+// dealt from a deck of its own holding each output as many times as its
+// weight, in random order. The proportions are thus met for every class
+// after every deck rather than only on average.
+func (s *CSVSplitter) ratioRoute(header []string) (func(record []string) int, uint64, error) {
+	weights, err := parseRatio(s.config.Ratio)
+	if err != nil {
+		return nil, 0, err
+	}
+	stratum := -1
+	if s.config.StratifyBy != "" {
+		if stratum = columnIndex(header, s.config.StratifyBy); stratum < 0 {
+			return nil, 0, configErrorf("stratify-by column %q not found in header", s.config.StratifyBy)
+		}
+	}
+
+	seed := s.config.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+		if s.logger == nil && !s.config.Verbose {
+			fmt.Fprintf(os.Stderr, "Split with -seed %d\n", seed)
+		}
+	}
+	names := ratioNames(s.config)
+	if s.logger != nil {
+		s.logger.Info("ratio split", "ratio", s.config.Ratio, "outputs", strings.Join(names, ","), "seed", seed)
+	} else if s.config.Verbose {
+		fmt.Printf("Ratio: %s into %s (seed %d)\n", s.config.Ratio, strings.Join(names, ", "), seed)
+	}
+
+	rng := rand.New(rand.NewPCG(seed, seed))
+	decks := map[string][]int{}
+	route := func(record []string) int {
+		var class string
+		if stratum >= 0 && stratum < len(record) {
+			class = record[stratum]
+		}
+		deck := decks[class]
+		if len(deck) == 0 {
+			for i, weight := range weights {
+				for range weight {
+					deck = append(deck, i)
+				}
+			}
+			rng.Shuffle(len(deck), func(i, j int) { deck[i], deck[j] = deck[j], deck[i] })
+		}
+		decks[class] = deck[:len(deck)-1]
+		return deck[len(deck)-1]
+	}
+	return route, seed, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rejectWriter records malformed input rows to a rejects file, creating the
+// file on the first rejected row
+type rejectWriter struct {
+	path      string
+	delimiter rune
+	file      *os.File
+	writer    *csv.Writer
+	count     int
+	// sourceColumn names the column of the input file of each row, if any
+	sourceColumn string
+
+	// appendOnly keeps the rows of a previous run when the file is opened
+	appendOnly bool
+}
+
+// newRejectWriter returns a reject writer targeting path
+func newRejectWriter(path string, delimiter rune) *rejectWriter {
+	return &rejectWriter{path: path, delimiter: delimiter}
+}
+
+// Write appends a rejected record with its input line number and the reason
+// it was rejected, and with -source-column the input file it came from
+func (r *rejectWriter) Write(line int, source string, record []string, reason error) error {
+	if r.writer == nil {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if r.appendOnly {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(r.path, flags, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create rejects file '%s': %w", r.path, err)
+		}
+		r.file = file
+		r.writer = csv.NewWriter(file)
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			header := []string{"line", "error", "record"}
+			if r.sourceColumn != "" {
+				header = append(header, r.sourceColumn)
+			}
+			if err := r.writer.Write(header); err != nil {
+				return fmt.Errorf("failed to write rejects file '%s': %w", r.path, err)
+			}
+		}
+	}
+
+	row := []string{strconv.Itoa(line), reason.Error(), r.joinRecord(record)}
+	if r.sourceColumn != "" {
+		row = append(row, source)
+	}
+	if err := r.writer.Write(row); err != nil {
+		return fmt.Errorf("failed to write rejects file '%s': %w", r.path, err)
+	}
+	r.count++
+	return nil
+}
+
+// joinRecord re-encodes record as a single CSV line in the input dialect
+func (r *rejectWriter) joinRecord(record []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = r.delimiter
+	w.Write(record)
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Close flushes and closes the rejects file if one was created
+func (r *rejectWriter) Close() error {
+	if r.writer == nil {
+		return nil
+	}
+	r.writer.Flush()
+	err := r.writer.Error()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.writer = nil
+	if err != nil {
+		return fmt.Errorf("failed to write rejects file '%s': %w", r.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RepairConfig holds the configuration for the repair subcommand
+type RepairConfig struct {
+	InputPath  string
+	OutputPath string
+	Delimiter  rune
+}
+
+// repairCounts counts the fixes made to an input
+type repairCounts struct {
+	nuls      int
+	quotes    int
+	padded    int
+	truncated int
+}
+
+func (c *repairCounts) String() string {
+	return fmt.Sprintf("%d NUL bytes stripped, %d quotes closed, %d records padded, %d records truncated",
+		c.nuls, c.quotes, c.padded, c.truncated)
+}
+
+// runRepair implements the repair subcommand, which rewrites a malformed CSV
+// file as a clean one
+func runRepair(args []string) error {
+	config := RepairConfig{}
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	fs.StringVar(&config.InputPath, "input", "", "Path to the input CSV file (or pass it as an argument)")
+	fs.StringVar(&config.InputPath, "i", "", "Path to the input CSV file (shorthand)")
+	fs.StringVar(&config.OutputPath, "output", "", "Write the repaired CSV to this file instead of stdout")
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character (default: detected)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s repair [options] [file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Rewrite a malformed CSV file as a clean one: strip NUL bytes, close unbalanced\n")
+		fmt.Fprintf(os.Stderr, "quotes, and pad or truncate records to the width of the header.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if config.InputPath == "" && fs.NArg() > 0 {
+		config.InputPath = fs.Arg(0)
+	}
+	if config.InputPath == "" {
+		return configErrorf("input file path is required")
+	}
+
+	file, err := openInput(config.InputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := os.Stdout
+	if config.OutputPath != "" {
+		outFile, err := os.Create(config.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create repaired file '%s': %w", config.OutputPath, err)
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+
+	input := bufio.NewReader(file)
+	if config.Delimiter == 0 {
+		config.Delimiter = sniffDelimiter(input)
+	}
+	counts := &repairCounts{}
+	reader := csv.NewReader(newRepairReader(input, config.Delimiter, true, counts))
+	reader.Comma = config.Delimiter
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	buf := bufio.NewWriter(out)
+	writer := csv.NewWriter(buf)
+	writer.Comma = config.Delimiter
+	if err := repairRecords(reader, writer, counts); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write repaired file: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write repaired file: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Repaired: %s\n", counts)
+	return nil
+}
+
+// repairRecords copies the header and records from reader to writer, fitting
+// every record to the width of the header
+func repairRecords(reader *csv.Reader, writer *csv.Writer, counts *repairCounts) error {
+	header, err := reader.Read()
+	if err == io.EOF {
+		return withExitCode(exitParse, fmt.Errorf("input file is empty"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for records := 0; ; records++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", records+2, err)
+		}
+		if err := writer.Write(counts.fit(record, len(header))); err != nil {
+			return err
+		}
+	}
+}
+
+// fit pads record with empty fields, or truncates it, to width fields
+func (c *repairCounts) fit(record []string, width int) []string {
+	switch {
+	case len(record) < width:
+		c.padded++
+		padded := make([]string, width)
+		copy(padded, record)
+		return padded
+	case len(record) > width:
+		c.truncated++
+		return record[:width]
+	}
+	return record
+}
+
+// repairReader strips the NUL bytes of an input and closes the quoted fields
+// left open at the end of a line. A quoted field may span lines, so one still
+// open at the end of a line is only closed there when the next line reads as
+// a record of its own, with as many fields as the header, or when the input
+// ends.
+type repairReader struct {
+	r         *bufio.Reader
+	delimiter byte
+	// trim lets whitespace come before the opening quote of a field, as
+	// csv.Reader.TrimLeadingSpace does
+	trim   bool
+	counts *repairCounts
+	// width is the number of fields of the header, once it is read
+	width  int
+	fields int
+	// quoted is set while the current record is within a quoted field
+	quoted bool
+	// line is the next line to return, and ahead the one after it
+	line  []byte
+	ahead []byte
+	out   []byte
+	eof   bool
+}
+
+func newRepairReader(r io.Reader, delimiter rune, trim bool, counts *repairCounts) *repairReader {
+	return &repairReader{r: bufio.NewReader(r), delimiter: byte(delimiter), trim: trim, counts: counts}
+}
+
+func (r *repairReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.line == nil {
+			line, err := r.readLine()
+			if err != nil {
+				return 0, err
+			}
+			r.line = line
+		}
+		if err := r.repairLine(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// readLine returns the next line of the input without its NUL bytes, the
+// line read ahead if there is one
+func (r *repairReader) readLine() ([]byte, error) {
+	if r.ahead != nil {
+		line := r.ahead
+		r.ahead = nil
+		return line, nil
+	}
+	if r.eof {
+		return nil, io.EOF
+	}
+	line, err := r.r.ReadBytes('\n')
+	if err == io.EOF {
+		r.eof = true
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if n := bytes.Count(line, []byte{0}); n > 0 {
+		r.counts.nuls += n
+		line = bytes.ReplaceAll(line, []byte{0}, nil)
+	}
+	return line, nil
+}
+
+// repairLine moves r.line to the output, closing its open quoted field if
+// the line after it shows that the field was never meant to span lines
+func (r *repairReader) repairLine() error {
+	line := r.line
+	r.line = nil
+	if !r.quoted && len(bytes.TrimRight(line, "\r\n")) == 0 {
+		// Blank lines are skipped by the reader
+		r.out = line
+		return nil
+	}
+	r.quoted, r.fields = r.scan(line, r.quoted, r.fields)
+	if !r.quoted {
+		if r.width == 0 {
+			r.width = r.fields
+		}
+		r.fields = 0
+		r.out = line
+		return nil
+	}
+
+	next, err := r.readLine()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	r.ahead = next
+	if next != nil {
+		quoted, fields := r.scan(next, false, 0)
+		if quoted || fields != r.width || r.width == 0 {
+			r.out = line
+			return nil
+		}
+	}
+
+	// Close the field before the line ending
+	body := bytes.TrimRight(line, "\r\n")
+	r.out = slices.Concat(body, []byte{'"'}, line[len(body):])
+	r.counts.quotes++
+	r.quoted, r.fields = false, 0
+	return nil
+}
+
+// scan follows line through the quoting rules of a lenient csv.Reader,
+// starting within a quoted field if quoted is set and after the given number
+// of fields. It returns whether the line ends within a quoted field, and the
+// number of fields so far, counting the one the line ends in.
+func (r *repairReader) scan(line []byte, quoted bool, fields int) (bool, int) {
+	body := bytes.TrimRight(line, "\r\n")
+	start := !quoted
+	if !quoted {
+		fields++
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quoted && c == '"':
+			if i+1 < len(body) && body[i+1] == '"' {
+				i++
+			} else if i+1 == len(body) || body[i+1] == r.delimiter {
+				quoted = false
+			}
+		case quoted:
+		case c == r.delimiter:
+			fields++
+			start = true
+		case start && c == '"':
+			quoted = true
+			start = false
+		case start && r.trim && strings.ContainsRune(" \t", rune(c)):
+		default:
+			start = false
+		}
+	}
+	return quoted, fields
+}
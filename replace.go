@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// replaceRule is a -replace rule, replacing the matches of pattern in the
+// values of column with replacement
+type replaceRule struct {
+	column      string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// parseReplace parses a -replace rule of the form column:/pattern/replacement/.
+// The column ends at the first colon, and the character after it delimits
+// the pattern and the replacement, as in sed, so that s:|a/b|c| works too. A
+// delimiter preceded by a backslash is taken literally.
+func parseReplace(value string) (replaceRule, error) {
+	invalid := fmt.Errorf("invalid replace %q: must be column:/pattern/replacement/", value)
+	column, rest, ok := strings.Cut(value, ":")
+	column = strings.TrimSpace(column)
+	if !ok || column == "" || rest == "" {
+		return replaceRule{}, invalid
+	}
+	delim := rest[:1]
+	parts := splitUnescaped(rest[1:], delim[0])
+	if len(parts) != 3 || parts[2] != "" {
+		return replaceRule{}, invalid
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return replaceRule{}, fmt.Errorf("invalid replace %q: %v", value, err)
+	}
+	return replaceRule{column, pattern, parts[1]}, nil
+}
+
+// splitUnescaped splits s at every delim not preceded by a backslash,
+// removing the backslash of escaped ones
+func splitUnescaped(s string, delim byte) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return append(parts, b.String())
+}
+
+// checkReplace checks the syntax of a -replace rule
+func checkReplace(value string) error {
+	_, err := parseReplace(value)
+	return err
+}
+
+// newReplaces returns the transforms of the -replace rules for the columns
+// of header
+func (s *CSVSplitter) newReplaces(header []string) ([]fieldTransform, error) {
+	var transforms []fieldTransform
+	for _, value := range s.config.Replaces {
+		rule, err := parseReplace(value)
+		if err != nil {
+			return nil, err
+		}
+		column := columnIndex(header, rule.column)
+		if column < 0 {
+			return nil, configErrorf("replace column %q not found in header", rule.column)
+		}
+		transforms = append(transforms, fieldTransform{column, func(value string) (string, error) {
+			return rule.pattern.ReplaceAllString(value, rule.replacement), nil
+		}})
+	}
+	return transforms, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxReportIssues caps the number of individual rows listed in a report
+const maxReportIssues = 1000
+
+// PartInfo describes a single output part. FirstRecord and LastRecord are
+// the input record numbers of its first and last records, and FirstKey and
+// LastKey their -key values.
+type PartInfo struct {
+	Path        string   `json:"path"`
+	Records     int      `json:"records"`
+	Bytes       int64    `json:"bytes"`
+	Checksum    string   `json:"checksum,omitempty"`
+	FirstRecord int      `json:"first_record,omitempty"`
+	LastRecord  int      `json:"last_record,omitempty"`
+	FirstKey    []string `json:"first_key,omitempty"`
+	LastKey     []string `json:"last_key,omitempty"`
+	// Kafka are the offsets the records of the part were produced to by
+	// -kafka-brokers
+	Kafka []KafkaOffsets `json:"kafka,omitempty"`
+}
+
+// RowIssue records a skipped or rejected input row
+type RowIssue struct {
+	Line     int       `json:"line"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+	Location *Location `json:"location,omitempty"`
+}
+
+// splitStats accumulates counters over a split run
+type splitStats struct {
+	startedAt time.Time
+	columns   int
+	records   int
+	written   int
+	skipped   map[string]int
+	rejected  map[string]int
+	issues    []RowIssue
+	// typeFailures counts the values failing -types by column
+	typeFailures map[string]int
+}
+
+// Report is the machine-readable summary written by -report
+type Report struct {
+	RunID  string `json:"run_id,omitempty"`
+	Seed   uint64 `json:"seed,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Input  struct {
+		Path     string   `json:"path,omitempty"`
+		Files    []string `json:"files,omitempty"`
+		Bytes    int64    `json:"bytes,omitempty"`
+		Encoding string   `json:"encoding,omitempty"`
+		Columns  int      `json:"columns"`
+		Records  int      `json:"records"`
+	} `json:"input"`
+	Output struct {
+		Dir     string     `json:"dir"`
+		Records int        `json:"records"`
+		Parts   []PartInfo `json:"parts"`
+	} `json:"output"`
+	Skipped         map[string]int `json:"skipped"`
+	Rejected        map[string]int `json:"rejected"`
+	TypeFailures    map[string]int `json:"type_failures,omitempty"`
+	WidthMismatches map[string]int `json:"width_mismatches,omitempty"`
+	RejectsFile     string         `json:"rejects_file,omitempty"`
+	LineageFile     string         `json:"lineage_file,omitempty"`
+	ErrorLocation   *Location      `json:"error_location,omitempty"`
+	Changes         *ChangeCounts  `json:"changes,omitempty"`
+	Issues          []RowIssue     `json:"issues"`
+	IssuesTruncated bool           `json:"issues_truncated,omitempty"`
+	StartedAt       time.Time      `json:"started_at"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+}
+
+// Summary is the machine-readable result of a run that -json prints on
+// stdout, on a single line
+type Summary struct {
+	RunID  string     `json:"run_id,omitempty"`
+	Status string     `json:"status"`
+	Dir    string     `json:"dir"`
+	Parts  []PartInfo `json:"parts"`
+	Totals struct {
+		Parts    int   `json:"parts"`
+		Records  int   `json:"records"`
+		Bytes    int64 `json:"bytes"`
+		Rejected int   `json:"rejected"`
+	} `json:"totals"`
+	RejectsFile     string         `json:"rejects_file,omitempty"`
+	InputEncoding   string         `json:"input_encoding,omitempty"`
+	WidthMismatches map[string]int `json:"width_mismatches,omitempty"`
+	DurationSeconds float64        `json:"duration_seconds"`
+}
+
+// skip counts a row dropped without being written anywhere
+func (s *CSVSplitter) skip(line int, reason string) {
+	if s.stats.skipped == nil {
+		s.stats.skipped = make(map[string]int)
+	}
+	s.stats.skipped[reason]++
+	s.metrics.recordsSkipped.Add(1)
+	s.addIssue(line, "skipped", reason, nil)
+	s.record(sessionEvent{Event: "skip", Line: line, Reason: reason})
+	if s.logger != nil {
+		s.logger.Debug("record skipped", "line", line, "reason", reason)
+	}
+}
+
+// reject writes a row to the rejects file and counts it under its reason
+func (s *CSVSplitter) reject(line int, record []string, reason error) error {
+	record = s.maskRejected(record, reason)
+	if err := s.rejects.Write(line, s.source, record, reason); err != nil {
+		return err
+	}
+	if s.stats.rejected == nil {
+		s.stats.rejected = make(map[string]int)
+	}
+	s.stats.rejected[reasonKey(reason)]++
+	s.metrics.recordsRejected.Add(1)
+	loc := errorLocation(reason)
+	s.addIssue(line, "rejected", reasonText(reason), loc)
+	s.record(sessionEvent{Event: "reject", Line: line, Reason: reasonKey(reason)})
+	if s.logger != nil {
+		if loc != nil {
+			s.logger.Debug("record rejected", "line", line, "reason", reasonText(reason), "location", loc)
+		} else {
+			s.logger.Debug("record rejected", "line", line, "reason", reason.Error())
+		}
+	}
+	return nil
+}
+
+func (s *CSVSplitter) addIssue(line int, action, reason string, loc *Location) {
+	if len(s.stats.issues) <= maxReportIssues {
+		s.stats.issues = append(s.stats.issues, RowIssue{Line: line, Action: action, Reason: reason, Location: loc})
+	}
+}
+
+// reasonText returns the message of err without its location, which reports
+// and logs give separately
+func reasonText(err error) string {
+	var located *locatedError
+	if errors.As(err, &located) {
+		return located.err.Error()
+	}
+	return err.Error()
+}
+
+// reasonKey groups errors into categories that do not depend on the
+// offending values, for use as report counters
+func reasonKey(err error) string {
+	var violation *schemaViolation
+	if errors.As(err, &violation) {
+		return "schema: " + violation.Column + ": " + violation.Rule
+	}
+	var typeErr *typeViolation
+	if errors.As(err, &typeErr) {
+		return "type: " + typeErr.Column + ": " + typeErr.Type
+	}
+	if errors.Is(err, csv.ErrFieldCount) {
+		return csv.ErrFieldCount.Error()
+	}
+	return reasonText(err)
+}
+
+// runStatus returns the status of a run that ended with runErr
+func runStatus(runErr error) string {
+	switch {
+	case runErr == nil:
+		return "succeeded"
+	case exitCode(runErr) == exitInterrupted:
+		return "interrupted"
+	case exitCode(runErr) == exitDeadline:
+		return "deadline"
+	}
+	return "failed"
+}
+
+// WriteReport writes a JSON report of the run to path. runErr is the error
+// the run ended with, if any.
+func (s *CSVSplitter) WriteReport(path string, runErr error) error {
+	report := Report{
+		RunID:    s.config.RunID,
+		Status:   "succeeded",
+		Skipped:  s.stats.skipped,
+		Rejected: s.stats.rejected,
+		Issues:   s.stats.issues,
+	}
+	report.TypeFailures = s.stats.typeFailures
+	if s.widths != nil {
+		report.WidthMismatches = s.widths.counts()
+	}
+	if runErr != nil {
+		report.Status = runStatus(runErr)
+		report.Error = runErr.Error()
+		report.ErrorLocation = errorLocation(runErr)
+	}
+
+	report.Input.Path = s.config.InputPath
+	files, _ := inputFiles(s.config)
+	if len(files) > 1 {
+		report.Input.Files = files
+	}
+	for _, file := range files {
+		if info, err := statInput(file); err == nil {
+			report.Input.Bytes += info.Size()
+		}
+	}
+	report.Input.Encoding = strings.Join(s.encodings, ", ")
+	report.Input.Columns = s.stats.columns
+	report.Input.Records = s.stats.records
+
+	report.Output.Dir = s.config.OutputDir
+	report.Output.Records = s.stats.written
+	report.Output.Parts = s.parts
+	if report.Output.Parts == nil {
+		report.Output.Parts = []PartInfo{}
+	}
+	if report.Skipped == nil {
+		report.Skipped = map[string]int{}
+	}
+	if report.Rejected == nil {
+		report.Rejected = map[string]int{}
+	}
+	if report.Issues == nil {
+		report.Issues = []RowIssue{}
+	}
+	if len(report.Issues) > maxReportIssues {
+		report.Issues = report.Issues[:maxReportIssues]
+		report.IssuesTruncated = true
+	}
+	if s.rejects != nil && s.rejects.count > 0 {
+		report.RejectsFile = s.rejects.path
+	}
+	if s.lineage != nil {
+		report.LineageFile = s.lineage.path
+	}
+	if s.routed != nil {
+		report.Seed = s.routed.seed
+	}
+	if s.shuffle != nil {
+		report.Seed = s.shuffle.seed
+	}
+
+	if s.delta != nil {
+		report.Changes = &s.delta.counts
+	}
+
+	report.StartedAt = s.stats.startedAt
+	report.FinishedAt = time.Now()
+	report.DurationSeconds = report.FinishedAt.Sub(report.StartedAt).Seconds()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report '%s': %w", path, err)
+	}
+	return nil
+}
+
+// printSummary writes the -json result of a completed run to w. A run that
+// rejected records is partial.
+func (s *CSVSplitter) printSummary(w io.Writer) error {
+	summary := Summary{RunID: s.config.RunID, Status: "succeeded", Dir: s.config.OutputDir, Parts: s.parts}
+	if summary.Parts == nil {
+		summary.Parts = []PartInfo{}
+	}
+	summary.InputEncoding = strings.Join(s.encodings, ", ")
+	if s.widths != nil {
+		summary.WidthMismatches = s.widths.counts()
+	}
+	summary.Totals.Parts = len(s.parts)
+	summary.Totals.Records = s.stats.written
+	for _, part := range s.parts {
+		summary.Totals.Bytes += part.Bytes
+	}
+	if s.rejects != nil && s.rejects.count > 0 {
+		summary.Status = "partial"
+		summary.Totals.Rejected = s.rejects.count
+		summary.RejectsFile = s.rejects.path
+	}
+	summary.DurationSeconds = time.Since(s.stats.startedAt).Seconds()
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write json result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// routedSplit writes each record to one of several outputs open at once,
+// such as the train and test sets of -ratio, the partitions of -hash-key, or
+// the periods of -time-column, instead of chunking records in input order.
+// Each output is a single part written by a splitter of its own.
+type routedSplit struct {
+	s       *CSVSplitter
+	header  []string
+	outputs []*CSVSplitter
+	// route returns the index of the output a record goes to
+	route func(record []string) int
+	// seed is the seed of the random generator of -ratio
+	seed uint64
+	// window names the output of each record of a -time-column split,
+	// whose outputs are started as their first record comes
+	window *timeWindow
+	byName map[string]*CSVSplitter
+	// spill holds the records of the outputs -priority puts last
+	spill *prioritySpill
+}
+
+// routedMode reports whether config routes records among outputs, and the
+// flag that selects it
+func routedMode(config Config) (string, bool) {
+	switch {
+	case config.Ratio != "":
+		return "ratio", true
+	case config.HashKey != "":
+		return "hash-key", true
+	case config.TimeColumn != "":
+		return "time-column", true
+	}
+	return "", false
+}
+
+// outputNames returns the names of the outputs of a routed split, which are
+// not known in advance for -time-column
+func outputNames(config Config) []string {
+	switch {
+	case config.Ratio != "":
+		return ratioNames(config)
+	case config.HashKey != "":
+		return partitionNames(config.Partitions)
+	}
+	return nil
+}
+
+// outputPath returns the path of the output of a routed split called name
+func outputPath(config Config, name string) string {
+	return filepath.Join(config.OutputDir, fmt.Sprintf("%s_%s.%s", config.OutputPrefix, name, partExtension(config)))
+}
+
+// validateRoutedConfig checks the options of a routed split, and the outputs
+// a previous run left behind as prepareOutputDir does for numbered parts
+func validateRoutedConfig(config Config) error {
+	mode, _ := routedMode(config)
+	modes := 0
+	for _, set := range []bool{config.Ratio != "", config.HashKey != "", config.TimeColumn != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("only one of ratio, hash-key, and time-column can be used")
+	}
+	var err error
+	switch mode {
+	case "ratio":
+		err = validateRatioConfig(config)
+	case "hash-key":
+		err = validatePartitionConfig(config)
+	default:
+		err = validateTimeConfig(config)
+	}
+	if err != nil {
+		return err
+	}
+
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.HardLimit != 0, "hard-limit"},
+		{config.GroupBy != "", "group-by"},
+		{config.BagIt, "bagit"},
+		{config.Incremental, "incremental"},
+		{config.AlignWith != "", "align-with"},
+		{config.Deadline != 0, "deadline"},
+		{config.Resume, "resume"},
+		{config.FilterMode, "filter-mode"},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("%s cannot be combined with %s, which writes one part per output", option.name, mode)
+		}
+	}
+
+	var existing []string
+	for _, name := range outputNames(config) {
+		path := outputPath(config, name)
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	if config.TimeColumn != "" {
+		if existing, err = staleTimeOutputs(config); err != nil {
+			return err
+		}
+	}
+	for _, path := range existing {
+		switch {
+		case config.Clean:
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove stale part: %w", err)
+			}
+		case !config.Force:
+			return fmt.Errorf("output file '%s' already exists; use -force to overwrite or -clean to remove previous parts", path)
+		}
+	}
+	return nil
+}
+
+// newRoutedSplit starts the outputs of a routed split, each with header
+func (s *CSVSplitter) newRoutedSplit(header []string) (*routedSplit, error) {
+	r := &routedSplit{s: s, header: header, byName: map[string]*CSVSplitter{}, spill: s.newPrioritySpill()}
+	var err error
+	switch {
+	case s.config.Ratio != "":
+		r.route, r.seed, err = s.ratioRoute(header)
+		s.record(sessionEvent{Event: "detect", Name: "seed", Value: fmt.Sprint(r.seed)})
+	case s.config.HashKey != "":
+		r.route, err = s.partitionRoute(header)
+	default:
+		r.window, err = s.newTimeWindow(header)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range outputNames(s.config) {
+		if _, err := r.open(name); err != nil {
+			r.discard()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// open starts the output called name. With -max-memory, the periods of a
+// -time-column split can take no more outputs than their write buffers fit.
+func (r *routedSplit) open(name string) (*CSVSplitter, error) {
+	s := r.s
+	if limit := newMemoryBudget(s.config).outputs; limit > 0 && len(r.outputs) == limit {
+		return nil, fmt.Errorf("max-memory of %s leaves room for the write buffers of %d outputs open at once, and %s would be one more",
+			formatByteSize(int64(s.config.MaxMemory)), limit, name)
+	}
+	config := s.config
+	config.MaxRecords = 0
+	output := &CSVSplitter{
+		config:     config,
+		partNumber: 1,
+		partName:   name,
+		logger:     s.logger,
+		metrics:    s.metrics,
+		limits:     s.limits,
+		keys:       s.keys,
+		lineage:    s.lineage,
+		partDone:   s.partDone,
+		notifier:   s.notifier,
+		fs:         s.fs,
+		fsName:     s.fsName,
+		recorder:   s.recorder,
+		recipients: s.recipients,
+		signer:     s.signer,
+		raw:        s.raw,
+		comments:   s.comments,
+	}
+	r.outputs = append(r.outputs, output)
+	r.byName[name] = output
+	if err := output.createNewFile(r.header); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// write writes record to the output it is routed to, or holds it back when
+// -priority puts the output last. In a -time-column split, that is the
+// output of the period window.parse last found.
+func (r *routedSplit) write(record []string) error {
+	var name string
+	if r.window != nil {
+		name = r.window.name
+	} else {
+		name = r.outputs[r.route(record)].partName
+	}
+	if r.spill.deferred(name) {
+		return r.spill.add(name, r.s.recordsRead, record)
+	}
+	return r.writeTo(name, r.s.recordsRead, record)
+}
+
+// writeTo writes record, the given input record, to the output called name,
+// starting it if needed
+func (r *routedSplit) writeTo(name string, number int, record []string) error {
+	output := r.byName[name]
+	if output == nil {
+		var err error
+		if output, err = r.open(name); err != nil {
+			return err
+		}
+	}
+	output.recordsRead = number
+	if err := output.writeRecord(nil, record); err != nil {
+		return err
+	}
+	r.s.stats.written++
+	return nil
+}
+
+// close completes the outputs, adding their parts to the parts of the run.
+// With -priority, the outputs it names are completed first, in its order,
+// and the records held back are then written to the others.
+func (r *routedSplit) close() error {
+	done := map[*CSVSplitter]bool{}
+	finish := func(output *CSVSplitter) error {
+		done[output] = true
+		if err := output.closeCurrentFile(); err != nil {
+			return err
+		}
+		r.s.parts = append(r.s.parts, output.parts...)
+		return nil
+	}
+	for _, name := range r.spill.priorities() {
+		if output := r.byName[name]; output != nil {
+			if err := finish(output); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.spill.each(r.writeTo); err != nil {
+		return err
+	}
+	for _, output := range r.outputs {
+		if !done[output] {
+			if err := finish(output); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// discard removes the outputs that have not been completed
+func (r *routedSplit) discard() {
+	r.spill.Close()
+	for _, output := range r.outputs {
+		output.discardCurrentFile()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newRunID returns a ULID for a run started at now: 26 characters that sort
+// by start time, with 80 random bits telling runs of the same millisecond
+// apart
+func newRunID(now time.Time) string {
+	var b [16]byte
+	ms := uint64(now.UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	rand.Read(b[6:])
+
+	// The 128 bits are encoded as a 130-bit number with two leading zeros
+	var id [26]byte
+	for i := range id {
+		v := 0
+		for p := i*5 - 2; p < i*5+3; p++ {
+			v <<= 1
+			if p >= 0 && b[p/8]&(0x80>>(p%8)) != 0 {
+				v |= 1
+			}
+		}
+		id[i] = crockford[v]
+	}
+	return string(id[:])
+}
+
+// startRun gives config a run ID, unless -run-id set one, and expands the
+// {run_id} and {time} placeholders in the names of the files the run
+// writes, so that every artifact of one invocation carries the same values
+func startRun(config *Config) {
+	now := time.Now().UTC()
+	if config.RunID == "" {
+		config.RunID = newRunID(now)
+	}
+	expand := strings.NewReplacer(
+		"{run_id}", config.RunID,
+		"{time}", now.Format(config.TimeFormat),
+	)
+	config.OutputPrefix = expand.Replace(config.OutputPrefix)
+	config.ReportPath = expand.Replace(config.ReportPath)
+	config.LineagePath = expand.Replace(config.LineagePath)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+)
+
+// SampleConfig holds the configuration for the sample subcommand
+type SampleConfig struct {
+	InputPath  string
+	OutputPath string
+	Size       int
+	Fraction   float64
+	Seed       uint64
+	Delimiter  rune
+}
+
+// sampledRecord is a record kept by the reservoir with its input position
+type sampledRecord struct {
+	index  int
+	record []string
+}
+
+// runSample implements the sample subcommand, which writes a random subset
+// of the records of a CSV file in a single pass
+func runSample(args []string) error {
+	config := SampleConfig{}
+	fs := flag.NewFlagSet("sample", flag.ExitOnError)
+	fs.StringVar(&config.InputPath, "input", "", "Path to the input CSV file (or pass it as an argument)")
+	fs.StringVar(&config.InputPath, "i", "", "Path to the input CSV file (shorthand)")
+	fs.StringVar(&config.OutputPath, "output", "", "Write the sample to this file instead of stdout")
+	fs.IntVar(&config.Size, "n", 0, "Number of records to sample")
+	fs.Float64Var(&config.Fraction, "fraction", 0, "Fraction of records to sample, between 0 and 1, instead of -n")
+	fs.Uint64Var(&config.Seed, "seed", 0, "Seed of the random generator, for a reproducible sample (default: random)")
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character (default: detected)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s sample -n N | -fraction F [options] [file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Write a random sample of the records of a CSV file, in input order.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if config.InputPath == "" && fs.NArg() > 0 {
+		config.InputPath = fs.Arg(0)
+	}
+	if config.InputPath == "" {
+		return configErrorf("input file path is required")
+	}
+	if (config.Size > 0) == (config.Fraction > 0) {
+		return configErrorf("exactly one of -n and -fraction must be given")
+	}
+	if config.Size < 0 || config.Fraction < 0 || config.Fraction > 1 {
+		return configErrorf("n must be positive and fraction between 0 and 1")
+	}
+	seeded := false
+	fs.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "seed" })
+	if !seeded {
+		config.Seed = rand.Uint64()
+	}
+
+	file, err := openInput(config.InputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := os.Stdout
+	if config.OutputPath != "" {
+		outFile, err := os.Create(config.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create sample file '%s': %w", config.OutputPath, err)
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+	buf := bufio.NewWriter(out)
+
+	reader, delimiter := newInspectReader(file, config.Delimiter)
+	writer := csv.NewWriter(buf)
+	writer.Comma = delimiter
+	if err := sampleRecords(reader, writer, config); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write sample: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write sample: %w", err)
+	}
+	if !seeded {
+		fmt.Fprintf(os.Stderr, "Sampled with -seed %d\n", config.Seed)
+	}
+	return nil
+}
+
+// sampleRecords copies the header and a sample of the records from reader to
+// writer. With a fraction, each record is kept with that probability and
+// written at once; with a size, a reservoir holds a uniform sample of the
+// records read so far, written in input order at the end.
+func sampleRecords(reader *csv.Reader, writer *csv.Writer, config SampleConfig) error {
+	header, err := reader.Read()
+	if err == io.EOF {
+		return withExitCode(exitParse, fmt.Errorf("input file is empty"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewPCG(config.Seed, config.Seed))
+	var reservoir []sampledRecord
+	for records := 0; ; records++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", records+2, err)
+		}
+
+		switch {
+		case config.Fraction > 0:
+			if rng.Float64() < config.Fraction {
+				if err := writer.Write(record); err != nil {
+					return err
+				}
+			}
+		case len(reservoir) < config.Size:
+			reservoir = append(reservoir, sampledRecord{records, record})
+		default:
+			if i := rng.IntN(records + 1); i < config.Size {
+				reservoir[i] = sampledRecord{records, record}
+			}
+		}
+	}
+
+	slices.SortFunc(reservoir, func(a, b sampledRecord) int { return a.index - b.index })
+	for _, sampled := range reservoir {
+		if err := writer.Write(sampled.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
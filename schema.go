@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Schema describes the expected columns of the input
+type Schema struct {
+	Columns []SchemaColumn `json:"columns"`
+}
+
+// SchemaColumn describes the constraints on a single column. Type is one of
+// string, int, float, bool, date, or datetime; Format overrides the Go time
+// layout used for date and datetime columns.
+type SchemaColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	Nullable *bool  `json:"nullable,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Format   string `json:"format,omitempty"`
+}
+
+// schemaViolation is the error returned for a value breaking a schema rule
+type schemaViolation struct {
+	Column string
+	Rule   string
+	Value  string
+}
+
+func (v *schemaViolation) Error() string {
+	switch v.Rule {
+	case "null":
+		return fmt.Sprintf("column %q: value is required", v.Column)
+	case "pattern":
+		return fmt.Sprintf("column %q: %q does not match pattern", v.Column, v.Value)
+	default:
+		return fmt.Sprintf("column %q: %q is not a valid %s", v.Column, v.Value, v.Rule)
+	}
+}
+
+// columnCheck is a schema column bound to its position in the header
+type columnCheck struct {
+	index    int
+	column   SchemaColumn
+	nullable bool
+	pattern  *regexp.Regexp
+	parse    func(string) error
+}
+
+// PartValidation summarizes the validation outcome of one output part
+type PartValidation struct {
+	File       string         `json:"file"`
+	Rows       int            `json:"rows"`
+	Rejected   int            `json:"rejected"`
+	Violations map[string]int `json:"violations,omitempty"`
+}
+
+// schemaValidator checks records against a schema and tallies the results
+// per output part
+type schemaValidator struct {
+	checks []columnCheck
+	parts  []PartValidation
+}
+
+// loadSchema reads and parses a JSON schema file
+func loadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema '%s': %w", path, err)
+	}
+
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema '%s': %w", path, err)
+	}
+	if len(schema.Columns) == 0 {
+		return nil, fmt.Errorf("schema '%s' defines no columns", path)
+	}
+	return &schema, nil
+}
+
+// newSchemaValidator binds schema to the columns of header
+func newSchemaValidator(schema *Schema, header []string) (*schemaValidator, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+
+	v := &schemaValidator{}
+	for _, column := range schema.Columns {
+		index, ok := positions[column.Name]
+		if !ok {
+			if column.Required {
+				return nil, fmt.Errorf("required column %q is missing from the header", column.Name)
+			}
+			continue
+		}
+
+		check := columnCheck{
+			index:    index,
+			column:   column,
+			nullable: column.Nullable == nil || *column.Nullable,
+		}
+		if column.Pattern != "" {
+			re, err := regexp.Compile(column.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for column %q: %w", column.Name, err)
+			}
+			check.pattern = re
+		}
+		parse, err := typeParser(column)
+		if err != nil {
+			return nil, err
+		}
+		check.parse = parse
+
+		v.checks = append(v.checks, check)
+	}
+	return v, nil
+}
+
+// typeParser returns a function validating values of the column's type
+func typeParser(column SchemaColumn) (func(string) error, error) {
+	switch column.Type {
+	case "", "string":
+		return nil, nil
+	case "int", "integer":
+		return func(s string) error {
+			_, err := strconv.ParseInt(s, 10, 64)
+			return err
+		}, nil
+	case "float", "number", "decimal":
+		return func(s string) error {
+			_, err := strconv.ParseFloat(s, 64)
+			return err
+		}, nil
+	case "bool", "boolean":
+		return func(s string) error {
+			_, err := strconv.ParseBool(s)
+			return err
+		}, nil
+	case "date", "datetime", "timestamp":
+		layout := column.Format
+		if layout == "" && column.Type == "date" {
+			layout = time.DateOnly
+		} else if layout == "" {
+			layout = time.RFC3339
+		}
+		return func(s string) error {
+			_, err := time.Parse(layout, s)
+			return err
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %q for column %q", column.Type, column.Name)
+}
+
+// Validate checks record against the schema and returns the first
+// violation found
+func (v *schemaValidator) Validate(record []string) error {
+	for _, check := range v.checks {
+		value := ""
+		if check.index < len(record) {
+			value = record[check.index]
+		}
+
+		switch {
+		case value == "" && !check.nullable:
+			return &schemaViolation{Column: check.column.Name, Rule: "null"}
+		case value == "":
+			continue
+		case check.parse != nil && check.parse(value) != nil:
+			return &schemaViolation{Column: check.column.Name, Rule: check.column.Type, Value: value}
+		case check.pattern != nil && !check.pattern.MatchString(value):
+			return &schemaViolation{Column: check.column.Name, Rule: "pattern", Value: value}
+		}
+	}
+	return nil
+}
+
+// Tally attributes a validation outcome to the part file. Rejected rows are
+// counted against the part being written when they were encountered.
+func (v *schemaValidator) Tally(file string, err error) {
+	if len(v.parts) == 0 || v.parts[len(v.parts)-1].File != file {
+		v.parts = append(v.parts, PartValidation{File: file})
+	}
+	stats := &v.parts[len(v.parts)-1]
+
+	var violation *schemaViolation
+	if !errors.As(err, &violation) {
+		stats.Rows++
+		return
+	}
+	stats.Rejected++
+	if stats.Violations == nil {
+		stats.Violations = make(map[string]int)
+	}
+	stats.Violations[violation.Column+": "+violation.Rule]++
+}
+
+// WriteSummary writes the per-part validation summary as JSON to path
+func (v *schemaValidator) WriteSummary(path string) error {
+	summary := struct {
+		Rows     int              `json:"rows"`
+		Rejected int              `json:"rejected"`
+		Parts    []PartValidation `json:"parts"`
+	}{Parts: v.parts}
+	for _, part := range v.parts {
+		summary.Rows += part.Rows
+		summary.Rejected += part.Rejected
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write validation summary '%s': %w", path, err)
+	}
+	return nil
+}
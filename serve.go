@@ -0,0 +1,903 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus describes the lifecycle state of a split job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// JobRequest is the body accepted when submitting a split job
+type JobRequest struct {
+	Input   string            `json:"input"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Job tracks a single asynchronous split operation
+type Job struct {
+	ID         string    `json:"id"`
+	Status     JobStatus `json:"status"`
+	Input      string    `json:"input"`
+	Error      string    `json:"error,omitempty"`
+	Parts      []string  `json:"parts,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+
+	config    Config
+	dir       string
+	inputPath string
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// ServeConfig holds the configuration for the HTTP server
+type ServeConfig struct {
+	Addr       string
+	GRPCAddr   string
+	DataDir    string
+	Workers    int
+	QueueSize  int
+	InputRoot  string
+	AllowHosts []string
+	// MaxUpload caps the input posted to /split, uploaded as a job, or
+	// streamed over gRPC, and JobTTL is how long finished jobs are kept
+	MaxUpload    byteSize
+	JobTTL       time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// serveIdleTimeout is how long an idle keep-alive connection is kept open,
+// and serveHeaderTimeout how long a client has to send request headers
+const (
+	serveIdleTimeout   = 2 * time.Minute
+	serveHeaderTimeout = 10 * time.Second
+)
+
+// JobQueue runs submitted split jobs on a bounded pool of workers. Splits
+// answered within their request share a separate limit of the same size.
+type JobQueue struct {
+	dataDir string
+	queue   chan *Job
+	slots   chan struct{}
+	// inputRoot is the directory jobs may name inputs in, and allowHosts
+	// the hosts they may fetch inputs from; jobs may do neither without them
+	inputRoot  string
+	allowHosts []string
+	maxUpload  int64
+	jobTTL     time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// runServe implements the serve subcommand
+func runServe(args []string) error {
+	config := ServeConfig{}
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&config.Addr, "addr", ":8080", "Address to listen on")
+	fs.StringVar(&config.GRPCAddr, "grpc-addr", "", "Address to serve the gRPC streaming API of splitcsv.proto on (disabled if empty)")
+	fs.StringVar(&config.DataDir, "data-dir", "splitcsv-jobs", "Directory holding job inputs and results")
+	fs.IntVar(&config.Workers, "workers", 2, "Number of jobs processed concurrently")
+	fs.IntVar(&config.QueueSize, "queue-size", 100, "Maximum number of jobs waiting to run")
+	fs.StringVar(&config.InputRoot, "input-root", "", "Directory under which jobs may name local inputs (default: uploaded inputs only)")
+	fs.Var(&listValue{&config.AllowHosts, checkAllowHost}, "allow-host", "Host jobs may fetch http(s) inputs from, repeatable (default: none)")
+	config.MaxUpload = 4 << 30
+	fs.Var(&config.MaxUpload, "max-upload", "Largest input accepted by /split, an uploaded job, or a gRPC stream, such as 512MB (0: no limit)")
+	fs.DurationVar(&config.JobTTL, "job-ttl", 24*time.Hour, "How long finished and canceled jobs and their files are kept before they are removed (0: until deleted)")
+	fs.DurationVar(&config.ReadTimeout, "read-timeout", 30*time.Minute, "Longest time to read a request, including its body")
+	fs.DurationVar(&config.WriteTimeout, "write-timeout", 30*time.Minute, "Longest time to answer a request once it is read, including the split of /split")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s serve [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Run an HTTP API that splits posted CSV files, returning the parts as an archive\n")
+		fmt.Fprintf(os.Stderr, "or processing them asynchronously as jobs.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if config.Workers <= 0 {
+		return configErrorf("workers must be greater than 0")
+	}
+	if config.QueueSize <= 0 {
+		return configErrorf("queue size must be greater than 0")
+	}
+	if config.JobTTL < 0 || config.ReadTimeout < 0 || config.WriteTimeout < 0 {
+		return configErrorf("job-ttl, read-timeout, and write-timeout must not be negative")
+	}
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if config.InputRoot != "" {
+		root, err := filepath.Abs(config.InputRoot)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return configErrorf("invalid input-root: %v", err)
+		}
+		config.InputRoot = root
+	}
+
+	queue := NewJobQueue(config)
+	for i := 0; i < config.Workers; i++ {
+		go queue.work()
+	}
+	if config.JobTTL > 0 {
+		go queue.reap(time.NewTicker(min(config.JobTTL, time.Hour)).C)
+	}
+
+	errs := make(chan error, 2)
+	if config.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", config.GRPCAddr, err)
+		}
+		fmt.Printf("Serving gRPC on %s\n", config.GRPCAddr)
+		go func() { errs <- newGRPCServer(queue).Serve(listener) }()
+	}
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           queue.Handler(),
+		ReadHeaderTimeout: serveHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	fmt.Printf("Listening on %s\n", config.Addr)
+	go func() { errs <- server.ListenAndServe() }()
+	return <-errs
+}
+
+// NewJobQueue creates a job queue storing job files under the data
+// directory, holding up to the queue size of waiting jobs and running up to
+// the number of workers of splits within requests
+func NewJobQueue(config ServeConfig) *JobQueue {
+	return &JobQueue{
+		dataDir:    config.DataDir,
+		queue:      make(chan *Job, config.QueueSize),
+		slots:      make(chan struct{}, config.Workers),
+		inputRoot:  config.InputRoot,
+		allowHosts: config.AllowHosts,
+		maxUpload:  int64(config.MaxUpload),
+		jobTTL:     config.JobTTL,
+		jobs:       make(map[string]*Job),
+	}
+}
+
+// Handler returns the HTTP handler exposing the job API
+func (q *JobQueue) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /split", q.handleSplit)
+	mux.HandleFunc("POST /jobs", q.handleSubmit)
+	mux.HandleFunc("GET /jobs/{id}", q.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/result", q.handleResult)
+	mux.HandleFunc("GET /jobs/{id}/archive", q.handleArchive)
+	mux.HandleFunc("GET /jobs/{id}/files/{name}", q.handleFile)
+	mux.HandleFunc("DELETE /jobs/{id}", q.handleCancel)
+	return mux
+}
+
+// jobOptions are the options a job or request may set: those that only
+// shape the parts written to its own directory. Options naming files or
+// directories of the server, or services it would connect to, are left out.
+var jobOptions = []string{
+	"out", "o", "time-format", "force", "clean", "limit", "l", "group-by", "hard-limit",
+	"ratio", "ratio-names", "stratify-by", "shuffle", "seed", "hash-key", "partitions", "route", "priority",
+	"time-column", "time-granularity", "time-layout", "max-memory", "max-throughput",
+	"workers", "skip-empty", "on-error", "start-row", "header", "set-header", "dedupe-headers",
+	"fail-on-duplicate-headers", "footer-rows", "detect-footer", "footer-action", "max-rows",
+	"strict", "strict-action", "on-width-mismatch", "output-format", "table", "sql-dialect",
+	"sql-batch", "sql-null", "trim-fields", "collapse-whitespace", "normalize-case",
+	"normalize-columns", "null-values", "null-output", "types", "replace", "reparse-date", "mask",
+	"add-row-number", "add-part-column", "add-part-row-column", "source-column", "checksums",
+	"deadline", "input-format", "input-encoding", "delimiter", "comment", "output-delimiter",
+	"lazy-quotes", "trim-leading-space", "repair", "pedantic", "keep-comments",
+	"output-line-ending", "quote-style", "passthrough",
+}
+
+// jobConfig builds the split configuration of a job or request from its
+// options, which are named like the command line flags
+func jobConfig(options map[string]string) (Config, error) {
+	config := Config{}
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerFlags(fs, &config)
+	for name, value := range options {
+		if !slices.Contains(jobOptions, name) {
+			return config, fmt.Errorf("option %q cannot be set on a job", name)
+		}
+		if (name == "out" || name == "o") && (strings.ContainsAny(value, `/\`) || strings.Contains(value, "..")) {
+			return config, fmt.Errorf("invalid option %q: the prefix must be usable in file names", name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return config, fmt.Errorf("invalid option %q: %w", name, err)
+		}
+	}
+	config.Verbose = false
+	startRun(&config)
+	return config, nil
+}
+
+// newJob creates a queued job with the given options
+func (q *JobQueue) newJob(input string, options map[string]string) (*Job, error) {
+	config, err := jobConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Job{
+		ID:        id,
+		Status:    JobQueued,
+		Input:     input,
+		CreatedAt: time.Now(),
+		config:    config,
+		dir:       filepath.Join(q.dataDir, id),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Submit validates a job request and enqueues it
+func (q *JobQueue) Submit(req JobRequest) (*Job, error) {
+	if req.Input == "" {
+		return nil, fmt.Errorf("input is required")
+	}
+	if _, err := q.checkInput(req.Input); err != nil {
+		return nil, err
+	}
+	job, err := q.newJob(req.Input, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return job, q.enqueue(job)
+}
+
+// SubmitUpload stores the CSV read from body as the input of a new job and
+// enqueues it
+func (q *JobQueue) SubmitUpload(body io.Reader, options map[string]string) (*Job, error) {
+	job, err := q.newJob("upload", options)
+	if err != nil {
+		return nil, err
+	}
+	job.inputPath = filepath.Join(job.dir, "input.csv")
+	if err := saveUpload(body, job.inputPath); err != nil {
+		job.cancel()
+		os.RemoveAll(job.dir)
+		return nil, err
+	}
+	if err := q.enqueue(job); err != nil {
+		os.RemoveAll(job.dir)
+		return nil, err
+	}
+	return job, nil
+}
+
+// enqueue adds job to the queue, failing if the queue is full
+func (q *JobQueue) enqueue(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- job:
+	default:
+		job.cancel()
+		return errQueueFull
+	}
+	q.jobs[job.ID] = job
+	return nil
+}
+
+var errQueueFull = errors.New("job queue is full")
+
+// Get returns a snapshot of the job with the given ID
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Cancel stops a queued or running job. Finished jobs are removed along with
+// their files.
+func (q *JobQueue) Cancel(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	switch job.Status {
+	case JobQueued:
+		job.Status = JobCanceled
+		job.FinishedAt = time.Now()
+		job.cancel()
+		// The job will never run, so nothing of it is left to fetch
+		os.RemoveAll(job.dir)
+	case JobRunning:
+		job.cancel()
+	default:
+		delete(q.jobs, id)
+		os.RemoveAll(job.dir)
+	}
+	return *job, true
+}
+
+// work processes jobs from the queue until it is closed
+func (q *JobQueue) work() {
+	for job := range q.queue {
+		q.mu.Lock()
+		if job.Status != JobQueued {
+			q.mu.Unlock()
+			continue
+		}
+		job.Status = JobRunning
+		job.StartedAt = time.Now()
+		q.mu.Unlock()
+
+		parts, err := q.run(job)
+		q.finish(job, parts, err)
+	}
+}
+
+// finish records the outcome of a job that was running
+func (q *JobQueue) finish(job *Job, parts []string, err error) {
+	q.mu.Lock()
+	job.FinishedAt = time.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = JobCanceled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+		job.Parts = parts
+	}
+	q.mu.Unlock()
+	job.cancel()
+	if job.Status != JobSucceeded {
+		// Only the parts of succeeded jobs can be fetched
+		os.RemoveAll(job.dir)
+	}
+}
+
+// reap removes the jobs that finished more than -job-ttl ago, along with
+// their files, on every tick. It first removes what an earlier run of the
+// server left in the data directory as old, since those jobs are gone.
+func (q *JobQueue) reap(ticks <-chan time.Time) {
+	cutoff := time.Now().Add(-q.jobTTL)
+	entries, _ := os.ReadDir(q.dataDir)
+	for _, entry := range entries {
+		// Anything written since the server started is newer
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.RemoveAll(filepath.Join(q.dataDir, entry.Name()))
+		}
+	}
+	for now := range ticks {
+		q.reapJobs(now)
+	}
+}
+
+// reapJobs removes the jobs that finished before now less -job-ttl, along
+// with their files
+func (q *JobQueue) reapJobs(now time.Time) {
+	cutoff := now.Add(-q.jobTTL)
+	q.mu.Lock()
+	var dirs []string
+	for id, job := range q.jobs {
+		switch job.Status {
+		case JobSucceeded, JobFailed, JobCanceled:
+			if job.FinishedAt.Before(cutoff) {
+				delete(q.jobs, id)
+				dirs = append(dirs, job.dir)
+			}
+		}
+	}
+	q.mu.Unlock()
+	for _, dir := range dirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// run fetches the job input and splits it into the job directory
+func (q *JobQueue) run(job *Job) ([]string, error) {
+	outDir := filepath.Join(job.dir, "parts")
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+
+	inputPath := job.inputPath
+	if inputPath == "" {
+		var err error
+		if inputPath, err = q.fetchInput(job.ctx, job.Input, job.dir); err != nil {
+			return nil, err
+		}
+	}
+
+	config := job.config
+	config.InputPath = inputPath
+	config.OutputDir = outDir
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	splitter := NewCSVSplitter(config)
+	if err := splitter.SplitContext(job.ctx); err != nil {
+		return nil, err
+	}
+
+	parts := make([]string, len(splitter.parts))
+	for i, part := range splitter.parts {
+		parts[i] = filepath.Base(part.Path)
+	}
+	return parts, nil
+}
+
+// checkInput checks that a job may read the input it names: a path or
+// file:// URI under -input-root, or an http(s) URL on a host of -allow-host.
+// It returns the path of a local input, or an empty path for a URL.
+func (q *JobQueue) checkInput(input string) (string, error) {
+	u, err := url.Parse(input)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Plain paths, including Windows drive letters
+		return q.localInput(input)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return q.localInput(u.Path)
+	case "http", "https":
+		return "", q.checkHost(u)
+	}
+	return "", fmt.Errorf("unsupported input scheme: %s", u.Scheme)
+}
+
+// localInput returns the path of a local input, which must be under
+// -input-root once relative paths are taken from it and links are followed.
+// The archive of a zip entry is the file checked.
+func (q *JobQueue) localInput(input string) (string, error) {
+	if q.inputRoot == "" {
+		return "", fmt.Errorf("local inputs are not accepted; upload the input, or start the server with -input-root")
+	}
+	path := input
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(q.inputRoot, path)
+	}
+	archive, entry, isZip := zipInputPath(path)
+	resolved, err := filepath.EvalSymlinks(archive)
+	if err != nil {
+		return "", withExitCode(exitInputNotFound, fmt.Errorf("input '%s' not found under input-root", input))
+	}
+	if rel, err := filepath.Rel(q.inputRoot, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("input '%s' is not under input-root", input)
+	}
+	if isZip && entry != "" {
+		return resolved + ":" + entry, nil
+	}
+	return resolved, nil
+}
+
+// checkAllowHost checks a host of -allow-host, given without a scheme or
+// port, as in data.example.com
+func checkAllowHost(value string) error {
+	if value == "" || strings.ContainsAny(value, "/:") {
+		return fmt.Errorf("invalid allow-host %q: must be a host name, without a scheme or port", value)
+	}
+	return nil
+}
+
+// checkHost checks that the host of an input URL is one of -allow-host
+func (q *JobQueue) checkHost(u *url.URL) error {
+	if !slices.Contains(q.allowHosts, u.Hostname()) {
+		return fmt.Errorf("input host '%s' is not allowed; the server fetches inputs only from the hosts of -allow-host", u.Hostname())
+	}
+	return nil
+}
+
+// fetchInput resolves the input of a job to a local file path. Local paths
+// and file:// URIs are used in place; http(s) URIs are downloaded into dir,
+// following redirects only to allowed hosts.
+func (q *JobQueue) fetchInput(ctx context.Context, input, dir string) (string, error) {
+	path, err := q.checkInput(input)
+	if err != nil || path != "" {
+		return path, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, input, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid input URI '%s': %w", input, err)
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return q.checkHost(req.URL)
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download input '%s': %w", input, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download input '%s': %s", input, resp.Status)
+	}
+
+	path = filepath.Join(dir, "input.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create input file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download input '%s': %w", input, err)
+	}
+	return path, nil
+}
+
+// limitUpload caps the body of r at -max-upload
+func (q *JobQueue) limitUpload(w http.ResponseWriter, r *http.Request) {
+	if q.maxUpload > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, q.maxUpload)
+	}
+}
+
+// uploadStatus returns the status answering a request whose upload failed
+// with err
+func uploadStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// saveUpload writes an uploaded input to path
+func saveUpload(body io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create input file '%s': %w", path, err)
+	}
+	_, err = io.Copy(file, body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read uploaded input: %w", err)
+	}
+	return nil
+}
+
+// newJobID returns a random hexadecimal job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (q *JobQueue) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	q.limitUpload(w, r)
+	var job *Job
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		// The body is the input itself, with options in the query string
+		job, err = q.SubmitUpload(r.Body, queryOptions(r.URL.Query()))
+	} else {
+		var req JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, uploadStatus(err), fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+		job, err = q.Submit(req)
+	}
+	if errors.Is(err, errQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, uploadStatus(err), err)
+		return
+	}
+
+	snapshot, _ := q.Get(job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (q *JobQueue) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (q *JobQueue) handleResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	if job.Status != JobSucceeded {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.Status))
+		return
+	}
+
+	files := make([]string, len(job.Parts))
+	for i, part := range job.Parts {
+		files[i] = "/jobs/" + job.ID + "/files/" + url.PathEscape(part)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":    job.ID,
+		"parts": job.Parts,
+		"files": files,
+	})
+}
+
+// handleSplit splits the CSV in the request body and responds with the parts
+// as a zip or tar archive
+func (q *JobQueue) handleSplit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := archiveFormat(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	config, err := jobConfig(queryOptions(query))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		defer func() { <-q.slots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	q.limitUpload(w, r)
+	dir, err := os.MkdirTemp(q.dataDir, "split-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create work directory: %w", err))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config.InputPath = filepath.Join(dir, "input.csv")
+	config.OutputDir = filepath.Join(dir, "parts")
+	if err := saveUpload(r.Body, config.InputPath); err != nil {
+		writeError(w, uploadStatus(err), err)
+		return
+	}
+	if err := validateConfig(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := NewCSVSplitter(config).SplitContext(r.Context()); err != nil {
+		status := http.StatusInternalServerError
+		if code := exitCode(err); code == exitParse || code == exitConfig {
+			status = http.StatusUnprocessableEntity
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	writeArchive(w, config.OutputDir, config.OutputPrefix, format)
+}
+
+func (q *JobQueue) handleArchive(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	if job.Status != JobSucceeded {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.Status))
+		return
+	}
+	format, err := archiveFormat(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeArchive(w, filepath.Join(job.dir, "parts"), job.config.OutputPrefix, format)
+}
+
+func (q *JobQueue) handleFile(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok || job.Status != JobSucceeded {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+
+	name := r.PathValue("name")
+	for _, part := range job.Parts {
+		if part == name && !strings.ContainsAny(name, `/\`) {
+			http.ServeFile(w, r, filepath.Join(job.dir, "parts", name))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("file not found"))
+}
+
+func (q *JobQueue) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Cancel(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// queryOptions returns the split options given in a query string, taking the
+// last value of repeated parameters. The archive format is not an option.
+func queryOptions(query url.Values) map[string]string {
+	options := make(map[string]string, len(query))
+	for name, values := range query {
+		if name != "format" && len(values) > 0 {
+			options[name] = values[len(values)-1]
+		}
+	}
+	return options
+}
+
+// archiveFormat returns the archive format requested by the format query
+// parameter: zip, the default, or tar
+func archiveFormat(query url.Values) (string, error) {
+	switch format := query.Get("format"); format {
+	case "", "zip":
+		return "zip", nil
+	case "tar":
+		return "tar", nil
+	default:
+		return "", fmt.Errorf("format must be zip or tar")
+	}
+}
+
+// writeArchive responds with every file in dir, the parts and any rejects
+// or validation files, as a zip or tar archive named after prefix
+func writeArchive(w http.ResponseWriter, dir, prefix, format string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to read parts: %w", err))
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	contentType := "application/zip"
+	if format == "tar" {
+		contentType = "application/x-tar"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": prefix + "." + format}))
+	w.WriteHeader(http.StatusOK)
+
+	// Once the response has started, errors can only cut the archive short
+	if format == "tar" {
+		tw := tar.NewWriter(w)
+		for _, name := range names {
+			if err := addTarFile(tw, filepath.Join(dir, name)); err != nil {
+				return
+			}
+		}
+		tw.Close()
+		return
+	}
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		if err := addZipFile(zw, filepath.Join(dir, name)); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
+
+// addZipFile compresses the file at path into zw under its base name
+func addZipFile(zw *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Deflate
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// addTarFile writes the file at path into tw under its base name
+func addTarFile(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{"split options", map[string]string{"limit": "10", "out": "chunk", "route": "country:^US$=>us"}, ""},
+		{"output format", map[string]string{"output-format": "sql", "table": "orders", "sql-null": "string"}, ""},
+		{"server file", map[string]string{"schema": "/etc/schema.json"}, `option "schema" cannot be set on a job`},
+		{"hmac key file", map[string]string{"hmac-key-file": "/run/secrets/key"}, `option "hmac-key-file" cannot be set on a job`},
+		{"output directory", map[string]string{"dir": "/tmp"}, `option "dir" cannot be set on a job`},
+		{"input", map[string]string{"i": "/etc/passwd"}, `option "i" cannot be set on a job`},
+		{"service", map[string]string{"pg-dsn": "postgres://db"}, `option "pg-dsn" cannot be set on a job`},
+		{"unknown option", map[string]string{"no-such-option": "1"}, `option "no-such-option" cannot be set on a job`},
+		{"prefix with parent", map[string]string{"out": "../escape"}, `invalid option "out"`},
+		{"prefix with dots", map[string]string{"o": "..chunk"}, `invalid option "o"`},
+		{"prefix with slash", map[string]string{"out": "sub/chunk"}, `invalid option "out"`},
+		{"prefix with backslash", map[string]string{"o": `sub\chunk`}, `invalid option "o"`},
+		{"invalid value", map[string]string{"limit": "many"}, `invalid option "limit"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jobConfig(tt.options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("jobConfig(%v) = %v, want no error", tt.options, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("jobConfig(%v) = %v, want error containing %q", tt.options, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckInput(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(root, "data", "in.csv")
+	secret := filepath.Join(outside, "secret.csv")
+	for _, path := range []string{inside, secret} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("id\n1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.csv")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewJobQueue(ServeConfig{InputRoot: root, AllowHosts: []string{"data.example.com"}})
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"relative path", "data/in.csv", inside, false},
+		{"absolute path", inside, inside, false},
+		{"file uri", "file://" + filepath.ToSlash(inside), inside, false},
+		{"zip entry", "data/in.zip:in.csv", "", true},
+		{"parent directory", "../" + filepath.Base(outside) + "/secret.csv", "", true},
+		{"absolute path outside", secret, "", true},
+		{"file uri outside", "file://" + filepath.ToSlash(secret), "", true},
+		{"symlink outside", "link.csv", "", true},
+		{"symlinked directory outside", "linkdir/secret.csv", "", true},
+		{"missing file", "data/missing.csv", "", true},
+		{"allowed host", "https://data.example.com/in.csv", "", false},
+		{"other host", "https://evil.example.com/in.csv", "", true},
+		{"metadata address", "http://169.254.169.254/latest/meta-data", "", true},
+		{"unsupported scheme", "ftp://data.example.com/in.csv", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.checkInput(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("checkInput(%q) = %q, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("checkInput(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("without input root", func(t *testing.T) {
+		q := NewJobQueue(ServeConfig{})
+		if _, err := q.checkInput(inside); err == nil {
+			t.Fatalf("checkInput(%q) without -input-root succeeded, want an error", inside)
+		}
+		if _, err := q.checkInput("https://data.example.com/in.csv"); err == nil {
+			t.Fatal("checkInput of a URL without -allow-host succeeded, want an error")
+		}
+	})
+}
+
+func TestUploadLimit(t *testing.T) {
+	q := NewJobQueue(ServeConfig{DataDir: t.TempDir(), Workers: 1, QueueSize: 1, MaxUpload: 16})
+	server := httptest.NewServer(q.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"split", "/split?limit=1", "id\n1\n2\n", http.StatusOK},
+		{"split too large", "/split?limit=1", "id\n1\n2\n3\n4\n5\n6\n7\n", http.StatusRequestEntityTooLarge},
+		{"job too large", "/jobs?limit=1", "id\n1\n2\n3\n4\n5\n6\n7\n", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+tt.path, "text/csv", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("POST %s = %s, want %d", tt.path, resp.Status, tt.want)
+			}
+		})
+	}
+	if entries, _ := os.ReadDir(q.dataDir); len(entries) != 0 {
+		t.Errorf("data directory holds %d entries after the uploads, want none", len(entries))
+	}
+}
+
+func TestReapJobs(t *testing.T) {
+	dataDir := t.TempDir()
+	q := NewJobQueue(ServeConfig{DataDir: dataDir, Workers: 1, QueueSize: 4, JobTTL: time.Hour})
+	now := time.Now()
+	jobs := []*Job{
+		{ID: "old", Status: JobSucceeded, FinishedAt: now.Add(-2 * time.Hour)},
+		{ID: "recent", Status: JobSucceeded, FinishedAt: now.Add(-time.Minute)},
+		{ID: "canceled", Status: JobCanceled, FinishedAt: now.Add(-2 * time.Hour)},
+		{ID: "running", Status: JobRunning, StartedAt: now.Add(-2 * time.Hour)},
+	}
+	for _, job := range jobs {
+		job.dir = filepath.Join(dataDir, job.ID)
+		if err := os.MkdirAll(filepath.Join(job.dir, "parts"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		q.jobs[job.ID] = job
+	}
+
+	q.reapJobs(now)
+	for _, job := range jobs {
+		_, kept := q.Get(job.ID)
+		_, err := os.Stat(job.dir)
+		want := job.ID == "recent" || job.ID == "running"
+		if kept != want || (err == nil) != want {
+			t.Errorf("job %s kept = %v, files kept = %v, want %v", job.ID, kept, err == nil, want)
+		}
+	}
+}
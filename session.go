@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionMagic starts a -record file. The rest of the file is gzipped JSON
+// lines: the session header, then one event per line.
+const sessionMagic = "SPLTREC1"
+
+// sessionSampleEvents is the number of rejected, skipped, or warned records
+// of each reason that are all recorded. Past it, only every
+// sessionSampleEvents-th one is, keeping the trace of a run with millions of
+// bad rows small.
+const sessionSampleEvents = 1000
+
+// sessionHeader describes a recorded run. Config holds the options only; no
+// value of the input is ever recorded.
+type sessionHeader struct {
+	Version   int       `json:"version"`
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	Config    Config    `json:"config"`
+}
+
+// sessionEvent is a decision taken during a run: what was detected about
+// the input ("detect"), where a part started ("part"), which records were
+// rejected, skipped, or warned about and why, and how the run ended ("end").
+// Count numbers the events of the same kind and reason, since they are
+// sampled.
+type sessionEvent struct {
+	Event  string `json:"e"`
+	Name   string `json:"name,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Part   int    `json:"part,omitempty"`
+	Record int    `json:"record,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Count  int    `json:"count,omitempty"`
+}
+
+func (e sessionEvent) String() string {
+	switch e.Event {
+	case "detect":
+		return fmt.Sprintf("detect %s=%s", e.Name, e.Value)
+	case "part":
+		name := strconv.Itoa(e.Part)
+		if e.Name != "" {
+			name = e.Name
+		}
+		return fmt.Sprintf("part %s starts at record %d", name, e.Record)
+	case "end":
+		status := "ok"
+		if e.Reason != "" {
+			status = e.Reason
+		}
+		return fmt.Sprintf("end after %d records, %d written: %s", e.Record, e.Count, status)
+	}
+	return fmt.Sprintf("%s line %d (#%d): %s", e.Event, e.Line, e.Count, e.Reason)
+}
+
+// sessionRecorder collects the events of a run for -record
+type sessionRecorder struct {
+	header sessionHeader
+	events []sessionEvent
+	counts map[string]int
+}
+
+// newSessionRecorder starts recording a run with config
+func newSessionRecorder(config Config) *sessionRecorder {
+	return &sessionRecorder{
+		header: sessionHeader{Version: 1, RunID: config.RunID, StartedAt: time.Now().UTC(), Config: config},
+		counts: map[string]int{},
+	}
+}
+
+// add records e, sampling the events about records by their reason
+func (r *sessionRecorder) add(e sessionEvent) {
+	if e.Line != 0 {
+		key := e.Event + "\x00" + e.Reason
+		r.counts[key]++
+		e.Count = r.counts[key]
+		if e.Count > sessionSampleEvents && e.Count%sessionSampleEvents != 0 {
+			return
+		}
+	}
+	r.events = append(r.events, e)
+}
+
+// record adds an event to the session being recorded, if any
+func (s *CSVSplitter) record(e sessionEvent) {
+	if s.recorder != nil {
+		s.recorder.add(e)
+	}
+}
+
+// recordHeader records the shape of the input header: its width and a hash
+// of the column names, which are not recorded themselves
+func (s *CSVSplitter) recordHeader(header []string) {
+	if s.recorder == nil {
+		return
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(header, "\x1f")))
+	s.record(sessionEvent{Event: "detect", Name: "columns", Value: strconv.Itoa(len(header))})
+	s.record(sessionEvent{Event: "detect", Name: "header_hash", Value: fmt.Sprintf("%016x", h.Sum64())})
+}
+
+// finishSession records the end of the run, which failed with err if it is
+// not nil
+func (s *CSVSplitter) finishSession(err error) {
+	end := sessionEvent{Event: "end", Record: s.stats.records, Count: s.stats.written}
+	if err != nil {
+		end.Reason = reasonText(err)
+	}
+	s.record(end)
+}
+
+// writeSession writes the recorded session to path
+func (r *sessionRecorder) writeSession(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create session file '%s': %w", path, err)
+	}
+	defer file.Close()
+	file.WriteString(sessionMagic)
+	zw := gzip.NewWriter(file)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(r.header); err != nil {
+		return err
+	}
+	for _, e := range r.events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write session file '%s': %w", path, err)
+	}
+	return file.Close()
+}
+
+// readSession reads a session written by -record
+func readSession(path string) (sessionHeader, []sessionEvent, error) {
+	var header sessionHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return header, nil, withExitCode(exitInputNotFound, fmt.Errorf("failed to open session file: %w", err))
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(sessionMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != sessionMagic {
+		return header, nil, withExitCode(exitParse, fmt.Errorf("'%s' is not a session recorded with -record", path))
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return header, nil, withExitCode(exitParse, fmt.Errorf("invalid session file '%s': %w", path, err))
+	}
+	dec := json.NewDecoder(zr)
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, withExitCode(exitParse, fmt.Errorf("invalid session file '%s': %w", path, err))
+	}
+	var events []sessionEvent
+	for {
+		var e sessionEvent
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header, nil, withExitCode(exitParse, fmt.Errorf("invalid session file '%s': %w", path, err))
+		}
+		events = append(events, e)
+	}
+	return header, events, nil
+}
+
+// runReplay implements the replay subcommand, which runs a recorded split
+// again against its input and reports where its decisions diverge from the
+// recorded ones
+func runReplay(args []string) error {
+	var inputPath string
+	var printOnly bool
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&inputPath, "input", "", "Replay against this input file instead of the recorded input path")
+	fs.StringVar(&inputPath, "i", "", "Replay against this input file (shorthand)")
+	fs.BoolVar(&printOnly, "print", false, "Print the recorded session instead of replaying it")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [options] session.bin\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Split the input of a session recorded with -record again, with the recorded\n")
+		fmt.Fprintf(os.Stderr, "options, and compare the decisions taken. Parts are written to a temporary\n")
+		fmt.Fprintf(os.Stderr, "directory and removed.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return configErrorf("a session file is required")
+	}
+
+	header, recorded, err := readSession(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if printOnly {
+		fmt.Printf("Run %s started %s\n", header.RunID, header.StartedAt.Format(time.RFC3339))
+		options, _ := json.MarshalIndent(header.Config, "", "  ")
+		fmt.Printf("Options: %s\n", options)
+		for _, e := range recorded {
+			fmt.Println(e)
+		}
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "splitcsv-replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Outputs go to the temporary directory, and nothing is written or
+	// served elsewhere
+	config := header.Config
+	if inputPath != "" {
+		config.InputPath = inputPath
+		config.Inputs = nil
+	}
+	config.OutputDir = dir
+	config.ReportPath = ""
+	config.LineagePath = ""
+	config.RecordPath = ""
+	config.MetricsAddr = ""
+	config.MarkDir = false
+	config.Verbose = false
+	config.LogFormat = "text"
+	config.Force = true
+	config.Clean = false
+	config.Resume = false
+	config.Deadline = 0
+	config.StateDir = ""
+	if (config.Ratio != "" || config.Shuffle) && config.Seed == 0 {
+		for _, e := range recorded {
+			if e.Event == "detect" && e.Name == "seed" {
+				config.Seed, _ = strconv.ParseUint(e.Value, 10, 64)
+			}
+		}
+	}
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	splitter := NewCSVSplitter(config)
+	splitter.recorder = newSessionRecorder(config)
+	splitter.record(sessionEvent{Event: "detect", Name: "fs_profile", Value: splitter.fsName})
+	splitter.finishSession(splitter.Split())
+	replayed := splitter.recorder.events
+
+	for i := 0; i < max(len(recorded), len(replayed)); i++ {
+		if i < len(recorded) && i < len(replayed) && recorded[i] == replayed[i] {
+			continue
+		}
+		fmt.Printf("Diverged at event %d of %d:\n", i+1, len(recorded))
+		if i < len(recorded) {
+			fmt.Printf("  recorded: %s\n", recorded[i])
+		} else {
+			fmt.Printf("  recorded: nothing\n")
+		}
+		if i < len(replayed) {
+			fmt.Printf("  replayed: %s\n", replayed[i])
+		} else {
+			fmt.Printf("  replayed: nothing\n")
+		}
+		return fmt.Errorf("replay diverged from the recorded session")
+	}
+	fmt.Printf("Replay matched all %d recorded events.\n", len(recorded))
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+)
+
+// shuffleMemory is how many bytes of records -shuffle holds in memory before
+// it spills them to bucket files
+const shuffleMemory = 64 << 20
+
+// shuffleBuckets is the number of bucket files of a -shuffle too large for
+// memory. Each bucket is read back into memory on its own, so a run needs
+// about 1/shuffleBuckets of its input in memory.
+const shuffleBuckets = 256
+
+// shuffledRecord is a record held by -shuffle, with its input record number
+type shuffledRecord struct {
+	Number int
+	Fields []string
+}
+
+// shuffleBucket is a bucket file of a spilled -shuffle
+type shuffleBucket struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *gob.Encoder
+}
+
+// recordShuffler collects the records of a -shuffle run and writes them out
+// in random order once all are read. Records are kept in memory until they
+// pass shuffleMemory, or half of -max-memory; they are then dealt at random
+// into bucket files in a temporary directory under the output directory, or
+// -temp-dir, each of which is shuffled in memory in turn. Both give every
+// order of the records the same chance.
+type recordShuffler struct {
+	dir     string
+	memory  int
+	rng     *rand.Rand
+	seed    uint64
+	records []shuffledRecord
+	size    int
+	buckets []*shuffleBucket
+}
+
+// validateShuffleConfig checks that -shuffle is not combined with options
+// that need records in input order
+func validateShuffleConfig(config Config) error {
+	if mode, routed := routedMode(config); routed {
+		return fmt.Errorf("shuffle cannot be combined with %s", mode)
+	}
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.HardLimit != 0, "hard-limit"},
+		{config.GroupBy != "", "group-by"},
+		{config.Incremental, "incremental"},
+		{config.AlignWith != "", "align-with"},
+		{config.Deadline != 0, "deadline"},
+		{config.Resume, "resume"},
+		{config.FilterMode, "filter-mode"},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("%s cannot be combined with shuffle, which writes the records out of input order", option.name)
+		}
+	}
+	return nil
+}
+
+// newShuffler starts the shuffle of a -shuffle run. Without -seed a random
+// seed is used, and printed so that the shuffle can be repeated.
+func (s *CSVSplitter) newShuffler() *recordShuffler {
+	seed := s.config.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+		if s.logger == nil && !s.config.Verbose {
+			fmt.Fprintf(os.Stderr, "Shuffled with -seed %d\n", seed)
+		}
+	}
+	if s.logger != nil {
+		s.logger.Info("shuffling records", "seed", seed)
+	} else if s.config.Verbose {
+		fmt.Printf("Shuffling records (seed %d)\n", seed)
+	}
+	s.record(sessionEvent{Event: "detect", Name: "seed", Value: fmt.Sprint(seed)})
+	dir := s.config.TempDir
+	if dir == "" {
+		dir = s.config.OutputDir
+	}
+	return &recordShuffler{
+		dir:    dir,
+		memory: newMemoryBudget(s.config).shuffle,
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+		seed:   seed,
+	}
+}
+
+// add holds record, the given input record, until the shuffle is written
+func (sh *recordShuffler) add(number int, record []string) error {
+	r := shuffledRecord{number, record}
+	if sh.buckets != nil {
+		return sh.spill(r)
+	}
+	sh.records = append(sh.records, r)
+	// Count the record and its slot in sh.records, which may be twice the
+	// size of the records as it grows
+	sh.size += 2*32 + 24
+	for _, field := range record {
+		sh.size += len(field) + 16
+	}
+	if sh.size <= sh.memory {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(sh.dir, ".shuffle-")
+	if err != nil {
+		return fmt.Errorf("failed to create shuffle directory: %w", err)
+	}
+	sh.dir = dir
+	sh.buckets = make([]*shuffleBucket, shuffleBuckets)
+	for i := range sh.buckets {
+		file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%03d", i)))
+		if err != nil {
+			return fmt.Errorf("failed to create shuffle bucket: %w", err)
+		}
+		buf := bufio.NewWriter(file)
+		sh.buckets[i] = &shuffleBucket{file, buf, gob.NewEncoder(buf)}
+	}
+	for _, r := range sh.records {
+		if err := sh.spill(r); err != nil {
+			return err
+		}
+	}
+	sh.records = nil
+	return nil
+}
+
+// spill writes r to a bucket file picked at random
+func (sh *recordShuffler) spill(r shuffledRecord) error {
+	if err := sh.buckets[sh.rng.IntN(len(sh.buckets))].enc.Encode(r); err != nil {
+		return fmt.Errorf("failed to write shuffle bucket: %w", err)
+	}
+	return nil
+}
+
+// shuffle shuffles records in place
+func (sh *recordShuffler) shuffle(records []shuffledRecord) {
+	sh.rng.Shuffle(len(records), func(i, j int) { records[i], records[j] = records[j], records[i] })
+}
+
+// each calls fn with every record held, in random order
+func (sh *recordShuffler) each(fn func(number int, record []string) error) error {
+	if sh.buckets == nil {
+		sh.shuffle(sh.records)
+		for _, r := range sh.records {
+			if err := fn(r.Number, r.Fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, bucket := range sh.buckets {
+		if err := bucket.buf.Flush(); err != nil {
+			return fmt.Errorf("failed to write shuffle bucket: %w", err)
+		}
+		if _, err := bucket.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var records []shuffledRecord
+		dec := gob.NewDecoder(bufio.NewReader(bucket.file))
+		for {
+			var r shuffledRecord
+			err := dec.Decode(&r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read shuffle bucket: %w", err)
+			}
+			records = append(records, r)
+		}
+		sh.shuffle(records)
+		for _, r := range records {
+			if err := fn(r.Number, r.Fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close removes the bucket files, if any
+func (sh *recordShuffler) Close() {
+	if sh.buckets == nil {
+		return
+	}
+	for _, bucket := range sh.buckets {
+		bucket.file.Close()
+	}
+	os.RemoveAll(sh.dir)
+	sh.buckets = nil
+}
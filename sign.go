@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"aead.dev/minisign"
+)
+
+// signSuffix is appended to the name of a file for its detached signature
+const signSuffix = ".sig"
+
+// minisignPasswordEnv holds the password of an encrypted minisign key
+const minisignPasswordEnv = "SPLITCSV_MINISIGN_PASSWORD"
+
+// validateSignConfig checks -sign, which is gpg:KEYID or minisign:KEYFILE
+func validateSignConfig(config Config) error {
+	if config.Sign == "" {
+		return nil
+	}
+	method, key, _ := strings.Cut(config.Sign, ":")
+	if (method != "gpg" && method != "minisign") || key == "" {
+		return fmt.Errorf("sign must be gpg:KEYID or minisign:KEYFILE")
+	}
+	if config.FilterMode || config.PgOnly || config.KafkaOnly {
+		return fmt.Errorf("sign cannot be combined with filter-mode, pg-only, or kafka-only, which do not keep the parts")
+	}
+	if config.BagIt {
+		return fmt.Errorf("sign cannot be combined with bagit, whose manifests would not list the signatures")
+	}
+	return nil
+}
+
+// manifestPath returns the path of the manifest of -sign, which lists the
+// SHA-256 digest of every part
+func manifestPath(config Config) string {
+	return filepath.Join(config.OutputDir, config.OutputPrefix+"_manifest.sha256")
+}
+
+// partSigner writes a detached signature next to every part, and at the end
+// of the run a manifest of the parts with a signature of its own, so the
+// recipient of the parts can tell that none was changed, added, or left out.
+type partSigner struct {
+	// gpgKey is the key gpg signs with, or minisignKey the key of minisign
+	gpgKey      string
+	minisignKey minisign.PrivateKey
+	// entries are the parts listed in the manifest, and previous the parts
+	// the run being resumed completed
+	entries  []bagEntry
+	previous []PartInfo
+}
+
+// newPartSigner checks that the key of -sign can be used
+func newPartSigner(config Config) (*partSigner, error) {
+	method, key, _ := strings.Cut(config.Sign, ":")
+	if method == "gpg" {
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return nil, configErrorf("sign gpg needs gpg, which was not found: %v", err)
+		}
+		return &partSigner{gpgKey: key}, nil
+	}
+
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, configErrorf("failed to read minisign key: %v", err)
+	}
+	g := &partSigner{}
+	if minisign.IsEncrypted(data) {
+		password := os.Getenv(minisignPasswordEnv)
+		if password == "" {
+			return nil, configErrorf("minisign key '%s' is encrypted; set %s to its password", key, minisignPasswordEnv)
+		}
+		g.minisignKey, err = minisign.DecryptKey(password, data)
+	} else {
+		err = g.minisignKey.UnmarshalText(data)
+	}
+	if err != nil {
+		return nil, configErrorf("invalid minisign key '%s': %v", key, err)
+	}
+	return g, nil
+}
+
+// sign writes the detached signature of the file at path to path.sig
+func (g *partSigner) sign(path string) error {
+	sigPath := path + signSuffix
+	if g.gpgKey != "" {
+		cmd := exec.Command("gpg", "--batch", "--yes", "--local-user", g.gpgKey, "--detach-sign", "--output", sigPath, path)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to sign '%s': %v: %s", path, err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to sign '%s': %w", path, err)
+	}
+	defer file.Close()
+	reader := minisign.NewReader(file)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("failed to sign '%s': %w", path, err)
+	}
+	// The trusted comment is the one the minisign tool writes
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filepath.Base(path))
+	signature := reader.SignWithComments(g.minisignKey, comment, "signature from splitcsv")
+	if err := os.WriteFile(sigPath, signature, 0644); err != nil {
+		return fmt.Errorf("failed to write signature '%s': %w", sigPath, err)
+	}
+	return nil
+}
+
+// addPart signs the completed part, and lists it in the manifest
+func (g *partSigner) addPart(part PartInfo, dir string) error {
+	if err := g.sign(part.Path); err != nil {
+		return err
+	}
+	return g.list(part, dir)
+}
+
+// list adds part to the manifest under its path relative to dir, reusing
+// its -checksums digest when that is SHA-256
+func (g *partSigner) list(part PartInfo, dir string) error {
+	var sum []byte
+	if digest, ok := strings.CutPrefix(part.Checksum, "sha256:"); ok {
+		sum, _ = hex.DecodeString(digest)
+	}
+	if sum == nil {
+		var err error
+		if sum, err = hashFile(part.Path); err != nil {
+			return err
+		}
+	}
+	name, err := filepath.Rel(dir, part.Path)
+	if err != nil {
+		name = filepath.Base(part.Path)
+	}
+	g.entries = append(g.entries, bagEntry{path: filepath.ToSlash(name), sum: sum})
+	return nil
+}
+
+// writeManifest writes and signs the manifest of the parts, in the format
+// read by sha256sum -c, and returns its path
+func (g *partSigner) writeManifest(config Config) (string, error) {
+	entries := g.entries
+	g.entries = nil
+	for _, part := range g.previous {
+		if err := g.list(part, config.OutputDir); err != nil {
+			return "", err
+		}
+	}
+	g.entries = append(g.entries, entries...)
+
+	var b strings.Builder
+	for _, entry := range g.entries {
+		fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(entry.sum), entry.path)
+	}
+	path := manifestPath(config)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write manifest '%s': %w", path, err)
+	}
+	return path, g.sign(path)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// slackIcons mark the message of a run by its status
+var slackIcons = map[string]string{
+	"succeeded":   ":white_check_mark:",
+	"partial":     ":warning:",
+	"failed":      ":x:",
+	"interrupted": ":octagonal_sign:",
+	"deadline":    ":hourglass:",
+}
+
+// slackMessage returns the text of the message posted to -slack-webhook
+// at the end of the run of s, which split source and ended with runErr
+func (s *CSVSplitter) slackMessage(source string, runErr error) string {
+	event := s.runEvent(runErr)
+	host, _ := os.Hostname()
+	duration := time.Duration(event.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s *csvplit* split of `%s` on %s %s after %s",
+		slackIcons[event.Status], source, host, event.Status, duration)
+	fmt.Fprintf(&b, "\nParts: %d, records: %d, size: %s, in `%s`", event.Parts, event.Records, formatSpace(event.Bytes), s.config.OutputDir)
+	if event.Rejected > 0 {
+		fmt.Fprintf(&b, "\n%d records rejected to `%s`", event.Rejected, s.rejects.path)
+	}
+	if event.Error != "" {
+		fmt.Fprintf(&b, "\n```%s```", event.Error)
+	}
+	fmt.Fprintf(&b, "\nRun %s", event.RunID)
+	return b.String()
+}
+
+// notifySlack posts the summary of the run of s to -slack-webhook. A message
+// that cannot be posted is reported as a warning and does not fail the run.
+func (s *CSVSplitter) notifySlack(source string, runErr error) {
+	body, err := json.Marshal(map[string]string{"text": s.slackMessage(source, runErr)})
+	if err == nil {
+		err = postJSON(s.config.SlackWebhook, body)
+	}
+	if err == nil {
+		return
+	}
+	if s.logger != nil {
+		s.logger.Warn("slack notification failed", "error", err.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: failed to post to -slack-webhook: %v\n", err)
+	}
+}
+
+// inputSource names the input of a run in its Slack message
+func inputSource(config Config) string {
+	if config.FilterMode {
+		return "stdin"
+	}
+	return strings.Join(append([]string{config.InputPath}, config.Inputs...), ", ")
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSlackMessageError(t *testing.T) {
+	s := &CSVSplitter{}
+	located := &locatedError{Location{Offset: 42, Line: 3, Column: 7, Context: "1,987-65-4321,x", Hex: "31 2c"}, errors.New(`bare " in non-quoted field`)}
+	got := s.slackMessage("orders.csv", fmt.Errorf("failed to read input: %w", located))
+	if want := "```failed to read input: bare \" in non-quoted field at byte 42 (line 3, column 7)```"; !strings.Contains(got, want) {
+		t.Errorf("slackMessage = %q, want it to contain %q", got, want)
+	}
+	if strings.Contains(got, "987-65-4321") || strings.Contains(got, "31 2c") {
+		t.Errorf("slackMessage = %q, holds the context of the error", got)
+	}
+}
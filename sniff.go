@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"regexp"
+	"slices"
+)
+
+// delimiterCandidates lists the delimiters considered by detectDelimiter, in
+// order of preference when scores tie
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+// sniffSize is the number of bytes inspected when detecting the dialect
+const sniffSize = 64 * 1024
+
+// detectDelimiter guesses the delimiter of the CSV data in sample. The
+// candidate appearing the same non-zero number of times on the most lines
+// wins; a comma is returned when nothing stands out.
+func detectDelimiter(sample []byte) rune {
+	lines := bytes.Split(sample, []byte("\n"))
+	if len(lines) > 1 {
+		// The last line may have been cut off mid-record
+		lines = lines[:len(lines)-1]
+	}
+
+	best, bestScore := ',', 0
+	for _, candidate := range delimiterCandidates {
+		counts := make(map[int]int)
+		for _, line := range lines {
+			if n := bytes.Count(line, []byte(string(candidate))); n > 0 {
+				counts[n]++
+			}
+		}
+		score := 0
+		for _, lines := range counts {
+			score = max(score, lines)
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+// sniffDelimiter peeks at the start of r to detect its delimiter without
+// consuming any input
+func sniffDelimiter(r *bufio.Reader) rune {
+	sample, err := r.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return ','
+	}
+	return detectDelimiter(sample)
+}
+
+// headerSampleRecords is the number of records under the first line that
+// detectHeader compares it with
+const headerSampleRecords = 100
+
+// headerWords matches column names made of the words they commonly are
+var headerWords = regexp.MustCompile(`(?i)(^|[^a-z])(id|key|code|name|title|type|kind|status|date|time|timestamp|created|updated|amount|price|cost|total|qty|quantity|count|email|phone|address|city|state|country|zip|description|value|number|no)([^a-z]|$)`)
+
+// detectHeader guesses whether first, the first line of an input, is its
+// header, by comparing it with records, the lines below it. A field that
+// does not fit the type of the values below it, such as a word above
+// numbers, points to a header, as do unique names and the words column
+// names are made of; a field that fits, or repeats a value below it, points
+// to data. sure is false when the evidence is too weak to go by.
+func detectHeader(first []string, records [][]string) (header, sure bool) {
+	score := 0
+	types := inferColumnTypes(records, len(first))
+	for i, field := range first {
+		below := make([]string, 0, len(records))
+		for _, record := range records {
+			if i < len(record) && record[i] != "" {
+				below = append(below, record[i])
+			}
+		}
+		switch {
+		case field == "":
+			score--
+		case len(below) == 0:
+		case types[i] != "string":
+			if mergeTypes(types[i], inferValueType(field)) == types[i] {
+				score -= 2
+			} else {
+				score += 2
+			}
+		case inferValueType(field) != "string":
+			// Column names are not numbers, dates, or booleans
+			score -= 2
+		case slices.Contains(below, field):
+			score--
+		}
+		if headerWords.MatchString(field) {
+			score++
+		}
+	}
+	unique := slices.Compact(slices.Sorted(slices.Values(first)))
+	if len(unique) == len(first) && !slices.Contains(first, "") {
+		score++
+	} else {
+		score--
+	}
+	// Without lines below it, a lone line is taken as the header it is in
+	// most files
+	return score >= 0, len(records) > 0 && (score >= 2 || score <= -2)
+}
+
+// newInspectReader returns a lenient CSV reader over r for subcommands that
+// inspect files. A zero delimiter is detected from the data; the delimiter in
+// use is returned alongside the reader.
+func newInspectReader(r io.Reader, delimiter rune) (*csv.Reader, rune) {
+	input := bufio.NewReader(r)
+	if delimiter == 0 {
+		delimiter = sniffDelimiter(input)
+	}
+
+	reader := csv.NewReader(input)
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	return reader, delimiter
+}
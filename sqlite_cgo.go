@@ -0,0 +1,11 @@
+//go:build cgo
+
+package main
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSupported reports whether -output-format sqlite can write databases
+// in this build, whose sqlite driver needs cgo
+const sqliteSupported = true
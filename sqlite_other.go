@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package main
+
+// sqliteSupported reports whether -output-format sqlite can write databases
+// in this build, whose sqlite driver needs cgo
+const sqliteSupported = false
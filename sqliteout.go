@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sqliteInferRows is how many records of a part are read to infer the
+// column types of its table before any is inserted
+const sqliteInferRows = 1000
+
+// sqliteDefaultTable is the table of sqlite parts without -table
+const sqliteDefaultTable = "data"
+
+// sqliteColumnTypes maps inferred schema types to the declared types of
+// sqlite columns
+var sqliteColumnTypes = map[string]string{
+	"int":      "INTEGER",
+	"float":    "REAL",
+	"bool":     "BOOLEAN",
+	"date":     "DATE",
+	"datetime": "DATETIME",
+	"string":   "TEXT",
+}
+
+// validateSQLiteOptions checks the options used by the sqlite output format
+func validateSQLiteOptions(config Config) error {
+	if !sqliteSupported {
+		return fmt.Errorf("sqlite output is not supported by this build, which was built without cgo")
+	}
+	return nil
+}
+
+// sqliteWriter writes the records of a part as a table of a sqlite
+// database. The database is built in a scratch file, as sqlite needs one,
+// and copied to the part once it is complete, so that it is counted and
+// checksummed like any other part.
+type sqliteWriter struct {
+	w       io.Writer
+	table   string
+	path    string
+	db      *sql.DB
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	header  []string
+	types   []string
+	pending [][]string
+	closed  bool
+}
+
+func newSQLiteWriter(w io.Writer, config Config) *sqliteWriter {
+	table := config.SQLTable
+	if table == "" {
+		table = sqliteDefaultTable
+	}
+	return &sqliteWriter{w: w, table: table}
+}
+
+// WriteHeader opens the scratch database. The table is created once the
+// column types are inferred from the first records.
+func (s *sqliteWriter) WriteHeader(header []string) error {
+	file, err := os.CreateTemp("", "splitcsv-*.sqlite")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	s.path = file.Name()
+	if s.db, err = sql.Open("sqlite3", s.path); err != nil {
+		os.Remove(s.path)
+		return err
+	}
+	s.header = slices.Clone(header)
+	return nil
+}
+
+// Write holds the record until the column types are inferred, and inserts
+// it after that
+func (s *sqliteWriter) Write(record []string) error {
+	if len(record) > len(s.header) {
+		return fmt.Errorf("record has %d fields, table %s has %d columns", len(record), s.table, len(s.header))
+	}
+	if s.types == nil {
+		s.pending = append(s.pending, slices.Clone(record))
+		if len(s.pending) < sqliteInferRows {
+			return nil
+		}
+		return s.createTable()
+	}
+	return s.insertRecord(record)
+}
+
+// createTable creates the table with the types inferred from the held
+// records, and inserts them
+func (s *sqliteWriter) createTable() error {
+	s.types = inferColumnTypes(s.pending, len(s.header))
+	columns := make([]string, len(s.header))
+	for i, name := range s.header {
+		columns[i] = doubleQuoteIdent(name) + " " + sqliteColumnTypes[s.types[i]]
+	}
+	table := doubleQuoteIdent(s.table)
+	if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(columns, ", "))); err != nil {
+		return err
+	}
+
+	var err error
+	if s.tx, err = s.db.Begin(); err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(s.header)), ", ")
+	if s.insert, err = s.tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", table, placeholders)); err != nil {
+		return err
+	}
+	for _, record := range s.pending {
+		if err := s.insertRecord(record); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+// insertRecord inserts record with its values converted to the types of
+// their columns. Missing fields are NULL.
+func (s *sqliteWriter) insertRecord(record []string) error {
+	values := make([]any, len(s.header))
+	for i, value := range record {
+		values[i] = sqliteValue(value, s.types[i])
+	}
+	_, err := s.insert.Exec(values...)
+	return err
+}
+
+// sqliteValue converts value to the type of its column. Empty values of
+// columns other than text ones are NULL, and values that do not parse as
+// the type of their column are kept as text, as sqlite allows.
+func sqliteValue(value, columnType string) any {
+	if value == "" && columnType != "string" {
+		return nil
+	}
+	switch columnType {
+	case "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// Close commits the table and copies the database to the part. The scratch
+// database is removed either way.
+func (s *sqliteWriter) Close() error {
+	if s.closed || s.db == nil {
+		return nil
+	}
+	s.closed = true
+	defer os.Remove(s.path)
+	err := s.finish()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(s.w, file)
+	return err
+}
+
+// finish creates the table of a part with fewer records than
+// sqliteInferRows, and commits the inserted records
+func (s *sqliteWriter) finish() error {
+	var err error
+	if s.types == nil {
+		err = s.createTable()
+	}
+	if s.insert == nil {
+		// The table was not created, and a split failing on that stops
+		if s.tx != nil {
+			s.tx.Rollback()
+		}
+		if err == nil {
+			err = fmt.Errorf("table %s was not created", s.table)
+		}
+		return err
+	}
+	if err == nil {
+		err = s.insert.Close()
+	}
+	if err != nil {
+		s.tx.Rollback()
+		return err
+	}
+	return s.tx.Commit()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sqlDialect describes how a SQL flavor quotes identifiers and strings
+type sqlDialect struct {
+	quoteIdent  func(name string) string
+	quoteString func(value string) string
+}
+
+// sqlDialects maps -sql-dialect values to their quoting rules
+var sqlDialects = map[string]sqlDialect{
+	"ansi":      {quoteIdent: doubleQuoteIdent, quoteString: standardQuoteString},
+	"postgres":  {quoteIdent: doubleQuoteIdent, quoteString: standardQuoteString},
+	"sqlite":    {quoteIdent: doubleQuoteIdent, quoteString: standardQuoteString},
+	"mysql":     {quoteIdent: backtickQuoteIdent, quoteString: mysqlQuoteString},
+	"sqlserver": {quoteIdent: bracketQuoteIdent, quoteString: nationalQuoteString},
+}
+
+func doubleQuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func backtickQuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func bracketQuoteIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// standardQuoteString quotes a string literal by doubling single quotes
+func standardQuoteString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// nationalQuoteString quotes a Unicode string literal for SQL Server, whose
+// plain literals are in the code page of the database and lose the
+// characters it lacks
+func nationalQuoteString(value string) string {
+	return "N" + standardQuoteString(value)
+}
+
+// mysqlReplacer escapes the characters MySQL treats specially inside string
+// literals when NO_BACKSLASH_ESCAPES is not set
+var mysqlReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\x1a", `\Z`,
+)
+
+func mysqlQuoteString(value string) string {
+	return "'" + mysqlReplacer.Replace(value) + "'"
+}
+
+// validateSQLOptions checks the options used by the sql output format
+func validateSQLOptions(config Config) error {
+	if config.SQLTable == "" {
+		return fmt.Errorf("table is required for sql output")
+	}
+	if _, ok := sqlDialects[config.SQLDialect]; !ok {
+		return fmt.Errorf("unsupported sql dialect: %s", config.SQLDialect)
+	}
+	if config.SQLBatchSize <= 0 {
+		return fmt.Errorf("sql batch size must be greater than 0")
+	}
+	switch config.SQLNull {
+	case "null":
+		if config.NullOutput != "" {
+			return fmt.Errorf("null-output cannot be combined with sql output, which writes empty fields as NULL, unless -sql-null is string")
+		}
+	case "string":
+	default:
+		return fmt.Errorf("sql-null must be null or string")
+	}
+	return nil
+}
+
+// sqlWriter writes records as batched INSERT statements, with empty fields
+// written as NULL unless -sql-null is string
+type sqlWriter struct {
+	writer    *bufio.Writer
+	dialect   sqlDialect
+	table     string
+	batchSize int
+	nulls     bool
+	prefix    string
+	width     int
+	pending   int
+}
+
+func newSQLWriter(w io.Writer, config Config) *sqlWriter {
+	dialect := sqlDialects[config.SQLDialect]
+
+	// Schema-qualified names are quoted part by part
+	parts := strings.Split(config.SQLTable, ".")
+	for i, part := range parts {
+		parts[i] = dialect.quoteIdent(part)
+	}
+
+	return &sqlWriter{
+		writer:    bufio.NewWriterSize(w, config.WriteBufferSize),
+		dialect:   dialect,
+		table:     strings.Join(parts, "."),
+		batchSize: config.SQLBatchSize,
+		nulls:     config.SQLNull != "string",
+	}
+}
+
+// WriteHeader records the column list used by every INSERT statement
+func (s *sqlWriter) WriteHeader(header []string) error {
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = s.dialect.quoteIdent(name)
+	}
+	s.prefix = fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", s.table, strings.Join(columns, ", "))
+	s.width = len(header)
+	return nil
+}
+
+// Write adds a row to the current INSERT statement, starting a new statement
+// once the batch is full. Missing fields are NULL, and a record with more
+// fields than the header is an error, as its statement would fail.
+func (s *sqlWriter) Write(record []string) error {
+	if len(record) > s.width {
+		return fmt.Errorf("record has %d fields, table %s has %d columns", len(record), s.table, s.width)
+	}
+	if s.pending == s.batchSize {
+		s.writer.WriteString(";\n")
+		s.pending = 0
+	}
+	if s.pending == 0 {
+		s.writer.WriteString(s.prefix)
+	} else {
+		s.writer.WriteString(",\n")
+	}
+
+	s.writer.WriteString("  (")
+	for i, value := range record {
+		if i > 0 {
+			s.writer.WriteString(", ")
+		}
+		if value == "" && s.nulls {
+			s.writer.WriteString("NULL")
+			continue
+		}
+		s.writer.WriteString(s.dialect.quoteString(value))
+	}
+	for i := len(record); i < s.width; i++ {
+		if i > 0 {
+			s.writer.WriteString(", ")
+		}
+		s.writer.WriteString("NULL")
+	}
+	_, err := s.writer.WriteString(")")
+	s.pending++
+	return err
+}
+
+// Close terminates the final statement and flushes the output
+func (s *sqlWriter) Close() error {
+	if s.pending > 0 {
+		s.writer.WriteString(";\n")
+		s.pending = 0
+	}
+	return s.writer.Flush()
+}
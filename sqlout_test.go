@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSQLDialectQuoting(t *testing.T) {
+	tests := []struct {
+		dialect string
+		ident   string
+		value   string
+		want    string
+	}{
+		{"ansi", `or"ders`, "it's", `INSERT INTO "or""ders" ("id") VALUES` + "\n" + `  ('it''s');` + "\n"},
+		{"postgres", "orders", `a\b`, `INSERT INTO "orders" ("id") VALUES` + "\n" + `  ('a\b');` + "\n"},
+		{"mysql", "or`ders", "it's \\ \x00", "INSERT INTO `or``ders` (`id`) VALUES\n  ('it\\'s \\\\ \\0');\n"},
+		{"sqlserver", "or]ders", "Zoë's", "INSERT INTO [or]]ders] ([id]) VALUES\n  (N'Zoë''s');\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			var b bytes.Buffer
+			w := newSQLWriter(&b, Config{SQLTable: tt.ident, SQLDialect: tt.dialect, SQLBatchSize: 10, SQLNull: "null", WriteBufferSize: 4096})
+			w.WriteHeader([]string{"id"})
+			if err := w.Write([]string{tt.value}); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			if got := b.String(); got != tt.want {
+				t.Errorf("sql output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLWriterWidth(t *testing.T) {
+	var b bytes.Buffer
+	w := newSQLWriter(&b, Config{SQLTable: "orders", SQLDialect: "ansi", SQLBatchSize: 10, SQLNull: "null", WriteBufferSize: 4096})
+	w.WriteHeader([]string{"id", "amount", "country"})
+	if err := w.Write([]string{"1"}); err != nil {
+		t.Fatalf("Write of a short record = %v, want it padded", err)
+	}
+	if err := w.Write([]string{"2", "5", "US", "extra"}); err == nil || !strings.Contains(err.Error(), "record has 4 fields") {
+		t.Fatalf("Write of a long record = %v, want an error", err)
+	}
+	w.Close()
+	want := `INSERT INTO "orders" ("id", "amount", "country") VALUES` + "\n" + `  ('1', NULL, NULL);` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("sql output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,757 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A state directory holds the incremental index on disk, so that only small
+// summaries of it are kept in memory:
+//
+//	MANIFEST.json  the committed state, replaced atomically at the end of a run
+//	index.<gen>    entries sorted by key hash: key hash, row hash, key offset
+//	keys.<gen>     length-prefixed key values, appended to by every run and
+//	               rewritten when most of it belongs to deleted keys
+//	*.tmp          files of a run in progress
+//
+// Lookups binary search an in-memory fence per block of index entries and
+// read a single block. Entries of the new index are sorted in memory-sized
+// runs and merged at the end of the run.
+const (
+	stateManifestName   = "MANIFEST.json"
+	stateVersion        = 1
+	indexMagic          = "SPLTIDX1"
+	indexEntrySize      = 32
+	indexBlockEntries   = 128
+	indexSpillEntries   = 1 << 20
+	keysCompactMinBytes = 1 << 20
+)
+
+// stateManifest describes the committed files of a state directory and the
+// checksums used to detect corruption
+type stateManifest struct {
+	Version       int       `json:"version"`
+	Key           []string  `json:"key"`
+	Generation    int       `json:"generation"`
+	Entries       int64     `json:"entries"`
+	IndexFile     string    `json:"index_file"`
+	IndexCRC32    uint32    `json:"index_crc32"`
+	KeysFile      string    `json:"keys_file"`
+	KeysBytes     int64     `json:"keys_bytes"`
+	KeysCRC32     uint32    `json:"keys_crc32"`
+	KeysLiveBytes int64     `json:"keys_live_bytes"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// keyHash is the 128-bit fingerprint identifying a key in the index
+type keyHash [2]uint64
+
+func (k keyHash) compare(other keyHash) int {
+	if c := cmp.Compare(k[0], other[0]); c != 0 {
+		return c
+	}
+	return cmp.Compare(k[1], other[1])
+}
+
+// hashKey fingerprints the fields of a key
+func hashKey(fields []string) keyHash {
+	h := fnv.New128a()
+	writeFields(h, fields)
+	var sum [16]byte
+	h.Sum(sum[:0])
+	return keyHash{binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:])}
+}
+
+// indexEntry is a single key of the on-disk index
+type indexEntry struct {
+	key    keyHash
+	row    uint64
+	offset int64
+}
+
+func (e indexEntry) encode(b []byte) {
+	binary.BigEndian.PutUint64(b[0:], e.key[0])
+	binary.BigEndian.PutUint64(b[8:], e.key[1])
+	binary.BigEndian.PutUint64(b[16:], e.row)
+	binary.BigEndian.PutUint64(b[24:], uint64(e.offset))
+}
+
+func decodeIndexEntry(b []byte) indexEntry {
+	return indexEntry{
+		key:    keyHash{binary.BigEndian.Uint64(b[0:]), binary.BigEndian.Uint64(b[8:])},
+		row:    binary.BigEndian.Uint64(b[16:]),
+		offset: int64(binary.BigEndian.Uint64(b[24:])),
+	}
+}
+
+// diskIndex is a changeIndex kept in a state directory
+type diskIndex struct {
+	dir       string
+	keyNames  []string
+	previous  stateManifest
+	prevFile  *os.File
+	fences    []keyHash
+	seen      []uint64
+	keysName  string
+	keysFile  *os.File
+	keys      *bufio.Writer
+	keysLen   int64
+	keysCRC   uint32
+	liveBytes int64
+	buffer    []indexEntry
+	// spillEntries is how many entries are buffered before they spill
+	spillEntries int
+	runs         []string
+	block        []byte
+	saved        bool
+}
+
+// openDiskIndex opens the state in dir, creating it if needed, and verifies
+// the committed files against the manifest
+func openDiskIndex(dir string, keyNames []string, spillEntries int) (*diskIndex, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	d := &diskIndex{
+		dir:          dir,
+		keyNames:     keyNames,
+		spillEntries: spillEntries,
+		block:        make([]byte, indexBlockEntries*indexEntrySize),
+	}
+	d.removeTemporary()
+
+	data, err := os.ReadFile(filepath.Join(dir, stateManifestName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		d.keysName = "keys.1"
+		d.keysFile, err = os.OpenFile(filepath.Join(dir, d.keysName), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create state file: %w", err)
+		}
+		d.keys = bufio.NewWriterSize(d.keysFile, 64*1024)
+		return d, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &d.previous); err != nil {
+		return nil, d.corrupt("unreadable manifest: %v", err)
+	}
+	if d.previous.Version != stateVersion {
+		return nil, d.corrupt("unsupported version %d", d.previous.Version)
+	}
+	if !slices.Equal(d.previous.Key, keyNames) {
+		return nil, configErrorf("state in '%s' was built with key %s, not %s",
+			dir, strings.Join(d.previous.Key, ","), strings.Join(keyNames, ","))
+	}
+	if err := d.loadIndex(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if err := d.loadKeys(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.seen = make([]uint64, (d.previous.Entries+63)/64)
+	d.liveBytes = d.previous.KeysLiveBytes
+	return d, nil
+}
+
+// corrupt formats an error for a state directory that failed verification
+func (d *diskIndex) corrupt(format string, args ...any) error {
+	return fmt.Errorf("state directory '%s' is corrupt: %s; remove it to rebuild the index with a full run",
+		d.dir, fmt.Sprintf(format, args...))
+}
+
+// removeTemporary deletes files left behind by runs that did not commit
+func (d *diskIndex) removeTemporary() {
+	matches, _ := filepath.Glob(filepath.Join(d.dir, "*"+tmpSuffix))
+	for _, path := range matches {
+		os.Remove(path)
+	}
+}
+
+// loadIndex opens the previous index, checking its size, checksum, and order
+// while building the in-memory fences
+func (d *diskIndex) loadIndex() error {
+	path := filepath.Join(d.dir, d.previous.IndexFile)
+	file, err := os.Open(path)
+	if err != nil {
+		return d.corrupt("%v", err)
+	}
+	d.prevFile = file
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if info.Size() != int64(len(indexMagic))+d.previous.Entries*indexEntrySize {
+		return d.corrupt("%s has %d bytes, expected %d entries", d.previous.IndexFile, info.Size(), d.previous.Entries)
+	}
+
+	sum := crc32.NewIEEE()
+	reader := bufio.NewReaderSize(io.TeeReader(file, sum), 256*1024)
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if string(magic) != indexMagic {
+		return d.corrupt("%s is not an index file", d.previous.IndexFile)
+	}
+
+	buf := make([]byte, indexEntrySize)
+	var last keyHash
+	for i := int64(0); i < d.previous.Entries; i++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("failed to read state file: %w", err)
+		}
+		entry := decodeIndexEntry(buf)
+		if i > 0 && entry.key.compare(last) <= 0 {
+			return d.corrupt("%s is not sorted at entry %d", d.previous.IndexFile, i)
+		}
+		if i%indexBlockEntries == 0 {
+			d.fences = append(d.fences, entry.key)
+		}
+		last = entry.key
+	}
+	if sum.Sum32() != d.previous.IndexCRC32 {
+		return d.corrupt("%s checksum mismatch", d.previous.IndexFile)
+	}
+	return nil
+}
+
+// loadKeys opens the keys file for appending, checking the committed prefix
+// and dropping anything appended by a run that did not commit
+func (d *diskIndex) loadKeys() error {
+	d.keysName = d.previous.KeysFile
+	path := filepath.Join(d.dir, d.keysName)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return d.corrupt("%v", err)
+	}
+	if err := d.verifyKeys(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	d.keysFile = file
+	d.keysLen = d.previous.KeysBytes
+	d.keysCRC = d.previous.KeysCRC32
+	d.keys = bufio.NewWriterSize(file, 64*1024)
+	return nil
+}
+
+// verifyKeys checks the committed prefix of the keys file against the
+// manifest, truncates what follows it, and positions file at its end
+func (d *diskIndex) verifyKeys(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if info.Size() < d.previous.KeysBytes {
+		return d.corrupt("%s is truncated", d.keysName)
+	}
+	sum := crc32.NewIEEE()
+	if _, err := io.CopyN(sum, file, d.previous.KeysBytes); err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	if sum.Sum32() != d.previous.KeysCRC32 {
+		return d.corrupt("%s checksum mismatch", d.keysName)
+	}
+	if err := file.Truncate(d.previous.KeysBytes); err != nil {
+		return fmt.Errorf("failed to truncate state file: %w", err)
+	}
+	if _, err := file.Seek(d.previous.KeysBytes, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	return nil
+}
+
+// lookup finds key in the previous index, returning its position and entry
+func (d *diskIndex) lookup(key keyHash) (int64, indexEntry, bool, error) {
+	b := sort.Search(len(d.fences), func(i int) bool { return d.fences[i].compare(key) > 0 }) - 1
+	if b < 0 {
+		return 0, indexEntry{}, false, nil
+	}
+
+	start := int64(b) * indexBlockEntries
+	n := min(int64(indexBlockEntries), d.previous.Entries-start)
+	block := d.block[:n*indexEntrySize]
+	if _, err := d.prevFile.ReadAt(block, int64(len(indexMagic))+start*indexEntrySize); err != nil {
+		return 0, indexEntry{}, false, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	i := sort.Search(int(n), func(i int) bool {
+		return decodeIndexEntry(block[i*indexEntrySize:]).key.compare(key) >= 0
+	})
+	if i < int(n) {
+		if entry := decodeIndexEntry(block[i*indexEntrySize:]); entry.key == key {
+			return start + int64(i), entry, true, nil
+		}
+	}
+	return 0, indexEntry{}, false, nil
+}
+
+func (d *diskIndex) Diff(fields []string, sum uint64) (string, error) {
+	key := hashKey(fields)
+	i, previous, found, err := d.lookup(key)
+	if err != nil {
+		return "", err
+	}
+
+	op := opInsert
+	offset := previous.offset
+	if found {
+		if d.seen[i/64]&(1<<(i%64)) != 0 {
+			return "", fmt.Errorf("duplicate key %s", strings.Join(fields, ","))
+		}
+		d.seen[i/64] |= 1 << (i % 64)
+		op = ""
+		if previous.row != sum {
+			op = opUpdate
+		}
+	} else {
+		if offset, err = d.appendKey(fields); err != nil {
+			return "", err
+		}
+	}
+
+	d.buffer = append(d.buffer, indexEntry{key: key, row: sum, offset: offset})
+	if len(d.buffer) >= d.spillEntries {
+		if err := d.spill(); err != nil {
+			return "", err
+		}
+	}
+	return op, nil
+}
+
+// appendKey writes the values of a new key to the keys file, returning the
+// offset of its record
+func (d *diskIndex) appendKey(fields []string) (int64, error) {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(fields)))
+	for _, field := range fields {
+		payload = binary.AppendUvarint(payload, uint64(len(field)))
+		payload = append(payload, field...)
+	}
+	record := binary.AppendUvarint(nil, uint64(len(payload)))
+	record = append(record, payload...)
+
+	if _, err := d.keys.Write(record); err != nil {
+		return 0, fmt.Errorf("failed to write state file: %w", err)
+	}
+	offset := d.keysLen
+	d.keysCRC = crc32.Update(d.keysCRC, crc32.IEEETable, record)
+	d.keysLen += int64(len(record))
+	d.liveBytes += int64(len(record))
+	return offset, nil
+}
+
+// readKeyRecord returns the raw key record at offset in the keys file
+func (d *diskIndex) readKeyRecord(offset int64) ([]byte, error) {
+	var prefix [binary.MaxVarintLen64]byte
+	n, err := d.keysFile.ReadAt(prefix[:], offset)
+	if n == 0 {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	size, k := binary.Uvarint(prefix[:n])
+	if k <= 0 || offset+int64(k)+int64(size) > d.keysLen {
+		return nil, d.corrupt("bad key record at offset %d", offset)
+	}
+	record := make([]byte, k+int(size))
+	if _, err := d.keysFile.ReadAt(record, offset); err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return record, nil
+}
+
+// readKey decodes the key values at offset in the keys file, also returning
+// the size of their record
+func (d *diskIndex) readKey(offset int64) ([]string, int64, error) {
+	record, err := d.readKeyRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, k := binary.Uvarint(record)
+	payload := record[k:]
+	count, k := binary.Uvarint(payload)
+	if k <= 0 || count != uint64(len(d.keyNames)) {
+		return nil, 0, d.corrupt("bad key record at offset %d", offset)
+	}
+	payload = payload[k:]
+	fields := make([]string, count)
+	for i := range fields {
+		size, k := binary.Uvarint(payload)
+		if k <= 0 || uint64(len(payload)-k) < size {
+			return nil, 0, d.corrupt("bad key record at offset %d", offset)
+		}
+		fields[i] = string(payload[k : k+int(size)])
+		payload = payload[k+int(size):]
+	}
+	return fields, int64(len(record)), nil
+}
+
+// spill sorts the buffered entries and writes them to a run file
+func (d *diskIndex) spill() error {
+	sortEntries(d.buffer)
+	path := filepath.Join(d.dir, fmt.Sprintf("run-%d%s", len(d.runs)+1, tmpSuffix))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	d.runs = append(d.runs, path)
+
+	w := bufio.NewWriterSize(file, 256*1024)
+	buf := make([]byte, indexEntrySize)
+	for _, entry := range d.buffer {
+		entry.encode(buf)
+		w.Write(buf)
+	}
+	err = w.Flush()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	d.buffer = d.buffer[:0]
+	return nil
+}
+
+// Deleted reports the unseen keys in key hash order
+func (d *diskIndex) Deleted(fn func(key []string) error) error {
+	if d.prevFile == nil {
+		return nil
+	}
+	if err := d.keys.Flush(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	section := io.NewSectionReader(d.prevFile, int64(len(indexMagic)), d.previous.Entries*indexEntrySize)
+	reader := bufio.NewReaderSize(section, 256*1024)
+	buf := make([]byte, indexEntrySize)
+	for i := int64(0); i < d.previous.Entries; i++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("failed to read state file: %w", err)
+		}
+		if d.seen[i/64]&(1<<(i%64)) != 0 {
+			continue
+		}
+		fields, size, err := d.readKey(decodeIndexEntry(buf).offset)
+		if err != nil {
+			return err
+		}
+		d.liveBytes -= size
+		if err := fn(fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save merges this run's entries into a new index generation, compacting
+// the keys file when most of it is garbage, and commits it in the manifest
+func (d *diskIndex) Save() error {
+	if err := d.keys.Flush(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	sortEntries(d.buffer)
+	sources := []entrySource{&sliceSource{entries: d.buffer}}
+	var runFiles []*os.File
+	defer func() {
+		for _, file := range runFiles {
+			file.Close()
+		}
+	}()
+	for _, path := range d.runs {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to read state file: %w", err)
+		}
+		runFiles = append(runFiles, file)
+		sources = append(sources, &fileSource{r: bufio.NewReaderSize(file, 64*1024)})
+	}
+
+	manifest := stateManifest{
+		Version:       stateVersion,
+		Key:           d.keyNames,
+		Generation:    d.previous.Generation + 1,
+		IndexFile:     fmt.Sprintf("index.%d", d.previous.Generation+1),
+		KeysFile:      d.keysName,
+		KeysBytes:     d.keysLen,
+		KeysCRC32:     d.keysCRC,
+		KeysLiveBytes: d.liveBytes,
+	}
+	compact := d.keysLen > 2*d.liveBytes && d.keysLen > keysCompactMinBytes
+	if compact {
+		manifest.KeysFile = fmt.Sprintf("keys.%d", manifest.Generation)
+	}
+
+	index, err := newStateFile(filepath.Join(d.dir, manifest.IndexFile))
+	if err != nil {
+		return err
+	}
+	defer index.abort()
+	index.Write([]byte(indexMagic))
+
+	var keys *stateFile
+	if compact {
+		if keys, err = newStateFile(filepath.Join(d.dir, manifest.KeysFile)); err != nil {
+			return err
+		}
+		defer keys.abort()
+	}
+
+	buf := make([]byte, indexEntrySize)
+	var last keyHash
+	err = mergeEntries(sources, func(entry indexEntry) error {
+		if manifest.Entries > 0 && entry.key == last {
+			fields, _, err := d.readKey(entry.offset)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("duplicate key %s", strings.Join(fields, ","))
+		}
+		if keys != nil {
+			record, err := d.readKeyRecord(entry.offset)
+			if err != nil {
+				return err
+			}
+			entry.offset = keys.size
+			keys.Write(record)
+		}
+		entry.encode(buf)
+		index.Write(buf)
+		manifest.Entries++
+		last = entry.key
+		return nil
+	})
+	for _, file := range runFiles {
+		file.Close()
+	}
+	runFiles = nil
+	if err != nil {
+		return err
+	}
+
+	if err := index.commit(); err != nil {
+		return err
+	}
+	manifest.IndexCRC32 = index.crc
+	if keys != nil {
+		if err := keys.commit(); err != nil {
+			return err
+		}
+		manifest.KeysBytes = keys.size
+		manifest.KeysCRC32 = keys.crc
+		manifest.KeysLiveBytes = keys.size
+	} else if err := d.keysFile.Sync(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	manifest.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(d.dir, stateManifestName)
+	if err := os.WriteFile(manifestPath+tmpSuffix, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write state manifest: %w", err)
+	}
+	if err := os.Rename(manifestPath+tmpSuffix, manifestPath); err != nil {
+		return fmt.Errorf("failed to write state manifest: %w", err)
+	}
+	d.saved = true
+
+	// The new generation is committed; drop the files it replaced
+	d.Close()
+	if d.previous.IndexFile != "" {
+		os.Remove(filepath.Join(d.dir, d.previous.IndexFile))
+	}
+	if compact {
+		os.Remove(filepath.Join(d.dir, d.keysName))
+	}
+	return nil
+}
+
+// Close releases the state files. Unless the run was saved, key values it
+// appended are dropped again.
+func (d *diskIndex) Close() error {
+	if d.prevFile != nil {
+		d.prevFile.Close()
+		d.prevFile = nil
+	}
+	if d.keysFile != nil {
+		if !d.saved {
+			d.keysFile.Truncate(d.previous.KeysBytes)
+		}
+		d.keysFile.Close()
+		d.keysFile = nil
+		if !d.saved && d.previous.Generation == 0 {
+			os.Remove(filepath.Join(d.dir, d.keysName))
+		}
+	}
+	for _, path := range d.runs {
+		os.Remove(path)
+	}
+	d.runs = nil
+	return nil
+}
+
+// stateFile writes a state file under a temporary name, tracking its size
+// and checksum, and moves it into place on commit
+type stateFile struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+	size int64
+	crc  uint32
+	err  error
+}
+
+func newStateFile(path string) (*stateFile, error) {
+	file, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write state file: %w", err)
+	}
+	return &stateFile{path: path, file: file, w: bufio.NewWriterSize(file, 256*1024)}, nil
+}
+
+func (f *stateFile) Write(p []byte) {
+	if f.err == nil {
+		_, f.err = f.w.Write(p)
+		f.crc = crc32.Update(f.crc, crc32.IEEETable, p)
+		f.size += int64(len(p))
+	}
+}
+
+// commit flushes the file to disk and renames it to its final name
+func (f *stateFile) commit() error {
+	if f.err == nil {
+		f.err = f.w.Flush()
+	}
+	if f.err == nil {
+		f.err = f.file.Sync()
+	}
+	if err := f.file.Close(); f.err == nil {
+		f.err = err
+	}
+	f.file = nil
+	if f.err == nil {
+		f.err = os.Rename(f.path+tmpSuffix, f.path)
+	}
+	if f.err != nil {
+		return fmt.Errorf("failed to write state file: %w", f.err)
+	}
+	return nil
+}
+
+// abort removes the temporary file unless it was committed
+func (f *stateFile) abort() {
+	if f.file != nil {
+		f.file.Close()
+		os.Remove(f.path + tmpSuffix)
+	}
+}
+
+func sortEntries(entries []indexEntry) {
+	slices.SortFunc(entries, func(a, b indexEntry) int { return a.key.compare(b.key) })
+}
+
+// entrySource yields index entries in key hash order
+type entrySource interface {
+	next() (indexEntry, bool, error)
+}
+
+type sliceSource struct {
+	entries []indexEntry
+}
+
+func (s *sliceSource) next() (indexEntry, bool, error) {
+	if len(s.entries) == 0 {
+		return indexEntry{}, false, nil
+	}
+	entry := s.entries[0]
+	s.entries = s.entries[1:]
+	return entry, true, nil
+}
+
+type fileSource struct {
+	r   *bufio.Reader
+	buf [indexEntrySize]byte
+}
+
+func (s *fileSource) next() (indexEntry, bool, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err == io.EOF {
+		return indexEntry{}, false, nil
+	} else if err != nil {
+		return indexEntry{}, false, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return decodeIndexEntry(s.buf[:]), true, nil
+}
+
+// mergeHead is the current entry of a source being merged
+type mergeHead struct {
+	entry  indexEntry
+	source entrySource
+}
+
+type mergeHeap []mergeHead
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].entry.key.compare(h[j].entry.key) < 0 }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(mergeHead)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergeEntries calls fn with the entries of all sources in key hash order
+func mergeEntries(sources []entrySource, fn func(indexEntry) error) error {
+	h := &mergeHeap{}
+	for _, source := range sources {
+		entry, ok, err := source.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			*h = append(*h, mergeHead{entry: entry, source: source})
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		head := &(*h)[0]
+		if err := fn(head.entry); err != nil {
+			return err
+		}
+		entry, ok, err := head.source.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			head.entry = entry
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// diffRun opens the index in dir and diffs rows, keys with the sums of their
+// rows, returning the change of each row and the deleted keys
+func diffRun(t *testing.T, dir string, rows map[string]uint64, order []string) ([]string, []string, error) {
+	t.Helper()
+	d, err := openDiskIndex(dir, []string{"id"}, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.Close()
+	var ops, deleted []string
+	for _, key := range order {
+		op, err := d.Diff([]string{key}, rows[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, op)
+	}
+	if err := d.Deleted(func(key []string) error {
+		deleted = append(deleted, key...)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return ops, deleted, d.Save()
+}
+
+func TestDiskIndex(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+
+	ops, deleted, err := diffRun(t, dir, map[string]uint64{"a": 1, "b": 2, "c": 3}, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{opInsert, opInsert, opInsert}; !slices.Equal(ops, want) || len(deleted) > 0 {
+		t.Fatalf("first run = %q, deleted %q, want %q", ops, deleted, want)
+	}
+
+	ops, deleted, err = diffRun(t, dir, map[string]uint64{"a": 1, "b": 20, "d": 4}, []string{"a", "b", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", opUpdate, opInsert}; !slices.Equal(ops, want) || !slices.Equal(deleted, []string{"c"}) {
+		t.Fatalf("second run = %q, deleted %q, want %q, deleted [c]", ops, deleted, want)
+	}
+
+	// A run that is not saved leaves the state as it was
+	d, err := openDiskIndex(dir, []string{"id"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Diff([]string{"e"}, 5); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	ops, _, err = diffRun(t, dir, map[string]uint64{"e": 5, "b": 20}, []string{"e", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{opInsert, ""}; !slices.Equal(ops, want) {
+		t.Fatalf("run after an unsaved one = %q, want %q", ops, want)
+	}
+
+	t.Run("duplicate key", func(t *testing.T) {
+		if _, _, err := diffRun(t, dir, map[string]uint64{"b": 20}, []string{"b", "b"}); err == nil || !strings.Contains(err.Error(), "duplicate key b") {
+			t.Errorf("diff of a repeated known key = %v, want an error", err)
+		}
+		if _, _, err := diffRun(t, dir, map[string]uint64{"z": 1}, []string{"z", "z"}); err == nil || !strings.Contains(err.Error(), "duplicate key z") {
+			t.Errorf("save of a repeated new key = %v, want an error", err)
+		}
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		if _, err := openDiskIndex(dir, []string{"code"}, 2); err == nil || !strings.Contains(err.Error(), "was built with key id") {
+			t.Errorf("openDiskIndex with another key = %v, want an error", err)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.Name() != stateManifestName {
+				path := filepath.Join(dir, entry.Name())
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data[len(data)-1] ^= 0xff
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if _, err := openDiskIndex(dir, []string{"id"}, 2); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+			t.Errorf("openDiskIndex of a corrupt state = %v, want an error", err)
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDistinctValues caps the number of distinct values tracked per
+// categorical histogram; rarer values beyond the cap count as "other"
+const maxDistinctValues = 100000
+
+// StatsConfig holds the configuration for the stats subcommand
+type StatsConfig struct {
+	InputPath  string
+	OutputPath string
+	Delimiter  rune
+	Histograms []string
+	Buckets    int
+	Top        int
+}
+
+// ColumnStats summarizes the values of one column
+type ColumnStats struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	NonEmpty int      `json:"non_empty"`
+	Empty    int      `json:"empty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+// Histogram is the bucketed distribution of one column. Numeric columns use
+// equal-width buckets; other columns list their most frequent values.
+type Histogram struct {
+	Column   string            `json:"column"`
+	Kind     string            `json:"kind"`
+	Buckets  []HistogramBucket `json:"buckets,omitempty"`
+	Values   []ValueCount      `json:"values,omitempty"`
+	Other    int               `json:"other,omitempty"`
+	Distinct int               `json:"distinct,omitempty"`
+	Empty    int               `json:"empty"`
+}
+
+// HistogramBucket counts the values in the range [Lower, Upper). The last
+// bucket also includes Upper.
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+// ValueCount is the number of occurrences of a categorical value
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// StatsReport is the JSON document produced by the stats subcommand
+type StatsReport struct {
+	Input      string        `json:"input"`
+	Delimiter  string        `json:"delimiter"`
+	Records    int           `json:"records"`
+	Columns    []ColumnStats `json:"columns"`
+	Histograms []Histogram   `json:"histograms,omitempty"`
+}
+
+// runStats implements the stats subcommand, which profiles the columns of a
+// CSV file and writes a JSON report
+func runStats(args []string) error {
+	config := StatsConfig{}
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.StringVar(&config.InputPath, "input", "", "Path to the input CSV file (or pass it as an argument)")
+	fs.StringVar(&config.InputPath, "i", "", "Path to the input CSV file (shorthand)")
+	fs.StringVar(&config.OutputPath, "output", "", "Write the JSON report to this file instead of stdout")
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character (default: detected)")
+	fs.Func("histogram", "Column to build a histogram for (repeatable)", func(column string) error {
+		config.Histograms = append(config.Histograms, column)
+		return nil
+	})
+	fs.IntVar(&config.Buckets, "buckets", 10, "Number of buckets in numeric histograms")
+	fs.IntVar(&config.Top, "top", 20, "Number of most frequent values in categorical histograms")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s stats [options] [file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Profile the columns of a CSV file as a JSON report.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if config.InputPath == "" && fs.NArg() > 0 {
+		config.InputPath = fs.Arg(0)
+	}
+	if config.InputPath == "" {
+		return configErrorf("input file path is required")
+	}
+	if config.Buckets <= 0 {
+		return configErrorf("buckets must be greater than 0")
+	}
+	if config.Top <= 0 {
+		return configErrorf("top must be greater than 0")
+	}
+
+	report, err := collectStats(config)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if config.OutputPath != "" {
+		file, err := os.Create(config.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create stats report '%s': %w", config.OutputPath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// histogramState accumulates the values of a histogram column during the
+// first pass
+type histogramState struct {
+	index  int
+	counts map[string]int
+	other  int
+}
+
+// collectStats reads the input to profile every column and build the
+// requested histograms. Numeric columns with too many distinct values to
+// count in memory are bucketed in a second pass once their range is known.
+func collectStats(config StatsConfig) (*StatsReport, error) {
+	file, err := openInput(config.InputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, delimiter := newInspectReader(file, config.Delimiter)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, withExitCode(exitParse, fmt.Errorf("input file is empty"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	report := &StatsReport{
+		Input:     config.InputPath,
+		Delimiter: string(delimiter),
+		Columns:   make([]ColumnStats, len(header)),
+	}
+	for i, name := range header {
+		report.Columns[i].Name = name
+	}
+
+	histograms := make([]*histogramState, len(config.Histograms))
+	for i, column := range config.Histograms {
+		index := columnIndex(header, column)
+		if index < 0 {
+			return nil, configErrorf("histogram column %q is not in the header", column)
+		}
+		histograms[i] = &histogramState{index: index, counts: make(map[string]int)}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading record at line %d: %w", report.Records+2, err)
+		}
+		report.Records++
+
+		for i := range report.Columns {
+			value := ""
+			if i < len(record) {
+				value = record[i]
+			}
+			report.Columns[i].add(value)
+		}
+		for _, h := range histograms {
+			if h.index >= len(record) || record[h.index] == "" {
+				continue
+			}
+			value := record[h.index]
+			if _, ok := h.counts[value]; ok || len(h.counts) < maxDistinctValues {
+				h.counts[value]++
+			} else {
+				h.other++
+			}
+		}
+	}
+
+	for i := range report.Columns {
+		column := &report.Columns[i]
+		if column.Type == "" {
+			column.Type = "string"
+		}
+		if column.Type != "int" && column.Type != "float" {
+			column.Min, column.Max = nil, nil
+		}
+	}
+
+	for _, h := range histograms {
+		column := report.Columns[h.index]
+		histogram := Histogram{Column: column.Name, Empty: column.Empty}
+		if column.Min != nil && (column.Type == "int" || column.Type == "float") {
+			histogram.Kind = "numeric"
+			histogram.Buckets = numericBuckets(*column.Min, *column.Max, config.Buckets, column.Type == "int")
+			if h.other > 0 {
+				if err := rescanBuckets(config, delimiter, h.index, histogram.Buckets); err != nil {
+					return nil, err
+				}
+			} else {
+				for value, count := range h.counts {
+					addToBuckets(histogram.Buckets, value, count)
+				}
+			}
+		} else {
+			histogram.Kind = "categorical"
+			histogram.Values, histogram.Other = topValues(h.counts, config.Top)
+			histogram.Other += h.other
+			histogram.Distinct = len(h.counts)
+		}
+		report.Histograms = append(report.Histograms, histogram)
+	}
+
+	return report, nil
+}
+
+// add folds value into the column statistics
+func (c *ColumnStats) add(value string) {
+	if value == "" {
+		c.Empty++
+		return
+	}
+	c.NonEmpty++
+	if c.Type != "string" {
+		c.Type = mergeTypes(c.Type, inferValueType(value))
+	}
+	if c.Type != "int" && c.Type != "float" {
+		return
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	if c.Min == nil {
+		c.Min, c.Max = new(float64), new(float64)
+		*c.Min, *c.Max = n, n
+	}
+	*c.Min = math.Min(*c.Min, n)
+	*c.Max = math.Max(*c.Max, n)
+}
+
+// numericBuckets creates equal-width buckets spanning lo to hi. Integer
+// columns use whole-number bucket edges.
+func numericBuckets(lo, hi float64, buckets int, integer bool) []HistogramBucket {
+	width := (hi - lo) / float64(buckets)
+	if integer {
+		width = math.Ceil(width)
+	}
+	if width == 0 {
+		buckets, width = 1, 1
+	}
+
+	result := make([]HistogramBucket, buckets)
+	for i := range result {
+		result[i].Lower = lo + float64(i)*width
+		result[i].Upper = lo + float64(i+1)*width
+	}
+	result[buckets-1].Upper = math.Max(result[buckets-1].Upper, hi)
+	return result
+}
+
+// addToBuckets counts value count times in the bucket containing it
+func addToBuckets(buckets []HistogramBucket, value string, count int) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	width := buckets[0].Upper - buckets[0].Lower
+	i := min(max(int((n-buckets[0].Lower)/width), 0), len(buckets)-1)
+	buckets[i].Count += count
+}
+
+// rescanBuckets fills buckets from the values of column index in a second
+// pass over the input, used when a column had too many distinct values to
+// count in memory
+func rescanBuckets(config StatsConfig, delimiter rune, index int, buckets []HistogramBucket) error {
+	file, err := openInput(config.InputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, _ := newInspectReader(file, delimiter)
+	if _, err := reader.Read(); err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", line, err)
+		}
+		if index < len(record) && record[index] != "" {
+			addToBuckets(buckets, record[index], 1)
+		}
+	}
+}
+
+// topValues returns the n most frequent values, most frequent first, and the
+// total count of the remaining values
+func topValues(counts map[string]int, n int) ([]ValueCount, int) {
+	values := make([]ValueCount, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+
+	other := 0
+	if len(values) > n {
+		for _, v := range values[n:] {
+			other += v.Count
+		}
+		values = values[:n]
+	}
+	return values, other
+}
+
+// columnIndex returns the position of column in header, matching names
+// exactly and then case-insensitively, or -1 if it is absent
+func columnIndex(header []string, column string) int {
+	for i, name := range header {
+		if name == column {
+			return i
+		}
+	}
+	for i, name := range header {
+		if strings.EqualFold(name, column) {
+			return i
+		}
+	}
+	return -1
+}
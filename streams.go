@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// routeRule is a -route rule: records whose column matches pattern go to the
+// stream called name
+type routeRule struct {
+	column  string
+	pattern *regexp.Regexp
+	name    string
+	index   int
+}
+
+// parseRoute parses a -route rule of the form column:pattern=>name. The
+// column ends at the first colon and the name starts after the last =>, so
+// the pattern may contain either.
+func parseRoute(value string) (routeRule, error) {
+	column, rest, ok := strings.Cut(value, ":")
+	arrow := strings.LastIndex(rest, "=>")
+	if !ok || arrow < 0 {
+		return routeRule{}, fmt.Errorf("invalid route %q: must be column:pattern=>name", value)
+	}
+	rule := routeRule{column: strings.TrimSpace(column), name: strings.TrimSpace(rest[arrow+2:])}
+	if rule.column == "" {
+		return routeRule{}, fmt.Errorf("invalid route %q: no column", value)
+	}
+	if rule.name == "" || strings.ContainsAny(rule.name, `/\`) {
+		return routeRule{}, fmt.Errorf("invalid route %q: the name must be usable in file names", value)
+	}
+	var err error
+	if rule.pattern, err = regexp.Compile(rest[:arrow]); err != nil {
+		return routeRule{}, fmt.Errorf("invalid route %q: %v", value, err)
+	}
+	return rule, nil
+}
+
+// checkRoute checks the syntax of a -route rule
+func checkRoute(value string) error {
+	_, err := parseRoute(value)
+	return err
+}
+
+// streamConfig returns the configuration of the parts of the stream called
+// name, which are numbered after the prefix output_name_
+func streamConfig(config Config, name string) Config {
+	config.OutputPrefix += "_" + name
+	return config
+}
+
+// validateRoutesConfig checks the -route rules, and the parts of their
+// streams that a previous run left behind, as prepareOutputDir does
+func validateRoutesConfig(config Config) error {
+	if mode, routed := routedMode(config); routed {
+		return fmt.Errorf("route cannot be combined with %s", mode)
+	}
+	options := []struct {
+		set  bool
+		name string
+	}{
+		{config.Shuffle, "shuffle"},
+		{config.BagIt, "bagit"},
+		{config.AlignWith != "", "align-with"},
+		{config.Deadline != 0, "deadline"},
+		{config.Resume, "resume"},
+		{config.FilterMode, "filter-mode"},
+	}
+	for _, option := range options {
+		if option.set {
+			return fmt.Errorf("%s cannot be combined with route", option.name)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, value := range config.Routes {
+		rule, err := parseRoute(value)
+		if err != nil {
+			return err
+		}
+		if seen[rule.name] {
+			continue
+		}
+		seen[rule.name] = true
+		if err := prepareOutputDir(streamConfig(config, rule.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordStreams sends the records matching a -route rule to the parts of
+// its stream, each split by -limit on its own. The streams are started as
+// their first record comes; records matching no rule stay in the main parts.
+type recordStreams struct {
+	s       *CSVSplitter
+	header  []string
+	rules   []routeRule
+	streams map[string]*CSVSplitter
+	// order is the streams in the order they were started
+	order []*CSVSplitter
+	// spill holds the records of the streams -priority puts last
+	spill *prioritySpill
+}
+
+// newRecordStreams resolves the -route rules against header
+func (s *CSVSplitter) newRecordStreams(header []string) (*recordStreams, error) {
+	r := &recordStreams{s: s, header: header, streams: map[string]*CSVSplitter{}, spill: s.newPrioritySpill()}
+	for _, value := range s.config.Routes {
+		rule, err := parseRoute(value)
+		if err != nil {
+			return nil, err
+		}
+		if rule.index = columnIndex(header, rule.column); rule.index < 0 {
+			return nil, configErrorf("route column %q not found in header", rule.column)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	if s.logger != nil {
+		s.logger.Info("routing records", "routes", strings.Join(s.config.Routes, " "))
+	} else if s.config.Verbose {
+		fmt.Printf("Routing records by %d rules\n", len(r.rules))
+	}
+	return r, nil
+}
+
+// route returns the name of the stream of record, or an empty name if no
+// rule matches it. The first matching rule wins.
+func (r *recordStreams) route(record []string) string {
+	for _, rule := range r.rules {
+		if rule.index < len(record) && rule.pattern.MatchString(record[rule.index]) {
+			return rule.name
+		}
+	}
+	return ""
+}
+
+// add writes record to the stream called name, or holds it back when
+// -priority puts the stream last
+func (r *recordStreams) add(name string, record []string) error {
+	if r.spill.deferred(name) {
+		return r.spill.add(name, r.s.recordsRead, record)
+	}
+	return r.write(name, r.s.recordsRead, record)
+}
+
+// start returns the stream called name, starting it with record number, the
+// first of its records, if needed
+func (r *recordStreams) start(name string, number int) (*CSVSplitter, error) {
+	if stream := r.streams[name]; stream != nil {
+		return stream, nil
+	}
+	s := r.s
+	stream := &CSVSplitter{
+		config:     streamConfig(s.config, name),
+		partNumber: 1,
+		logger:     s.logger,
+		metrics:    s.metrics,
+		limits:     s.limits,
+		keys:       s.keys,
+		groups:     s.groups,
+		lineage:    s.lineage,
+		partDone:   s.partDone,
+		notifier:   s.notifier,
+		fs:         s.fs,
+		fsName:     s.fsName,
+		recorder:   s.recorder,
+		recipients: s.recipients,
+		signer:     s.signer,
+		raw:        s.raw,
+	}
+	stream.recordsRead = number
+	if err := stream.createNewFile(r.header); err != nil {
+		return nil, err
+	}
+	r.streams[name] = stream
+	r.order = append(r.order, stream)
+	return stream, nil
+}
+
+// write writes record, the given input record, to the stream called name,
+// counting it as written by the run
+func (r *recordStreams) write(name string, number int, record []string) error {
+	stream, err := r.start(name, number)
+	if err != nil {
+		return err
+	}
+	stream.recordsRead = number
+	if err := stream.writeRecord(r.header, record); err != nil {
+		return err
+	}
+	r.s.stats.written++
+	return nil
+}
+
+// close completes the current part of every stream, adding the parts of the
+// streams to the parts of the run. With -priority, the streams it names are
+// completed first, in its order, and the records held back are then written
+// to the others.
+func (r *recordStreams) close() error {
+	done := map[*CSVSplitter]bool{}
+	finish := func(stream *CSVSplitter) error {
+		done[stream] = true
+		if err := stream.closeCurrentFile(); err != nil {
+			return err
+		}
+		r.s.parts = append(r.s.parts, stream.parts...)
+		return nil
+	}
+	for _, name := range r.spill.priorities() {
+		if stream := r.streams[name]; stream != nil {
+			if err := finish(stream); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.spill.each(r.write); err != nil {
+		return err
+	}
+	for _, stream := range r.order {
+		if !done[stream] {
+			if err := finish(stream); err != nil {
+				return err
+			}
+		}
+	}
+	r.order = nil
+	return nil
+}
+
+// discard removes the current part of every stream, keeping those completed
+func (r *recordStreams) discard() {
+	r.spill.Close()
+	for _, stream := range r.order {
+		stream.discardCurrentFile()
+		r.s.parts = append(r.s.parts, stream.parts...)
+	}
+	r.order = nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConfig returns the configuration of a split run with args
+func testConfig(t *testing.T, args ...string) Config {
+	t.Helper()
+	config := Config{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestValidateRoutesConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     func(t *testing.T) string
+		stale   []string
+		force   bool
+		wantErr bool
+	}{
+		{
+			name: "new directory",
+			dir:  func(t *testing.T) string { return filepath.Join(t.TempDir(), "new") },
+		},
+		{
+			name: "nested new directory",
+			dir:  func(t *testing.T) string { return filepath.Join(t.TempDir(), "a", "b") },
+		},
+		{
+			name: "empty directory",
+			dir:  func(t *testing.T) string { return t.TempDir() },
+		},
+		{
+			name:    "stale stream part",
+			dir:     func(t *testing.T) string { return t.TempDir() },
+			stale:   []string{"output_us_1.csv"},
+			wantErr: true,
+		},
+		{
+			name:  "stale stream part with force",
+			dir:   func(t *testing.T) string { return t.TempDir() },
+			stale: []string{"output_us_1.csv"},
+			force: true,
+		},
+		{
+			name:  "other files",
+			dir:   func(t *testing.T) string { return t.TempDir() },
+			stale: []string{"notes.txt", "other_1.csv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tt.dir(t)
+			for _, name := range tt.stale {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("id\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			args := []string{"-dir", dir, "-route", "country:^US$=>us", "-route", "country:^DE$=>de"}
+			if tt.force {
+				args = append(args, "-force")
+			}
+			err := validateRoutesConfig(testConfig(t, args...))
+			if tt.wantErr && err == nil {
+				t.Fatal("validateRoutesConfig succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("validateRoutesConfig = %v, want no error", err)
+			}
+		})
+	}
+}
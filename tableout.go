@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"strings"
+)
+
+// markdownReplacer escapes characters that would break a Markdown table cell
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"<", "&lt;",
+	">", "&gt;",
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// markdownWriter writes records as a GitHub-flavored Markdown table
+type markdownWriter struct {
+	writer *bufio.Writer
+}
+
+func newMarkdownWriter(w io.Writer) *markdownWriter {
+	return &markdownWriter{writer: bufio.NewWriter(w)}
+}
+
+func (m *markdownWriter) WriteHeader(header []string) error {
+	if err := m.Write(header); err != nil {
+		return err
+	}
+	m.writer.WriteString("|")
+	for range header {
+		m.writer.WriteString(" --- |")
+	}
+	_, err := m.writer.WriteString("\n")
+	return err
+}
+
+func (m *markdownWriter) Write(record []string) error {
+	m.writer.WriteString("|")
+	for _, field := range record {
+		m.writer.WriteString(" ")
+		m.writer.WriteString(markdownReplacer.Replace(field))
+		m.writer.WriteString(" |")
+	}
+	_, err := m.writer.WriteString("\n")
+	return err
+}
+
+func (m *markdownWriter) Close() error {
+	return m.writer.Flush()
+}
+
+// htmlWriter writes records as an HTML table fragment
+type htmlWriter struct {
+	writer  *bufio.Writer
+	started bool
+}
+
+func newHTMLWriter(w io.Writer) *htmlWriter {
+	return &htmlWriter{writer: bufio.NewWriter(w)}
+}
+
+func (h *htmlWriter) WriteHeader(header []string) error {
+	h.writer.WriteString("<table>\n<thead>\n")
+	h.writeRow("th", header)
+	_, err := h.writer.WriteString("</thead>\n<tbody>\n")
+	h.started = true
+	return err
+}
+
+func (h *htmlWriter) Write(record []string) error {
+	return h.writeRow("td", record)
+}
+
+// writeRow writes a table row whose cells use the given tag
+func (h *htmlWriter) writeRow(tag string, fields []string) error {
+	h.writer.WriteString("<tr>")
+	for _, field := range fields {
+		h.writer.WriteString("<" + tag + ">")
+		h.writer.WriteString(html.EscapeString(field))
+		h.writer.WriteString("</" + tag + ">")
+	}
+	_, err := h.writer.WriteString("</tr>\n")
+	return err
+}
+
+func (h *htmlWriter) Close() error {
+	if h.started {
+		h.writer.WriteString("</tbody>\n</table>\n")
+		h.started = false
+	}
+	return h.writer.Flush()
+}
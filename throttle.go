@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateValue is a flag value holding a rate in bytes per second, given as a
+// size with an optional /s, such as 50MB/s
+type rateValue int64
+
+func (r *rateValue) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return formatByteSize(int64(*r)) + "/s"
+}
+
+func (r *rateValue) Set(value string) error {
+	size, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil || size == 0 {
+		return fmt.Errorf("invalid rate %q: must be a size per second such as 50MB/s", value)
+	}
+	*r = rateValue(size)
+	return nil
+}
+
+// throttleBurst is how far behind its rate a rateLimiter may fall, after a
+// pause, and then catch up at full speed
+const throttleBurst = time.Second
+
+// rateLimiter holds a stream of bytes to a rate by sleeping once it gets
+// ahead of it. It is shared by every part of a run, and by the goroutines
+// of routed splits.
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  float64
+	start time.Time
+	bytes int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: float64(rate), start: time.Now()}
+}
+
+// wait counts n more bytes, sleeping until the rate allows them
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	due := l.start.Add(time.Duration(float64(l.bytes) / l.rate * float64(time.Second)))
+	if now.Sub(due) > throttleBurst {
+		// Do not let an idle stretch be made up for in a single burst
+		l.start, l.bytes = now.Add(-throttleBurst), 0
+	}
+	l.bytes += int64(n)
+	due = l.start.Add(time.Duration(float64(l.bytes) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	if delay := time.Until(due); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// ioLimits are the rate limiters of -max-throughput, one for reading the
+// input and one for writing the parts
+type ioLimits struct {
+	read  *rateLimiter
+	write *rateLimiter
+}
+
+// newIOLimits returns the limits of config, or nil without -max-throughput
+func newIOLimits(config Config) *ioLimits {
+	if config.MaxThroughput == 0 {
+		return nil
+	}
+	return &ioLimits{
+		read:  newRateLimiter(int64(config.MaxThroughput)),
+		write: newRateLimiter(int64(config.MaxThroughput)),
+	}
+}
+
+// throttledReader reads at the rate of its limiter
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
+
+// throttledWriter writes at the rate of its limiter
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.limiter.wait(len(p))
+	return t.w.Write(p)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeGranularities maps each -time-granularity to the Go layout of the names
+// of its outputs. Weeks are ISO weeks, which timeWindow names itself.
+var timeGranularities = map[string]string{
+	"day":   "2006-01-02",
+	"week":  "2006-W01",
+	"month": "2006-01",
+}
+
+// timeWindow finds the period of each record of a -time-column split
+type timeWindow struct {
+	column      int
+	layout      string
+	granularity string
+	// name is the name of the period of the last record parsed
+	name string
+}
+
+// validateTimeConfig checks -time-column, -time-granularity, and -time-layout
+func validateTimeConfig(config Config) error {
+	if strings.TrimSpace(config.TimeColumn) == "" {
+		return fmt.Errorf("time-column must name a column")
+	}
+	if _, ok := timeGranularities[config.TimeGranularity]; !ok {
+		return fmt.Errorf("invalid time-granularity '%s': must be day, week, or month", config.TimeGranularity)
+	}
+	if config.TimeLayout == "" {
+		return fmt.Errorf("time-layout must be a Go time layout, unix, or unixms")
+	}
+	return nil
+}
+
+// periodName returns the name of the output that t belongs to
+func periodName(t time.Time, granularity string) string {
+	if granularity == "week" {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	}
+	return t.Format(timeGranularities[granularity])
+}
+
+// isPeriodName reports whether name is the name of an output of granularity
+func isPeriodName(name, granularity string) bool {
+	if granularity == "week" {
+		year, week, ok := strings.Cut(name, "-W")
+		_, yerr := strconv.Atoi(year)
+		w, werr := strconv.Atoi(week)
+		return ok && len(year) == 4 && yerr == nil && werr == nil && w >= 1 && w <= 53
+	}
+	_, err := time.Parse(timeGranularities[granularity], name)
+	return err == nil
+}
+
+// staleTimeOutputs returns the outputs a previous -time-column run with the
+// same prefix and granularity left in the output directory
+func staleTimeOutputs(config Config) ([]string, error) {
+	entries, err := os.ReadDir(config.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+	prefix := config.OutputPrefix + "_"
+	ext := "." + partExtension(config)
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if isPeriodName(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), config.TimeGranularity) {
+			stale = append(stale, filepath.Join(config.OutputDir, name))
+		}
+	}
+	return stale, nil
+}
+
+// newTimeWindow returns the time window of a -time-column split with header
+func (s *CSVSplitter) newTimeWindow(header []string) (*timeWindow, error) {
+	column := columnIndex(header, strings.TrimSpace(s.config.TimeColumn))
+	if column < 0 {
+		return nil, fmt.Errorf("time column '%s' not found in header", s.config.TimeColumn)
+	}
+	if s.logger != nil {
+		s.logger.Info("time-window split", "column", s.config.TimeColumn, "granularity", s.config.TimeGranularity)
+	} else if s.config.Verbose {
+		fmt.Printf("Splitting by %s of %s\n", s.config.TimeGranularity, s.config.TimeColumn)
+	}
+	return &timeWindow{column: column, layout: s.config.TimeLayout, granularity: s.config.TimeGranularity}, nil
+}
+
+// parse finds the period of record. Times without a zone are taken as UTC;
+// others fall in the period of their own offset.
+func (w *timeWindow) parse(record []string) error {
+	value := ""
+	if w.column < len(record) {
+		value = strings.TrimSpace(record[w.column])
+	}
+	var t time.Time
+	var err error
+	switch w.layout {
+	case "unix", "unixms":
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		if w.layout == "unix" {
+			t = time.Unix(n, 0).UTC()
+		} else {
+			t = time.UnixMilli(n).UTC()
+		}
+	default:
+		t, err = time.Parse(w.layout, value)
+	}
+	if err != nil {
+		return fmt.Errorf("time does not match layout %s", w.layout)
+	}
+	w.name = periodName(t, w.granularity)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// fieldTransform rewrites the values of one column of the records
+type fieldTransform struct {
+	column int
+	apply  func(value string) (string, error)
+}
+
+// validateTransformConfig checks the options of the field transforms
+func validateTransformConfig(config Config) error {
+	switch config.NormalizeCase {
+	case "", "upper", "lower":
+	default:
+		return fmt.Errorf("invalid normalize-case '%s': must be upper or lower", config.NormalizeCase)
+	}
+	if _, err := parseTypes(config.Types); err != nil {
+		return err
+	}
+	if config.Join != "" {
+		if _, err := parseJoin(config.Join); err != nil {
+			return err
+		}
+	}
+	normalizing := config.TrimFields || config.CollapseWhitespace || config.NormalizeCase != ""
+	if config.NormalizeColumns != "" && !normalizing {
+		return fmt.Errorf("normalize-columns needs -trim-fields, -collapse-whitespace, or -normalize-case")
+	}
+	return nil
+}
+
+// transformColumns resolves a comma-separated list of columns against
+// header, or returns every column when the list is empty
+func transformColumns(header []string, value string) ([]int, error) {
+	if value == "" {
+		columns := make([]int, len(header))
+		for i := range columns {
+			columns[i] = i
+		}
+		return columns, nil
+	}
+	var columns []int
+	for _, name := range parseKeyColumns(value) {
+		i := columnIndex(header, name)
+		if i < 0 {
+			return nil, configErrorf("column %q not found in header", name)
+		}
+		columns = append(columns, i)
+	}
+	return columns, nil
+}
+
+// newTransforms returns the transforms configured for the columns of
+// header, in the order they apply: nulls first, then whitespace, then case,
+// then replacements, then dates
+func (s *CSVSplitter) newTransforms(header []string) ([]fieldTransform, error) {
+	normalize, err := s.normalizeTransforms(header)
+	if err != nil {
+		return nil, err
+	}
+	transforms := append(s.nullTransforms(header), normalize...)
+	replaces, err := s.newReplaces(header)
+	if err != nil {
+		return nil, err
+	}
+	transforms = append(transforms, replaces...)
+	for _, value := range s.config.ReparseDates {
+		rule, err := parseDateRule(value)
+		if err != nil {
+			return nil, err
+		}
+		column := columnIndex(header, rule.column)
+		if column < 0 {
+			return nil, configErrorf("reparse-date column %q not found in header", rule.column)
+		}
+		transforms = append(transforms, fieldTransform{column, rule.apply})
+	}
+	return transforms, nil
+}
+
+// normalizeTransforms returns the transforms of -trim-fields,
+// -collapse-whitespace, and -normalize-case
+func (s *CSVSplitter) normalizeTransforms(header []string) ([]fieldTransform, error) {
+	var steps []func(string) string
+	if s.config.TrimFields {
+		steps = append(steps, strings.TrimSpace)
+	}
+	if s.config.CollapseWhitespace {
+		steps = append(steps, collapseWhitespace)
+	}
+	switch s.config.NormalizeCase {
+	case "upper":
+		steps = append(steps, strings.ToUpper)
+	case "lower":
+		steps = append(steps, strings.ToLower)
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	columns, err := transformColumns(header, s.config.NormalizeColumns)
+	if err != nil {
+		return nil, err
+	}
+	normalize := func(value string) (string, error) {
+		for _, step := range steps {
+			value = step(value)
+		}
+		return value, nil
+	}
+	transforms := make([]fieldTransform, len(columns))
+	for i, column := range columns {
+		transforms[i] = fieldTransform{column, normalize}
+	}
+	return transforms, nil
+}
+
+// transform returns record with transforms applied, leaving record itself
+// unchanged. An error is located at the field that failed, of the fields
+// the record was read with.
+func (s *CSVSplitter) transform(transforms []fieldTransform, fields int, record []string) ([]string, error) {
+	out, column, err := applyTransforms(transforms, record)
+	if err != nil {
+		return nil, s.fieldError(fields, column, err)
+	}
+	return out, nil
+}
+
+// applyTransforms returns record with transforms applied, leaving record
+// itself unchanged, or the error of the column that failed. It does not
+// touch the splitter, so -workers can run it on many records at once.
+func applyTransforms(transforms []fieldTransform, record []string) ([]string, int, error) {
+	out := slices.Clone(record)
+	for _, t := range transforms {
+		if t.column >= len(out) {
+			continue
+		}
+		value, err := t.apply(out[t.column])
+		if err != nil {
+			return nil, t.column, err
+		}
+		out[t.column] = value
+	}
+	return out, 0, nil
+}
+
+// collapseWhitespace replaces every run of whitespace in value with a
+// single space
+func collapseWhitespace(value string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range value {
+		if unicode.IsSpace(r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
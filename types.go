@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// typeCoercions are the types of -types, each parsing a value and returning
+// it in the canonical form of the type
+var typeCoercions = map[string]func(value string) (string, bool){
+	"int":      coerceInt,
+	"decimal":  coerceDecimal,
+	"bool":     coerceBool,
+	"date":     coerceDate,
+	"datetime": coerceDatetime,
+}
+
+// typeHint is a column of -types with its type
+type typeHint struct {
+	column string
+	typ    string
+}
+
+// typeViolation is the error returned for a value that does not parse as
+// the type of its column
+type typeViolation struct {
+	Column string
+	Type   string
+	Value  string
+}
+
+func (v *typeViolation) Error() string {
+	return fmt.Sprintf("column %q: %q is not a valid %s", v.Column, v.Value, v.Type)
+}
+
+// parseTypes parses a -types list of the form column:type,column:type
+func parseTypes(value string) ([]typeHint, error) {
+	var hints []typeHint
+	for _, item := range parseKeyColumns(value) {
+		column, typ, ok := strings.Cut(item, ":")
+		column, typ = strings.TrimSpace(column), strings.TrimSpace(typ)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid types entry %q: must be column:type", item)
+		}
+		if _, ok := typeCoercions[typ]; !ok {
+			return nil, fmt.Errorf("invalid types entry %q: type must be int, decimal, bool, date, or datetime", item)
+		}
+		hints = append(hints, typeHint{column, typ})
+	}
+	return hints, nil
+}
+
+// newTypes returns the coercions of -types for the columns of header. Empty
+// values stay empty.
+func (s *CSVSplitter) newTypes(header []string) ([]fieldTransform, error) {
+	hints, err := parseTypes(s.config.Types)
+	if err != nil {
+		return nil, err
+	}
+	var coercions []fieldTransform
+	for _, hint := range hints {
+		column := columnIndex(header, hint.column)
+		if column < 0 {
+			return nil, configErrorf("types column %q not found in header", hint.column)
+		}
+		coerce := typeCoercions[hint.typ]
+		coercions = append(coercions, fieldTransform{column, func(value string) (string, error) {
+			trimmed := strings.TrimSpace(value)
+			if trimmed == "" {
+				return "", nil
+			}
+			canonical, ok := coerce(trimmed)
+			if !ok {
+				return "", &typeViolation{Column: header[column], Type: hint.typ, Value: value}
+			}
+			return canonical, nil
+		}})
+	}
+	return coercions, nil
+}
+
+// countTypeFailure counts err against its column if it is a typeViolation
+func (s *CSVSplitter) countTypeFailure(err error) {
+	var violation *typeViolation
+	if !errors.As(err, &violation) {
+		return
+	}
+	if s.stats.typeFailures == nil {
+		s.stats.typeFailures = make(map[string]int)
+	}
+	s.stats.typeFailures[violation.Column]++
+}
+
+// typeFailureSummary lists the per-column type failures of the run, as in
+// "amount 3, qty 1"
+func (s *CSVSplitter) typeFailureSummary() string {
+	columns := make([]string, 0, len(s.stats.typeFailures))
+	for column := range s.stats.typeFailures {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for i, column := range columns {
+		columns[i] = fmt.Sprintf("%s %d", column, s.stats.typeFailures[column])
+	}
+	return strings.Join(columns, ", ")
+}
+
+// coerceInt returns value as a base 10 integer without a plus sign or
+// leading zeros
+func coerceInt(value string) (string, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(n, 10), true
+}
+
+// coerceDecimal returns value as a decimal number without a plus sign or
+// leading zeros. The digits after the point are kept as written, since they
+// may carry the scale of the value, and no precision is lost to floats.
+func coerceDecimal(value string) (string, bool) {
+	sign := ""
+	if rest, ok := strings.CutPrefix(value, "-"); ok {
+		sign, value = "-", rest
+	} else {
+		value = strings.TrimPrefix(value, "+")
+	}
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return "", false
+	}
+	if whole = strings.TrimLeft(whole, "0"); whole == "" {
+		whole = "0"
+	}
+	if whole == "0" && strings.Trim(frac, "0") == "" {
+		sign = ""
+	}
+	if frac == "" {
+		return sign + whole, true
+	}
+	return sign + whole + "." + frac, true
+}
+
+// isDigits reports whether s holds only ASCII digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// coerceBool returns value as true or false, accepting the spellings of
+// strconv.ParseBool and yes or no
+func coerceBool(value string) (string, bool) {
+	switch strings.ToLower(value) {
+	case "yes", "y":
+		return "true", true
+	case "no", "n":
+		return "false", true
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatBool(b), true
+}
+
+// coerceDate returns value, written in one of the layouts that inference
+// takes for dates, as a YYYY-MM-DD date
+func coerceDate(value string) (string, bool) {
+	return coerceTime(value, dateLayouts)
+}
+
+// coerceDatetime returns value, written in one of the layouts that inference
+// takes for datetimes, as an RFC 3339 time. Times without a zone are taken as
+// UTC.
+func coerceDatetime(value string) (string, bool) {
+	return coerceTime(value, datetimeLayouts)
+}
+
+// coerceTime parses value with the first of layouts that matches, and
+// formats it with the first layout
+func coerceTime(value string, layouts []string) (string, bool) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(layouts[0]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Validator checks the records of a split, in input order, after they are
+// parsed and cleaned and before they are written. line is the line number
+// reported for the record elsewhere, its record number plus one for the
+// header. A non-nil error fails the record, with the consequence set by the
+// FailurePolicy the validator was added with.
+type Validator interface {
+	Validate(line int, record []string) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface
+type ValidatorFunc func(line int, record []string) error
+
+// Validate calls f(line, record)
+func (f ValidatorFunc) Validate(line int, record []string) error {
+	return f(line, record)
+}
+
+// FailurePolicy decides what happens to a record that a Validator fails
+type FailurePolicy int
+
+const (
+	// RejectRecord writes the record to the rejects file and goes on with
+	// the next record, as -schema does
+	RejectRecord FailurePolicy = iota
+	// SkipRecord drops the record, counting it as skipped
+	SkipRecord
+	// WarnRecord logs the failure and keeps the record, which the next
+	// validators still check
+	WarnRecord
+	// FailRun stops the split with the error
+	FailRun
+)
+
+// chainedValidator is a validator in the chain of a splitter
+type chainedValidator struct {
+	validator Validator
+	policy    FailurePolicy
+}
+
+// AddValidator appends v to the chain of validators run on every record. The
+// -schema checks come first, then the validators in the order they were
+// added; the first one to fail a record decides its fate by its policy, and
+// the following ones do not see it unless the policy is WarnRecord. It must
+// be called before the split starts.
+func (s *CSVSplitter) AddValidator(v Validator, policy FailurePolicy) {
+	s.validators = append(s.validators, chainedValidator{v, policy})
+}
+
+// schemaChain returns the chain of validators of a split, starting with the
+// -schema checks, which tally their rejections for the validation summary
+func (s *CSVSplitter) schemaChain() []chainedValidator {
+	if s.validator == nil {
+		return s.validators
+	}
+	schema := ValidatorFunc(func(line int, record []string) error {
+		err := s.validator.Validate(record)
+		if err != nil {
+			s.validator.Tally(filepath.Base(s.outPath), err)
+		}
+		return err
+	})
+	return append([]chainedValidator{{schema, RejectRecord}}, s.validators...)
+}
+
+// validate runs the chain of validators on record, which was read with the
+// given number of fields, and reports whether it is to be written
+func (s *CSVSplitter) validate(chain []chainedValidator, line, fields int, header, record []string) (bool, error) {
+	for _, c := range chain {
+		err := c.validator.Validate(line, record)
+		if err == nil {
+			continue
+		}
+		// Warnings and failures quote the value as the rejects file does
+		s.maskReason(err)
+		field := 0
+		var violation *schemaViolation
+		if errors.As(err, &violation) {
+			field = columnIndex(header, violation.Column)
+		}
+		err = s.fieldError(fields, field, err)
+
+		switch c.policy {
+		case WarnRecord:
+			s.addIssue(line, "warned", reasonText(err), errorLocation(err))
+			s.record(sessionEvent{Event: "warn", Line: line, Reason: reasonKey(err)})
+			if s.logger != nil {
+				s.logger.Warn("record failed validation", "line", line, "reason", reasonText(err), "location", errorLocation(err))
+			} else if s.config.Verbose {
+				fmt.Printf("Warning: line %d: %v\n", line, err)
+			}
+		case SkipRecord:
+			s.skip(line, reasonKey(err))
+			return false, nil
+		case FailRun:
+			return false, fmt.Errorf("record at line %d failed validation: %w", line, err)
+		default:
+			return false, s.reject(line, record, err)
+		}
+	}
+	return true, nil
+}
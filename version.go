@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// The version, commit, and build date of the binary, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=... -X main.date=...". Left
+// empty, they are taken from the module version and VCS stamps Go records
+// in the binary.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// BuildInfo describes the build of the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// buildInfo returns the version of the binary and how it was built. Parts
+// that were neither set at build time nor recorded by Go are "unknown".
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	for _, value := range []*string{&info.Version, &info.Commit, &info.Date} {
+		if *value == "" {
+			*value = "unknown"
+		}
+	}
+	return info
+}
+
+// printVersion writes the build information of the binary to w, as a JSON
+// object with -json
+func printVersion(w io.Writer, asJSON bool) error {
+	info := buildInfo()
+	if asJSON {
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+	modified := ""
+	if info.Modified {
+		modified = " (modified)"
+	}
+	_, err := fmt.Fprintf(w, "csvplit %s\ncommit: %s%s\ndate: %s\ngo: %s %s\n",
+		info.Version, info.Commit, modified, info.Date, info.GoVersion, info.Platform)
+	return err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences used by the view subcommand
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1;36m"
+	ansiDim   = "\x1b[2m"
+)
+
+// ViewConfig holds the configuration for the view subcommand
+type ViewConfig struct {
+	InputPath string
+	Rows      int
+	MaxWidth  int
+	Delimiter rune
+	Color     string
+}
+
+// runView implements the view subcommand, which prints the first rows of a
+// CSV file as an aligned table
+func runView(args []string) error {
+	config := ViewConfig{}
+	fs := flag.NewFlagSet("view", flag.ExitOnError)
+	fs.StringVar(&config.InputPath, "input", "", "Path to the input CSV file (or pass it as an argument)")
+	fs.StringVar(&config.InputPath, "i", "", "Path to the input CSV file (shorthand)")
+	fs.IntVar(&config.Rows, "n", 20, "Number of data rows to show")
+	fs.IntVar(&config.MaxWidth, "max-width", 30, "Truncate columns wider than this many characters")
+	fs.Var((*runeValue)(&config.Delimiter), "delimiter", "CSV delimiter character (default: detected)")
+	fs.StringVar(&config.Color, "color", "auto", "Colorize output: auto, always, or never")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s view [options] [file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Print the first rows of a CSV file as a column-aligned table.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if config.InputPath == "" && fs.NArg() > 0 {
+		config.InputPath = fs.Arg(0)
+	}
+	if config.InputPath == "" {
+		return configErrorf("input file path is required")
+	}
+	if config.Rows <= 0 {
+		return configErrorf("n must be greater than 0")
+	}
+	if config.MaxWidth < 2 {
+		return configErrorf("max-width must be at least 2")
+	}
+
+	color, err := useColor(config.Color)
+	if err != nil {
+		return err
+	}
+
+	file, err := openInput(config.InputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, _ := newInspectReader(file, config.Delimiter)
+
+	var rows [][]string
+	for len(rows) <= config.Rows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", len(rows)+1, err)
+		}
+		rows = append(rows, record)
+	}
+	if len(rows) == 0 {
+		return withExitCode(exitParse, fmt.Errorf("input file is empty"))
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	renderTable(out, rows, config.MaxWidth, color)
+	return nil
+}
+
+// useColor resolves the -color setting against the terminal and NO_COLOR
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, configErrorf("color must be auto, always, or never")
+}
+
+// renderTable writes rows as an aligned table whose first row is the header
+func renderTable(w io.Writer, rows [][]string, maxWidth int, color bool) {
+	cells := make([][]string, len(rows))
+	var widths []int
+	for i, row := range rows {
+		cells[i] = make([]string, len(row))
+		for j, field := range row {
+			cell := truncateCell(field, maxWidth)
+			cells[i][j] = cell
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[j] = max(widths[j], utf8.RuneCountInString(cell))
+		}
+	}
+
+	separator := make([]string, len(widths))
+	for j, width := range widths {
+		separator[j] = strings.Repeat("-", width)
+	}
+
+	for i, row := range cells {
+		line := formatRow(row, widths)
+		switch {
+		case i == 0 && color:
+			fmt.Fprintln(w, ansiBold+line+ansiReset)
+		case i%2 == 0 && color:
+			fmt.Fprintln(w, ansiDim+line+ansiReset)
+		default:
+			fmt.Fprintln(w, line)
+		}
+		if i == 0 {
+			fmt.Fprintln(w, formatRow(separator, widths))
+		}
+	}
+}
+
+// formatRow pads each cell to its column width and joins them with a gutter
+func formatRow(row []string, widths []int) string {
+	var b strings.Builder
+	for j, width := range widths {
+		if j > 0 {
+			b.WriteString(" | ")
+		}
+		cell := ""
+		if j < len(row) {
+			cell = row[j]
+		}
+		b.WriteString(cell)
+		if j < len(widths)-1 {
+			b.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(cell)))
+		}
+	}
+	return b.String()
+}
+
+// truncateCell flattens line breaks and shortens s to at most width
+// characters, marking truncation with an ellipsis
+func truncateCell(s string, width int) string {
+	s = strings.NewReplacer("\r\n", "↵", "\n", "↵", "\r", "↵", "\t", " ").Replace(s)
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
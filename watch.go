@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long a file in the watched directory must go without
+// changes before it is considered complete and split
+const watchSettle = 2 * time.Second
+
+// runWatch splits every CSV file that lands in config.WatchDir, including
+// those already there, until the process is stopped. Each file is split into
+// a directory of its own under config.OutputDir and then moved to the
+// archive directory, or to the failed directory if it could not be split.
+func runWatch(config Config) error {
+	logger := newLogger(config)
+	if config.InputPath != "" {
+		return reportError(logger, configErrorf("watch cannot be combined with input"))
+	}
+	if config.Resume {
+		return reportError(logger, configErrorf("watch cannot be combined with resume"))
+	}
+	if info, err := os.Stat(config.WatchDir); err != nil || !info.IsDir() {
+		return reportError(logger, withExitCode(exitInputNotFound,
+			fmt.Errorf("watch directory does not exist: %s", config.WatchDir)))
+	}
+	if config.ArchiveDir == "" {
+		config.ArchiveDir = filepath.Join(config.WatchDir, "processed")
+	}
+	if filepath.Clean(config.ArchiveDir) == filepath.Clean(config.WatchDir) {
+		return reportError(logger, configErrorf("archive directory must differ from the watched directory"))
+	}
+	failedDir := filepath.Join(config.WatchDir, "failed")
+	for _, dir := range []string{config.ArchiveDir, failedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return reportError(logger, fmt.Errorf("failed to create directory: %w", err))
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return reportError(logger, fmt.Errorf("failed to watch directory: %w", err))
+	}
+	defer watcher.Close()
+	if err := watcher.Add(config.WatchDir); err != nil {
+		return reportError(logger, withExitCode(exitInputNotFound,
+			fmt.Errorf("failed to watch directory '%s': %w", config.WatchDir, err)))
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// Files that landed while no watcher was running are split first
+	pending := map[string]time.Time{}
+	entries, err := os.ReadDir(config.WatchDir)
+	if err != nil {
+		return reportError(logger, fmt.Errorf("failed to read directory '%s': %w", config.WatchDir, err))
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isInputCandidate(entry.Name()) {
+			pending[filepath.Join(config.WatchDir, entry.Name())] = time.Time{}
+		}
+	}
+
+	if logger != nil {
+		logger.Info("watching directory", "dir", config.WatchDir, "archive", config.ArchiveDir)
+	} else if !config.JSON {
+		fmt.Printf("Watching %s for CSV files\n", config.WatchDir)
+	}
+
+	ticker := time.NewTicker(watchSettle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			switch {
+			case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+				delete(pending, event.Name)
+			case event.Has(fsnotify.Create) || event.Has(fsnotify.Write):
+				if isInputCandidate(filepath.Base(event.Name)) {
+					pending[event.Name] = time.Now()
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if logger != nil {
+				logger.Warn("watch error", "error", err.Error())
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		case <-ticker.C:
+			var ready []string
+			for path, changed := range pending {
+				if time.Since(changed) >= watchSettle {
+					ready = append(ready, path)
+				}
+			}
+			slices.Sort(ready)
+			for _, path := range ready {
+				delete(pending, path)
+				if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+					continue
+				}
+				if err := splitWatched(config, failedDir, path); err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// isInputCandidate reports whether a file name in a watched or -input-dir
+// directory is a CSV file to split. Hidden files, such as those of uploads in
+// progress, are skipped.
+func isInputCandidate(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") {
+		return false
+	}
+	return strings.EqualFold(filepath.Ext(name), ".csv")
+}
+
+// splitWatched splits a file from the watched directory and moves it out of
+// the way. Only invalid configuration, which would fail every file, ends the
+// watch with an error.
+func splitWatched(config Config, failedDir, path string) error {
+	logger := newLogger(config)
+	name := filepath.Base(path)
+	fileConfig := config
+	fileConfig.InputPath = path
+	fileConfig.OutputDir = filepath.Join(config.OutputDir, strings.TrimSuffix(name, filepath.Ext(name)))
+	fileConfig.Clean = true
+	if config.ReportPath != "" {
+		fileConfig.ReportPath = filepath.Join(fileConfig.OutputDir, filepath.Base(config.ReportPath))
+	}
+
+	err := runSplit(fileConfig, nil)
+	switch exitCode(err) {
+	case exitConfig:
+		return err
+	case exitInterrupted:
+		// The file stays in place and is split again on the next start
+		return nil
+	}
+
+	target := config.ArchiveDir
+	if err != nil && exitCode(err) != exitPartial {
+		target = failedDir
+	}
+	moved, merr := moveAside(path, target)
+	if merr != nil {
+		return reportError(logger, merr)
+	}
+	if logger != nil {
+		if target == failedDir {
+			logger.Error("file failed", "input", path, "moved_to", moved)
+		} else {
+			logger.Info("file processed", "input", path, "output_dir", fileConfig.OutputDir, "moved_to", moved)
+		}
+	} else if !config.JSON {
+		// With -json, the result of each file is its line of JSON
+		if target == failedDir {
+			fmt.Printf("Failed to split %s, moved it to %s\n", path, moved)
+		} else {
+			fmt.Printf("Split %s into %s, moved it to %s\n", path, fileConfig.OutputDir, moved)
+		}
+	}
+	return nil
+}
+
+// moveAside moves the file at path into dir, adding a timestamp to its name
+// if a file of that name is already there, and returns its new path
+func moveAside(path, dir string) (string, error) {
+	name := filepath.Base(path)
+	target := filepath.Join(dir, name)
+	if _, err := os.Lstat(target); err == nil {
+		ext := filepath.Ext(name)
+		target = filepath.Join(dir, strings.TrimSuffix(name, ext)+"-"+time.Now().Format("20060102T150405.000")+ext)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move '%s': %w", path, err)
+	}
+	return target, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"slices"
+)
+
+// widthPolicies are the values of -on-width-mismatch
+var widthPolicies = []string{"pad", "truncate", "reject", "keep"}
+
+// validateWidthConfig checks -on-width-mismatch, which replaces -strict and
+// -strict-action, and the fitting of records by -repair
+func validateWidthConfig(config Config) error {
+	if config.OnWidthMismatch == "" {
+		return nil
+	}
+	if !slices.Contains(widthPolicies, config.OnWidthMismatch) {
+		return fmt.Errorf("on-width-mismatch must be pad, truncate, reject, or keep")
+	}
+	if config.Strict {
+		return fmt.Errorf("on-width-mismatch cannot be combined with strict, whose strict-action it replaces")
+	}
+	if config.Repair {
+		return fmt.Errorf("on-width-mismatch cannot be combined with repair, which pads and truncates records itself")
+	}
+	return nil
+}
+
+// widthCounts counts the records of -on-width-mismatch whose field count
+// differs from the header's, by what was done with them
+type widthCounts struct {
+	padded    int
+	truncated int
+	rejected  int
+	kept      int
+}
+
+func (c *widthCounts) String() string {
+	return fmt.Sprintf("%d records padded, %d truncated, %d rejected, %d kept", c.padded, c.truncated, c.rejected, c.kept)
+}
+
+// counts returns the counters for the report and the -json result
+func (c *widthCounts) counts() map[string]int {
+	return map[string]int{"padded": c.padded, "truncated": c.truncated, "rejected": c.rejected, "kept": c.kept}
+}
+
+// fitWidth applies -on-width-mismatch to a record whose field count differs
+// from width: pad fills short records with empty fields and truncate cuts
+// long ones, rejecting the others, and keep writes the record as it is. A
+// rejected record is returned as an error with -on-error quarantine, and
+// dropped otherwise.
+func (s *CSVSplitter) fitWidth(record []string, width int) ([]string, error) {
+	switch {
+	case s.config.OnWidthMismatch == "keep":
+		s.widths.kept++
+		return record, nil
+	case s.config.OnWidthMismatch == "pad" && len(record) < width:
+		s.widths.padded++
+		padded := make([]string, width)
+		copy(padded, record)
+		return padded, nil
+	case s.config.OnWidthMismatch == "truncate" && len(record) > width:
+		s.widths.truncated++
+		return record[:width], nil
+	}
+	s.widths.rejected++
+	if s.config.OnError != "quarantine" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: record has %d fields, header has %d", csv.ErrFieldCount, len(record), width)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// wizardSampleRows is the number of rows inspected to infer column types
+const wizardSampleRows = 1000
+
+// prompter asks questions on an interactive terminal. When input runs out
+// every question takes its default answer.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask prompts for a free-form answer
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	if !p.in.Scan() {
+		fmt.Fprintln(p.out)
+		return def
+	}
+	if answer := strings.TrimSpace(p.in.Text()); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// askBool prompts for a yes/no answer
+func (p *prompter) askBool(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer := strings.ToLower(p.ask(question+" ("+hint+")", ""))
+		switch answer {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(p.out, "Please answer y or n.")
+	}
+}
+
+// askInt prompts for a positive integer
+func (p *prompter) askInt(question string, def int) int {
+	for {
+		n, err := strconv.Atoi(p.ask(question, strconv.Itoa(def)))
+		if err == nil && n > 0 {
+			return n
+		}
+		fmt.Fprintln(p.out, "Please enter a positive number.")
+	}
+}
+
+// askChoice prompts until the answer is one of choices
+func (p *prompter) askChoice(question string, choices []string, def string) string {
+	for {
+		answer := p.ask(question+" ("+strings.Join(choices, "/")+")", def)
+		for _, choice := range choices {
+			if answer == choice {
+				return answer
+			}
+		}
+		fmt.Fprintf(p.out, "Please choose one of: %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// askColumns prompts for comma-separated columns of header, given by their
+// number in the table of columns or by name. An empty answer picks none.
+func (p *prompter) askColumns(question string, header []string) []string {
+	for {
+		answer := p.ask(question+" (numbers or names, blank for none)", "")
+		if answer == "" {
+			return nil
+		}
+		var columns []string
+		for _, field := range strings.Split(answer, ",") {
+			field = strings.TrimSpace(field)
+			if n, err := strconv.Atoi(field); err == nil && n >= 1 && n <= len(header) {
+				field = header[n-1]
+			} else if columnIndex(header, field) < 0 {
+				fmt.Fprintf(p.out, "There is no column %q.\n", field)
+				columns = nil
+				break
+			}
+			if !slices.Contains(columns, field) {
+				columns = append(columns, field)
+			}
+		}
+		if columns != nil {
+			return columns
+		}
+	}
+}
+
+// wizardFormats returns the output formats offered by the wizard: every
+// registered one this build can write, csv first
+func wizardFormats() []string {
+	formats := []string{"csv"}
+	for _, format := range slices.Sorted(maps.Keys(outputFormats)) {
+		if format != "csv" && (format != "sqlite" || sqliteSupported) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// runWizard implements the wizard subcommand, which inspects an input file
+// and interactively builds the command line to split it
+func runWizard(args []string) error {
+	fs := flag.NewFlagSet("wizard", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s wizard [file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Inspect a CSV file and interactively build the command to split it.\n")
+	}
+	fs.Parse(args)
+
+	p := &prompter{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+
+	inputPath := fs.Arg(0)
+	for inputPath == "" {
+		inputPath = p.ask("Input CSV file", "")
+	}
+
+	file, err := openInput(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, delimiter := newInspectReader(file, 0)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return withExitCode(exitParse, fmt.Errorf("input file is empty"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+
+	var sample [][]string
+	for len(sample) < wizardSampleRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading record at line %d: %w", len(sample)+2, err)
+		}
+		sample = append(sample, record)
+	}
+	types := inferColumnTypes(sample, len(header))
+
+	fmt.Fprintf(p.out, "\nDetected delimiter: %q\n", delimiter)
+	fmt.Fprintf(p.out, "Detected %d columns (types inferred from %d rows):\n", len(header), len(sample))
+	rows := [][]string{{"#", "column", "type"}}
+	for i, name := range header {
+		rows = append(rows, []string{strconv.Itoa(i + 1), name, types[i]})
+	}
+	renderTable(p.out, rows, 40, false)
+	fmt.Fprintln(p.out)
+
+	options := [][2]string{{"input", inputPath}}
+	if delimiter != ',' {
+		options = append(options, [2]string{"delimiter", string(delimiter)})
+	}
+
+	limit := p.askInt("Records per output file", 10000)
+	options = append(options, [2]string{"limit", strconv.Itoa(limit)})
+
+	if prefix := p.ask("Output file prefix", "output"); prefix != "output" {
+		options = append(options, [2]string{"out", prefix})
+	}
+	if dir := p.ask("Output directory", "."); dir != "." {
+		options = append(options, [2]string{"dir", dir})
+	}
+	if columns := p.askColumns("Columns whose consecutive equal values must stay in one part", header); columns != nil {
+		options = append(options, [2]string{"group-by", strings.Join(columns, ",")})
+	}
+	for _, column := range p.askColumns("Sensitive columns to mask", header) {
+		method := p.askChoice("Mask "+column+" by", slices.Sorted(maps.Keys(maskMethods)), "redact")
+		options = append(options, [2]string{"mask", column + ":" + method})
+	}
+
+	format := p.askChoice("Output format", wizardFormats(), "csv")
+	if format != "csv" {
+		options = append(options, [2]string{"output-format", format})
+	}
+	switch format {
+	case "sql":
+		table := ""
+		for table == "" {
+			table = p.ask("Table name", "")
+		}
+		dialect := p.askChoice("SQL dialect", slices.Sorted(maps.Keys(sqlDialects)), "ansi")
+		options = append(options, [2]string{"table", table}, [2]string{"sql-dialect", dialect})
+	case "sqlite":
+		if table := p.ask("Table name", sqliteDefaultTable); table != sqliteDefaultTable {
+			options = append(options, [2]string{"table", table})
+		}
+	case "mysql":
+		if p.askBool("Write the LOAD DATA statement of each part next to it", false) {
+			table := ""
+			for table == "" {
+				table = p.ask("Table name", "")
+			}
+			options = append(options, [2]string{"table", table}, [2]string{"mysql-load-sql", "true"})
+		}
+	case "fwf":
+		spec := ""
+		for spec == "" {
+			spec = p.ask("Layout of the fixed-width parts (-output-fwf-spec file)", "")
+		}
+		options = append(options, [2]string{"output-fwf-spec", spec})
+	}
+
+	if checksum := p.askChoice("Checksum sidecar files", []string{"none", "md5", "sha1", "sha256", "sha512"}, "none"); checksum != "none" {
+		options = append(options, [2]string{"checksums", checksum})
+	}
+	if !p.askBool("Skip empty records", true) {
+		options = append(options, [2]string{"skip-empty", "false"})
+	}
+	if p.askBool("Require every record to match the header width", false) {
+		action := p.askChoice("Action on mismatched records", []string{"fail", "skip", "pad", "truncate"}, "fail")
+		options = append(options, [2]string{"strict", "true"}, [2]string{"strict-action", action})
+	}
+
+	if p.askBool("Validate rows against the detected column types", false) {
+		schemaPath := p.ask("Schema file to write", "schema.json")
+		if err := writeInferredSchema(schemaPath, header, types); err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "Wrote %s; edit it to tighten the rules.\n", schemaPath)
+		options = append(options, [2]string{"schema", schemaPath})
+		if p.askBool("Quarantine malformed rows instead of failing", true) {
+			options = append(options, [2]string{"on-error", "quarantine"})
+		}
+	}
+
+	command := []string{os.Args[0]}
+	if p.askBool("Save the options to a config file", false) {
+		configPath := p.ask("Config file to write (.yaml, .toml, or .json)", "splitcsv.yaml")
+		if err := writeConfigFile(configPath, options); err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "Wrote %s; commit it alongside the job.\n", configPath)
+		command = append(command, "-config", configPath)
+	} else {
+		command = append(command, optionArgs(options)...)
+	}
+
+	fmt.Fprintf(p.out, "\nYour command:\n\n  %s\n", shellJoin(command))
+	return nil
+}
+
+// optionArgs renders name and value pairs as command line flags. Boolean
+// options set to true become bare flags.
+func optionArgs(options [][2]string) []string {
+	var args []string
+	for _, option := range options {
+		switch option[1] {
+		case "true":
+			args = append(args, "-"+option[0])
+		case "false":
+			args = append(args, "-"+option[0]+"=false")
+		default:
+			args = append(args, "-"+option[0], option[1])
+		}
+	}
+	return args
+}
+
+// writeInferredSchema writes a schema declaring the inferred type of each
+// header column
+func writeInferredSchema(path string, header, types []string) error {
+	schema := Schema{}
+	for i, name := range header {
+		schema.Columns = append(schema.Columns, SchemaColumn{Name: name, Type: types[i]})
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write schema '%s': %w", path, err)
+	}
+	return nil
+}
+
+// shellJoin joins args into a command line safe to paste into a POSIX shell
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote single-quotes s unless it consists only of safe characters
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
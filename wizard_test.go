@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAskColumns(t *testing.T) {
+	header := []string{"id", "amount", "country"}
+	tests := []struct {
+		name   string
+		answer string
+		want   []string
+	}{
+		{"none", "\n", nil},
+		{"numbers", "3,1\n", []string{"country", "id"}},
+		{"names", "amount, country\n", []string{"amount", "country"}},
+		{"repeated", "1,id\n", []string{"id"}},
+		{"unknown then valid", "4\nzip\n2\n", []string{"amount"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &prompter{in: bufio.NewScanner(strings.NewReader(tt.answer)), out: io.Discard}
+			if got := p.askColumns("Columns", header); !slices.Equal(got, tt.want) {
+				t.Errorf("askColumns(%q) = %q, want %q", tt.answer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWizardFormats(t *testing.T) {
+	formats := wizardFormats()
+	if formats[0] != "csv" {
+		t.Errorf("wizardFormats()[0] = %q, want csv", formats[0])
+	}
+	for format := range outputFormats {
+		if want := format != "sqlite" || sqliteSupported; slices.Contains(formats, format) != want {
+			t.Errorf("wizardFormats() offers %s = %v, want %v", format, !want, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// workerBatch is how many records each of the -workers transforms at a time
+const workerBatch = 256
+
+// validateWorkersConfig checks -workers
+func validateWorkersConfig(config Config) error {
+	if config.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if config.Workers > 1 && config.Passthrough {
+		return fmt.Errorf("workers cannot be combined with passthrough, which has no transforms to run")
+	}
+	return nil
+}
+
+// recordTransforms are the stages of the pipeline that depend on nothing
+// but the record, so that they can run on many records at once: the field
+// transforms, the type coercions, and the masks
+type recordTransforms struct {
+	transforms []fieldTransform
+	types      []fieldTransform
+	masks      []fieldTransform
+}
+
+// pendingRecord is a record that has been read and is waiting to be
+// written, with the results of its transforms once they have run
+type pendingRecord struct {
+	number int
+	fields int
+	record []string
+	// source, tail, and positions are those of the record when it was read,
+	// to locate the errors found in it after later records are read
+	source    string
+	tail      *tailReader
+	positions []int
+
+	// transformed is the record after the field transforms, and coerced
+	// after the type coercions as well; masked is coerced with the masks
+	// applied, which is only written once the record is validated
+	transformed []string
+	coerced     []string
+	masked      []string
+	// err is the error of the transform or coercion of column that failed,
+	// with typed set for a coercion
+	err    error
+	column int
+	typed  bool
+}
+
+// prepare runs the transforms on the record of p
+func (t *recordTransforms) prepare(p *pendingRecord) {
+	record := p.record
+	if len(t.transforms) > 0 {
+		out, column, err := applyTransforms(t.transforms, record)
+		if err != nil {
+			p.err, p.column = err, column
+			return
+		}
+		record = out
+	}
+	p.transformed = record
+	if len(t.types) > 0 {
+		out, column, err := applyTransforms(t.types, record)
+		if err != nil {
+			p.err, p.column, p.typed = err, column, true
+			return
+		}
+		record = out
+	}
+	p.coerced = record
+	p.masked = record
+	if len(t.masks) > 0 {
+		// Masks do not fail
+		p.masked, _, _ = applyTransforms(t.masks, record)
+	}
+}
+
+// workerPool runs the transforms of batches of records on -workers
+// goroutines, then hands the records to the rest of the pipeline one at a
+// time, in input order. Reading the records and everything after the
+// transforms, from validation to writing, stay on a single goroutine.
+type workerPool struct {
+	s          *CSVSplitter
+	workers    int
+	transforms *recordTransforms
+	batch      []*pendingRecord
+}
+
+// newWorkerPool returns the pool of -workers, or nil when there is a single
+// worker or no transforms for it to run
+func (s *CSVSplitter) newWorkerPool(transforms *recordTransforms) *workerPool {
+	if s.config.Workers <= 1 || len(transforms.transforms)+len(transforms.types)+len(transforms.masks) == 0 {
+		return nil
+	}
+	if s.logger != nil {
+		s.logger.Info("transforming records in parallel", "workers", s.config.Workers)
+	} else if s.config.Verbose {
+		fmt.Printf("Transforming records on %d workers\n", s.config.Workers)
+	}
+	return &workerPool{s: s, workers: s.config.Workers, transforms: transforms}
+}
+
+// add queues p, the record last read, keeping where it was read
+func (w *workerPool) add(p *pendingRecord) {
+	s := w.s
+	p.source, p.tail, p.positions = s.source, s.tail, s.positions
+	if p.positions == nil {
+		p.positions = make([]int, 2*p.fields)
+		for i := range p.fields {
+			p.positions[2*i], p.positions[2*i+1] = s.input.FieldPos(i)
+		}
+	}
+	w.batch = append(w.batch, p)
+}
+
+// full reports whether the queued records make a batch for every worker
+func (w *workerPool) full() bool {
+	return len(w.batch) >= w.workers*workerBatch
+}
+
+// flush transforms the queued records in parallel, then passes them to
+// process in input order, as if each had just been read
+func (w *workerPool) flush(process func(p *pendingRecord) error) error {
+	if w == nil || len(w.batch) == 0 {
+		return nil
+	}
+	var wg sync.WaitGroup
+	size := (len(w.batch) + w.workers - 1) / w.workers
+	for start := 0; start < len(w.batch); start += size {
+		chunk := w.batch[start:min(start+size, len(w.batch))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, p := range chunk {
+				w.transforms.prepare(p)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := w.s
+	source, tail, read, positions := s.source, s.tail, s.recordsRead, s.positions
+	defer func() {
+		s.source, s.tail, s.recordsRead, s.positions = source, tail, read, positions
+	}()
+	for i, p := range w.batch {
+		s.source, s.tail, s.recordsRead, s.positions = p.source, p.tail, p.number, p.positions
+		w.batch[i] = nil
+		if err := process(p); err != nil {
+			return err
+		}
+	}
+	w.batch = w.batch[:0]
+	return nil
+}
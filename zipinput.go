@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// zipSuffix is the extension of inputs read as zip archives
+const zipSuffix = ".zip"
+
+// inputFile is an opened input: a file, or an entry of a zip archive
+type inputFile interface {
+	io.ReadCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// zipInputPath splits an input path naming a zip archive, optionally
+// followed by a colon and the name of one of its entries, as in
+// exports.zip:orders.csv. ok is false for inputs that are not archives.
+func zipInputPath(input string) (archive, entry string, ok bool) {
+	lower := strings.ToLower(input)
+	if i := strings.Index(lower, zipSuffix+":"); i >= 0 {
+		return input[:i+len(zipSuffix)], input[i+len(zipSuffix)+1:], true
+	}
+	return input, "", strings.HasSuffix(lower, zipSuffix)
+}
+
+// zipEntries returns the inputs an input path naming a zip archive stands
+// for: the entry it names, or every CSV entry of the archive, in archive
+// order, as archive:entry
+func zipEntries(input string) ([]string, error) {
+	archive, entry, _ := zipInputPath(input)
+	if entry != "" {
+		return []string{input}, nil
+	}
+	r, err := openZipArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var entries []string
+	for _, f := range csvEntries(r) {
+		entries = append(entries, archive+":"+f.Name)
+	}
+	if len(entries) == 0 {
+		return nil, withExitCode(exitInputNotFound, fmt.Errorf("no CSV files in input archive '%s'", archive))
+	}
+	return entries, nil
+}
+
+// csvEntries returns the entries of r that are read as inputs, chosen by
+// name as the files of -watch are
+func csvEntries(r *zip.ReadCloser) []*zip.File {
+	var files []*zip.File
+	for _, f := range r.File {
+		// Archives made on macOS carry resource forks under __MACOSX
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || !isInputCandidate(path.Base(f.Name)) {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// openZipArchive opens the zip archive at path, tagging a missing archive
+// with its own exit code
+func openZipArchive(archive string) (*zip.ReadCloser, error) {
+	r, err := zip.OpenReader(archive)
+	if os.IsNotExist(err) {
+		return nil, withExitCode(exitInputNotFound, fmt.Errorf("failed to open input archive '%s': %w", archive, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input archive '%s': %w", archive, err)
+	}
+	return r, nil
+}
+
+// zipEntryFile is an entry of a zip archive opened as an input. It is
+// decompressed as it is read, so it is never extracted to disk.
+type zipEntryFile struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+	info    fs.FileInfo
+}
+
+// openZipEntry opens the entry of the archive named by input, or the one
+// CSV entry of an archive named alone
+func openZipEntry(input string) (*zipEntryFile, error) {
+	archive, entry, _ := zipInputPath(input)
+	r, err := openZipArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	var file *zip.File
+	if entry == "" {
+		files := csvEntries(r)
+		if len(files) != 1 {
+			r.Close()
+			return nil, withExitCode(exitInputNotFound, fmt.Errorf("input archive '%s' has %d CSV files; name one as %s:FILE", archive, len(files), archive))
+		}
+		file = files[0]
+	}
+	for _, f := range r.File {
+		if file == nil && f.Name == entry {
+			file = f
+		}
+	}
+	if file == nil {
+		r.Close()
+		return nil, withExitCode(exitInputNotFound, fmt.Errorf("input archive '%s' has no file '%s'", archive, entry))
+	}
+	rc, err := file.Open()
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to open '%s' in input archive '%s': %w", file.Name, archive, err)
+	}
+	return &zipEntryFile{ReadCloser: rc, archive: r, info: file.FileInfo()}, nil
+}
+
+// Stat describes the entry, with its uncompressed size
+func (z *zipEntryFile) Stat() (fs.FileInfo, error) {
+	return z.info, nil
+}
+
+func (z *zipEntryFile) Close() error {
+	err := z.ReadCloser.Close()
+	if cerr := z.archive.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// statInput describes the input at path, which may be an entry of a zip
+// archive
+func statInput(input string) (fs.FileInfo, error) {
+	if _, _, ok := zipInputPath(input); ok {
+		file, err := openZipEntry(input)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return file.Stat()
+	}
+	return os.Stat(input)
+}